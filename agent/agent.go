@@ -0,0 +1,504 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package agent implements a key agent, that holds static keypairs in a
+// separate process, and performs Diffie-Hellman calculations on behalf of
+// clients over a Unix domain socket, such that the private keys never
+// leave the agent.
+//
+// The Client's Keypair implements `dh.Keypair`, and can be used as
+// `HandshakeConfig.LocalStatic`.
+package agent // import "gitlab.com/yawning/nyquist.git/agent"
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+// Audit log results.
+//
+// The audit log is a text format, with one entry per line:
+//
+//	<time> <operation> <dh> <public_key> <peer_public_key> <result>
+//
+// where `time` is in RFC 3339 format, `operation` is one of `ADD`,
+// `REMOVE`, `LIST`, or `DH`, binary values are hex encoded, and fields
+// that are not applicable are written as `-`.
+const (
+	// AuditResultOK is the audit log result for successful operations.
+	AuditResultOK = "OK"
+
+	// AuditResultNotFound is the audit log result for operations on keys
+	// that are not held by the agent.
+	AuditResultNotFound = "NOT_FOUND"
+
+	// AuditResultExpired is the audit log result for DH operations
+	// refused due to the key's NotAfter constraint.
+	AuditResultExpired = "EXPIRED"
+
+	// AuditResultExhausted is the audit log result for DH operations
+	// refused due to the key's MaxUses constraint.
+	AuditResultExhausted = "EXHAUSTED"
+
+	// AuditResultPeerNotAllowed is the audit log result for DH
+	// operations refused due to the key's AllowedPeers constraint.
+	AuditResultPeerNotAllowed = "PEER_NOT_ALLOWED"
+
+	// AuditResultFailed is the audit log result for operations that
+	// failed for any other reason.
+	AuditResultFailed = "FAILED"
+
+	auditAbsent = "-"
+)
+
+var (
+	// ErrKeyNotFound is the error returned when a key is not held by the
+	// agent.
+	ErrKeyNotFound = errors.New("nyquist/agent: key not found")
+
+	// ErrConstraintViolation is the error returned when the agent refuses
+	// to use a key due to the key's constraints.
+	ErrConstraintViolation = errors.New("nyquist/agent: key constraint violation")
+
+	// ErrAgentFailure is the error returned when the agent fails to
+	// service a request.
+	ErrAgentFailure = errors.New("nyquist/agent: agent failure")
+
+	// ErrPrivateKeyUnavailable is the error returned when attempting to
+	// export or import the private key of a Client Keypair.
+	ErrPrivateKeyUnavailable = errors.New("nyquist/agent: private key is held by the agent")
+
+	errDuplicateKey = errors.New("nyquist/agent: key already held by the agent")
+	errTooManyKeys  = errors.New("nyquist/agent: too many keys")
+	errClosed       = errors.New("nyquist/agent: agent closed")
+	errInsecureDir  = errors.New("nyquist/agent: socket directory is accessible by other users")
+)
+
+// Constraints are the per-key usage constraints.  The zero value imposes
+// no constraints.
+type Constraints struct {
+	// MaxUses is the maximum number of DH calculations the key may be
+	// used for, or 0 for unlimited.
+	MaxUses uint64
+
+	// NotAfter is the time after which the key may no longer be used for
+	// DH calculations, or the zero time for no expiry.
+	NotAfter time.Time
+
+	// AllowedPeers, if non-empty, are the only peer public keys the key
+	// may be used for DH calculations with.
+	AllowedPeers []dh.PublicKey
+}
+
+// Config is the agent configuration.
+type Config struct {
+	// AuditLog, if non-nil, is where audit log entries are written.
+	AuditLog io.Writer
+
+	// Now is the function used to get the current time.  If nil,
+	// `time.Now` will be used.
+	Now func() time.Time
+}
+
+func (cfg *Config) getNow() func() time.Time {
+	if cfg.Now == nil {
+		return time.Now
+	}
+	return cfg.Now
+}
+
+type agentKey struct {
+	dh          dh.DH
+	keypair     dh.Keypair
+	constraints Constraints
+	uses        uint64
+}
+
+func (k *agentKey) matches(dhName string, publicKey []byte) bool {
+	return k.dh.String() == dhName && bytes.Equal(k.keypair.Public().Bytes(), publicKey)
+}
+
+// Agent is a key agent.
+type Agent struct {
+	l sync.Mutex
+
+	keys      []*agentKey
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+
+	auditLog io.Writer
+	now      func() time.Time
+}
+
+// Add adds a keypair for the DH function to the agent, with the provided
+// constraints (which may be nil).  The number of keys is limited by the
+// maximum size of the LIST response.
+func (a *Agent) Add(dhImpl dh.DH, keypair dh.Keypair, constraints *Constraints) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	publicKey := keypair.Public().Bytes()
+	if a.closed {
+		return errClosed
+	}
+	if a.findKey(dhImpl.String(), publicKey) != nil {
+		a.audit("ADD", dhImpl.String(), publicKey, nil, AuditResultFailed)
+		return errDuplicateKey
+	}
+	if a.listSize()+listEntrySize(dhImpl.String(), publicKey) > math.MaxUint16 {
+		a.audit("ADD", dhImpl.String(), publicKey, nil, AuditResultFailed)
+		return errTooManyKeys
+	}
+
+	k := &agentKey{
+		dh:      dhImpl,
+		keypair: keypair,
+	}
+	if constraints != nil {
+		k.constraints = *constraints
+		k.constraints.AllowedPeers = append([]dh.PublicKey{}, constraints.AllowedPeers...)
+	}
+	a.keys = append(a.keys, k)
+	a.audit("ADD", dhImpl.String(), publicKey, nil, AuditResultOK)
+
+	return nil
+}
+
+// Remove removes a key from the agent, and discards the private key.
+func (a *Agent) Remove(dhImpl dh.DH, publicKey dh.PublicKey) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	for i, k := range a.keys {
+		if k.matches(dhImpl.String(), publicKey.Bytes()) {
+			a.keys = append(a.keys[:i], a.keys[i+1:]...)
+			k.keypair.DropPrivate()
+			a.audit("REMOVE", dhImpl.String(), publicKey.Bytes(), nil, AuditResultOK)
+			return nil
+		}
+	}
+	a.audit("REMOVE", dhImpl.String(), publicKey.Bytes(), nil, AuditResultNotFound)
+
+	return ErrKeyNotFound
+}
+
+// Serve accepts connections on the listener, and services requests until
+// the listener is closed, or the agent is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	a.l.Lock()
+	if a.closed {
+		a.l.Unlock()
+		return errClosed
+	}
+	a.listeners[l] = true
+	a.l.Unlock()
+
+	defer func() {
+		a.l.Lock()
+		delete(a.listeners, l)
+		a.l.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			a.l.Lock()
+			closed := a.closed
+			a.l.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		a.l.Lock()
+		if a.closed {
+			a.l.Unlock()
+			conn.Close()
+			return nil
+		}
+		a.conns[conn] = true
+		a.l.Unlock()
+
+		go a.serveConn(conn)
+	}
+}
+
+// Close closes all listeners and connections, and discards all of the
+// private keys held by the agent.
+func (a *Agent) Close() error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	for l := range a.listeners {
+		l.Close()
+	}
+	for conn := range a.conns {
+		conn.Close()
+	}
+	for _, k := range a.keys {
+		k.keypair.DropPrivate()
+	}
+	a.keys = nil
+
+	return nil
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer func() {
+		a.l.Lock()
+		delete(a.conns, conn)
+		a.l.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		req, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		resp, err := a.handleRequest(req)
+		if err != nil {
+			// Malformed requests terminate the connection.
+			return
+		}
+		if err = writeFrame(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) handleRequest(req []byte) ([]byte, error) {
+	r := &messageReader{b: req}
+	switch r.byte() {
+	case msgList:
+		if err := r.finish(); err != nil {
+			return nil, err
+		}
+		return a.handleList(), nil
+	case msgDH:
+		dhName, publicKey, peerPublicKey := r.field(), r.field(), r.field()
+		if err := r.finish(); err != nil {
+			return nil, err
+		}
+		return a.handleDH(string(dhName), publicKey, peerPublicKey), nil
+	default:
+		return nil, errMalformedMessage
+	}
+}
+
+func (a *Agent) handleList() []byte {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	// Add ensures that the response fits in a frame, but fail the
+	// request rather than the connection if it does not.
+	if a.listSize() > math.MaxUint16 {
+		a.audit("LIST", "", nil, nil, AuditResultFailed)
+		return []byte{statusFailure}
+	}
+
+	resp := make([]byte, 0, a.listSize())
+	resp = append(resp, statusOK, byte(len(a.keys)>>8), byte(len(a.keys)))
+	for _, k := range a.keys {
+		resp = appendField(resp, []byte(k.dh.String()))
+		resp = appendField(resp, k.keypair.Public().Bytes())
+	}
+	a.audit("LIST", "", nil, nil, AuditResultOK)
+
+	return resp
+}
+
+// listSize returns the size of the LIST response.
+func (a *Agent) listSize() int {
+	size := 3
+	for _, k := range a.keys {
+		size += listEntrySize(k.dh.String(), k.keypair.Public().Bytes())
+	}
+	return size
+}
+
+func listEntrySize(dhName string, publicKey []byte) int {
+	return 2 + len(dhName) + len(publicKey)
+}
+
+func (a *Agent) handleDH(dhName string, publicKey, peerPublicKey []byte) []byte {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	k := a.findKey(dhName, publicKey)
+	if k == nil {
+		a.audit("DH", dhName, publicKey, peerPublicKey, AuditResultNotFound)
+		return []byte{statusKeyNotFound}
+	}
+	if result := k.checkConstraints(peerPublicKey, a.now()); result != AuditResultOK {
+		a.audit("DH", dhName, publicKey, peerPublicKey, result)
+		return []byte{statusConstraintViolation}
+	}
+
+	peer, err := k.dh.ParsePublicKey(peerPublicKey)
+	if err != nil {
+		a.audit("DH", dhName, publicKey, peerPublicKey, AuditResultFailed)
+		return []byte{statusFailure}
+	}
+	sharedSecret, err := k.keypair.DH(peer)
+	if err != nil {
+		a.audit("DH", dhName, publicKey, peerPublicKey, AuditResultFailed)
+		return []byte{statusFailure}
+	}
+	defer clear(sharedSecret)
+	k.uses++
+	a.audit("DH", dhName, publicKey, peerPublicKey, AuditResultOK)
+
+	return appendField([]byte{statusOK}, sharedSecret)
+}
+
+func (k *agentKey) checkConstraints(peerPublicKey []byte, now time.Time) string {
+	c := &k.constraints
+	if !c.NotAfter.IsZero() && now.After(c.NotAfter) {
+		return AuditResultExpired
+	}
+	if c.MaxUses != 0 && k.uses >= c.MaxUses {
+		return AuditResultExhausted
+	}
+	if len(c.AllowedPeers) > 0 {
+		var allowed bool
+		for _, v := range c.AllowedPeers {
+			if bytes.Equal(v.Bytes(), peerPublicKey) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return AuditResultPeerNotAllowed
+		}
+	}
+	return AuditResultOK
+}
+
+func (a *Agent) findKey(dhName string, publicKey []byte) *agentKey {
+	for _, k := range a.keys {
+		if k.matches(dhName, publicKey) {
+			return k
+		}
+	}
+	return nil
+}
+
+func (a *Agent) audit(op, dhName string, publicKey, peerPublicKey []byte, result string) {
+	if a.auditLog == nil {
+		return
+	}
+
+	if dhName == "" {
+		dhName = auditAbsent
+	}
+
+	// Failing to write an audit log entry does not fail the operation.
+	_, _ = fmt.Fprintf(
+		a.auditLog,
+		"%s %s %s %s %s %s\n",
+		a.now().UTC().Format(time.RFC3339),
+		op,
+		dhName,
+		auditHex(publicKey),
+		auditHex(peerPublicKey),
+		result,
+	)
+}
+
+func auditHex(b []byte) string {
+	if len(b) == 0 {
+		return auditAbsent
+	}
+	return hex.EncodeToString(b)
+}
+
+// New creates a new agent.  The configuration may be nil.
+func New(cfg *Config) *Agent {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return &Agent{
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[net.Conn]bool),
+		auditLog:  cfg.AuditLog,
+		now:       cfg.getNow(),
+	}
+}
+
+// Listen creates a Unix domain socket listener at `path`, accessible only
+// by the current user.
+//
+// The socket is created inside a directory that is only accessible by the
+// current user, so that it is never reachable by other users, even before
+// its permissions are restricted.  The directory is created with mode 0700
+// if it does not exist, and an existing directory that is accessible by
+// the group or by other users is rejected.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		return nil, errInsecureDir
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,451 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package agent
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/cipher"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+type syncBuffer struct {
+	l sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return strings.Split(strings.TrimSuffix(b.b.String(), "\n"), "\n")
+}
+
+type testClock struct {
+	l   sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type testAgent struct {
+	agent    *Agent
+	client   *Client
+	auditLog *syncBuffer
+	clock    *testClock
+}
+
+func newTestAgent(t *testing.T) *testAgent {
+	require := require.New(t)
+
+	ta := &testAgent{
+		auditLog: &syncBuffer{},
+		clock:    &testClock{now: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+	}
+	ta.agent = New(&Config{
+		AuditLog: ta.auditLog,
+		Now:      ta.clock.Now,
+	})
+
+	path := filepath.Join(t.TempDir(), "agent", "agent.sock")
+	l, err := Listen(path)
+	require.NoError(err, "Listen")
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- ta.agent.Serve(l)
+	}()
+
+	ta.client, err = Dial(path)
+	require.NoError(err, "Dial")
+
+	t.Cleanup(func() {
+		ta.client.Close()
+		ta.agent.Close()
+		require.NoError(<-serveErr, "Serve")
+	})
+
+	return ta
+}
+
+func (ta *testAgent) addKey(t *testing.T, dhImpl dh.DH, constraints *Constraints) (dh.Keypair, *Keypair) {
+	keypair, err := dhImpl.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	err = ta.agent.Add(dhImpl, keypair, constraints)
+	require.NoError(t, err, "Add")
+
+	agentKeypair, err := ta.client.Keypair(dhImpl, keypair.Public())
+	require.NoError(t, err, "Client.Keypair")
+
+	return keypair, agentKeypair
+}
+
+func TestAgent(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Handshake", testAgentHandshake},
+		{"Keys", testAgentKeys},
+		{"Export", testAgentExport},
+		{"Constraints", testAgentConstraints},
+		{"AuditLog", testAgentAuditLog},
+		{"Close", testAgentClose},
+		{"Listen", testAgentListen},
+		{"TooManyKeys", testAgentTooManyKeys},
+		{"Timeout", testAgentTimeout},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testAgentHandshake(t *testing.T) {
+	for _, dhImpl := range []dh.DH{dh.X25519, dh.X448} {
+		t.Run(dhImpl.String(), func(t *testing.T) {
+			require := require.New(t)
+
+			ta := newTestAgent(t)
+			keypair, agentKeypair := ta.addKey(t, dhImpl, nil)
+			require.Equal(keypair.Public(), agentKeypair.Public(), "Public")
+
+			initiatorStatic, err := dhImpl.GenerateKeypair(rand.Reader)
+			require.NoError(err, "GenerateKeypair")
+
+			protocol := &nyquist.Protocol{
+				Pattern: pattern.XX,
+				DH:      dhImpl,
+				Cipher:  cipher.ChaChaPoly,
+				Hash:    hash.BLAKE2s,
+			}
+			initiator, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+				Protocol:    protocol,
+				LocalStatic: initiatorStatic,
+				IsInitiator: true,
+			})
+			require.NoError(err, "NewHandshake: initiator")
+			defer initiator.Reset()
+			responder, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+				Protocol:    protocol,
+				LocalStatic: agentKeypair,
+			})
+			require.NoError(err, "NewHandshake: responder")
+			defer responder.Reset()
+
+			msg, err := initiator.WriteMessage(nil, nil)
+			require.NoError(err, "initiator.WriteMessage(1)")
+			_, err = responder.ReadMessage(nil, msg)
+			require.NoError(err, "responder.ReadMessage(1)")
+			msg, err = responder.WriteMessage(nil, nil)
+			require.NoError(err, "responder.WriteMessage(2)")
+			_, err = initiator.ReadMessage(nil, msg)
+			require.NoError(err, "initiator.ReadMessage(2)")
+			msg, err = initiator.WriteMessage(nil, nil)
+			require.ErrorIs(err, nyquist.ErrDone, "initiator.WriteMessage(3)")
+			_, err = responder.ReadMessage(nil, msg)
+			require.ErrorIs(err, nyquist.ErrDone, "responder.ReadMessage(3)")
+
+			initiatorStatus, responderStatus := initiator.GetStatus(), responder.GetStatus()
+			require.Equal(initiatorStatus.HandshakeHash, responderStatus.HandshakeHash, "HandshakeHash")
+			require.Equal(keypair.Public().Bytes(), initiatorStatus.RemoteStatic.Bytes(), "initiator RemoteStatic")
+		})
+	}
+}
+
+func testAgentKeys(t *testing.T) {
+	require := require.New(t)
+
+	ta := newTestAgent(t)
+
+	keys, err := ta.client.List()
+	require.NoError(err, "List: empty")
+	require.Empty(keys, "List: empty")
+
+	keypair25519, _ := ta.addKey(t, dh.X25519, nil)
+	keypair448, agentKeypair448 := ta.addKey(t, dh.X448, nil)
+
+	err = ta.agent.Add(dh.X25519, keypair25519, nil)
+	require.ErrorIs(err, errDuplicateKey, "Add: duplicate")
+
+	keys, err = ta.client.List()
+	require.NoError(err, "List")
+	require.Len(keys, 2, "List")
+	require.Equal(dh.X25519, keys[0].DH, "List: DH (0)")
+	require.Equal(keypair25519.Public(), keys[0].PublicKey, "List: public key (0)")
+	require.Equal(dh.X448, keys[1].DH, "List: DH (1)")
+	require.Equal(keypair448.Public(), keys[1].PublicKey, "List: public key (1)")
+
+	_, err = ta.client.Keypair(dh.X448, keypair25519.Public())
+	require.ErrorIs(err, ErrKeyNotFound, "Client.Keypair: mismatched DH")
+
+	err = ta.agent.Remove(dh.X448, keypair448.Public())
+	require.NoError(err, "Remove")
+	err = ta.agent.Remove(dh.X448, keypair448.Public())
+	require.ErrorIs(err, ErrKeyNotFound, "Remove: again")
+
+	peer, err := dh.X448.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	_, err = agentKeypair448.DH(peer.Public())
+	require.ErrorIs(err, ErrKeyNotFound, "DH: removed key")
+
+	keys, err = ta.client.List()
+	require.NoError(err, "List: after Remove")
+	require.Len(keys, 1, "List: after Remove")
+}
+
+func testAgentExport(t *testing.T) {
+	require := require.New(t)
+
+	ta := newTestAgent(t)
+	keypair, agentKeypair := ta.addKey(t, dh.X25519, nil)
+
+	b, err := agentKeypair.MarshalBinary()
+	require.ErrorIs(err, ErrPrivateKeyUnavailable, "MarshalBinary")
+	require.Nil(b, "MarshalBinary")
+
+	err = agentKeypair.UnmarshalBinary(make([]byte, 32))
+	require.ErrorIs(err, ErrPrivateKeyUnavailable, "UnmarshalBinary")
+
+	// DropPrivate must not affect the key held by the agent.
+	agentKeypair.DropPrivate()
+	peer, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	sharedSecret, err := agentKeypair.DH(peer.Public())
+	require.NoError(err, "DH")
+	expected, err := keypair.DH(peer.Public())
+	require.NoError(err, "DH: local")
+	require.Equal(expected, sharedSecret, "DH")
+
+	peer448, err := dh.X448.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair: X448")
+	_, err = agentKeypair.DH(peer448.Public())
+	require.ErrorIs(err, dh.ErrMismatchedPublicKey, "DH: mismatched public key")
+}
+
+func testAgentConstraints(t *testing.T) {
+	require := require.New(t)
+
+	ta := newTestAgent(t)
+
+	peers := make([]dh.Keypair, 0, 2)
+	for i := 0; i < 2; i++ {
+		peer, err := dh.X25519.GenerateKeypair(rand.Reader)
+		require.NoError(err, "GenerateKeypair")
+		peers = append(peers, peer)
+	}
+
+	_, maxUses := ta.addKey(t, dh.X25519, &Constraints{
+		MaxUses: 2,
+	})
+	for i := 0; i < 2; i++ {
+		_, err := maxUses.DH(peers[0].Public())
+		require.NoError(err, "MaxUses: DH(%d)", i)
+	}
+	_, err := maxUses.DH(peers[0].Public())
+	require.ErrorIs(err, ErrConstraintViolation, "MaxUses: exhausted")
+
+	_, notAfter := ta.addKey(t, dh.X25519, &Constraints{
+		NotAfter: ta.clock.Now().Add(time.Hour),
+	})
+	_, err = notAfter.DH(peers[0].Public())
+	require.NoError(err, "NotAfter: DH")
+	ta.clock.advance(time.Hour + time.Second)
+	_, err = notAfter.DH(peers[0].Public())
+	require.ErrorIs(err, ErrConstraintViolation, "NotAfter: expired")
+
+	_, allowedPeers := ta.addKey(t, dh.X25519, &Constraints{
+		AllowedPeers: []dh.PublicKey{peers[1].Public()},
+	})
+	_, err = allowedPeers.DH(peers[1].Public())
+	require.NoError(err, "AllowedPeers: allowed peer")
+	_, err = allowedPeers.DH(peers[0].Public())
+	require.ErrorIs(err, ErrConstraintViolation, "AllowedPeers: other peer")
+}
+
+func testAgentAuditLog(t *testing.T) {
+	require := require.New(t)
+
+	ta := newTestAgent(t)
+	keypair, agentKeypair := ta.addKey(t, dh.X25519, &Constraints{
+		MaxUses: 1,
+	})
+
+	peer, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	_, err = agentKeypair.DH(peer.Public())
+	require.NoError(err, "DH")
+	_, err = agentKeypair.DH(peer.Public())
+	require.ErrorIs(err, ErrConstraintViolation, "DH: exhausted")
+
+	publicKey, peerPublicKey := auditHex(keypair.Public().Bytes()), auditHex(peer.Public().Bytes())
+	require.Equal([]string{
+		"2026-10-18T00:00:00Z ADD 25519 " + publicKey + " - OK",
+		"2026-10-18T00:00:00Z LIST - - - OK", // Client.Keypair
+		"2026-10-18T00:00:00Z DH 25519 " + publicKey + " " + peerPublicKey + " OK",
+		"2026-10-18T00:00:00Z DH 25519 " + publicKey + " " + peerPublicKey + " EXHAUSTED",
+	}, ta.auditLog.lines(), "audit log")
+}
+
+func testAgentClose(t *testing.T) {
+	require := require.New(t)
+
+	ta := newTestAgent(t)
+	_, agentKeypair := ta.addKey(t, dh.X25519, nil)
+
+	err := ta.agent.Close()
+	require.NoError(err, "Close")
+
+	peer, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	_, err = agentKeypair.DH(peer.Public())
+	require.Error(err, "DH: after Close")
+
+	err = ta.agent.Add(dh.X25519, peer, nil)
+	require.ErrorIs(err, errClosed, "Add: after Close")
+
+	// A nil configuration uses the defaults.
+	a := New(nil)
+	err = a.Add(dh.X25519, peer, nil)
+	require.NoError(err, "Add: nil Config")
+	require.NoError(a.Close(), "Close: nil Config")
+}
+
+func testAgentListen(t *testing.T) {
+	require := require.New(t)
+
+	// A missing directory is created, accessible only by the current user.
+	dir := filepath.Join(t.TempDir(), "agent")
+	l, err := Listen(filepath.Join(dir, "agent.sock"))
+	require.NoError(err, "Listen: missing directory")
+	l.Close()
+	fi, err := os.Stat(dir)
+	require.NoError(err, "Stat: directory")
+	require.Equal(os.FileMode(0o700), fi.Mode().Perm(), "Listen: directory mode")
+
+	// A directory accessible by other users is rejected.
+	dir = filepath.Join(t.TempDir(), "insecure")
+	err = os.Mkdir(dir, 0o700)
+	require.NoError(err, "Mkdir")
+	err = os.Chmod(dir, 0o755)
+	require.NoError(err, "Chmod")
+	_, err = Listen(filepath.Join(dir, "agent.sock"))
+	require.ErrorIs(err, errInsecureDir, "Listen: insecure directory")
+}
+
+func testAgentTimeout(t *testing.T) {
+	require := require.New(t)
+
+	// The agent never responds.
+	clientConn, agentConn := net.Pipe()
+	defer agentConn.Close()
+	go func() {
+		_, _ = readFrame(agentConn)
+	}()
+
+	client := NewClient(clientConn)
+	defer client.Close()
+	client.SetTimeout(50 * time.Millisecond)
+
+	_, err := client.List()
+	require.ErrorIs(err, os.ErrDeadlineExceeded, "List: timeout")
+
+	// The connection is closed after a failed request.
+	_, err = client.List()
+	require.ErrorIs(err, io.ErrClosedPipe, "List: after timeout")
+}
+
+func testAgentTooManyKeys(t *testing.T) {
+	require := require.New(t)
+
+	ta := newTestAgent(t)
+
+	// Keys are added until the LIST response would not fit in a frame.
+	var (
+		keypair dh.Keypair
+		nrKeys  int
+		err     error
+	)
+	for {
+		keypair, err = dh.X448.GenerateKeypair(rand.Reader)
+		require.NoError(err, "GenerateKeypair")
+		if err = ta.agent.Add(dh.X448, keypair, nil); err != nil {
+			break
+		}
+		nrKeys++
+	}
+	require.ErrorIs(err, errTooManyKeys, "Add: too many keys")
+	require.Equal(nrKeys, (math.MaxUint16-3)/(2+len(dh.X448.String())+dh.X448.Size()), "Add: number of keys")
+
+	keys, err := ta.client.List()
+	require.NoError(err, "List")
+	require.Len(keys, nrKeys, "List")
+
+	// A LIST response that does not fit fails the request, not the
+	// connection.
+	ta.agent.l.Lock()
+	ta.agent.keys = append(ta.agent.keys, &agentKey{dh: dh.X448, keypair: keypair})
+	ta.agent.l.Unlock()
+	_, err = ta.client.List()
+	require.ErrorIs(err, ErrAgentFailure, "List: oversized")
+
+	err = ta.agent.Remove(dh.X448, keypair.Public())
+	require.NoError(err, "Remove")
+	keys, err = ta.client.List()
+	require.NoError(err, "List: after Remove")
+	require.Len(keys, nrKeys, "List: after Remove")
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package agent
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+// Key is a key held by the agent.
+type Key struct {
+	// DH is the DH function the key is for.
+	DH dh.DH
+
+	// PublicKey is the public key.
+	PublicKey dh.PublicKey
+}
+
+// DefaultTimeout is the default timeout for each request to the agent.
+const DefaultTimeout = 10 * time.Second
+
+// Client is a key agent client.  It is safe for concurrent use.
+type Client struct {
+	l       sync.Mutex
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// SetTimeout sets the timeout for each request to the agent, with 0
+// disabling the timeout.  If a request fails due to a timeout or other
+// I/O error, the connection to the agent is closed.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.timeout = timeout
+}
+
+// List returns the keys held by the agent.  Keys for DH functions that are
+// not supported locally are omitted.
+func (c *Client) List() ([]*Key, error) {
+	r, err := c.roundTrip([]byte{msgList})
+	if err != nil {
+		return nil, err
+	}
+
+	n := int(r.uint16())
+	keys := make([]*Key, 0, n)
+	for i := 0; i < n; i++ {
+		dhName, publicKey := r.field(), r.field()
+		if r.err != nil {
+			break
+		}
+		dhImpl := dh.FromString(string(dhName))
+		if dhImpl == nil {
+			continue
+		}
+		pk, err := dhImpl.ParsePublicKey(publicKey)
+		if err != nil {
+			return nil, ErrAgentFailure
+		}
+		keys = append(keys, &Key{
+			DH:        dhImpl,
+			PublicKey: pk,
+		})
+	}
+	if err = r.finish(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Keypair returns a `dh.Keypair` backed by the agent for the key with the
+// public key, for the DH function.
+func (c *Client) Keypair(dhImpl dh.DH, publicKey dh.PublicKey) (*Keypair, error) {
+	keys, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.DH.String() == dhImpl.String() && bytes.Equal(k.PublicKey.Bytes(), publicKey.Bytes()) {
+			return &Keypair{
+				c:         c,
+				dh:        dhImpl,
+				publicKey: k.PublicKey,
+			}, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Close closes the connection to the agent.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) roundTrip(req []byte) (*messageReader, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	var deadline time.Time
+	if c.timeout > 0 {
+		deadline = time.Now().Add(c.timeout)
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(req)
+	if err != nil {
+		// The connection may be in the middle of a frame, so it can not
+		// be used for further requests.
+		c.conn.Close()
+		return nil, err
+	}
+
+	r := &messageReader{b: resp}
+	switch r.byte() {
+	case statusOK:
+		return r, nil
+	case statusKeyNotFound:
+		return nil, ErrKeyNotFound
+	case statusConstraintViolation:
+		return nil, ErrConstraintViolation
+	default:
+		return nil, ErrAgentFailure
+	}
+}
+
+func (c *Client) exchange(req []byte) ([]byte, error) {
+	if err := writeFrame(c.conn, req); err != nil {
+		return nil, err
+	}
+	return readFrame(c.conn)
+}
+
+// NewClient creates a new key agent client over an existing connection,
+// with the timeout set to DefaultTimeout.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn:    conn,
+		timeout: DefaultTimeout,
+	}
+}
+
+// Dial connects to the agent listening on the Unix domain socket at
+// `path`.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Keypair is a `dh.Keypair` with the private key held by the agent.
+type Keypair struct {
+	c         *Client
+	dh        dh.DH
+	publicKey dh.PublicKey
+}
+
+// MarshalBinary always fails with ErrPrivateKeyUnavailable, as the private
+// key can not be exported from the agent.
+func (kp *Keypair) MarshalBinary() ([]byte, error) {
+	return nil, ErrPrivateKeyUnavailable
+}
+
+// UnmarshalBinary always fails with ErrPrivateKeyUnavailable.
+func (kp *Keypair) UnmarshalBinary(data []byte) error {
+	return ErrPrivateKeyUnavailable
+}
+
+// DropPrivate does nothing, as the private key is held by the agent.  Use
+// `Agent.Remove` to discard the private key.
+func (kp *Keypair) DropPrivate() {}
+
+// Public returns the public key of the keypair.
+func (kp *Keypair) Public() dh.PublicKey {
+	return kp.publicKey
+}
+
+// DH requests that the agent perform a Diffie-Hellman calculation between
+// the private key in the keypair and the provided public key.
+func (kp *Keypair) DH(publicKey dh.PublicKey) ([]byte, error) {
+	peerPublicKey := publicKey.Bytes()
+	if len(peerPublicKey) != kp.dh.Size() {
+		return nil, dh.ErrMismatchedPublicKey
+	}
+
+	req := []byte{msgDH}
+	req = appendField(req, []byte(kp.dh.String()))
+	req = appendField(req, kp.publicKey.Bytes())
+	req = appendField(req, peerPublicKey)
+
+	r, err := kp.c.roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret := r.field()
+	if err = r.finish(); err != nil {
+		return nil, err
+	}
+	return sharedSecret, nil
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// The agent protocol consists of request/response pairs, each framed as a
+// 16-bit big-endian length followed by the message.  Messages consist of a
+// type (requests) or status (responses), followed by fields that are each
+// an 8-bit length followed by the value.
+//
+//	LIST request:  msgList
+//	LIST response: statusOK || u16 count || count * (dh || public key)
+//	DH request:    msgDH || dh || public key || peer public key
+//	DH response:   statusOK || shared secret
+//
+// Failed requests are answered with a non-OK status and no fields.
+const (
+	msgList = 0x01
+	msgDH   = 0x02
+
+	statusOK                  = 0x00
+	statusFailure             = 0x01
+	statusKeyNotFound         = 0x02
+	statusConstraintViolation = 0x03
+)
+
+var errMalformedMessage = errors.New("nyquist/agent: malformed message")
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeFrame(w io.Writer, b []byte) error {
+	if len(b) > math.MaxUint16 {
+		return errMalformedMessage
+	}
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(b)), uint16(len(b)))
+	_, err := w.Write(append(frame, b...))
+	return err
+}
+
+func appendField(b, field []byte) []byte {
+	if len(field) > math.MaxUint8 {
+		panic("nyquist/agent: field too large")
+	}
+	b = append(b, byte(len(field)))
+	return append(b, field...)
+}
+
+type messageReader struct {
+	b   []byte
+	err error
+}
+
+func (r *messageReader) byte() byte {
+	if r.err != nil || len(r.b) < 1 {
+		r.err = errMalformedMessage
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *messageReader) uint16() uint16 {
+	if r.err != nil || len(r.b) < 2 {
+		r.err = errMalformedMessage
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.b)
+	r.b = r.b[2:]
+	return v
+}
+
+func (r *messageReader) field() []byte {
+	n := int(r.byte())
+	if r.err != nil || len(r.b) < n {
+		r.err = errMalformedMessage
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *messageReader) finish() error {
+	if r.err == nil && len(r.b) != 0 {
+		r.err = errMalformedMessage
+	}
+	return r.err
+}
@@ -0,0 +1,391 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package authz implements a peer authorization policy engine, for use as a
+// `nyquist.HandshakeObserver`.
+//
+// The Observer implements `nyquist.PeerIdentityObserver`, so the matched
+// identity is returned in `HandshakeStatus.PeerIdentity` on handshake
+// completion, and can be retrieved with IdentityFromStatus.
+package authz // import "gitlab.com/yawning/nyquist.git/authz"
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var (
+	// ErrRevoked is the error returned when the peer's static public key
+	// is on the revocation list.
+	ErrRevoked = errors.New("nyquist/authz: peer revoked")
+
+	// ErrDenied is the error returned when the peer's static public key
+	// is explicitly denied, or is not permitted by the policy.
+	ErrDenied = errors.New("nyquist/authz: peer denied")
+
+	// ErrUnknownPeer is the error returned when the peer's static public
+	// key does not correspond to a known identity.
+	ErrUnknownPeer = errors.New("nyquist/authz: unknown peer")
+
+	errNoRemoteStatic = errors.New("nyquist/authz: no remote static public key")
+)
+
+// Identity is an identity record associated with a static public key.
+type Identity struct {
+	// Name is the name of the identity.
+	Name string
+
+	// PublicKey is the identity's static public key.
+	PublicKey dh.PublicKey
+
+	// Groups are the groups the identity is a member of.
+	Groups []string
+
+	// Labels are arbitrary key/value labels attached to the identity.
+	Labels map[string]string
+}
+
+// IsMemberOf returns true iff the identity is a member of the group.
+func (id *Identity) IsMemberOf(group string) bool {
+	for _, v := range id.Groups {
+		if v == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision is an authorization decision.
+type Decision struct {
+	// Time is the time the decision was made.
+	Time time.Time
+
+	// PublicKey is the peer's static public key.
+	PublicKey dh.PublicKey
+
+	// Identity is the matched identity, if any.
+	Identity *Identity
+
+	// Err is the reason the peer was rejected, or nil iff the peer was
+	// authorized.
+	Err error
+}
+
+// IsAllowed returns true iff the decision authorized the peer.
+func (d *Decision) IsAllowed() bool {
+	return d.Err == nil
+}
+
+// DecisionLog is an authorization decision log.
+type DecisionLog interface {
+	// LogDecision will be called with every authorization decision.
+	LogDecision(*Decision)
+}
+
+// Config is an Authorizer configuration.
+type Config struct {
+	// Identities are the initial identities to allow.
+	Identities []*Identity
+
+	// Denied are the initial static public keys to deny.
+	Denied []dh.PublicKey
+
+	// AllowedGroups, if non-empty, restricts authorization to identities
+	// that are a member of at least one of the groups.
+	AllowedGroups []string
+
+	// AllowUnknown will authorize peers that do not correspond to a known
+	// identity, as long as they are not denied or revoked.
+	AllowUnknown bool
+
+	// DecisionLog is the optional decision log.
+	DecisionLog DecisionLog
+
+	// Now is the optional function that returns the current time.  If the
+	// value is `nil`, `time.Now` will be used.
+	Now func() time.Time
+}
+
+// Authorizer is a peer authorization policy engine.  It is safe for
+// concurrent use, and all of the lists may be altered at runtime, with the
+// changes applying to all subsequent authorization decisions.
+type Authorizer struct {
+	l sync.RWMutex
+
+	identities    map[string]*Identity
+	denied        map[string]bool
+	revoked       map[string]bool
+	allowedGroups []string
+	allowUnknown  bool
+
+	log DecisionLog
+	now func() time.Time
+}
+
+// AddIdentity adds (or replaces) an identity.
+func (a *Authorizer) AddIdentity(id *Identity) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.identities[keyID(id.PublicKey)] = id
+}
+
+// RemoveIdentity removes the identity associated with a static public key.
+func (a *Authorizer) RemoveIdentity(publicKey dh.PublicKey) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	delete(a.identities, keyID(publicKey))
+}
+
+// Deny adds a static public key to the denylist.
+func (a *Authorizer) Deny(publicKey dh.PublicKey) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.denied[keyID(publicKey)] = true
+}
+
+// Undeny removes a static public key from the denylist.
+func (a *Authorizer) Undeny(publicKey dh.PublicKey) {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	delete(a.denied, keyID(publicKey))
+}
+
+// SetRevocationList atomically replaces the revocation list.
+func (a *Authorizer) SetRevocationList(publicKeys [][]byte) {
+	revoked := make(map[string]bool)
+	for _, v := range publicKeys {
+		revoked[string(v)] = true
+	}
+
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	a.revoked = revoked
+}
+
+// LoadRevocationList parses a revocation list, and atomically replaces the
+// existing revocation list with it.  The revocation list consists of one hex
+// encoded static public key per line, with empty lines and lines starting
+// with `#` ignored.  On failure, the existing revocation list is left
+// intact.
+func (a *Authorizer) LoadRevocationList(r io.Reader) error {
+	var publicKeys [][]byte
+
+	scanner := bufio.NewScanner(r)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil || len(b) == 0 {
+			return fmt.Errorf("nyquist/authz: malformed revocation list entry on line %d", lineNr)
+		}
+		publicKeys = append(publicKeys, b)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.SetRevocationList(publicKeys)
+
+	return nil
+}
+
+// ReloadRevocationList loads the revocation list from a file, with the same
+// semantics as LoadRevocationList.
+func (a *Authorizer) ReloadRevocationList(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.LoadRevocationList(f)
+}
+
+// Authorize makes an authorization decision for a peer's static public key,
+// returning the matched identity (if any), or an error iff the peer is not
+// authorized.
+func (a *Authorizer) Authorize(publicKey dh.PublicKey) (*Identity, error) {
+	d := a.decide(publicKey)
+	if a.log != nil {
+		a.log.LogDecision(d)
+	}
+
+	return d.Identity, d.Err
+}
+
+// AuthorizeStatus makes an authorization decision for the remote static
+// public key of a completed handshake.  This is useful for patterns where
+// the remote static public key is part of the pre-messages, and thus is not
+// seen by the observer.
+func (a *Authorizer) AuthorizeStatus(status *nyquist.HandshakeStatus) (*Identity, error) {
+	if status.RemoteStatic == nil {
+		return nil, errNoRemoteStatic
+	}
+	return a.Authorize(status.RemoteStatic)
+}
+
+func (a *Authorizer) decide(publicKey dh.PublicKey) *Decision {
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	d := &Decision{
+		Time:      a.now(),
+		PublicKey: publicKey,
+	}
+
+	id := keyID(publicKey)
+	switch {
+	case a.revoked[id]:
+		d.Err = ErrRevoked
+	case a.denied[id]:
+		d.Err = ErrDenied
+	default:
+		d.Identity = a.identities[id]
+		switch {
+		case d.Identity != nil:
+			if !a.isInAllowedGroup(d.Identity) {
+				d.Err = ErrDenied
+			}
+		case !a.allowUnknown:
+			d.Err = ErrUnknownPeer
+		}
+	}
+
+	return d
+}
+
+func (a *Authorizer) isInAllowedGroup(id *Identity) bool {
+	if len(a.allowedGroups) == 0 {
+		return true
+	}
+	for _, v := range a.allowedGroups {
+		if id.IsMemberOf(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewObserver creates a new per-handshake Observer backed by the
+// Authorizer.
+func (a *Authorizer) NewObserver() *Observer {
+	return &Observer{
+		a: a,
+	}
+}
+
+// Observer is a `nyquist.HandshakeObserver` that authorizes the peer's
+// static public key.  A separate Observer should be used for each handshake.
+type Observer struct {
+	a *Authorizer
+
+	identity *Identity
+}
+
+// OnPeerPublicKey implements the `nyquist.HandshakeObserver` interface.
+func (o *Observer) OnPeerPublicKey(token pattern.Token, publicKey dh.PublicKey) error {
+	if token != pattern.Token_s {
+		return nil
+	}
+
+	id, err := o.a.Authorize(publicKey)
+	if err != nil {
+		return err
+	}
+	o.identity = id
+
+	return nil
+}
+
+// Identity returns the identity matched by the observer, if any.
+func (o *Observer) Identity() *Identity {
+	return o.identity
+}
+
+// PeerIdentity implements the `nyquist.PeerIdentityObserver` interface.
+func (o *Observer) PeerIdentity() interface{} {
+	if o.identity == nil {
+		// Avoid returning a non-nil interface holding a nil pointer.
+		return nil
+	}
+	return o.identity
+}
+
+// IdentityFromStatus returns the identity matched by an Observer, from the
+// status of a completed handshake, if any.
+func IdentityFromStatus(status *nyquist.HandshakeStatus) *Identity {
+	id, _ := status.PeerIdentity.(*Identity)
+	return id
+}
+
+func keyID(publicKey dh.PublicKey) string {
+	return string(publicKey.Bytes())
+}
+
+// New creates a new Authorizer with the provided configuration.
+func New(cfg *Config) *Authorizer {
+	a := &Authorizer{
+		identities:    make(map[string]*Identity),
+		denied:        make(map[string]bool),
+		revoked:       make(map[string]bool),
+		allowedGroups: append([]string{}, cfg.AllowedGroups...),
+		allowUnknown:  cfg.AllowUnknown,
+		log:           cfg.DecisionLog,
+		now:           cfg.Now,
+	}
+	if a.now == nil {
+		a.now = time.Now
+	}
+	for _, v := range cfg.Identities {
+		a.identities[keyID(v.PublicKey)] = v
+	}
+	for _, v := range cfg.Denied {
+		a.denied[keyID(v)] = true
+	}
+
+	return a
+}
@@ -0,0 +1,188 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package authz
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+type testDecisionLog struct {
+	decisions []*Decision
+}
+
+func (l *testDecisionLog) LogDecision(d *Decision) {
+	l.decisions = append(l.decisions, d)
+}
+
+func mustGenerateKeypair(t *testing.T) dh.Keypair {
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+func doHandshake(t *testing.T, aliceStatic, bobStatic dh.Keypair, observer nyquist.HandshakeObserver) (*nyquist.HandshakeStatus, error) {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol("Noise_XX_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+
+	aliceHs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: aliceStatic,
+		IsInitiator: true,
+	})
+	require.NoError(err, "NewHandshake(alice)")
+	defer aliceHs.Reset()
+	bobHs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: bobStatic,
+		Observer:    observer,
+	})
+	require.NoError(err, "NewHandshake(bob)")
+	defer bobHs.Reset()
+
+	msg, err := aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage(1)")
+	_, err = bobHs.ReadMessage(nil, msg)
+	require.NoError(err, "bobHs.ReadMessage(1)")
+	msg, err = bobHs.WriteMessage(nil, nil)
+	require.NoError(err, "bobHs.WriteMessage(1)")
+	_, err = aliceHs.ReadMessage(nil, msg)
+	require.NoError(err, "aliceHs.ReadMessage(1)")
+	msg, err = aliceHs.WriteMessage(nil, nil)
+	require.Equal(nyquist.ErrDone, err, "aliceHs.WriteMessage(2)")
+	_, err = bobHs.ReadMessage(nil, msg)
+	if err == nyquist.ErrDone {
+		return bobHs.GetStatus(), nil
+	}
+	return bobHs.GetStatus(), err
+}
+
+func TestAuthorizer(t *testing.T) {
+	require := require.New(t)
+
+	aliceStatic, bobStatic := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	charlieStatic, mallory := mustGenerateKeypair(t), mustGenerateKeypair(t)
+
+	alice := &Identity{
+		Name:      "alice",
+		PublicKey: aliceStatic.Public(),
+		Groups:    []string{"admins", "users"},
+		Labels:    map[string]string{"site": "ams"},
+	}
+	charlie := &Identity{
+		Name:      "charlie",
+		PublicKey: charlieStatic.Public(),
+		Groups:    []string{"guests"},
+	}
+
+	var log testDecisionLog
+	a := New(&Config{
+		Identities:    []*Identity{alice, charlie},
+		Denied:        []dh.PublicKey{mallory.Public()},
+		AllowedGroups: []string{"users"},
+		DecisionLog:   &log,
+	})
+
+	// Known identity in an allowed group.
+	obs := a.NewObserver()
+	status, err := doHandshake(t, aliceStatic, bobStatic, obs)
+	require.NoError(err, "Handshake - alice")
+	require.Equal(alice, obs.Identity(), "Observer.Identity()")
+	require.Equal(alice, IdentityFromStatus(status), "IdentityFromStatus()")
+	require.Len(log.decisions, 1, "Decision logged")
+	require.True(log.decisions[0].IsAllowed(), "Decision.IsAllowed()")
+	require.Equal(alice, log.decisions[0].Identity, "Decision.Identity")
+
+	// Known identity, but not in an allowed group.
+	obs = a.NewObserver()
+	_, err = doHandshake(t, charlieStatic, bobStatic, obs)
+	require.ErrorIs(err, ErrDenied, "Handshake - charlie")
+	require.Nil(obs.Identity(), "Observer.Identity() - charlie")
+	require.Equal(charlie, log.decisions[1].Identity, "Decision.Identity - charlie")
+
+	// Explicitly denied.
+	_, err = doHandshake(t, mallory, bobStatic, a.NewObserver())
+	require.ErrorIs(err, ErrDenied, "Handshake - mallory")
+
+	// Unknown.
+	_, err = doHandshake(t, bobStatic, aliceStatic, a.NewObserver())
+	require.ErrorIs(err, ErrUnknownPeer, "Handshake - unknown")
+	require.Len(log.decisions, 4, "All decisions logged")
+	require.False(log.decisions[3].IsAllowed(), "Decision.IsAllowed() - unknown")
+
+	// Revocation takes effect without rebuilding the Authorizer.
+	revocationList := "# revoked keys\n\n" + hex.EncodeToString(aliceStatic.Public().Bytes()) + "\n"
+	err = a.LoadRevocationList(strings.NewReader(revocationList))
+	require.NoError(err, "LoadRevocationList")
+	_, err = doHandshake(t, aliceStatic, bobStatic, a.NewObserver())
+	require.ErrorIs(err, ErrRevoked, "Handshake - alice revoked")
+
+	err = a.LoadRevocationList(strings.NewReader("not hex\n"))
+	require.Error(err, "LoadRevocationList - malformed")
+	_, err = a.Authorize(aliceStatic.Public())
+	require.ErrorIs(err, ErrRevoked, "Malformed revocation list leaves the old list intact")
+
+	a.SetRevocationList(nil)
+	id, err := a.Authorize(aliceStatic.Public())
+	require.NoError(err, "Authorize - alice un-revoked")
+	require.Equal(alice, id, "Authorize - alice un-revoked")
+
+	// Runtime identity/denylist changes.
+	a.RemoveIdentity(aliceStatic.Public())
+	_, err = a.Authorize(aliceStatic.Public())
+	require.ErrorIs(err, ErrUnknownPeer, "Authorize - alice removed")
+
+	a.Undeny(mallory.Public())
+	a.AddIdentity(&Identity{Name: "mallory", PublicKey: mallory.Public(), Groups: []string{"users"}})
+	id, err = a.Authorize(mallory.Public())
+	require.NoError(err, "Authorize - mallory undenied")
+	require.Equal("mallory", id.Name, "Authorize - mallory undenied")
+
+	// AllowUnknown.
+	a = New(&Config{AllowUnknown: true})
+	obs = a.NewObserver()
+	status, err = doHandshake(t, aliceStatic, bobStatic, obs)
+	require.NoError(err, "Handshake - AllowUnknown")
+	require.Nil(obs.Identity(), "Observer.Identity() - AllowUnknown")
+	require.Nil(status.PeerIdentity, "HandshakeStatus.PeerIdentity - AllowUnknown")
+	require.Nil(IdentityFromStatus(status), "IdentityFromStatus() - AllowUnknown")
+
+	_, err = a.AuthorizeStatus(&nyquist.HandshakeStatus{})
+	require.Error(err, "AuthorizeStatus - no remote static")
+}
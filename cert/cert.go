@@ -0,0 +1,500 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package cert implements a compact certificate format binding Noise static
+// public keys to identities, signed by Ed25519 certificate authorities, and
+// a handshake wrapper that exchanges and verifies certificate chains in the
+// handshake payloads.
+package cert // import "gitlab.com/yawning/nyquist.git/cert"
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	certVersion = 1
+
+	flagIsCA = 1 << 0
+
+	signatureContext = "nyquist-cert-v1"
+
+	maxFieldLen  = math.MaxUint8
+	maxChainLen  = math.MaxUint8
+	maxStringLen = math.MaxUint8
+)
+
+var (
+	// ErrMalformed is the error returned when a certificate or certificate
+	// chain is malformed.
+	ErrMalformed = errors.New("nyquist/cert: malformed certificate")
+
+	errFieldTooLong = errors.New("nyquist/cert: certificate field too long")
+	errNotCA        = errors.New("nyquist/cert: issuer is not a CA")
+	errNoPublicKey  = errors.New("nyquist/cert: certificate has no public key")
+	errKeyMismatch  = errors.New("nyquist/cert: private key does not match certificate")
+)
+
+// Certificate is a signed binding of a public key to a name and set of
+// groups, with a validity period.
+//
+// Leaf certificates certify a Noise static public key for the DH function
+// named by DH.  CA certificates certify an Ed25519 public key used to sign
+// other certificates, and have an empty DH.
+type Certificate struct {
+	// Name is the name of the certificate subject.
+	Name string
+
+	// Groups is the set of groups the certificate subject belongs to.
+	// For CA certificates, if non-empty, this is the set of groups that
+	// certificates signed by the CA are limited to.
+	Groups []string
+
+	// Protocols is the set of Noise protocol names the certified key is
+	// allowed to be used with.  If empty, all protocols are allowed.
+	Protocols []string
+
+	// NotBefore is the start of the validity period (inclusive).
+	NotBefore time.Time
+
+	// NotAfter is the end of the validity period (inclusive).
+	NotAfter time.Time
+
+	// IsCA is true iff the certificate is for a certificate authority.
+	IsCA bool
+
+	// DH is the name of the DH function the PublicKey is for (leaf
+	// certificates only).
+	DH string
+
+	// PublicKey is the certified public key.
+	PublicKey []byte
+
+	// Issuer is the Ed25519 public key of the issuing CA.
+	Issuer ed25519.PublicKey
+
+	// Signature is the Ed25519 signature by Issuer.
+	Signature []byte
+}
+
+// IsValidAt returns true iff t is within the certificate's validity period.
+func (c *Certificate) IsValidAt(t time.Time) bool {
+	return !t.Before(c.NotBefore) && !t.After(c.NotAfter)
+}
+
+// IsSelfSigned returns true iff the certificate is a self-signed CA
+// certificate.
+func (c *Certificate) IsSelfSigned() bool {
+	return c.IsCA && ed25519.PublicKey(c.PublicKey).Equal(c.Issuer)
+}
+
+// IsMemberOf returns true iff the certificate subject is a member of the
+// group.
+func (c *Certificate) IsMemberOf(group string) bool {
+	for _, v := range c.Groups {
+		if v == group {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsProtocol returns true iff the certified key may be used with the
+// protocol.
+func (c *Certificate) AllowsProtocol(protocolName string) bool {
+	if len(c.Protocols) == 0 {
+		return true
+	}
+	for _, v := range c.Protocols {
+		if v == protocolName {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSignatureFrom verifies that the certificate was signed by the CA
+// certificate parent.
+func (c *Certificate) CheckSignatureFrom(parent *Certificate) error {
+	if !parent.IsCA || len(parent.PublicKey) != ed25519.PublicKeySize {
+		return errNotCA
+	}
+	if !ed25519.PublicKey(parent.PublicKey).Equal(c.Issuer) {
+		return ErrUnknownIssuer
+	}
+
+	tbs, err := c.marshalTBS()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(c.Issuer, tbs, c.Signature) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// MarshalBinary marshals the certificate to binary form.
+func (c *Certificate) MarshalBinary() ([]byte, error) {
+	b, err := c.marshalTBS()
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Signature) != ed25519.SignatureSize {
+		return nil, ErrMalformed
+	}
+
+	return append(b[len(signatureContext):], c.Signature...), nil
+}
+
+// UnmarshalBinary unmarshals the certificate from binary form.
+func (c *Certificate) UnmarshalBinary(data []byte) error {
+	r := &reader{b: data}
+
+	if r.readByte() != certVersion {
+		return ErrMalformed
+	}
+	flags := r.readByte()
+	if flags&^flagIsCA != 0 {
+		return ErrMalformed
+	}
+
+	var tmp Certificate
+	tmp.IsCA = flags&flagIsCA != 0
+	tmp.Name = string(r.readBytes())
+	tmp.Groups = r.readStrings()
+	tmp.Protocols = r.readStrings()
+	tmp.NotBefore = time.Unix(int64(r.readUint64()), 0).UTC()
+	tmp.NotAfter = time.Unix(int64(r.readUint64()), 0).UTC()
+	tmp.DH = string(r.readBytes())
+	tmp.PublicKey = r.readBytes()
+	tmp.Issuer = ed25519.PublicKey(r.readN(ed25519.PublicKeySize))
+	tmp.Signature = r.readN(ed25519.SignatureSize)
+	if r.err != nil || len(r.b) != 0 {
+		return ErrMalformed
+	}
+
+	*c = tmp
+
+	return nil
+}
+
+func (c *Certificate) marshalTBS() ([]byte, error) {
+	if len(c.PublicKey) == 0 {
+		return nil, errNoPublicKey
+	}
+	if len(c.Issuer) != ed25519.PublicKeySize {
+		return nil, ErrMalformed
+	}
+
+	var w writer
+	w.b = append(w.b, signatureContext...)
+	w.b = append(w.b, certVersion)
+	var flags byte
+	if c.IsCA {
+		flags |= flagIsCA
+	}
+	w.b = append(w.b, flags)
+	w.writeBytes([]byte(c.Name))
+	w.writeStrings(c.Groups)
+	w.writeStrings(c.Protocols)
+	w.writeUint64(uint64(c.NotBefore.Unix()))
+	w.writeUint64(uint64(c.NotAfter.Unix()))
+	w.writeBytes([]byte(c.DH))
+	w.writeBytes(c.PublicKey)
+	w.b = append(w.b, c.Issuer...)
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	return w.b, nil
+}
+
+// ParseCertificate parses a binary serialized certificate.
+func ParseCertificate(data []byte) (*Certificate, error) {
+	var c Certificate
+	if err := c.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// MarshalChain marshals a certificate chain (leaf first) to binary form.
+func MarshalChain(chain []*Certificate) ([]byte, error) {
+	if len(chain) == 0 || len(chain) > maxChainLen {
+		return nil, ErrMalformed
+	}
+
+	b := []byte{byte(len(chain))}
+	for _, c := range chain {
+		cb, err := c.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if len(cb) > math.MaxUint16 {
+			return nil, errFieldTooLong
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(cb)))
+		b = append(b, cb...)
+	}
+
+	return b, nil
+}
+
+// ParseChain parses a binary serialized certificate chain.
+func ParseChain(data []byte) ([]*Certificate, error) {
+	r := &reader{b: data}
+
+	n := int(r.readByte())
+	if n == 0 {
+		return nil, ErrMalformed
+	}
+	chain := make([]*Certificate, 0, n)
+	for i := 0; i < n; i++ {
+		cb := r.readN(int(r.readUint16()))
+		if r.err != nil {
+			return nil, ErrMalformed
+		}
+		c, err := ParseCertificate(cb)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, c)
+	}
+	if len(r.b) != 0 {
+		return nil, ErrMalformed
+	}
+
+	return chain, nil
+}
+
+// CA is a certificate authority.
+type CA struct {
+	// Certificate is the CA's certificate.
+	Certificate *Certificate
+
+	privateKey ed25519.PrivateKey
+}
+
+// Sign signs the template certificate, returning a new certificate issued
+// by the CA.  The template's Issuer and Signature are ignored.
+func (ca *CA) Sign(template *Certificate) (*Certificate, error) {
+	if !ca.Certificate.IsCA {
+		return nil, errNotCA
+	}
+
+	c := &Certificate{
+		Name:      template.Name,
+		Groups:    cloneStrings(template.Groups),
+		Protocols: cloneStrings(template.Protocols),
+		NotBefore: template.NotBefore.Truncate(time.Second).UTC(),
+		NotAfter:  template.NotAfter.Truncate(time.Second).UTC(),
+		IsCA:      template.IsCA,
+		DH:        template.DH,
+		PublicKey: append([]byte{}, template.PublicKey...),
+		Issuer:    ed25519.PublicKey(ca.Certificate.PublicKey),
+	}
+	tbs, err := c.marshalTBS()
+	if err != nil {
+		return nil, err
+	}
+	c.Signature = ed25519.Sign(ca.privateKey, tbs)
+
+	return c, nil
+}
+
+// NewIntermediateCA creates a new CA, with a certificate issued by this CA,
+// based on the template.  The template's IsCA, DH and PublicKey are
+// ignored.
+func (ca *CA) NewIntermediateCA(rng io.Reader, template *Certificate) (*CA, error) {
+	pub, priv, err := ed25519.GenerateKey(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp := *template
+	tmp.IsCA = true
+	tmp.DH = ""
+	tmp.PublicKey = pub
+
+	c, err := ca.Sign(&tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{
+		Certificate: c,
+		privateKey:  priv,
+	}, nil
+}
+
+// NewRootCA creates a new root CA with a self-signed certificate based on
+// the template.  The template's IsCA, DH and PublicKey are ignored.
+func NewRootCA(rng io.Reader, template *Certificate) (*CA, error) {
+	pub, priv, err := ed25519.GenerateKey(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &CA{
+		Certificate: &Certificate{
+			IsCA:      true,
+			PublicKey: pub,
+		},
+		privateKey: priv,
+	}
+	return self.newSelfSigned(template)
+}
+
+// NewCA creates a CA from an existing certificate and the corresponding
+// Ed25519 private key.
+func NewCA(c *Certificate, privateKey ed25519.PrivateKey) (*CA, error) {
+	if !c.IsCA {
+		return nil, errNotCA
+	}
+	pub, ok := privateKey.Public().(ed25519.PublicKey)
+	if !ok || !pub.Equal(ed25519.PublicKey(c.PublicKey)) {
+		return nil, errKeyMismatch
+	}
+
+	return &CA{
+		Certificate: c,
+		privateKey:  privateKey,
+	}, nil
+}
+
+func (ca *CA) newSelfSigned(template *Certificate) (*CA, error) {
+	tmp := *template
+	tmp.IsCA = true
+	tmp.DH = ""
+	tmp.PublicKey = ca.Certificate.PublicKey
+
+	c, err := ca.Sign(&tmp)
+	if err != nil {
+		return nil, err
+	}
+	ca.Certificate = c
+
+	return ca, nil
+}
+
+type writer struct {
+	b   []byte
+	err error
+}
+
+func (w *writer) writeBytes(b []byte) {
+	if len(b) > maxFieldLen {
+		w.err = errFieldTooLong
+		return
+	}
+	w.b = append(w.b, byte(len(b)))
+	w.b = append(w.b, b...)
+}
+
+func (w *writer) writeStrings(ss []string) {
+	if len(ss) > maxStringLen {
+		w.err = errFieldTooLong
+		return
+	}
+	w.b = append(w.b, byte(len(ss)))
+	for _, s := range ss {
+		w.writeBytes([]byte(s))
+	}
+}
+
+func (w *writer) writeUint64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) readN(n int) []byte {
+	if r.err != nil || len(r.b) < n {
+		r.err = ErrMalformed
+		return nil
+	}
+	v := append([]byte{}, r.b[:n]...)
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) readByte() byte {
+	v := r.readN(1)
+	if v == nil {
+		return 0
+	}
+	return v[0]
+}
+
+func (r *reader) readUint16() uint16 {
+	v := r.readN(2)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(v)
+}
+
+func (r *reader) readUint64() uint64 {
+	v := r.readN(8)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func (r *reader) readBytes() []byte {
+	return r.readN(int(r.readByte()))
+}
+
+func (r *reader) readStrings() []string {
+	n := int(r.readByte())
+	if n == 0 {
+		return nil
+	}
+	ss := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ss = append(ss, string(r.readBytes()))
+	}
+	return ss
+}
+
+func cloneStrings(ss []string) []string {
+	if len(ss) == 0 {
+		return nil
+	}
+	return append([]string{}, ss...)
+}
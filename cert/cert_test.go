@@ -0,0 +1,347 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package cert
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const testProtocol = "Noise_XX_25519_ChaChaPoly_BLAKE2s"
+
+var testNow = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testClock() time.Time {
+	return testNow
+}
+
+type testPKI struct {
+	root         *CA
+	intermediate *CA
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	require := require.New(t)
+
+	root, err := NewRootCA(rand.Reader, &Certificate{
+		Name:      "root",
+		NotBefore: testNow.Add(-24 * time.Hour),
+		NotAfter:  testNow.Add(365 * 24 * time.Hour),
+	})
+	require.NoError(err, "NewRootCA")
+	intermediate, err := root.NewIntermediateCA(rand.Reader, &Certificate{
+		Name:      "intermediate",
+		Groups:    []string{"servers", "clients"},
+		NotBefore: testNow.Add(-time.Hour),
+		NotAfter:  testNow.Add(30 * 24 * time.Hour),
+	})
+	require.NoError(err, "NewIntermediateCA")
+
+	return &testPKI{
+		root:         root,
+		intermediate: intermediate,
+	}
+}
+
+func (pki *testPKI) issue(t *testing.T, name, group string) (dh.Keypair, []*Certificate) {
+	require := require.New(t)
+
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	leaf, err := pki.intermediate.Sign(&Certificate{
+		Name:      name,
+		Groups:    []string{group},
+		Protocols: []string{testProtocol},
+		NotBefore: testNow.Add(-time.Minute),
+		NotAfter:  testNow.Add(24 * time.Hour),
+		DH:        dh.X25519.String(),
+		PublicKey: kp.Public().Bytes(),
+	})
+	require.NoError(err, "Sign")
+
+	return kp, []*Certificate{leaf, pki.intermediate.Certificate}
+}
+
+func (pki *testPKI) issueExpired(t *testing.T, kp dh.Keypair) *Certificate {
+	leaf, err := pki.intermediate.Sign(&Certificate{
+		Name:      "expired",
+		NotBefore: testNow.Add(-48 * time.Hour),
+		NotAfter:  testNow.Add(-24 * time.Hour),
+		DH:        dh.X25519.String(),
+		PublicKey: kp.Public().Bytes(),
+	})
+	require.NoError(t, err, "Sign - expired")
+	return leaf
+}
+
+func TestCert(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Serialization", testCertSerialization},
+		{"Verify", testCertVerify},
+		{"Handshake", testCertHandshake},
+		{"Handshake/Untrusted", testCertHandshakeUntrusted},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testCertSerialization(t *testing.T) {
+	require := require.New(t)
+
+	pki := newTestPKI(t)
+	_, chain := pki.issue(t, "alice", "clients")
+
+	b, err := chain[0].MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	c, err := ParseCertificate(b)
+	require.NoError(err, "ParseCertificate")
+	require.Equal(chain[0], c, "ParseCertificate - round trip")
+
+	b, err = MarshalChain(chain)
+	require.NoError(err, "MarshalChain")
+	parsed, err := ParseChain(b)
+	require.NoError(err, "ParseChain")
+	require.Equal(chain, parsed, "ParseChain - round trip")
+
+	_, err = ParseChain(b[:len(b)-1])
+	require.ErrorIs(err, ErrMalformed, "ParseChain - truncated")
+	_, err = ParseCertificate(append(append([]byte{}, b[3:]...), 0))
+	require.ErrorIs(err, ErrMalformed, "ParseCertificate - trailing garbage")
+
+	require.True(pki.root.Certificate.IsSelfSigned(), "IsSelfSigned - root")
+	require.False(pki.intermediate.Certificate.IsSelfSigned(), "IsSelfSigned - intermediate")
+}
+
+func testCertVerify(t *testing.T) {
+	require := require.New(t)
+
+	pki := newTestPKI(t)
+	kp, chain := pki.issue(t, "alice", "clients")
+	protocol, err := nyquist.NewProtocol(testProtocol)
+	require.NoError(err, "NewProtocol")
+
+	opts := &VerifyOptions{
+		Roots:        []*Certificate{pki.root.Certificate},
+		Protocol:     protocol,
+		RemoteStatic: kp.Public(),
+		Now:          testClock,
+	}
+	require.NoError(Verify(chain, opts), "Verify")
+	require.NoError(Verify(append(chain, pki.root.Certificate), opts), "Verify - with root")
+
+	// Expired, and not yet valid.
+	expiredOpts := *opts
+	expiredOpts.Now = func() time.Time { return testNow.Add(48 * time.Hour) }
+	require.ErrorIs(Verify(chain, &expiredOpts), ErrExpired, "Verify - expired")
+	expiredOpts.Now = func() time.Time { return testNow.Add(-2 * time.Minute) }
+	require.ErrorIs(Verify(chain, &expiredOpts), ErrExpired, "Verify - not yet valid")
+
+	// Certified key does not match.
+	otherKp, _ := pki.issue(t, "bob", "servers")
+	mismatchOpts := *opts
+	mismatchOpts.RemoteStatic = otherKp.Public()
+	require.ErrorIs(Verify(chain, &mismatchOpts), ErrKeyMismatch, "Verify - key mismatch")
+
+	// Protocol not allowed.
+	otherProtocol, err := nyquist.NewProtocol("Noise_IK_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+	protoOpts := *opts
+	protoOpts.Protocol = otherProtocol
+	require.ErrorIs(Verify(chain, &protoOpts), ErrProtocolNotAllowed, "Verify - protocol")
+
+	// Unknown root.
+	otherPKI := newTestPKI(t)
+	rootOpts := *opts
+	rootOpts.Roots = []*Certificate{otherPKI.root.Certificate}
+	require.ErrorIs(Verify(chain, &rootOpts), ErrUnknownIssuer, "Verify - unknown root")
+
+	// Chain with a bogus self-signed root masquerading as the real one.
+	require.ErrorIs(Verify(append(chain, otherPKI.root.Certificate), opts), ErrUnknownIssuer, "Verify - bogus root")
+
+	// Tampered certificate.
+	tampered := *chain[0]
+	tampered.Groups = []string{"servers"}
+	require.ErrorIs(Verify([]*Certificate{&tampered, chain[1]}, opts), ErrBadSignature, "Verify - tampered")
+
+	// Group not allowed by the intermediate.
+	badGroup, err := pki.intermediate.Sign(&Certificate{
+		Name:      "mallory",
+		Groups:    []string{"admins"},
+		NotBefore: testNow.Add(-time.Minute),
+		NotAfter:  testNow.Add(time.Hour),
+		DH:        dh.X25519.String(),
+		PublicKey: kp.Public().Bytes(),
+	})
+	require.NoError(err, "Sign - bad group")
+	require.ErrorIs(Verify([]*Certificate{badGroup, chain[1]}, opts), ErrGroupNotAllowed, "Verify - bad group")
+}
+
+func doHandshake(t *testing.T, aliceCfg, bobCfg *Config, aliceStatic, bobStatic dh.Keypair) (*Handshake, *Handshake, error) {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol(testProtocol)
+	require.NoError(err, "NewProtocol")
+
+	aliceHs, err := NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: aliceStatic,
+		IsInitiator: true,
+	}, aliceCfg)
+	require.NoError(err, "NewHandshake(alice)")
+	bobHs, err := NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: bobStatic,
+	}, bobCfg)
+	require.NoError(err, "NewHandshake(bob)")
+
+	msg, err := aliceHs.WriteMessage(nil, []byte("hello"))
+	require.NoError(err, "aliceHs.WriteMessage(1)")
+	payload, err := bobHs.ReadMessage(nil, msg)
+	require.NoError(err, "bobHs.ReadMessage(1)")
+	require.Equal([]byte("hello"), payload, "bobHs.ReadMessage(1)")
+
+	msg, err = bobHs.WriteMessage(nil, []byte("bob payload"))
+	require.NoError(err, "bobHs.WriteMessage(1)")
+	payload, err = aliceHs.ReadMessage(nil, msg)
+	if err != nil {
+		return aliceHs, bobHs, err
+	}
+	require.Equal([]byte("bob payload"), payload, "aliceHs.ReadMessage(1)")
+
+	msg, err = aliceHs.WriteMessage(nil, []byte("alice payload"))
+	require.Equal(nyquist.ErrDone, err, "aliceHs.WriteMessage(2)")
+	payload, err = bobHs.ReadMessage(nil, msg)
+	if err != nyquist.ErrDone {
+		return aliceHs, bobHs, err
+	}
+	require.Equal([]byte("alice payload"), payload, "bobHs.ReadMessage(2)")
+
+	return aliceHs, bobHs, nil
+}
+
+func testCertHandshake(t *testing.T) {
+	require := require.New(t)
+
+	pki := newTestPKI(t)
+	aliceStatic, aliceChain := pki.issue(t, "alice", "clients")
+	bobStatic, bobChain := pki.issue(t, "bob", "servers")
+	roots := []*Certificate{pki.root.Certificate}
+
+	aliceHs, bobHs, err := doHandshake(
+		t,
+		&Config{Chain: aliceChain, Roots: roots, Now: testClock},
+		&Config{Chain: bobChain, Roots: roots, Now: testClock},
+		aliceStatic,
+		bobStatic,
+	)
+	require.NoError(err, "Handshake")
+	require.Equal(bobChain[0], aliceHs.PeerCertificate(), "aliceHs.PeerCertificate()")
+	require.Equal(aliceChain, bobHs.PeerChain(), "bobHs.PeerChain()")
+	require.Equal("alice", bobHs.PeerCertificate().Name, "bobHs.PeerCertificate().Name")
+
+	// The local chain must match the local static key.
+	protocol, err := nyquist.NewProtocol(testProtocol)
+	require.NoError(err, "NewProtocol")
+	_, err = NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: aliceStatic,
+		IsInitiator: true,
+	}, &Config{Chain: bobChain, Roots: roots})
+	require.ErrorIs(err, errChainMismatch, "NewHandshake - mismatched chain")
+	_, err = NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: aliceStatic,
+		IsInitiator: true,
+	}, &Config{Roots: roots})
+	require.ErrorIs(err, errMissingChain, "NewHandshake - missing chain")
+}
+
+func testCertHandshakeUntrusted(t *testing.T) {
+	require := require.New(t)
+
+	pki, otherPKI := newTestPKI(t), newTestPKI(t)
+	aliceStatic, aliceChain := pki.issue(t, "alice", "clients")
+	bobStatic, bobChain := otherPKI.issue(t, "bob", "servers")
+
+	// Bob's certificate was issued by an untrusted root.
+	aliceHs, _, err := doHandshake(
+		t,
+		&Config{Chain: aliceChain, Roots: []*Certificate{pki.root.Certificate}, Now: testClock},
+		&Config{Chain: bobChain, Roots: []*Certificate{pki.root.Certificate}, Now: testClock},
+		aliceStatic,
+		bobStatic,
+	)
+	require.ErrorIs(err, ErrUnknownIssuer, "Handshake - untrusted responder")
+	require.Nil(aliceHs.PeerCertificate(), "aliceHs.PeerCertificate()")
+
+	// Bob's certificate is presented after it expired.
+	bobChain[0] = pki.issueExpired(t, bobStatic)
+	bobChain[1] = pki.intermediate.Certificate
+	_, _, err = doHandshake(
+		t,
+		&Config{Chain: aliceChain, Roots: []*Certificate{pki.root.Certificate}, Now: testClock},
+		&Config{Chain: bobChain, Roots: []*Certificate{pki.root.Certificate}, Now: testClock},
+		aliceStatic,
+		bobStatic,
+	)
+	require.ErrorIs(err, ErrExpired, "Handshake - expired responder")
+
+	// Alice's certificate (in the final message) was issued by an
+	// untrusted root, after the underlying handshake completes.
+	aliceStatic, aliceChain = otherPKI.issue(t, "alice", "clients")
+	bobStatic, bobChain = pki.issue(t, "bob", "servers")
+	_, bobHs, err := doHandshake(
+		t,
+		&Config{Chain: aliceChain, Roots: []*Certificate{pki.root.Certificate}, Now: testClock},
+		&Config{Chain: bobChain, Roots: []*Certificate{pki.root.Certificate}, Now: testClock},
+		aliceStatic,
+		bobStatic,
+	)
+	require.ErrorIs(err, ErrUnknownIssuer, "Handshake - untrusted initiator")
+	require.Nil(bobHs.PeerCertificate(), "bobHs.PeerCertificate()")
+
+	status := bobHs.GetStatus()
+	require.ErrorIs(status.Err, ErrUnknownIssuer, "bobHs.GetStatus().Err")
+	require.Nil(status.CipherStates, "bobHs.GetStatus().CipherStates")
+	require.Nil(status.HandshakeHash, "bobHs.GetStatus().HandshakeHash")
+	require.Equal(aliceStatic.Public(), status.RemoteStatic, "bobHs.GetStatus().RemoteStatic")
+
+	_, err = bobHs.ReadMessage(nil, []byte("retry"))
+	require.ErrorIs(err, ErrUnknownIssuer, "bobHs.ReadMessage() - after failure")
+}
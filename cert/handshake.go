@@ -0,0 +1,285 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package cert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var (
+	errMissingChain  = errors.New("nyquist/cert: local certificate chain required")
+	errChainMismatch = errors.New("nyquist/cert: local certificate does not match local static key")
+	errTruncated     = errors.New("nyquist/cert: truncated certificate chain")
+)
+
+// Config is the certificate handshake configuration.
+type Config struct {
+	// Chain is the local certificate chain (leaf first), sent to the peer
+	// in the payload of the message that contains the local static key.
+	Chain []*Certificate
+
+	// Roots is the set of trusted root CA certificates, used to verify the
+	// peer's certificate chain.
+	Roots []*Certificate
+
+	// Now is the clock used to check validity periods, if nil `time.Now`
+	// will be used.
+	Now func() time.Time
+}
+
+// Handshake is a `nyquist.HandshakeState` wrapper that exchanges and
+// verifies certificate chains.
+//
+// The certificate chain is prepended (with a 16-bit big-endian length) to
+// the payload of each handshake message that contains a `s` token, and
+// the peer's chain is verified against `HandshakeStatus.RemoteStatic` as
+// soon as it is received.  Static keys that are not transmitted during the
+// handshake (ie: pre-messages) are not certified.
+type Handshake struct {
+	hs       *nyquist.HandshakeState
+	cfg      *Config
+	protocol *nyquist.Protocol
+	messages []pattern.Message
+	chain    []byte
+
+	peerChain    []*Certificate
+	messageIndex int
+	isInitiator  bool
+
+	// failedStatus is the status to report iff the peer's certificate
+	// chain failed verification.
+	failedStatus *nyquist.HandshakeStatus
+}
+
+// HandshakeState returns the wrapped HandshakeState.
+func (h *Handshake) HandshakeState() *nyquist.HandshakeState {
+	return h.hs
+}
+
+// GetStatus returns the HandshakeState's status.  If the peer's certificate
+// chain failed verification, the status's error will be the verification
+// failure, even if the underlying handshake completed, and no CipherStates
+// will be returned.
+func (h *Handshake) GetStatus() *nyquist.HandshakeStatus {
+	if h.failedStatus != nil {
+		return h.failedStatus
+	}
+	return h.hs.GetStatus()
+}
+
+// PeerChain returns the peer's verified certificate chain, if any.
+func (h *Handshake) PeerChain() []*Certificate {
+	return h.peerChain
+}
+
+// PeerCertificate returns the peer's verified leaf certificate, if any.
+func (h *Handshake) PeerCertificate() *Certificate {
+	if len(h.peerChain) == 0 {
+		return nil
+	}
+	return h.peerChain[0]
+}
+
+// Reset clears the Handshake, to prevent future calls.
+func (h *Handshake) Reset() {
+	h.hs.Reset()
+	h.peerChain = nil
+}
+
+// WriteMessage processes a write step of the handshake protocol, appending
+// the handshake protocol message to dst, and returning the potentially new
+// slice.
+//
+// Iff the handshake is complete, the error returned will be `ErrDone`.
+func (h *Handshake) WriteMessage(dst, payload []byte) ([]byte, error) {
+	if h.failedStatus != nil {
+		return nil, h.failedStatus.Err
+	}
+	if h.hasStatic(true) {
+		payload = append(append([]byte{}, h.chain...), payload...)
+	}
+
+	dst, err := h.hs.WriteMessage(dst, payload)
+	if err == nil || err == nyquist.ErrDone {
+		h.messageIndex++
+	}
+
+	return dst, err
+}
+
+// ReadMessage processes a read step of the handshake protocol, appending
+// the authentiated/decrypted message payload to dst, and returning the
+// potentially new slice.
+//
+// Iff the handshake is complete, the error returned will be `ErrDone`.
+func (h *Handshake) ReadMessage(dst, payload []byte) ([]byte, error) {
+	if h.failedStatus != nil {
+		return dst, h.failedStatus.Err
+	}
+	hasStatic := h.hasStatic(false)
+
+	plaintext, err := h.hs.ReadMessage(nil, payload)
+	if err != nil && err != nyquist.ErrDone {
+		return dst, err
+	}
+	h.messageIndex++
+
+	if hasStatic {
+		var verifyErr error
+		if plaintext, verifyErr = h.verifyPeerChain(plaintext); verifyErr != nil {
+			h.onVerifyFailure(verifyErr)
+			return dst, verifyErr
+		}
+	}
+
+	return append(dst, plaintext...), err
+}
+
+func (h *Handshake) onVerifyFailure(err error) {
+	// The verification may fail after the underlying handshake has
+	// completed (eg: the final message of XX), so clear the CipherStates,
+	// and ensure that the status reports the failure.
+	status := h.hs.GetStatus()
+	for _, cs := range status.CipherStates {
+		if cs != nil {
+			cs.Reset()
+		}
+	}
+	h.failedStatus = &nyquist.HandshakeStatus{
+		Err:             err,
+		LocalEphemeral:  status.LocalEphemeral,
+		RemoteStatic:    status.RemoteStatic,
+		RemoteEphemeral: status.RemoteEphemeral,
+		RemoteSigning:   status.RemoteSigning,
+	}
+	h.Reset()
+}
+
+func (h *Handshake) hasStatic(isWrite bool) bool {
+	if h.messageIndex >= len(h.messages) {
+		return false
+	}
+
+	isInitiatorMessage := h.messageIndex&1 == 0
+	if isInitiatorMessage != (h.isInitiator == isWrite) {
+		return false
+	}
+	for _, token := range h.messages[h.messageIndex] {
+		if token == pattern.Token_s {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handshake) verifyPeerChain(plaintext []byte) ([]byte, error) {
+	if len(plaintext) < 2 {
+		return nil, errTruncated
+	}
+	chainLen := int(binary.BigEndian.Uint16(plaintext))
+	plaintext = plaintext[2:]
+	if len(plaintext) < chainLen {
+		return nil, errTruncated
+	}
+
+	chain, err := ParseChain(plaintext[:chainLen])
+	if err != nil {
+		return nil, err
+	}
+	if err = Verify(chain, &VerifyOptions{
+		Roots:        h.cfg.Roots,
+		Protocol:     h.protocol,
+		RemoteStatic: h.hs.GetStatus().RemoteStatic,
+		Now:          h.cfg.Now,
+	}); err != nil {
+		return nil, err
+	}
+	h.peerChain = chain
+
+	return plaintext[chainLen:], nil
+}
+
+// NewHandshake constructs a new certificate exchanging handshake from the
+// provided configuration.
+func NewHandshake(hsCfg *nyquist.HandshakeConfig, cfg *Config) (*Handshake, error) {
+	if hsCfg.Protocol == nil || hsCfg.Protocol.Pattern == nil {
+		return nil, errNoProtocol
+	}
+
+	h := &Handshake{
+		cfg:         cfg,
+		protocol:    hsCfg.Protocol,
+		messages:    hsCfg.Protocol.Pattern.Messages(),
+		isInitiator: hsCfg.IsInitiator,
+	}
+
+	// If the local static key is transmitted, validate and pre-serialize
+	// the local certificate chain.
+	for i, msg := range h.messages {
+		if (i&1 == 0) != h.isInitiator {
+			continue
+		}
+		for _, token := range msg {
+			if token != pattern.Token_s || h.chain != nil {
+				continue
+			}
+			if len(cfg.Chain) == 0 {
+				return nil, errMissingChain
+			}
+			leaf := cfg.Chain[0]
+			if hsCfg.LocalStatic == nil || leaf.IsCA || leaf.DH != h.protocol.DH.String() || !bytes.Equal(leaf.PublicKey, hsCfg.LocalStatic.Public().Bytes()) {
+				return nil, errChainMismatch
+			}
+			b, err := MarshalChain(cfg.Chain)
+			if err != nil {
+				return nil, err
+			}
+			if len(b) > math.MaxUint16 {
+				return nil, errFieldTooLong
+			}
+			h.chain = binary.BigEndian.AppendUint16(nil, uint16(len(b)))
+			h.chain = append(h.chain, b...)
+		}
+	}
+
+	var err error
+	if h.hs, err = nyquist.NewHandshake(hsCfg); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
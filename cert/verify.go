@@ -0,0 +1,170 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package cert
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+var (
+	// ErrExpired is the error returned when a certificate is outside of
+	// its validity period.
+	ErrExpired = errors.New("nyquist/cert: certificate expired or not yet valid")
+
+	// ErrBadSignature is the error returned when a certificate signature
+	// is invalid.
+	ErrBadSignature = errors.New("nyquist/cert: invalid certificate signature")
+
+	// ErrUnknownIssuer is the error returned when a certificate chain does
+	// not lead to a configured root.
+	ErrUnknownIssuer = errors.New("nyquist/cert: unknown certificate issuer")
+
+	// ErrKeyMismatch is the error returned when the certified key does not
+	// match the peer's static public key.
+	ErrKeyMismatch = errors.New("nyquist/cert: certified key does not match peer static key")
+
+	// ErrProtocolNotAllowed is the error returned when the certified key is
+	// not allowed to be used with the protocol.
+	ErrProtocolNotAllowed = errors.New("nyquist/cert: protocol not allowed by certificate")
+
+	// ErrGroupNotAllowed is the error returned when a certificate claims a
+	// group that the issuing CA is not allowed to grant.
+	ErrGroupNotAllowed = errors.New("nyquist/cert: group not allowed by issuer")
+
+	errNoChain    = errors.New("nyquist/cert: empty certificate chain")
+	errLeafIsCA   = errors.New("nyquist/cert: leaf certificate is a CA")
+	errNoRoots    = errors.New("nyquist/cert: no roots configured")
+	errNoProtocol = errors.New("nyquist/cert: no protocol specified")
+)
+
+// VerifyOptions is the certificate chain verification options.
+type VerifyOptions struct {
+	// Roots is the set of trusted root CA certificates.
+	Roots []*Certificate
+
+	// Protocol is the protocol the certified key is being used with.
+	Protocol *nyquist.Protocol
+
+	// RemoteStatic is the peer's static public key, which must match the
+	// key certified by the leaf certificate.
+	RemoteStatic dh.PublicKey
+
+	// Now is the clock used to check validity periods, if nil `time.Now`
+	// will be used.
+	Now func() time.Time
+}
+
+func (opts *VerifyOptions) now() time.Time {
+	if opts.Now == nil {
+		return time.Now()
+	}
+	return opts.Now()
+}
+
+// Verify verifies a certificate chain (leaf first, optionally terminated
+// by the root), returning nil iff the leaf certificate is trusted.
+func Verify(chain []*Certificate, opts *VerifyOptions) error {
+	if len(chain) == 0 {
+		return errNoChain
+	}
+	if len(opts.Roots) == 0 {
+		return errNoRoots
+	}
+	if opts.Protocol == nil {
+		return errNoProtocol
+	}
+
+	leaf := chain[0]
+	if leaf.IsCA {
+		return errLeafIsCA
+	}
+	if leaf.DH != opts.Protocol.DH.String() || opts.RemoteStatic == nil || !bytes.Equal(leaf.PublicKey, opts.RemoteStatic.Bytes()) {
+		return ErrKeyMismatch
+	}
+	if !leaf.AllowsProtocol(opts.Protocol.String()) {
+		return ErrProtocolNotAllowed
+	}
+
+	now := opts.now()
+	for i, c := range chain {
+		if !c.IsValidAt(now) {
+			return ErrExpired
+		}
+
+		var parent *Certificate
+		switch {
+		case i+1 < len(chain):
+			parent = chain[i+1]
+		case c.IsSelfSigned():
+			// The chain included a self-signed root, which must be
+			// in the set of configured roots.
+			parent = findRoot(opts.Roots, c.PublicKey)
+			if parent == nil || !bytes.Equal(parent.Signature, c.Signature) {
+				return ErrUnknownIssuer
+			}
+			continue
+		default:
+			if parent = findRoot(opts.Roots, c.Issuer); parent == nil {
+				return ErrUnknownIssuer
+			}
+			if !parent.IsValidAt(now) {
+				return ErrExpired
+			}
+		}
+
+		if err := c.CheckSignatureFrom(parent); err != nil {
+			return err
+		}
+		if len(parent.Groups) > 0 {
+			for _, group := range c.Groups {
+				if !parent.IsMemberOf(group) {
+					return ErrGroupNotAllowed
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func findRoot(roots []*Certificate, publicKey []byte) *Certificate {
+	for _, root := range roots {
+		if root.IsCA && ed25519.PublicKey(root.PublicKey).Equal(ed25519.PublicKey(publicKey)) {
+			return root
+		}
+	}
+	return nil
+}
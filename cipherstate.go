@@ -59,6 +59,8 @@ type CipherState struct {
 	k    []byte
 	n    uint64
 
+	keyLog *cipherStateKeyLog
+
 	maxMessageSize int
 	aeadOverhead   int
 }
@@ -179,9 +181,17 @@ func (cs *CipherState) Rekey() error {
 		newKey = truncateTo32BytesMax(newKey)
 	}
 
-	err := cs.setKey(newKey)
+	if err := cs.setKey(newKey); err != nil {
+		return err
+	}
 
-	return err
+	if cs.keyLog != nil {
+		// Key logging is best-effort, as failing to rekey after the key
+		// has changed would desynchronize the peers.
+		_ = cs.keyLog.writeRekey(cs.k)
+	}
+
+	return nil
 }
 
 // Reset sets the CipherState to a un-keyed state.
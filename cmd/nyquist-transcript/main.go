@@ -0,0 +1,175 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// nyquist-transcript decodes recorded Noise Protocol Framework sessions.
+//
+// Each direction of the session is read from a separate file, consisting of
+// messages prefixed by their length as a 16-bit big-endian integer.  Key
+// material is provided as hex encoded strings, or via a key log written by
+// `nyquist.HandshakeConfig.KeyLog`.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/transcript"
+)
+
+type hexList [][]byte
+
+func (l *hexList) String() string {
+	var s []string
+	for _, v := range *l {
+		s = append(s, hex.EncodeToString(v))
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *hexList) Set(s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, b)
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "nyquist-transcript: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var (
+		protocolName  = flag.String("protocol", "", "protocol name (required)")
+		initiatorFile = flag.String("initiator", "", "file containing the initiator's messages")
+		responderFile = flag.String("responder", "", "file containing the responder's messages")
+		keyLogFile    = flag.String("keylog", "", "key log file")
+		role          = flag.String("role", "initiator", "role of the observed party (initiator, responder)")
+		prologueHex   = flag.String("prologue", "", "hex encoded prologue")
+		staticHex     = flag.String("static", "", "hex encoded static private key of the observed party")
+		ephemeralHex  = flag.String("ephemeral", "", "hex encoded ephemeral private key of the observed party")
+		remoteHex     = flag.String("remote-static", "", "hex encoded pre-message static public key of the peer")
+		psks          hexList
+	)
+	flag.Var(&psks, "psk", "hex encoded pre-shared key (may be repeated)")
+	flag.Parse()
+
+	protocol, err := nyquist.NewProtocol(*protocolName)
+	if err != nil {
+		return fmt.Errorf("invalid protocol '%s': %w", *protocolName, err)
+	}
+
+	cfg := &transcript.Config{
+		Protocol:      protocol,
+		PreSharedKeys: psks,
+	}
+	switch *role {
+	case "initiator":
+		cfg.IsInitiator = true
+	case "responder":
+	default:
+		return fmt.Errorf("invalid role '%s'", *role)
+	}
+	if cfg.Prologue, err = hex.DecodeString(*prologueHex); err != nil {
+		return fmt.Errorf("invalid prologue: %w", err)
+	}
+	if *staticHex != "" {
+		var b []byte
+		if b, err = hex.DecodeString(*staticHex); err == nil {
+			cfg.LocalStatic, err = protocol.DH.ParsePrivateKey(b)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid static private key: %w", err)
+		}
+	}
+	if *ephemeralHex != "" {
+		var b []byte
+		if b, err = hex.DecodeString(*ephemeralHex); err == nil {
+			cfg.LocalEphemeral, err = protocol.DH.ParsePrivateKey(b)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ephemeral private key: %w", err)
+		}
+	}
+	if *remoteHex != "" {
+		var b []byte
+		if b, err = hex.DecodeString(*remoteHex); err == nil {
+			cfg.RemoteStatic, err = protocol.DH.ParsePublicKey(b)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid remote static public key: %w", err)
+		}
+	}
+	if *keyLogFile != "" {
+		f, err := os.Open(*keyLogFile)
+		if err != nil {
+			return err
+		}
+		cfg.KeyLog, err = transcript.ParseKeyLog(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	readFrames := func(fn string) ([][]byte, error) {
+		if fn == "" {
+			return nil, nil
+		}
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return transcript.ReadFrames(f)
+	}
+	initiatorMsgs, err := readFrames(*initiatorFile)
+	if err != nil {
+		return fmt.Errorf("failed to read initiator messages: %w", err)
+	}
+	responderMsgs, err := readFrames(*responderFile)
+	if err != nil {
+		return fmt.Errorf("failed to read responder messages: %w", err)
+	}
+
+	t, err := transcript.Decode(cfg, initiatorMsgs, responderMsgs)
+	if err != nil {
+		return err
+	}
+
+	return t.Dump(os.Stdout)
+}
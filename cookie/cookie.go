@@ -0,0 +1,370 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package cookie implements an opt-in stateless cookie challenge, that
+// allows responders to avoid processing the initiator's first handshake
+// message (and the associated DH operations) when under load, until the
+// initiator has proven that it can receive traffic at its source address.
+//
+// The initiator wraps its first handshake message in an initiation frame,
+// that includes two MACs, in the manner of WireGuard.  The first is keyed
+// by the responder's static public key, and is always checked.  The second
+// is keyed by the most recently received cookie (or all zeros).  When under
+// load, a responder that receives an initiation frame without a valid
+// second MAC answers with a cookie reply frame, instead of processing the
+// handshake message.  The cookie is a MAC over the initiator's source
+// address keyed by a periodically rotated secret, so the responder keeps no
+// per-initiator state.
+//
+// The cookie reply frame carries the cookie encrypted with
+// XChaCha20-Poly1305, keyed by the responder's static public key, with the
+// first MAC of the initiation frame as the associated data.  An attacker
+// that does not know the responder's static public key can neither forge
+// cookie replies, nor learn the cookie for a spoofed source address.
+//
+// The initiation and cookie reply frames start with a message type byte
+// (`MessageInitiation` and `MessageCookieReply`), other message type values
+// are left to the application.
+package cookie // import "gitlab.com/yawning/nyquist.git/cookie"
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const (
+	// MessageInitiation is the message type of initiation frames.
+	MessageInitiation = 0x01
+
+	// MessageCookieReply is the message type of cookie reply frames.
+	MessageCookieReply = 0x02
+
+	// MACSize is the size of a cookie or MAC in bytes.
+	MACSize = 16
+
+	// InitiationOverhead is the size of the initiation frame overhead in
+	// bytes.
+	InitiationOverhead = 1 + 2*MACSize
+
+	// CookieReplySize is the size of a cookie reply frame in bytes.
+	CookieReplySize = 1 + chacha20poly1305.NonceSizeX + MACSize + chacha20poly1305.Overhead
+
+	// DefaultRotationInterval is the default interval at which the cookie
+	// secret is rotated.
+	DefaultRotationInterval = 2 * time.Minute
+
+	secretSize = 32
+
+	labelMAC1   = "mac1----"
+	labelCookie = "cookie--"
+)
+
+var (
+	// ErrInvalidFrame is the error returned when a frame is malformed.
+	ErrInvalidFrame = errors.New("nyquist/cookie: invalid frame")
+
+	// ErrInvalidMAC is the error returned when an initiation frame's MAC
+	// keyed by the responder's static public key is invalid.
+	ErrInvalidMAC = errors.New("nyquist/cookie: invalid mac")
+
+	// ErrUnexpectedReply is the error returned when a cookie reply does
+	// not correspond to the most recent initiation frame.
+	ErrUnexpectedReply = errors.New("nyquist/cookie: unexpected cookie reply")
+
+	zeroMAC [MACSize]byte
+)
+
+// Config is the cookie challenge configuration.
+type Config struct {
+	// UnderLoad is called by the responder for each initiation frame, and
+	// returns true iff the responder is under load and cookies should be
+	// required.  If nil, the responder is always considered to be under
+	// load.
+	UnderLoad func() bool
+
+	// RotationInterval is the interval at which the responder's cookie
+	// secret is rotated, and after which initiators discard received
+	// cookies.  If 0, `DefaultRotationInterval` will be used.
+	RotationInterval time.Duration
+
+	// Now is the clock, if nil `time.Now` will be used.
+	Now func() time.Time
+
+	// Rng is the entropy source used to generate cookie secrets and
+	// cookie reply nonces, if nil `crypto/rand.Reader` will be used.
+	Rng io.Reader
+}
+
+func (cfg *Config) getRotationInterval() time.Duration {
+	if cfg.RotationInterval != 0 {
+		return cfg.RotationInterval
+	}
+	return DefaultRotationInterval
+}
+
+func (cfg *Config) getNow() func() time.Time {
+	if cfg.Now != nil {
+		return cfg.Now
+	}
+	return time.Now
+}
+
+func (cfg *Config) getRng() io.Reader {
+	if cfg.Rng != nil {
+		return cfg.Rng
+	}
+	return rand.Reader
+}
+
+// MessageType returns the message type of a frame, or 0 if the frame is
+// empty.
+func MessageType(frame []byte) byte {
+	if len(frame) == 0 {
+		return 0
+	}
+	return frame[0]
+}
+
+// Checker is the responder side of the cookie challenge.  It is safe for
+// concurrent use.
+type Checker struct {
+	l sync.Mutex
+
+	secrets    [2][secretSize]byte // Current, previous.
+	hasCurrent bool
+	hasPrev    bool
+	rotatedAt  time.Time
+
+	mac1Key   []byte
+	cookieKey []byte
+
+	interval  time.Duration
+	underLoad func() bool
+	now       func() time.Time
+	rng       io.Reader
+}
+
+// Check checks an initiation frame received from the source address src
+// (eg: the IP address and port).
+//
+// If the handshake message should be processed, it is returned, and reply
+// will be nil.  Otherwise reply is a cookie reply frame that should be sent
+// to src, and the handshake message must be dropped without calling
+// `ReadMessage`.  Frames with an invalid MAC keyed by the responder's
+// static public key are rejected with `ErrInvalidMAC`, regardless of load.
+func (c *Checker) Check(src, frame []byte) (msg, reply []byte, err error) {
+	if len(frame) < InitiationOverhead || frame[0] != MessageInitiation {
+		return nil, nil, ErrInvalidFrame
+	}
+	mac1Off, mac2Off := len(frame)-2*MACSize, len(frame)-MACSize
+	mac1 := frame[mac1Off:mac2Off]
+	if !hmac.Equal(mac(c.mac1Key, frame[:mac1Off]), mac1) {
+		return nil, nil, ErrInvalidMAC
+	}
+	msg = frame[1:mac1Off]
+
+	if c.underLoad != nil && !c.underLoad() {
+		return msg, nil, nil
+	}
+
+	cookies, nonce, err := c.cookies(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cookie := range cookies {
+		if hmac.Equal(mac(cookie, frame[:mac2Off]), frame[mac2Off:]) {
+			return msg, nil, nil
+		}
+	}
+
+	aead, err := chacha20poly1305.NewX(c.cookieKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	reply = make([]byte, 0, CookieReplySize)
+	reply = append(reply, MessageCookieReply)
+	reply = append(reply, nonce...)
+	reply = aead.Seal(reply, nonce, cookies[0], mac1)
+
+	return nil, reply, nil
+}
+
+// cookies returns the cookies for src, under the current and (if any)
+// previous secret, along with a fresh cookie reply nonce.
+func (c *Checker) cookies(src []byte) ([][]byte, []byte, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if now := c.now(); !c.hasCurrent || now.Sub(c.rotatedAt) >= c.interval {
+		var secret [secretSize]byte
+		if _, err := io.ReadFull(c.rng, secret[:]); err != nil {
+			return nil, nil, err
+		}
+
+		// If the previous rotation was missed entirely, the old secret is
+		// too old to accept.
+		c.hasPrev = c.hasCurrent && now.Sub(c.rotatedAt) < 2*c.interval
+		c.secrets[1], c.secrets[0] = c.secrets[0], secret
+		c.rotatedAt, c.hasCurrent = now, true
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(c.rng, nonce); err != nil {
+		return nil, nil, err
+	}
+
+	cookies := [][]byte{mac(c.secrets[0][:], src)}
+	if c.hasPrev {
+		cookies = append(cookies, mac(c.secrets[1][:], src))
+	}
+
+	return cookies, nonce, nil
+}
+
+// NewChecker creates a new Checker for the responder's static public key.
+func NewChecker(cfg *Config, localStatic dh.PublicKey) *Checker {
+	publicKey := localStatic.Bytes()
+	return &Checker{
+		mac1Key:   labeledHash(labelMAC1, publicKey),
+		cookieKey: labeledHash(labelCookie, publicKey),
+		interval:  cfg.getRotationInterval(),
+		underLoad: cfg.UnderLoad,
+		now:       cfg.getNow(),
+		rng:       cfg.getRng(),
+	}
+}
+
+// Generator is the initiator side of the cookie challenge, for a single
+// responder.  It is safe for concurrent use.
+type Generator struct {
+	l sync.Mutex
+
+	mac1Key   []byte
+	cookieKey []byte
+
+	cookie     []byte
+	receivedAt time.Time
+	lastMAC1   []byte
+	lifetime   time.Duration
+	now        func() time.Time
+}
+
+// Frame wraps the initiator's first handshake message in an initiation
+// frame, including a MAC keyed by the responder's static public key, and
+// a MAC keyed by the most recently received cookie, if any.
+func (g *Generator) Frame(msg []byte) []byte {
+	g.l.Lock()
+	defer g.l.Unlock()
+
+	frame := make([]byte, 0, InitiationOverhead+len(msg))
+	frame = append(frame, MessageInitiation)
+	frame = append(frame, msg...)
+
+	g.lastMAC1 = mac(g.mac1Key, frame)
+	frame = append(frame, g.lastMAC1...)
+
+	if g.cookie != nil && g.now().Sub(g.receivedAt) >= g.lifetime {
+		g.cookie = nil
+	}
+	if g.cookie != nil {
+		frame = append(frame, mac(g.cookie, frame)...)
+	} else {
+		frame = append(frame, zeroMAC[:]...)
+	}
+
+	return frame
+}
+
+// ConsumeReply consumes a cookie reply frame, which must correspond to the
+// most recent initiation frame.  The handshake message should be sent again
+// in a new initiation frame.
+func (g *Generator) ConsumeReply(reply []byte) error {
+	if len(reply) != CookieReplySize || reply[0] != MessageCookieReply {
+		return ErrInvalidFrame
+	}
+
+	g.l.Lock()
+	defer g.l.Unlock()
+
+	if g.lastMAC1 == nil {
+		return ErrUnexpectedReply
+	}
+
+	aead, err := chacha20poly1305.NewX(g.cookieKey)
+	if err != nil {
+		return err
+	}
+	nonce := reply[1 : 1+chacha20poly1305.NonceSizeX]
+	cookie, err := aead.Open(nil, nonce, reply[1+chacha20poly1305.NonceSizeX:], g.lastMAC1)
+	if err != nil {
+		return ErrUnexpectedReply
+	}
+	g.cookie = cookie
+	g.receivedAt = g.now()
+	g.lastMAC1 = nil
+
+	return nil
+}
+
+// NewGenerator creates a new Generator for the responder's static public
+// key.
+func NewGenerator(cfg *Config, remoteStatic dh.PublicKey) *Generator {
+	publicKey := remoteStatic.Bytes()
+	return &Generator{
+		mac1Key:   labeledHash(labelMAC1, publicKey),
+		cookieKey: labeledHash(labelCookie, publicKey),
+		lifetime:  cfg.getRotationInterval(),
+		now:       cfg.getNow(),
+	}
+}
+
+func labeledHash(label string, publicKey []byte) []byte {
+	h, _ := blake2s.New256(nil)
+	_, _ = h.Write([]byte(label))
+	_, _ = h.Write(publicKey)
+	return h.Sum(nil)
+}
+
+func mac(key, data []byte) []byte {
+	h, err := blake2s.New128(key)
+	if err != nil {
+		panic("nyquist/cookie: failed to initialize keyed BLAKE2s: " + err.Error())
+	}
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
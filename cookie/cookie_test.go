@@ -0,0 +1,417 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package cookie
+
+import (
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const (
+	protoIK = "Noise_IK_25519_ChaChaPoly_BLAKE2s"
+
+	messageResponse = 0x10
+)
+
+type packet struct {
+	src  string
+	data []byte
+}
+
+// memNetwork is an in-memory datagram network, where the source address
+// of each packet is set by the sender (and thus can be spoofed).
+type memNetwork struct {
+	l         sync.Mutex
+	endpoints map[string]chan packet
+}
+
+func (n *memNetwork) listen(addr string) <-chan packet {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	ch := make(chan packet, 16)
+	n.endpoints[addr] = ch
+	return ch
+}
+
+func (n *memNetwork) send(src, dst string, data []byte) {
+	n.l.Lock()
+	ch := n.endpoints[dst]
+	n.l.Unlock()
+
+	if ch != nil {
+		ch <- packet{src, append([]byte{}, data...)}
+	}
+}
+
+func (n *memNetwork) close() {
+	n.l.Lock()
+	defer n.l.Unlock()
+
+	for addr, ch := range n.endpoints {
+		close(ch)
+		delete(n.endpoints, addr)
+	}
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{
+		endpoints: make(map[string]chan packet),
+	}
+}
+
+type testResponder struct {
+	static    dh.Keypair
+	checker   *Checker
+	underLoad atomic.Bool
+	processed atomic.Int32
+	wg        sync.WaitGroup
+}
+
+func (r *testResponder) serve(t *testing.T, network *memNetwork, addr string) {
+	inbox := network.listen(addr)
+	protocol, err := nyquist.NewProtocol(protoIK)
+	require.NoError(t, err, "NewProtocol")
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for pkt := range inbox {
+			if MessageType(pkt.data) != MessageInitiation {
+				continue
+			}
+			msg, reply, err := r.checker.Check([]byte(pkt.src), pkt.data)
+			if err != nil {
+				continue
+			}
+			if reply != nil {
+				network.send(addr, pkt.src, reply)
+				continue
+			}
+
+			r.processed.Add(1)
+			hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+				Protocol:    protocol,
+				LocalStatic: r.static,
+			})
+			if err != nil {
+				continue
+			}
+			if _, err = hs.ReadMessage(nil, msg); err != nil {
+				continue
+			}
+			resp, err := hs.WriteMessage([]byte{messageResponse}, nil)
+			if err != nyquist.ErrDone {
+				continue
+			}
+			network.send(addr, pkt.src, resp)
+		}
+	}()
+}
+
+func newTestResponder(t *testing.T, cfg *Config) *testResponder {
+	static, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+
+	r := &testResponder{
+		static: static,
+	}
+	cfg.UnderLoad = r.underLoad.Load
+	r.checker = NewChecker(cfg, static.Public())
+
+	return r
+}
+
+// runInitiator runs an IK handshake against the responder, sending the
+// first message from src, and returns the number of cookie replies
+// received.
+func runInitiator(t *testing.T, network *memNetwork, src, dst string, responderStatic dh.PublicKey, gen *Generator) int {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol(protoIK)
+	require.NoError(err, "NewProtocol")
+	static, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:     protocol,
+		LocalStatic:  static,
+		RemoteStatic: responderStatic,
+		IsInitiator:  true,
+	})
+	require.NoError(err, "NewHandshake")
+	defer hs.Reset()
+
+	msg, err := hs.WriteMessage(nil, nil)
+	require.NoError(err, "WriteMessage")
+
+	inbox := network.listen(src)
+	var nrReplies int
+	for {
+		network.send(src, dst, gen.Frame(msg))
+
+		select {
+		case pkt := <-inbox:
+			switch MessageType(pkt.data) {
+			case MessageCookieReply:
+				require.NoError(gen.ConsumeReply(pkt.data), "ConsumeReply")
+				nrReplies++
+				require.LessOrEqual(nrReplies, 1, "repeated cookie replies")
+			case messageResponse:
+				_, err = hs.ReadMessage(nil, pkt.data[1:])
+				require.Equal(nyquist.ErrDone, err, "ReadMessage")
+				return nrReplies
+			default:
+				require.FailNow("unexpected message type")
+			}
+		case <-time.After(5 * time.Second):
+			require.FailNow("timed out")
+		}
+	}
+}
+
+func TestCookie(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"NotUnderLoad", testCookieNotUnderLoad},
+		{"UnderLoad", testCookieUnderLoad},
+		{"Spoofed", testCookieSpoofed},
+		{"Reply", testCookieReply},
+		{"Rotation", testCookieRotation},
+		{"Malformed", testCookieMalformed},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testCookieNotUnderLoad(t *testing.T) {
+	require := require.New(t)
+
+	network := newMemNetwork()
+	r := newTestResponder(t, &Config{})
+	r.serve(t, network, "responder")
+
+	gen := NewGenerator(&Config{}, r.static.Public())
+	n := runInitiator(t, network, "initiator", "responder", r.static.Public(), gen)
+	require.Equal(0, n, "cookie replies")
+	require.EqualValues(1, r.processed.Load(), "processed handshakes")
+
+	network.close()
+	r.wg.Wait()
+}
+
+func testCookieUnderLoad(t *testing.T) {
+	require := require.New(t)
+
+	network := newMemNetwork()
+	r := newTestResponder(t, &Config{})
+	r.underLoad.Store(true)
+	r.serve(t, network, "responder")
+
+	gen := NewGenerator(&Config{}, r.static.Public())
+	n := runInitiator(t, network, "initiator", "responder", r.static.Public(), gen)
+	require.Equal(1, n, "cookie replies")
+	require.EqualValues(1, r.processed.Load(), "processed handshakes")
+
+	// The cookie is reused for subsequent handshakes.
+	n = runInitiator(t, network, "initiator", "responder", r.static.Public(), gen)
+	require.Equal(0, n, "cookie replies - cached cookie")
+	require.EqualValues(2, r.processed.Load(), "processed handshakes - cached cookie")
+
+	network.close()
+	r.wg.Wait()
+}
+
+func testCookieSpoofed(t *testing.T) {
+	require := require.New(t)
+
+	r := newTestResponder(t, &Config{})
+	r.underLoad.Store(true)
+
+	gen := NewGenerator(&Config{}, r.static.Public())
+	frame := gen.Frame([]byte("first handshake message"))
+	_, reply, err := r.checker.Check([]byte("alice"), frame)
+	require.NoError(err, "Check")
+	require.NotNil(reply, "Check - no cookie")
+	require.NoError(gen.ConsumeReply(reply), "ConsumeReply")
+	require.ErrorIs(gen.ConsumeReply(reply), ErrUnexpectedReply, "ConsumeReply - repeated")
+
+	frame = gen.Frame([]byte("first handshake message"))
+	msg, reply, err := r.checker.Check([]byte("alice"), frame)
+	require.NoError(err, "Check - alice")
+	require.Nil(reply, "Check - alice")
+	require.Equal([]byte("first handshake message"), msg, "Check - alice")
+
+	// The same frame from a different source address is challenged.
+	msg, reply, err = r.checker.Check([]byte("mallory"), frame)
+	require.NoError(err, "Check - mallory")
+	require.Nil(msg, "Check - mallory")
+	require.NotNil(reply, "Check - mallory")
+
+	// The MAC keyed by the responder's static key is always checked.
+	frame[1] ^= 0x01
+	_, _, err = r.checker.Check([]byte("alice"), frame)
+	require.ErrorIs(err, ErrInvalidMAC, "Check - tampered")
+
+	other := newTestResponder(t, &Config{})
+	_, _, err = other.checker.Check([]byte("alice"), gen.Frame([]byte("msg")))
+	require.ErrorIs(err, ErrInvalidMAC, "Check - wrong responder")
+}
+
+func testCookieReply(t *testing.T) {
+	require := require.New(t)
+
+	r := newTestResponder(t, &Config{})
+	r.underLoad.Store(true)
+	gen := NewGenerator(&Config{}, r.static.Public())
+
+	// The cookie is encrypted.
+	cookies, _, err := r.checker.cookies([]byte("alice"))
+	require.NoError(err, "cookies")
+	_, reply, err := r.checker.Check([]byte("alice"), gen.Frame([]byte("msg")))
+	require.NoError(err, "Check")
+	require.Len(reply, CookieReplySize, "Check - reply")
+	require.NotContains(string(reply), string(cookies[0]), "Check - cookie in plaintext")
+
+	// The reply is authenticated.
+	tampered := append([]byte{}, reply...)
+	tampered[len(tampered)-1] ^= 0x01
+	require.ErrorIs(gen.ConsumeReply(tampered), ErrUnexpectedReply, "ConsumeReply - tampered")
+
+	// The reply is bound to the initiation frame.
+	_ = gen.Frame([]byte("another msg"))
+	require.ErrorIs(gen.ConsumeReply(reply), ErrUnexpectedReply, "ConsumeReply - stale")
+
+	// Replies from other responders are rejected.
+	other := newTestResponder(t, &Config{})
+	other.underLoad.Store(true)
+	otherGen := NewGenerator(&Config{}, other.static.Public())
+	_, reply, err = other.checker.Check([]byte("alice"), otherGen.Frame([]byte("msg")))
+	require.NoError(err, "Check - other")
+	require.NoError(otherGen.ConsumeReply(append([]byte{}, reply...)), "ConsumeReply - other")
+	_ = gen.Frame([]byte("msg"))
+	require.ErrorIs(gen.ConsumeReply(reply), ErrUnexpectedReply, "ConsumeReply - wrong responder")
+
+	frame := gen.Frame([]byte("msg"))
+	_, reply, err = r.checker.Check([]byte("alice"), frame)
+	require.NoError(err, "Check")
+	require.NoError(gen.ConsumeReply(reply), "ConsumeReply")
+}
+
+func testCookieRotation(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	cfg := &Config{
+		RotationInterval: time.Minute,
+		Now: func() time.Time {
+			return now
+		},
+	}
+	r := newTestResponder(t, cfg)
+	r.underLoad.Store(true)
+	gen := NewGenerator(cfg, r.static.Public())
+	src := []byte("alice")
+
+	getCookie := func() {
+		_, reply, err := r.checker.Check(src, gen.Frame([]byte("msg")))
+		require.NoError(err, "Check")
+		require.NotNil(reply, "Check")
+		require.NoError(gen.ConsumeReply(reply), "ConsumeReply")
+	}
+	checkFrame := func(frame []byte) bool {
+		msg, reply, err := r.checker.Check(src, frame)
+		require.NoError(err, "Check")
+		return msg != nil && reply == nil
+	}
+
+	getCookie()
+	frame := gen.Frame([]byte("msg"))
+	require.True(checkFrame(frame), "Check - fresh cookie")
+
+	// After one rotation, the previous secret is still accepted.
+	now = now.Add(time.Minute)
+	require.True(checkFrame(frame), "Check - previous secret")
+
+	// After another rotation, it is not.
+	now = now.Add(time.Minute)
+	require.False(checkFrame(frame), "Check - expired secret")
+
+	// The generator discards expired cookies.
+	getCookie()
+	now = now.Add(time.Minute)
+	frame = gen.Frame([]byte("msg"))
+	require.Equal(zeroMAC[:], frame[len(frame)-MACSize:], "Frame - expired cookie")
+
+	// If a rotation is missed entirely, the previous secret is not
+	// accepted.
+	getCookie()
+	frame = gen.Frame([]byte("msg"))
+	now = now.Add(2 * time.Minute)
+	require.False(checkFrame(frame), "Check - missed rotation")
+}
+
+func testCookieMalformed(t *testing.T) {
+	require := require.New(t)
+
+	static, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	checker := NewChecker(&Config{}, static.Public())
+	for _, v := range [][]byte{
+		nil,
+		{MessageInitiation},
+		append([]byte{MessageCookieReply}, make([]byte, MACSize)...),
+	} {
+		_, _, err := checker.Check([]byte("src"), v)
+		require.ErrorIs(err, ErrInvalidFrame, "Check - malformed")
+	}
+	frame := append([]byte{MessageInitiation}, make([]byte, InitiationOverhead)...)
+	_, _, err = checker.Check([]byte("src"), frame)
+	require.ErrorIs(err, ErrInvalidMAC, "Check - no mac")
+
+	gen := NewGenerator(&Config{}, static.Public())
+	require.ErrorIs(gen.ConsumeReply(make([]byte, CookieReplySize)), ErrInvalidFrame, "ConsumeReply - wrong type")
+	require.ErrorIs(gen.ConsumeReply([]byte{MessageCookieReply}), ErrInvalidFrame, "ConsumeReply - truncated")
+
+	reply := append([]byte{MessageCookieReply}, make([]byte, CookieReplySize-1)...)
+	require.ErrorIs(gen.ConsumeReply(reply), ErrUnexpectedReply, "ConsumeReply - no initiation")
+	_ = gen.Frame([]byte("msg"))
+	require.ErrorIs(gen.ConsumeReply(reply), ErrUnexpectedReply, "ConsumeReply - unauthenticated")
+}
@@ -0,0 +1,142 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package dh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"math/big"
+)
+
+var (
+	// p = 2^255 - 19
+	edP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	// d = -121665 / 121666 mod p
+	edD = func() *big.Int {
+		d := new(big.Int).ModInverse(big.NewInt(121666), edP)
+		d.Mul(d, big.NewInt(-121665))
+		return d.Mod(d, edP)
+	}()
+
+	// (p - 1) / 2
+	edLegendreExp = new(big.Int).Rsh(new(big.Int).Sub(edP, big.NewInt(1)), 1)
+)
+
+// Ed25519PrivateKeyToX25519 converts an Ed25519 private key to the X25519
+// keypair with the same underlying scalar (the clamped first half of the
+// SHA-512 digest of the seed, per RFC 8032 5.1.5).
+//
+// Warning: Using the same key for both signatures and Diffie-Hellman is
+// not covered by the usual security proofs for either.  This is provided
+// to allow reusing existing identities, not as a recommended practice.
+func Ed25519PrivateKeyToX25519(privateKey ed25519.PrivateKey) (*Keypair25519, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, ErrMalformedPrivateKey
+	}
+
+	digest := sha512.Sum512(privateKey.Seed())
+	defer clear(digest[:])
+	digest[0] &= 248
+	digest[31] &= 127
+	digest[31] |= 64
+
+	var kp Keypair25519
+	if err := kp.UnmarshalBinary(digest[:32]); err != nil {
+		return nil, err
+	}
+
+	// Ensure that the private key is internally consistent, so that the
+	// resulting keypair matches the Ed25519 public key.
+	publicKey, err := Ed25519PublicKeyToX25519(privateKey.Public().(ed25519.PublicKey))
+	if err != nil || !bytes.Equal(publicKey.Bytes(), kp.Public().Bytes()) {
+		kp.DropPrivate()
+		return nil, ErrMalformedPrivateKey
+	}
+
+	return &kp, nil
+}
+
+// Ed25519PublicKeyToX25519 converts an Ed25519 public key to the
+// corresponding X25519 public key, via the birational map from the Edwards
+// curve to the Montgomery curve (u = (1 + y) / (1 - y)).
+func Ed25519PublicKeyToX25519(publicKey ed25519.PublicKey) (*PublicKey25519, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, ErrMalformedPublicKey
+	}
+
+	// Decode y (little-endian, with the sign of x in the most significant
+	// bit), rejecting non-canonical encodings.
+	var buf [32]byte
+	for i, b := range publicKey {
+		buf[31-i] = b
+	}
+	xSign := buf[0] >> 7
+	buf[0] &= 0x7f
+	y := new(big.Int).SetBytes(buf[:])
+	if y.Cmp(edP) >= 0 {
+		return nil, ErrMalformedPublicKey
+	}
+
+	// Reject encodings that are not points on the curve, where
+	// x^2 = (y^2 - 1) / (d * y^2 + 1) is not a square, or x = 0 with the
+	// sign bit set.
+	yy := new(big.Int).Mul(y, y)
+	num := new(big.Int).Sub(yy, big.NewInt(1))
+	den := new(big.Int).Mul(edD, yy)
+	den.Add(den, big.NewInt(1))
+	xx := new(big.Int).ModInverse(den.Mod(den, edP), edP)
+	xx.Mul(xx, num).Mod(xx, edP)
+	switch l := new(big.Int).Exp(xx, edLegendreExp, edP); {
+	case l.Sign() == 0:
+		if xSign != 0 {
+			return nil, ErrMalformedPublicKey
+		}
+	case l.Cmp(big.NewInt(1)) != 0:
+		return nil, ErrMalformedPublicKey
+	}
+
+	// The identity point (y = 1) has no corresponding u-coordinate.
+	oneMinusY := new(big.Int).Sub(big.NewInt(1), y)
+	if oneMinusY.Mod(oneMinusY, edP).Sign() == 0 {
+		return nil, ErrMalformedPublicKey
+	}
+	u := new(big.Int).ModInverse(oneMinusY, edP)
+	u.Mul(u, new(big.Int).Add(big.NewInt(1), y)).Mod(u, edP)
+
+	u.FillBytes(buf[:])
+	var pk PublicKey25519
+	for i, b := range buf {
+		pk.rawPublicKey[31-i] = b
+	}
+
+	return &pk, nil
+}
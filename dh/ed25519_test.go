@@ -0,0 +1,138 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package dh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestEd25519(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Convert", testEd25519Convert},
+		{"Malformed", testEd25519Malformed},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func mustConvertEd25519(t *testing.T) (ed25519.PublicKey, *Keypair25519) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err, "ed25519.GenerateKey")
+
+	kp, err := Ed25519PrivateKeyToX25519(privateKey)
+	require.NoError(t, err, "Ed25519PrivateKeyToX25519")
+
+	return publicKey, kp
+}
+
+func testEd25519Convert(t *testing.T) {
+	require := require.New(t)
+
+	for i := 0; i < 16; i++ {
+		edPublicKeyA, kpA := mustConvertEd25519(t)
+		edPublicKeyB, kpB := mustConvertEd25519(t)
+
+		pkA, err := Ed25519PublicKeyToX25519(edPublicKeyA)
+		require.NoError(err, "Ed25519PublicKeyToX25519(a)")
+		pkB, err := Ed25519PublicKeyToX25519(edPublicKeyB)
+		require.NoError(err, "Ed25519PublicKeyToX25519(b)")
+
+		// The converted public key must match the public key derived
+		// from the converted private key with curve25519.X25519.
+		scalarA, _ := kpA.MarshalBinary()
+		derivedA, err := curve25519.X25519(scalarA, curve25519.Basepoint)
+		require.NoError(err, "curve25519.X25519(a, Basepoint)")
+		require.Equal(derivedA, pkA.Bytes(), "converted public key (a)")
+		require.Equal(derivedA, kpA.Public().Bytes(), "converted keypair public key (a)")
+
+		scalarB, _ := kpB.MarshalBinary()
+		derivedB, err := curve25519.X25519(scalarB, curve25519.Basepoint)
+		require.NoError(err, "curve25519.X25519(b, Basepoint)")
+		require.Equal(derivedB, pkB.Bytes(), "converted public key (b)")
+
+		// And the DH calculation must agree with curve25519.X25519.
+		sharedAB, err := kpA.DH(pkB)
+		require.NoError(err, "DH(a, B)")
+		sharedBA, err := kpB.DH(pkA)
+		require.NoError(err, "DH(b, A)")
+		expected, err := curve25519.X25519(scalarA, pkB.Bytes())
+		require.NoError(err, "curve25519.X25519(a, B)")
+		require.Equal(expected, sharedAB, "DH(a, B)")
+		require.Equal(expected, sharedBA, "DH(b, A)")
+	}
+}
+
+func testEd25519Malformed(t *testing.T) {
+	require := require.New(t)
+
+	_, err := Ed25519PublicKeyToX25519(make([]byte, 31))
+	require.ErrorIs(err, ErrMalformedPublicKey, "Ed25519PublicKeyToX25519: short")
+
+	// The identity point (y = 1).
+	identity := make([]byte, 32)
+	identity[0] = 1
+	_, err = Ed25519PublicKeyToX25519(identity)
+	require.ErrorIs(err, ErrMalformedPublicKey, "Ed25519PublicKeyToX25519: identity")
+
+	// Non-canonical y (y = p).
+	nonCanonical := make([]byte, 32)
+	edP.FillBytes(nonCanonical)
+	for i, j := 0, len(nonCanonical)-1; i < j; i, j = i+1, j-1 {
+		nonCanonical[i], nonCanonical[j] = nonCanonical[j], nonCanonical[i]
+	}
+	_, err = Ed25519PublicKeyToX25519(nonCanonical)
+	require.ErrorIs(err, ErrMalformedPublicKey, "Ed25519PublicKeyToX25519: non-canonical")
+
+	// Not on the curve (y = 2).
+	notOnCurve := make([]byte, 32)
+	notOnCurve[0] = 2
+	_, err = Ed25519PublicKeyToX25519(notOnCurve)
+	require.ErrorIs(err, ErrMalformedPublicKey, "Ed25519PublicKeyToX25519: not on curve")
+
+	_, err = Ed25519PrivateKeyToX25519(make([]byte, 32))
+	require.ErrorIs(err, ErrMalformedPrivateKey, "Ed25519PrivateKeyToX25519: short")
+
+	// A private key with a mismatched public half.
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err, "ed25519.GenerateKey")
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err, "ed25519.GenerateKey")
+	copy(privateKey[32:], otherPublicKey)
+	_, err = Ed25519PrivateKeyToX25519(privateKey)
+	require.ErrorIs(err, ErrMalformedPrivateKey, "Ed25519PrivateKeyToX25519: mismatched")
+}
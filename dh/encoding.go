@@ -0,0 +1,204 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package dh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+const (
+	// PrivateKeyPEMType is the PEM block type of private keys.
+	PrivateKeyPEMType = "NOISE PRIVATE KEY"
+
+	// PEMHeaderDH is the PEM header that records the DH function name.
+	PEMHeaderDH = "DH"
+
+	// TextPrefix is the prefix of the DH function name in the text
+	// encoding of public keys.
+	TextPrefix = "noise-"
+
+	// Bech32Prefix is the prefix of the DH function name in the Bech32
+	// human-readable part of public keys.
+	Bech32Prefix = "noise"
+)
+
+var (
+	// ErrUnknownDH is the error returned when an encoded key is for an
+	// unknown DH function.
+	ErrUnknownDH = errors.New("nyquist/dh: unknown DH function")
+
+	errMalformedPEM  = errors.New("nyquist/dh: malformed PEM private key")
+	errDHMismatch    = errors.New("nyquist/dh: keypair is not for the DH function")
+	errPublicKeyDH   = errors.New("nyquist/dh: public key is not for the DH function")
+	errMalformedText = errors.New("nyquist/dh: malformed public key text")
+)
+
+// MarshalPrivateKeyPEM encodes the keypair's private key for the DH function
+// in a PEM block of type `PrivateKeyPEMType`, with a `PEMHeaderDH` header.
+// The keypair must be for the DH function.
+func MarshalPrivateKeyPEM(dh DH, keypair Keypair) ([]byte, error) {
+	b, err := keypair.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(b)
+
+	// Ensure that the encoded key will parse as the same keypair.
+	parsed, err := dh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, errDHMismatch
+	}
+	defer parsed.DropPrivate()
+	if !bytes.Equal(parsed.Public().Bytes(), keypair.Public().Bytes()) {
+		return nil, errDHMismatch
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type: PrivateKeyPEMType,
+		Headers: map[string]string{
+			PEMHeaderDH: dh.String(),
+		},
+		Bytes: b,
+	}), nil
+}
+
+// ParsePrivateKeyPEM parses the first PEM encoded private key in data, and
+// returns the DH function and keypair.
+func ParsePrivateKeyPEM(data []byte) (DH, Keypair, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != PrivateKeyPEMType {
+		return nil, nil, errMalformedPEM
+	}
+	defer clear(block.Bytes)
+
+	dh := FromString(block.Headers[PEMHeaderDH])
+	if dh == nil {
+		return nil, nil, ErrUnknownDH
+	}
+	keypair, err := dh.ParsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dh, keypair, nil
+}
+
+// MarshalPublicKeyText encodes the public key for the DH function in the
+// OpenSSH style text format (eg: `noise-25519 <base64 public key>`).  The
+// public key must be for the DH function.
+func MarshalPublicKeyText(dh DH, publicKey PublicKey) (string, error) {
+	if err := checkPublicKey(dh, publicKey); err != nil {
+		return "", err
+	}
+	return TextPrefix + dh.String() + " " + base64.StdEncoding.EncodeToString(publicKey.Bytes()), nil
+}
+
+// ParsePublicKeyText parses a public key in the OpenSSH style text format,
+// and returns the DH function, public key, and the optional trailing
+// comment.
+func ParsePublicKeyText(s string) (DH, PublicKey, string, error) {
+	fields := strings.SplitN(strings.TrimSpace(s), " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], TextPrefix) {
+		return nil, nil, "", errMalformedText
+	}
+	dh := FromString(strings.TrimPrefix(fields[0], TextPrefix))
+	if dh == nil {
+		return nil, nil, "", ErrUnknownDH
+	}
+	b, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, nil, "", errMalformedText
+	}
+	publicKey, err := dh.ParsePublicKey(b)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var comment string
+	if len(fields) == 3 {
+		comment = strings.TrimSpace(fields[2])
+	}
+	return dh, publicKey, comment, nil
+}
+
+// MarshalPublicKeyBech32 encodes the public key for the DH function in
+// Bech32, with a human-readable part of `Bech32Prefix` followed by the DH
+// function name (eg: `noise25519`).  The DH function name must not contain
+// upper case characters, and the public key must be for the DH function.
+func MarshalPublicKeyBech32(dh DH, publicKey PublicKey) (string, error) {
+	if err := checkPublicKey(dh, publicKey); err != nil {
+		return "", err
+	}
+	return bech32Encode(Bech32Prefix+dh.String(), publicKey.Bytes())
+}
+
+// ParsePublicKeyBech32 parses a Bech32 encoded public key, and returns the
+// DH function and public key.
+func ParsePublicKeyBech32(s string) (DH, PublicKey, error) {
+	hrp, b, err := bech32Decode(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(hrp, Bech32Prefix) {
+		return nil, nil, errBech32Malformed
+	}
+	dh := FromString(strings.TrimPrefix(hrp, Bech32Prefix))
+	if dh == nil {
+		return nil, nil, ErrUnknownDH
+	}
+	publicKey, err := dh.ParsePublicKey(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dh, publicKey, nil
+}
+
+// ParsePublicKeyString parses a public key in either the text, or the
+// Bech32 encoding, and returns the DH function and public key.
+func ParsePublicKeyString(s string) (DH, PublicKey, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), TextPrefix) {
+		dh, publicKey, _, err := ParsePublicKeyText(s)
+		return dh, publicKey, err
+	}
+	return ParsePublicKeyBech32(s)
+}
+
+// checkPublicKey ensures that the encoded public key will parse as the same
+// public key for the DH function.
+func checkPublicKey(dh DH, publicKey PublicKey) error {
+	parsed, err := dh.ParsePublicKey(publicKey.Bytes())
+	if err != nil || !bytes.Equal(parsed.Bytes(), publicKey.Bytes()) {
+		return errPublicKeyDH
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package dh
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoding(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Bech32", testEncodingBech32},
+		{"RoundTrip", testEncodingRoundTrip},
+		{"Malformed", testEncodingMalformed},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testEncodingBech32(t *testing.T) {
+	require := require.New(t)
+
+	// Valid checksums from BIP-173.
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	} {
+		hrp, _, err := bech32Decode(s)
+		if err != nil {
+			// Some vectors have data that is not a whole number of
+			// bytes, which only fail the conversion.
+			require.NotErrorIs(err, errBech32Checksum, "bech32Decode(%s)", s)
+			continue
+		}
+		require.Equal(strings.ToLower(s[:strings.LastIndexByte(s, '1')]), hrp, "bech32Decode(%s) - hrp", s)
+	}
+
+	for _, s := range []string{
+		"a12uel5m",   // Checksum.
+		"A12uEL5L",   // Mixed case.
+		"1qzzfhee",   // Empty HRP.
+		"a1qqqqq",    // Short checksum.
+		"abc1qqqqqb", // Invalid character.
+	} {
+		_, _, err := bech32Decode(s)
+		require.Error(err, "bech32Decode(%s)", s)
+	}
+
+	data := make([]byte, 57)
+	_, _ = rand.Read(data)
+	s, err := bech32Encode("test", data)
+	require.NoError(err, "bech32Encode")
+	hrp, decoded, err := bech32Decode(strings.ToUpper(s))
+	require.NoError(err, "bech32Decode - upper case")
+	require.Equal("test", hrp, "bech32Decode - hrp")
+	require.Equal(data, decoded, "bech32Decode - data")
+
+	_, err = bech32Encode("Test", data)
+	require.Error(err, "bech32Encode - upper case HRP")
+}
+
+func testEncodingRoundTrip(t *testing.T) {
+	for name, dh := range supportedDHs {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+
+			keypair, err := dh.GenerateKeypair(rand.Reader)
+			require.NoError(err, "GenerateKeypair")
+			publicKey := keypair.Public()
+
+			b, err := MarshalPrivateKeyPEM(dh, keypair)
+			require.NoError(err, "MarshalPrivateKeyPEM")
+			require.True(strings.HasPrefix(string(b), "-----BEGIN "+PrivateKeyPEMType+"-----\n"+PEMHeaderDH+": "+name+"\n"), "MarshalPrivateKeyPEM - armour")
+			parsedDH, parsedKeypair, err := ParsePrivateKeyPEM(b)
+			require.NoError(err, "ParsePrivateKeyPEM")
+			require.Equal(dh, parsedDH, "ParsePrivateKeyPEM - DH")
+			require.Equal(publicKey.Bytes(), parsedKeypair.Public().Bytes(), "ParsePrivateKeyPEM - public key")
+			expected, _ := keypair.MarshalBinary()
+			actual, _ := parsedKeypair.MarshalBinary()
+			require.Equal(expected, actual, "ParsePrivateKeyPEM - private key")
+
+			s, err := MarshalPublicKeyText(dh, publicKey)
+			require.NoError(err, "MarshalPublicKeyText")
+			require.True(strings.HasPrefix(s, TextPrefix+name+" "), "MarshalPublicKeyText - prefix")
+			for _, v := range []struct {
+				s, comment string
+			}{
+				{s, ""},
+				{s + " alice@example.com", "alice@example.com"},
+				{s + " with spaces \n", "with spaces"},
+			} {
+				parsedDH, parsedPublicKey, comment, err := ParsePublicKeyText(v.s)
+				require.NoError(err, "ParsePublicKeyText(%s)", v.s)
+				require.Equal(dh, parsedDH, "ParsePublicKeyText - DH")
+				require.Equal(publicKey.Bytes(), parsedPublicKey.Bytes(), "ParsePublicKeyText - public key")
+				require.Equal(v.comment, comment, "ParsePublicKeyText - comment")
+			}
+
+			s, err = MarshalPublicKeyBech32(dh, publicKey)
+			require.NoError(err, "MarshalPublicKeyBech32")
+			require.True(strings.HasPrefix(s, Bech32Prefix+name+"1"), "MarshalPublicKeyBech32 - prefix")
+			parsedDH, parsedPublicKey, err := ParsePublicKeyBech32(s)
+			require.NoError(err, "ParsePublicKeyBech32")
+			require.Equal(dh, parsedDH, "ParsePublicKeyBech32 - DH")
+			require.Equal(publicKey.Bytes(), parsedPublicKey.Bytes(), "ParsePublicKeyBech32 - public key")
+
+			text, err := MarshalPublicKeyText(dh, publicKey)
+			require.NoError(err, "MarshalPublicKeyText")
+			for _, s := range []string{s, text} {
+				parsedDH, parsedPublicKey, err = ParsePublicKeyString(s)
+				require.NoError(err, "ParsePublicKeyString(%s)", s)
+				require.Equal(dh, parsedDH, "ParsePublicKeyString - DH")
+				require.Equal(publicKey.Bytes(), parsedPublicKey.Bytes(), "ParsePublicKeyString - public key")
+			}
+		})
+	}
+}
+
+// mismatchedKeypair is a keypair with a public key that does not match the
+// private key.
+type mismatchedKeypair struct {
+	Keypair
+	publicKey PublicKey
+}
+
+func (kp *mismatchedKeypair) Public() PublicKey {
+	return kp.publicKey
+}
+
+func testEncodingMalformed(t *testing.T) {
+	require := require.New(t)
+
+	keypair, err := X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	b, err := MarshalPrivateKeyPEM(X25519, keypair)
+	require.NoError(err, "MarshalPrivateKeyPEM")
+
+	// The keypair must be for the DH function.
+	_, err = MarshalPrivateKeyPEM(X448, keypair)
+	require.Error(err, "MarshalPrivateKeyPEM - mismatched DH")
+	otherKeypair, err := X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	_, err = MarshalPrivateKeyPEM(X25519, &mismatchedKeypair{keypair, otherKeypair.Public()})
+	require.Error(err, "MarshalPrivateKeyPEM - mismatched public key")
+
+	_, _, err = ParsePrivateKeyPEM([]byte(strings.Replace(string(b), "DH: 25519", "DH: 1337", 1)))
+	require.ErrorIs(err, ErrUnknownDH, "ParsePrivateKeyPEM - unknown DH")
+	_, _, err = ParsePrivateKeyPEM([]byte(strings.Replace(string(b), "DH: 25519", "DH: 448", 1)))
+	require.Error(err, "ParsePrivateKeyPEM - mismatched DH")
+	_, _, err = ParsePrivateKeyPEM([]byte(strings.ReplaceAll(string(b), PrivateKeyPEMType, "PRIVATE KEY")))
+	require.Error(err, "ParsePrivateKeyPEM - type")
+	_, _, err = ParsePrivateKeyPEM([]byte("not a PEM block"))
+	require.Error(err, "ParsePrivateKeyPEM - garbage")
+
+	// The public key must be for the DH function.
+	_, err = MarshalPublicKeyText(X448, keypair.Public())
+	require.Error(err, "MarshalPublicKeyText - mismatched DH")
+	_, err = MarshalPublicKeyBech32(X448, keypair.Public())
+	require.Error(err, "MarshalPublicKeyBech32 - mismatched DH")
+
+	s, err := MarshalPublicKeyText(X25519, keypair.Public())
+	require.NoError(err, "MarshalPublicKeyText")
+	for _, v := range []string{
+		"",
+		strings.TrimPrefix(s, TextPrefix),
+		strings.Replace(s, "25519", "1337", 1),
+		strings.Replace(s, "25519", "448", 1),
+		s[:len(s)-4],
+	} {
+		_, _, _, err = ParsePublicKeyText(v)
+		require.Error(err, "ParsePublicKeyText(%s)", v)
+	}
+
+	s, err = MarshalPublicKeyBech32(X25519, keypair.Public())
+	require.NoError(err, "MarshalPublicKeyBech32")
+	other, err := bech32Encode("other25519", keypair.Public().Bytes())
+	require.NoError(err, "bech32Encode")
+	unknown, err := bech32Encode(Bech32Prefix+"1337", keypair.Public().Bytes())
+	require.NoError(err, "bech32Encode")
+	for _, v := range []string{
+		"",
+		s[:len(s)-1],
+		other,
+		unknown,
+	} {
+		_, _, err = ParsePublicKeyBech32(v)
+		require.Error(err, "ParsePublicKeyBech32(%s)", v)
+	}
+}
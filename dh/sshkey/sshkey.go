@@ -0,0 +1,86 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package sshkey implements loading OpenSSH Ed25519 keys as X25519 keys,
+// with `dh.Ed25519PrivateKeyToX25519` and `dh.Ed25519PublicKeyToX25519`.
+//
+// Warning: Using the same key for both signatures and Diffie-Hellman is
+// not covered by the usual security proofs for either.  This is provided
+// to allow reusing existing identities, not as a recommended practice.
+package sshkey // import "gitlab.com/yawning/nyquist.git/dh/sshkey"
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+var errNotEd25519 = errors.New("nyquist/dh/sshkey: not an Ed25519 key")
+
+// ParsePrivateKey parses an unencrypted OpenSSH `ssh-ed25519` private key
+// file, and returns the equivalent X25519 keypair.
+func ParsePrivateKey(data []byte) (*dh.Keypair25519, error) {
+	rawKey, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := rawKey.(*ed25519.PrivateKey)
+	if !ok {
+		return nil, errNotEd25519
+	}
+	defer clear(*privateKey)
+
+	return dh.Ed25519PrivateKeyToX25519(*privateKey)
+}
+
+// ParseAuthorizedKey parses a single OpenSSH `authorized_keys` format
+// `ssh-ed25519` public key line, and returns the equivalent X25519 public
+// key and the comment.
+func ParseAuthorizedKey(line []byte) (*dh.PublicKey25519, string, error) {
+	sshKey, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		return nil, "", err
+	}
+	if sshKey.Type() != ssh.KeyAlgoED25519 {
+		return nil, "", errNotEd25519
+	}
+	publicKey, ok := sshKey.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, "", errNotEd25519
+	}
+
+	pk, err := dh.Ed25519PublicKeyToX25519(publicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return pk, comment, nil
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sshkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+func TestSSHKey(t *testing.T) {
+	require := require.New(t)
+
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err, "ed25519.GenerateKey")
+	expected, err := dh.Ed25519PrivateKeyToX25519(edPrivateKey)
+	require.NoError(err, "Ed25519PrivateKeyToX25519")
+
+	block, err := ssh.MarshalPrivateKey(edPrivateKey, "alice@example.com")
+	require.NoError(err, "ssh.MarshalPrivateKey")
+	kp, err := ParsePrivateKey(pem.EncodeToMemory(block))
+	require.NoError(err, "ParsePrivateKey")
+	require.Equal(expected, kp, "ParsePrivateKey: keypair")
+
+	sshPublicKey, err := ssh.NewPublicKey(edPublicKey)
+	require.NoError(err, "ssh.NewPublicKey")
+	line := ssh.MarshalAuthorizedKey(sshPublicKey)
+	line = append(line[:len(line)-1], " alice@example.com\n"...)
+	pk, comment, err := ParseAuthorizedKey(line)
+	require.NoError(err, "ParseAuthorizedKey")
+	require.Equal(expected.Public(), pk, "ParseAuthorizedKey: public key")
+	require.Equal("alice@example.com", comment, "ParseAuthorizedKey: comment")
+
+	// Encrypted private keys are unsupported.
+	block, err = ssh.MarshalPrivateKeyWithPassphrase(edPrivateKey, "", []byte("passphrase"))
+	require.NoError(err, "ssh.MarshalPrivateKeyWithPassphrase")
+	_, err = ParsePrivateKey(pem.EncodeToMemory(block))
+	require.Error(err, "ParsePrivateKey: encrypted")
+
+	// As are non-Ed25519 keys.
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err, "ecdsa.GenerateKey")
+	block, err = ssh.MarshalPrivateKey(ecdsaKey, "")
+	require.NoError(err, "ssh.MarshalPrivateKey: ECDSA")
+	_, err = ParsePrivateKey(pem.EncodeToMemory(block))
+	require.ErrorIs(err, errNotEd25519, "ParsePrivateKey: ECDSA")
+
+	sshPublicKey, err = ssh.NewPublicKey(&ecdsaKey.PublicKey)
+	require.NoError(err, "ssh.NewPublicKey: ECDSA")
+	_, _, err = ParseAuthorizedKey(ssh.MarshalAuthorizedKey(sshPublicKey))
+	require.ErrorIs(err, errNotEd25519, "ParseAuthorizedKey: ECDSA")
+}
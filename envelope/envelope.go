@@ -0,0 +1,616 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package envelope implements multi-recipient encryption, where a message
+// is encrypted once with a random file key, which is wrapped separately
+// for each recipient's static key with a one-way handshake.
+//
+// The envelope format is:
+//
+//	envelope = version (1 byte, 0x01) || flags (1 byte) ||
+//	           protocol name length (1 byte) || protocol name ||
+//	           stanza count (2 bytes, big-endian) || stanzas ||
+//	           header MAC || body
+//	stanza   = [key ID (8 bytes)] || handshake message
+//	body     = AEAD(file key, nonce 0, ad, plaintext)
+//
+// Each stanza's handshake message uses `N` for anonymous senders, and `X`
+// for authenticated senders, with the file key as the payload, and the
+// prologue:
+//
+//	"NyquistEnvelope" || version || flags
+//
+// The body is encrypted with the protocol's cipher, keyed by the file key,
+// with the associated data `ad` being the envelope up to (but excluding)
+// the stanza count.
+//
+// The header MAC is HMAC with the protocol's hash function over the
+// envelope up to (but excluding) the header MAC, keyed by
+// `HKDF(file key, "NyquistEnvelope Header MAC")`, so that the stanzas
+// are authenticated by the file key, in the manner of age.
+//
+// Unless the `FlagAnonymous` flag is set, each stanza is prefixed with an
+// identifier derived from the recipient's static public key.  Otherwise,
+// stanzas have no identifiers, recipients trial decrypt each stanza, and
+// the number of stanzas is padded with stanzas encrypted to random keys,
+// which are indistinguishable from real stanzas, even to recipients.
+// Recipients thus learn neither the identities, nor the exact number of
+// the other recipients.  As padding stanzas can't be identified,
+// `AddRecipients` retains them, and pads the stanzas again.
+//
+// Note: The stanzas and the body are only authenticated by the file key,
+// which is known to every recipient.  When the sender is authenticated, a
+// recipient is only assured that the sender wrapped the file key for them,
+// not that the stanzas or the body were not replaced by another recipient.
+package envelope // import "gitlab.com/yawning/nyquist.git/envelope"
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/cipher"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+const (
+	// Version is the envelope format version.
+	Version = 1
+
+	// FlagAnonymous is the flag set for anonymous recipient envelopes.
+	FlagAnonymous = 0x01
+
+	// FileKeySize is the size of a file key in bytes.
+	FileKeySize = 32
+
+	// DefaultMinStanzas is the default minimum number of stanzas in an
+	// anonymous recipient envelope.
+	DefaultMinStanzas = 4
+
+	// MaxStanzas is the maximum number of stanzas in an envelope.
+	MaxStanzas = math.MaxUint16
+
+	keyIDSize      = 8
+	keyIDLabel     = "NyquistEnvelope Key ID"
+	prologueLabel  = "NyquistEnvelope"
+	headerMACLabel = "NyquistEnvelope Header MAC"
+)
+
+var (
+	// ErrInvalidEnvelope is the error returned when an envelope is
+	// malformed, or uses an unsupported version or protocol.
+	ErrInvalidEnvelope = errors.New("nyquist/envelope: invalid envelope")
+
+	// ErrNoMatchingStanza is the error returned when none of the stanzas
+	// are for the recipient.
+	ErrNoMatchingStanza = errors.New("nyquist/envelope: no matching stanza")
+
+	// ErrInvalidBody is the error returned when the body fails to
+	// authenticate.
+	ErrInvalidBody = errors.New("nyquist/envelope: invalid body")
+
+	// ErrInvalidHeader is the error returned when the header MAC fails to
+	// authenticate.
+	ErrInvalidHeader = errors.New("nyquist/envelope: invalid header")
+
+	// ErrUnexpectedSender is the error returned when the envelope's sender
+	// does not match the expected sender.
+	ErrUnexpectedSender = errors.New("nyquist/envelope: unexpected sender")
+
+	errNoRecipients     = errors.New("nyquist/envelope: no recipients")
+	errTooManyStanzas   = errors.New("nyquist/envelope: too many stanzas")
+	errSenderMismatch   = errors.New("nyquist/envelope: sender does not match the envelope's pattern")
+	errInvalidFileKey   = errors.New("nyquist/envelope: invalid file key")
+	errInvalidMinStanza = errors.New("nyquist/envelope: invalid minimum stanza count")
+)
+
+// Options are the options for encrypting an envelope, and adding
+// recipients.
+type Options struct {
+	// Sender is the sender's static keypair.  If nil, the sender is
+	// anonymous.
+	Sender dh.Keypair
+
+	// Anonymous hides the recipients' identities, and the exact number of
+	// recipients.
+	Anonymous bool
+
+	// MinStanzas is the minimum number of stanzas in an anonymous
+	// recipient envelope.  The number of stanzas is padded to the larger
+	// of MinStanzas, and the next power of 2.  If 0, `DefaultMinStanzas`
+	// will be used.
+	MinStanzas int
+
+	// DH is the DH function.  If nil, `dh.X25519` will be used.
+	DH dh.DH
+
+	// Cipher is the cipher function.  If nil, `cipher.ChaChaPoly` will be
+	// used.
+	Cipher cipher.Cipher
+
+	// Hash is the hash function.  If nil, `hash.BLAKE2s` will be used.
+	Hash hash.Hash
+
+	// Rng is the entropy source.  If nil, `crypto/rand.Reader` will be
+	// used.
+	Rng io.Reader
+}
+
+func (opts *Options) getRng() io.Reader {
+	if opts.Rng == nil {
+		return rand.Reader
+	}
+	return opts.Rng
+}
+
+// DecryptOptions are the options for decrypting an envelope.
+type DecryptOptions struct {
+	// Sender is the expected sender's static public key.  If set,
+	// envelopes from anonymous or other senders are rejected.
+	Sender dh.PublicKey
+}
+
+// Encrypt encrypts the plaintext for the recipients' static public keys,
+// and returns the envelope and the file key.  The file key is required to
+// add recipients to the envelope.  The options may be nil.
+func Encrypt(recipients []dh.PublicKey, plaintext []byte, opts *Options) ([]byte, []byte, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if len(recipients) == 0 {
+		return nil, nil, errNoRecipients
+	}
+
+	protocol := &nyquist.Protocol{
+		Pattern: pattern.N,
+		DH:      opts.DH,
+		Cipher:  opts.Cipher,
+		Hash:    opts.Hash,
+	}
+	if opts.Sender != nil {
+		protocol.Pattern = pattern.X
+	}
+	if protocol.DH == nil {
+		protocol.DH = dh.X25519
+	}
+	if protocol.Cipher == nil {
+		protocol.Cipher = cipher.ChaChaPoly
+	}
+	if protocol.Hash == nil {
+		protocol.Hash = hash.BLAKE2s
+	}
+
+	var flags byte
+	if opts.Anonymous {
+		flags |= FlagAnonymous
+	}
+	name := protocol.String()
+	if len(name) > math.MaxUint8 {
+		return nil, nil, ErrInvalidEnvelope
+	}
+	h := &header{
+		protocol: protocol,
+		flags:    flags,
+	}
+	h.ad = append([]byte{Version, flags, uint8(len(name))}, name...)
+
+	fileKey := make([]byte, FileKeySize)
+	if _, err := io.ReadFull(opts.getRng(), fileKey); err != nil {
+		return nil, nil, err
+	}
+
+	stanzas, err := h.wrapFileKey(fileKey, recipients, nil, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := protocol.Cipher.New(fileKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	envelope := h.encode(fileKey, stanzas)
+	envelope = aead.Seal(envelope, protocol.Cipher.EncodeNonce(0), plaintext, h.ad)
+
+	return envelope, fileKey, nil
+}
+
+// AddRecipients adds stanzas for the recipients' static public keys to the
+// envelope, given the envelope's file key, and returns the new envelope.
+// The options must specify a sender iff the envelope was encrypted with
+// one.  The envelope's anonymity, and cryptographic primitives are
+// retained, and the corresponding options are ignored.  For anonymous
+// recipient envelopes, the existing stanzas (including padding) are
+// retained, and the stanzas are padded again.
+func AddRecipients(envelope, fileKey []byte, recipients []dh.PublicKey, opts *Options) ([]byte, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if len(recipients) == 0 {
+		return nil, errNoRecipients
+	}
+	h, stanzas, body, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if (opts.Sender != nil) != (h.protocol.Pattern == pattern.X) {
+		return nil, errSenderMismatch
+	}
+	if len(fileKey) != FileKeySize {
+		return nil, errInvalidFileKey
+	}
+
+	// Ensure that the file key is correct, before wrapping it.
+	if _, err = h.openBody(fileKey, body); err != nil {
+		return nil, errInvalidFileKey
+	}
+	if err = h.verifyMAC(fileKey); err != nil {
+		return nil, err
+	}
+
+	if stanzas, err = h.wrapFileKey(fileKey, recipients, stanzas, opts); err != nil {
+		return nil, err
+	}
+
+	return append(h.encode(fileKey, stanzas), body...), nil
+}
+
+// Decrypt decrypts the envelope with the recipient's static keypair, and
+// returns the plaintext, and the sender's static public key (nil if the
+// sender is anonymous).  The options may be nil.
+func Decrypt(recipient dh.Keypair, envelope []byte, opts *DecryptOptions) ([]byte, dh.PublicKey, error) {
+	h, stanzas, body, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileKey, sender, err := h.unwrapFileKey(recipient, stanzas, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = h.verifyMAC(fileKey); err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := h.openBody(fileKey, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext, sender, nil
+}
+
+// UnwrapFileKey returns the envelope's file key, and the sender's static
+// public key (nil if the sender is anonymous), using the recipient's
+// static keypair.  The options may be nil.
+func UnwrapFileKey(recipient dh.Keypair, envelope []byte, opts *DecryptOptions) ([]byte, dh.PublicKey, error) {
+	h, stanzas, _, err := parseEnvelope(envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileKey, sender, err := h.unwrapFileKey(recipient, stanzas, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = h.verifyMAC(fileKey); err != nil {
+		return nil, nil, err
+	}
+	return fileKey, sender, nil
+}
+
+type header struct {
+	protocol *nyquist.Protocol
+	flags    byte
+	ad       []byte
+
+	// authenticated and mac are the envelope up to the header MAC, and
+	// the header MAC of a parsed envelope.
+	authenticated []byte
+	mac           []byte
+}
+
+func (h *header) stanzaSize() int {
+	dhLen := h.protocol.DH.Size()
+	aead, err := h.protocol.Cipher.New(make([]byte, nyquist.SymmetricKeySize))
+	if err != nil {
+		panic("nyquist/envelope: failed to initialize cipher: " + err.Error())
+	}
+	overhead := aead.Overhead()
+
+	size := dhLen + FileKeySize + overhead
+	if h.protocol.Pattern == pattern.X {
+		size += dhLen + overhead
+	}
+	if h.flags&FlagAnonymous == 0 {
+		size += keyIDSize
+	}
+	return size
+}
+
+func (h *header) prologue() []byte {
+	return append([]byte(prologueLabel), Version, h.flags)
+}
+
+func (h *header) keyID(publicKey dh.PublicKey) []byte {
+	hh := h.protocol.Hash.New()
+	_, _ = hh.Write([]byte(keyIDLabel))
+	_, _ = hh.Write(publicKey.Bytes())
+	return hh.Sum(nil)[:keyIDSize]
+}
+
+// wrapFileKey returns the existing stanzas, with stanzas for the
+// recipients added.
+func (h *header) wrapFileKey(fileKey []byte, recipients []dh.PublicKey, existing [][]byte, opts *Options) ([][]byte, error) {
+	stanzas := make([][]byte, 0, len(existing)+len(recipients))
+	stanzas = append(stanzas, existing...)
+	for _, recipient := range recipients {
+		stanza, err := h.wrapStanza(fileKey, recipient, opts)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, stanza)
+	}
+
+	if h.flags&FlagAnonymous != 0 {
+		minStanzas := opts.MinStanzas
+		if minStanzas == 0 {
+			minStanzas = DefaultMinStanzas
+		}
+		if minStanzas < 0 || minStanzas > MaxStanzas {
+			return nil, errInvalidMinStanza
+		}
+
+		total := max(minStanzas, nextPowerOf2(len(stanzas)))
+		total = min(total, MaxStanzas)
+		for len(stanzas) < total {
+			// Padding stanzas wrap a random key to a random static key.
+			dummy, err := h.protocol.DH.GenerateKeypair(opts.getRng())
+			if err != nil {
+				return nil, err
+			}
+			dummyKey := make([]byte, FileKeySize)
+			if _, err = io.ReadFull(opts.getRng(), dummyKey); err != nil {
+				return nil, err
+			}
+			stanza, err := h.wrapStanza(dummyKey, dummy.Public(), opts)
+			dummy.DropPrivate()
+			if err != nil {
+				return nil, err
+			}
+			stanzas = append(stanzas, stanza)
+		}
+
+		// Avoid revealing which stanzas are padding, or were added.
+		if err := shuffle(stanzas, opts.getRng()); err != nil {
+			return nil, err
+		}
+	}
+	if len(stanzas) > MaxStanzas {
+		return nil, errTooManyStanzas
+	}
+
+	return stanzas, nil
+}
+
+func (h *header) wrapStanza(fileKey []byte, recipient dh.PublicKey, opts *Options) ([]byte, error) {
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:     h.protocol,
+		Prologue:     h.prologue(),
+		LocalStatic:  opts.Sender,
+		RemoteStatic: recipient,
+		Rng:          opts.getRng(),
+		IsInitiator:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	var stanza []byte
+	if h.flags&FlagAnonymous == 0 {
+		stanza = h.keyID(recipient)
+	}
+	if stanza, err = hs.WriteMessage(stanza, fileKey); err != nyquist.ErrDone {
+		return nil, err
+	}
+	hs.GetStatus().CipherStates[0].Reset()
+
+	return stanza, nil
+}
+
+func (h *header) unwrapFileKey(recipient dh.Keypair, stanzas [][]byte, opts *DecryptOptions) ([]byte, dh.PublicKey, error) {
+	if opts == nil {
+		opts = &DecryptOptions{}
+	}
+	if opts.Sender != nil && h.protocol.Pattern != pattern.X {
+		return nil, nil, ErrUnexpectedSender
+	}
+
+	var keyID []byte
+	if h.flags&FlagAnonymous == 0 {
+		keyID = h.keyID(recipient.Public())
+	}
+	for _, stanza := range stanzas {
+		if keyID != nil {
+			if !bytes.Equal(keyID, stanza[:keyIDSize]) {
+				continue
+			}
+			stanza = stanza[keyIDSize:]
+		}
+
+		fileKey, sender, err := h.unwrapStanza(recipient, stanza)
+		if err != nil {
+			continue
+		}
+		if opts.Sender != nil && !bytes.Equal(opts.Sender.Bytes(), sender.Bytes()) {
+			return nil, nil, ErrUnexpectedSender
+		}
+		return fileKey, sender, nil
+	}
+
+	return nil, nil, ErrNoMatchingStanza
+}
+
+func (h *header) unwrapStanza(recipient dh.Keypair, msg []byte) ([]byte, dh.PublicKey, error) {
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    h.protocol,
+		Prologue:    h.prologue(),
+		LocalStatic: recipient,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer hs.Reset()
+
+	fileKey, err := hs.ReadMessage(nil, msg)
+	if err != nyquist.ErrDone {
+		return nil, nil, err
+	}
+	status := hs.GetStatus()
+	status.CipherStates[0].Reset()
+
+	return fileKey, status.RemoteStatic, nil
+}
+
+func (h *header) openBody(fileKey, body []byte) ([]byte, error) {
+	aead, err := h.protocol.Cipher.New(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, h.protocol.Cipher.EncodeNonce(0), body, h.ad)
+	if err != nil {
+		return nil, ErrInvalidBody
+	}
+	return plaintext, nil
+}
+
+func (h *header) encode(fileKey []byte, stanzas [][]byte) []byte {
+	b := make([]byte, 0, len(h.ad)+2+len(stanzas)*h.stanzaSize()+h.protocol.Hash.Size())
+	b = append(b, h.ad...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(stanzas)))
+	for _, stanza := range stanzas {
+		b = append(b, stanza...)
+	}
+	return append(b, h.headerMAC(fileKey, b)...)
+}
+
+func (h *header) headerMAC(fileKey, authenticated []byte) []byte {
+	key := make([]byte, h.protocol.Hash.Size())
+	r := hkdf.New(h.protocol.Hash.New, fileKey, nil, []byte(headerMACLabel))
+	if _, err := io.ReadFull(r, key); err != nil {
+		panic("nyquist/envelope: failed to derive header MAC key: " + err.Error())
+	}
+
+	m := hmac.New(h.protocol.Hash.New, key)
+	_, _ = m.Write(authenticated)
+	return m.Sum(nil)
+}
+
+func (h *header) verifyMAC(fileKey []byte) error {
+	if !hmac.Equal(h.headerMAC(fileKey, h.authenticated), h.mac) {
+		return ErrInvalidHeader
+	}
+	return nil
+}
+
+func parseEnvelope(b []byte) (*header, [][]byte, []byte, error) {
+	if len(b) < 3 || b[0] != Version || b[1]&^FlagAnonymous != 0 {
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+	nameLen := int(b[2])
+	if len(b) < 3+nameLen+2 {
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+
+	protocol, err := nyquist.NewProtocol(string(b[3 : 3+nameLen]))
+	if err != nil || protocol.Sig != nil {
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+	switch protocol.Pattern.String() {
+	case pattern.N.String():
+		protocol.Pattern = pattern.N
+	case pattern.X.String():
+		protocol.Pattern = pattern.X
+	default:
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+
+	h := &header{
+		protocol: protocol,
+		flags:    b[1],
+		ad:       b[: 3+nameLen : 3+nameLen],
+	}
+	envelope := b
+	b = b[3+nameLen:]
+	nrStanzas := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+
+	stanzaSize, macSize := h.stanzaSize(), protocol.Hash.Size()
+	if nrStanzas == 0 || len(b) < nrStanzas*stanzaSize+macSize {
+		return nil, nil, nil, ErrInvalidEnvelope
+	}
+	stanzas := make([][]byte, 0, nrStanzas)
+	for i := 0; i < nrStanzas; i++ {
+		stanzas = append(stanzas, b[:stanzaSize:stanzaSize])
+		b = b[stanzaSize:]
+	}
+	macOff := len(envelope) - len(b)
+	h.authenticated = envelope[:macOff:macOff]
+	h.mac, b = b[:macSize:macSize], b[macSize:]
+
+	return h, stanzas, b, nil
+}
+
+func nextPowerOf2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// shuffle randomly permutes the stanzas, with a Fisher-Yates shuffle.
+func shuffle(stanzas [][]byte, rng io.Reader) error {
+	var b [8]byte
+	for i := len(stanzas) - 1; i > 0; i-- {
+		if _, err := io.ReadFull(rng, b[:]); err != nil {
+			return err
+		}
+		// The modulo bias is negligible for the number of stanzas.
+		j := int(binary.BigEndian.Uint64(b[:]) % uint64(i+1))
+		stanzas[i], stanzas[j] = stanzas[j], stanzas[i]
+	}
+	return nil
+}
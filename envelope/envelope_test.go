@@ -0,0 +1,331 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+func mustGenerateKeypairs(t *testing.T, n int) []dh.Keypair {
+	var kps []dh.Keypair
+	for i := 0; i < n; i++ {
+		kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+		require.NoError(t, err, "GenerateKeypair")
+		kps = append(kps, kp)
+	}
+	return kps
+}
+
+func publicKeys(kps []dh.Keypair) []dh.PublicKey {
+	var pks []dh.PublicKey
+	for _, kp := range kps {
+		pks = append(pks, kp.Public())
+	}
+	return pks
+}
+
+func stanzaCount(t *testing.T, envelope []byte) int {
+	h, stanzas, _, err := parseEnvelope(envelope)
+	require.NoError(t, err, "parseEnvelope")
+	nameLen := int(envelope[2])
+	require.Equal(t, len(stanzas), int(binary.BigEndian.Uint16(envelope[3+nameLen:])), "stanza count")
+	for _, stanza := range stanzas {
+		require.Len(t, stanza, h.stanzaSize(), "stanza size")
+	}
+	return len(stanzas)
+}
+
+func TestEnvelope(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Recipients", testEnvelopeRecipients},
+		{"Anonymous", testEnvelopeAnonymous},
+		{"AddRecipients", testEnvelopeAddRecipients},
+		{"Sender", testEnvelopeSender},
+		{"Failures", testEnvelopeFailures},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testEnvelopeRecipients(t *testing.T) {
+	require := require.New(t)
+
+	recipients, outsider := mustGenerateKeypairs(t, 5), mustGenerateKeypairs(t, 1)[0]
+	plaintext := []byte("multi-recipient plaintext")
+
+	envelope, fileKey, err := Encrypt(publicKeys(recipients), plaintext, nil)
+	require.NoError(err, "Encrypt")
+	require.Len(fileKey, FileKeySize, "Encrypt - file key")
+	require.Equal(len(recipients), stanzaCount(t, envelope), "Encrypt - stanzas")
+
+	// The payload is only encrypted once.
+	h, _, body, err := parseEnvelope(envelope)
+	require.NoError(err, "parseEnvelope")
+	require.Equal(len(envelope), len(h.ad)+2+len(recipients)*h.stanzaSize()+h.protocol.Hash.Size()+len(body), "envelope size")
+
+	for i, recipient := range recipients {
+		decrypted, sender, err := Decrypt(recipient, envelope, nil)
+		require.NoError(err, "Decrypt(%d)", i)
+		require.Equal(plaintext, decrypted, "Decrypt(%d)", i)
+		require.Nil(sender, "Decrypt(%d) - anonymous sender", i)
+
+		unwrapped, _, err := UnwrapFileKey(recipient, envelope, nil)
+		require.NoError(err, "UnwrapFileKey(%d)", i)
+		require.Equal(fileKey, unwrapped, "UnwrapFileKey(%d)", i)
+	}
+
+	_, _, err = Decrypt(outsider, envelope, nil)
+	require.ErrorIs(err, ErrNoMatchingStanza, "Decrypt - outsider")
+}
+
+func testEnvelopeAnonymous(t *testing.T) {
+	require := require.New(t)
+
+	recipients, outsider := mustGenerateKeypairs(t, 5), mustGenerateKeypairs(t, 1)[0]
+	plaintext := []byte("anonymous recipients")
+
+	for _, v := range []struct {
+		nrRecipients, minStanzas, expected int
+	}{
+		{1, 0, DefaultMinStanzas},
+		{3, 0, DefaultMinStanzas},
+		{5, 0, 8},
+		{2, 16, 16},
+		{5, 1, 8},
+	} {
+		opts := &Options{
+			Anonymous:  true,
+			MinStanzas: v.minStanzas,
+		}
+		envelope, _, err := Encrypt(publicKeys(recipients[:v.nrRecipients]), plaintext, opts)
+		require.NoError(err, "Encrypt(%d, %d)", v.nrRecipients, v.minStanzas)
+		require.Equal(v.expected, stanzaCount(t, envelope), "Encrypt(%d, %d) - stanzas", v.nrRecipients, v.minStanzas)
+
+		for i, recipient := range recipients[:v.nrRecipients] {
+			decrypted, _, err := Decrypt(recipient, envelope, nil)
+			require.NoError(err, "Decrypt(%d)", i)
+			require.Equal(plaintext, decrypted, "Decrypt(%d)", i)
+		}
+		_, _, err = Decrypt(outsider, envelope, nil)
+		require.ErrorIs(err, ErrNoMatchingStanza, "Decrypt - outsider")
+	}
+
+	// Anonymous stanzas do not include key IDs.
+	envelope, _, err := Encrypt(publicKeys(recipients[:1]), plaintext, &Options{Anonymous: true})
+	require.NoError(err, "Encrypt")
+	h, _, _, err := parseEnvelope(envelope)
+	require.NoError(err, "parseEnvelope")
+	require.NotContains(string(envelope), string(h.keyID(recipients[0].Public())), "anonymous key ID")
+
+	_, _, err = Encrypt(publicKeys(recipients), plaintext, &Options{Anonymous: true, MinStanzas: -1})
+	require.Error(err, "Encrypt - invalid MinStanzas")
+
+	// Padding stanzas are not derived from anything that the recipients
+	// hold, so a recipient can't tell them apart from the other
+	// recipients' stanzas.  Padding the same envelope twice, with the
+	// same file key, produces unrelated padding stanzas.
+	envelope, _, err = Encrypt(publicKeys(recipients[:1]), plaintext, &Options{Anonymous: true})
+	require.NoError(err, "Encrypt")
+	fileKey, _, err := UnwrapFileKey(recipients[0], envelope, nil)
+	require.NoError(err, "UnwrapFileKey")
+	_, existing, _, err := parseEnvelope(envelope)
+	require.NoError(err, "parseEnvelope")
+
+	seen := make(map[string]bool)
+	for _, stanza := range existing {
+		seen[string(stanza)] = true
+	}
+	for i := 0; i < 2; i++ {
+		padded, err := AddRecipients(envelope, fileKey, publicKeys(recipients[1:2]), nil)
+		require.NoError(err, "AddRecipients(%d)", i)
+		_, stanzas, _, err := parseEnvelope(padded)
+		require.NoError(err, "parseEnvelope(%d)", i)
+		require.Len(stanzas, 2*DefaultMinStanzas, "AddRecipients(%d) - stanzas", i)
+		for _, stanza := range stanzas {
+			seen[string(stanza)] = true
+		}
+	}
+	require.Len(seen, DefaultMinStanzas+2*DefaultMinStanzas, "AddRecipients - distinct stanzas")
+
+	// Trial decrypting every stanza, the recipient can only open its own.
+	var opened int
+	for _, stanza := range existing {
+		if _, _, err := h.unwrapStanza(recipients[0], stanza); err == nil {
+			opened++
+		}
+	}
+	require.Equal(1, opened, "unwrapStanza - opened stanzas")
+}
+
+func testEnvelopeAddRecipients(t *testing.T) {
+	require := require.New(t)
+
+	recipients := mustGenerateKeypairs(t, 6)
+	plaintext := []byte("recipients added later")
+
+	for _, anonymous := range []bool{false, true} {
+		opts := &Options{Anonymous: anonymous}
+		envelope, fileKey, err := Encrypt(publicKeys(recipients[:2]), plaintext, opts)
+		require.NoError(err, "Encrypt")
+		_, _, err = Decrypt(recipients[2], envelope, nil)
+		require.ErrorIs(err, ErrNoMatchingStanza, "Decrypt - not yet added")
+
+		// The file key is available from Encrypt.
+		envelope, err = AddRecipients(envelope, fileKey, publicKeys(recipients[2:3]), opts)
+		require.NoError(err, "AddRecipients")
+
+		// Or by unwrapping it as an existing recipient.
+		unwrapped, _, err := UnwrapFileKey(recipients[2], envelope, nil)
+		require.NoError(err, "UnwrapFileKey")
+		envelope, err = AddRecipients(envelope, unwrapped, publicKeys(recipients[3:]), nil)
+		require.NoError(err, "AddRecipients - unwrapped file key")
+
+		if anonymous {
+			// Padding stanzas can't be distinguished, so they are
+			// retained: 2+2 -> 4+1+3 -> 8+3+5.
+			require.Equal(16, stanzaCount(t, envelope), "AddRecipients - anonymous stanzas")
+		} else {
+			require.Equal(len(recipients), stanzaCount(t, envelope), "AddRecipients - stanzas")
+		}
+		for i, recipient := range recipients {
+			decrypted, _, err := Decrypt(recipient, envelope, nil)
+			require.NoError(err, "Decrypt(%d)", i)
+			require.Equal(plaintext, decrypted, "Decrypt(%d)", i)
+		}
+
+		wrongKey := make([]byte, FileKeySize)
+		_, err = AddRecipients(envelope, wrongKey, publicKeys(recipients[:1]), nil)
+		require.Error(err, "AddRecipients - wrong file key")
+	}
+}
+
+func testEnvelopeSender(t *testing.T) {
+	require := require.New(t)
+
+	kps := mustGenerateKeypairs(t, 4)
+	sender, other, recipients := kps[0], kps[1], kps[2:]
+	plaintext := []byte("authenticated sender")
+
+	envelope, fileKey, err := Encrypt(publicKeys(recipients[:1]), plaintext, &Options{Sender: sender})
+	require.NoError(err, "Encrypt")
+
+	decrypted, decryptedSender, err := Decrypt(recipients[0], envelope, &DecryptOptions{Sender: sender.Public()})
+	require.NoError(err, "Decrypt")
+	require.Equal(plaintext, decrypted, "Decrypt")
+	require.Equal(sender.Public().Bytes(), decryptedSender.Bytes(), "Decrypt - sender")
+
+	_, _, err = Decrypt(recipients[0], envelope, &DecryptOptions{Sender: other.Public()})
+	require.ErrorIs(err, ErrUnexpectedSender, "Decrypt - other sender")
+
+	// Adding recipients requires the sender iff the envelope has one.
+	_, err = AddRecipients(envelope, fileKey, publicKeys(recipients[1:]), nil)
+	require.Error(err, "AddRecipients - missing sender")
+	envelope, err = AddRecipients(envelope, fileKey, publicKeys(recipients[1:]), &Options{Sender: sender})
+	require.NoError(err, "AddRecipients")
+	_, decryptedSender, err = Decrypt(recipients[1], envelope, nil)
+	require.NoError(err, "Decrypt - added recipient")
+	require.Equal(sender.Public().Bytes(), decryptedSender.Bytes(), "Decrypt - added recipient, sender")
+
+	anonymous, fileKey, err := Encrypt(publicKeys(recipients), plaintext, nil)
+	require.NoError(err, "Encrypt - anonymous")
+	_, _, err = Decrypt(recipients[0], anonymous, &DecryptOptions{Sender: sender.Public()})
+	require.ErrorIs(err, ErrUnexpectedSender, "Decrypt - anonymous, expected sender")
+	_, err = AddRecipients(anonymous, fileKey, publicKeys(recipients), &Options{Sender: sender})
+	require.Error(err, "AddRecipients - unexpected sender")
+}
+
+func testEnvelopeFailures(t *testing.T) {
+	require := require.New(t)
+
+	recipients := mustGenerateKeypairs(t, 2)
+	plaintext := []byte("tamper resistant")
+
+	_, _, err := Encrypt(nil, plaintext, nil)
+	require.Error(err, "Encrypt - no recipients")
+
+	envelope, fileKey, err := Encrypt(publicKeys(recipients), plaintext, nil)
+	require.NoError(err, "Encrypt")
+
+	tampered := append([]byte{}, envelope...)
+	tampered[len(tampered)-1] ^= 0x01
+	_, _, err = Decrypt(recipients[0], tampered, nil)
+	require.ErrorIs(err, ErrInvalidBody, "Decrypt - tampered body")
+
+	// The stanzas are authenticated by the header MAC, so a recipient can
+	// not remove or replace the other recipients' stanzas.
+	h, stanzas, body, err := parseEnvelope(envelope)
+	require.NoError(err, "parseEnvelope")
+	tampered = append([]byte{}, h.ad...)
+	tampered = binary.BigEndian.AppendUint16(tampered, 1)
+	tampered = append(tampered, stanzas[1]...)
+	tampered = append(tampered, h.mac...)
+	tampered = append(tampered, body...)
+	_, _, err = Decrypt(recipients[1], tampered, nil)
+	require.ErrorIs(err, ErrInvalidHeader, "Decrypt - removed stanza")
+	_, _, err = UnwrapFileKey(recipients[1], tampered, nil)
+	require.ErrorIs(err, ErrInvalidHeader, "UnwrapFileKey - removed stanza")
+	_, err = AddRecipients(tampered, fileKey, publicKeys(recipients[:1]), nil)
+	require.ErrorIs(err, ErrInvalidHeader, "AddRecipients - removed stanza")
+
+	tampered = append([]byte{}, envelope...)
+	tampered[len(envelope)-len(body)-1] ^= 0x01
+	_, _, err = Decrypt(recipients[0], tampered, nil)
+	require.ErrorIs(err, ErrInvalidHeader, "Decrypt - tampered header MAC")
+
+	// The flags are bound to the stanzas and the body.
+	tampered = append([]byte{}, envelope...)
+	tampered[1] |= FlagAnonymous
+	_, _, err = Decrypt(recipients[0], tampered, nil)
+	require.Error(err, "Decrypt - tampered flags")
+
+	for _, v := range []struct {
+		n        string
+		envelope []byte
+	}{
+		{"empty", nil},
+		{"version", append([]byte{Version + 1}, envelope[1:]...)},
+		{"flags", append([]byte{Version, 0x80}, envelope[2:]...)},
+		{"truncated header MAC", envelope[:len(envelope)-len(plaintext)-40]},
+		{"truncated stanzas", envelope[:len(envelope)-len(plaintext)-16-32-8]},
+		{"interactive pattern", append([]byte{Version, 0, 33}, "Noise_NN_25519_ChaChaPoly_BLAKE2s\x00\x01"...)},
+	} {
+		_, _, err = Decrypt(recipients[0], v.envelope, nil)
+		require.ErrorIs(err, ErrInvalidEnvelope, "Decrypt - %s", v.n)
+	}
+}
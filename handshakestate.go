@@ -30,6 +30,7 @@
 package nyquist
 
 import (
+	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -40,6 +41,7 @@ import (
 	"gitlab.com/yawning/nyquist.git/dh"
 	"gitlab.com/yawning/nyquist.git/hash"
 	"gitlab.com/yawning/nyquist.git/pattern"
+	"gitlab.com/yawning/nyquist.git/sig"
 )
 
 const (
@@ -51,15 +53,58 @@ const (
 
 	protocolPrefix  = "Noise"
 	invalidProtocol = "[invalid protocol]"
+
+	opWriteMessage  = "WriteMessage"
+	opReadMessage   = "ReadMessage"
+	opReplayMessage = "ReplayMessage"
 )
 
 var (
 	errTruncatedE = errors.New("nyquist/HandshakeState/ReadMessage/e: truncated message")
 	errTruncatedS = errors.New("nyquist/HandshakeState/ReadMessage/s: truncated message")
 	errMissingS   = errors.New("nyquist/HandshakeState/WriteMessage/s: s not set")
+	errTruncatedV = errors.New("nyquist/HandshakeState/ReadMessage/v: truncated message")
+	errMissingV   = errors.New("nyquist/HandshakeState/WriteMessage/v: v not set")
+	errMissingRV  = errors.New("nyquist/HandshakeState/ReadMessage/sig: rv not set")
+
+	errTruncatedSig = errors.New("nyquist/HandshakeState/ReadMessage/sig: truncated message")
+
+	errReplayMissingE  = errors.New("nyquist/HandshakeState/ReplayMessage/e: e not set")
+	errReplayMismatchE = errors.New("nyquist/HandshakeState/ReplayMessage/e: mismatched e")
+	errReplayMismatchS = errors.New("nyquist/HandshakeState/ReplayMessage/s: mismatched s")
+	errReplayMismatchV = errors.New("nyquist/HandshakeState/ReplayMessage/v: mismatched v")
+
+	errInvalidToken = errors.New("nyquist/HandshakeState: invalid token")
 
+	errMissingSig = errors.New("nyquist/New: missing signature scheme")
 	errMissingPSK = errors.New("nyquist/New: missing or excessive PreSharedKey(s)")
 	errBadPSK     = errors.New("nyquist/New: malformed PreSharedKey(s)")
+
+	// handshakeErrors are the errors that have their prefix stripped
+	// when wrapped in a HandshakeError.
+	handshakeErrors = []error{
+		ErrNonceExhausted,
+		ErrMessageSize,
+		ErrOpen,
+		ErrInvalidConfig,
+		ErrOutOfOrder,
+		ErrDone,
+		ErrProtocolNotSupported,
+		errTruncatedE,
+		errTruncatedS,
+		errMissingS,
+		errTruncatedV,
+		errMissingV,
+		errMissingRV,
+		errTruncatedSig,
+		errReplayMissingE,
+		errReplayMismatchE,
+		errReplayMismatchS,
+		errReplayMismatchV,
+		errInvalidToken,
+		errInvalidKeySize,
+		errNoExistingKey,
+	}
 )
 
 // Protocol is a the protocol to be used with a handshake.
@@ -69,6 +114,10 @@ type Protocol struct {
 	DH     dh.DH
 	Cipher cipher.Cipher
 	Hash   hash.Hash
+
+	// Sig is the signature scheme, required iff the pattern uses the
+	// signature (`v`, `sig`) tokens.
+	Sig sig.Scheme
 }
 
 // String returns the string representation of the protocol name.
@@ -81,9 +130,11 @@ func (pr *Protocol) String() string {
 		protocolPrefix,
 		pr.Pattern.String(),
 		pr.DH.String(),
-		pr.Cipher.String(),
-		pr.Hash.String(),
 	}
+	if pr.Sig != nil {
+		parts = append(parts, pr.Sig.String())
+	}
+	parts = append(parts, pr.Cipher.String(), pr.Hash.String())
 	return strings.Join(parts, "_")
 }
 
@@ -91,24 +142,36 @@ func (pr *Protocol) String() string {
 // name.  Returned protocol objects may be reused across multiple
 // HandshakeConfigs.
 //
+// Protocols that use a signature scheme have the scheme name following the
+// DH function name (eg: `Noise_XXsig_25519_Ed25519_ChaChaPoly_BLAKE2s`).
+//
 // Note: Only protocols that can be built with the built-in crypto and patterns
 // are supported.  Using custom crypto/patterns will require manually building
 // a Protocol object.
 func NewProtocol(s string) (*Protocol, error) {
 	parts := strings.Split(s, "_")
-	if len(parts) != 5 || parts[0] != protocolPrefix {
+	if (len(parts) != 5 && len(parts) != 6) || parts[0] != protocolPrefix {
 		return nil, ErrProtocolNotSupported
 	}
 
 	var pr Protocol
 	pr.Pattern = pattern.FromString(parts[1])
 	pr.DH = dh.FromString(parts[2])
+	if len(parts) == 6 {
+		if pr.Sig = sig.FromString(parts[3]); pr.Sig == nil {
+			return nil, ErrProtocolNotSupported
+		}
+		parts = append(parts[:3], parts[4:]...)
+	}
 	pr.Cipher = cipher.FromString(parts[3])
 	pr.Hash = hash.FromString(parts[4])
 
 	if pr.Pattern == nil || pr.DH == nil || pr.Cipher == nil || pr.Hash == nil {
 		return nil, ErrProtocolNotSupported
 	}
+	if (pr.Sig != nil) != usesSignatures(pr.Pattern) {
+		return nil, ErrProtocolNotSupported
+	}
 
 	return &pr, nil
 }
@@ -141,6 +204,13 @@ type HandshakeConfig struct {
 	// RemoteEphemeral is the remote ephemeral public key, if any (`re`).
 	RemoteEphemeral dh.PublicKey
 
+	// LocalSigning is the local signing keypair, if any (`v`).
+	LocalSigning sig.Keypair
+
+	// RemoteSigning is the remote signature verification public key, if
+	// any (`rv`).
+	RemoteSigning sig.PublicKey
+
 	// PreSharedKeys is the vector of pre-shared symmetric key for PSK mode
 	// handshakes.
 	PreSharedKeys [][]byte
@@ -148,10 +218,29 @@ type HandshakeConfig struct {
 	// Observer is the optional handshake observer.
 	Observer HandshakeObserver
 
+	// Tracer is the optional handshake tracer.
+	Tracer HandshakeTracer
+
+	// TraceSecrets enables passing secret-bearing values to the Tracer.
+	//
+	// Warning: This will expose key material, and should only ever be
+	// used for debugging.
+	TraceSecrets bool
+
 	// Rng is the entropy source to be used when generating new DH key pairs.
 	// If the value is `nil`, `crypto/rand.Reader` will be used.
 	Rng io.Reader
 
+	// KeyLog is the optional destination for key log entries, which
+	// contain the handshake hash and the transport keys, in the format
+	// documented with `KeyLogLabelSplit`.
+	//
+	// Writing key log entries is best-effort, and failures are ignored.
+	//
+	// Warning: Use of KeyLog compromises the security of every session
+	// logged, and should only ever be used for debugging.
+	KeyLog io.Writer
+
 	// MaxMessageSize specifies the maximum Noise message size the handshake
 	// and session will process or generate.  If the value is `0`,
 	// `DefaultMaxMessageSize` will be used.  A negative value will disable
@@ -187,6 +276,10 @@ type HandshakeStatus struct {
 	// RemoteEphemeral is the remote ephemeral public key, if any (`re`).
 	RemoteEphemeral dh.PublicKey
 
+	// RemoteSigning is the remote signature verification public key, if
+	// any (`rv`).
+	RemoteSigning sig.PublicKey
+
 	// CipherStates is the resulting CipherState pair (`(cs1, cs2)`).
 	//
 	// Note: To prevent misuse, for one-way patterns `cs2` will be nil.
@@ -195,6 +288,72 @@ type HandshakeStatus struct {
 	// HandshakeHash is the handshake hash (`h`).  This field is only set
 	// once the handshake is completed.
 	HandshakeHash []byte
+
+	// ExporterSecret is a `HASHLEN` byte secret derived from the final
+	// chaining key, that is independent of the transport keys, and can be
+	// used to derive additional keying material (eg: for session
+	// resumption).  This field is only set once the handshake is completed.
+	ExporterSecret []byte
+
+	// PeerIdentity is the peer identity returned by the Observer, if it
+	// implements PeerIdentityObserver.  This field is only set once the
+	// handshake is completed.
+	PeerIdentity interface{}
+}
+
+// HandshakeError is the error returned when a handshake operation fails.
+//
+// Note: Sentinel errors (eg: `ErrOpen`, `dh.ErrMalformedPublicKey`) will
+// always be wrapped, so `errors.Is` should be used for comparison.
+type HandshakeError struct {
+	// Op is the handshake operation that failed (`WriteMessage`,
+	// `ReadMessage`, `ReplayMessage`).
+	Op string
+
+	// MessageIndex is the index of the handshake message being processed.
+	MessageIndex int
+
+	// Token is the handshake pattern token being processed, or
+	// `pattern.Token_invalid` if the failure was not specific to a token
+	// (eg: the message payload, message size, or ordering).
+	Token pattern.Token
+
+	// IsInitiator is true iff the failure occurred in the initiator role.
+	IsInitiator bool
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the string representation of the error.
+func (e *HandshakeError) Error() string {
+	role := "responder"
+	if e.IsInitiator {
+		role = "initiator"
+	}
+
+	var token string
+	if e.Token != pattern.Token_invalid {
+		token = ", token " + e.Token.String()
+	}
+
+	// Strip the prefix from this package's own errors, so that the
+	// string representation only has the one prefix.
+	errStr := e.Err.Error()
+	for _, err := range handshakeErrors {
+		if errors.Is(e.Err, err) {
+			prefix, _, _ := strings.Cut(err.Error(), ": ")
+			errStr = strings.TrimPrefix(errStr, prefix+": ")
+			break
+		}
+	}
+
+	return fmt.Sprintf("nyquist/HandshakeState/%s: %s message %d%s: %s", e.Op, role, e.MessageIndex, token, errStr)
+}
+
+// Unwrap returns the underlying error.
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
 }
 
 // HandshakeObserver is a handshake observer for monitoring handshake status.
@@ -207,6 +366,25 @@ type HandshakeObserver interface {
 	OnPeerPublicKey(pattern.Token, dh.PublicKey) error
 }
 
+// SigningKeyObserver is an optional interface that a HandshakeObserver may
+// implement to monitor the peer's signature verification key.
+type SigningKeyObserver interface {
+	// OnPeerSigningKey will be called when a signature verification key
+	// is received from the peer (`v`).
+	//
+	// Returning a non-nil error will abort the handshake immediately.
+	OnPeerSigningKey(sig.PublicKey) error
+}
+
+// PeerIdentityObserver is an optional interface that a HandshakeObserver may
+// implement to provide the peer identity it established (eg: from an
+// authorization policy), to be included in the HandshakeStatus.
+type PeerIdentityObserver interface {
+	// PeerIdentity will be called on handshake completion, and returns
+	// the peer identity, if any.
+	PeerIdentity() interface{}
+}
+
 func (cfg *HandshakeConfig) getRng() io.Reader {
 	if cfg.Rng == nil {
 		return rand.Reader
@@ -238,6 +416,9 @@ type HandshakeState struct {
 	rs dh.PublicKey
 	re dh.PublicKey
 
+	v  sig.Keypair
+	rv sig.PublicKey
+
 	status *HandshakeStatus
 
 	patternIndex   int
@@ -357,6 +538,164 @@ func (hs *HandshakeState) onReadTokenS(payload []byte) []byte {
 	return tail
 }
 
+func (hs *HandshakeState) onWriteTokenV(dst []byte) []byte {
+	if hs.v == nil {
+		hs.status.Err = errMissingV
+		return nil
+	}
+	return hs.ss.EncryptAndHash(dst, hs.v.Public().Bytes())
+}
+
+func (hs *HandshakeState) onReadTokenV(payload []byte) []byte {
+	tempLen := hs.cfg.Protocol.Sig.PublicKeySize()
+	if hs.ss.cs.HasKey() {
+		tempLen += hs.ss.cs.aead.Overhead()
+	}
+	if len(payload) < tempLen {
+		hs.status.Err = errTruncatedV
+		return nil
+	}
+	temp, tail := payload[:tempLen], payload[tempLen:]
+
+	var vBytes []byte
+	if vBytes, hs.status.Err = hs.ss.DecryptAndHash(nil, temp); hs.status.Err != nil {
+		return nil
+	}
+	if hs.rv, hs.status.Err = hs.cfg.Protocol.Sig.ParsePublicKey(vBytes); hs.status.Err != nil {
+		return nil
+	}
+	hs.status.RemoteSigning = hs.rv
+	if observer, ok := hs.cfg.Observer.(SigningKeyObserver); ok {
+		if hs.status.Err = observer.OnPeerSigningKey(hs.rv); hs.status.Err != nil {
+			return nil
+		}
+	}
+	return tail
+}
+
+func (hs *HandshakeState) onWriteTokenSig(dst []byte) []byte {
+	if hs.v == nil {
+		hs.status.Err = errMissingV
+		return nil
+	}
+	var sigBytes []byte
+	if sigBytes, hs.status.Err = hs.v.Sign(hs.ss.GetHandshakeHash()); hs.status.Err != nil {
+		return nil
+	}
+	return hs.ss.EncryptAndHash(dst, sigBytes)
+}
+
+func (hs *HandshakeState) onReadTokenSig(payload []byte, verifier sig.PublicKey) []byte {
+	if verifier == nil {
+		hs.status.Err = errMissingRV
+		return nil
+	}
+	tempLen := hs.cfg.Protocol.Sig.SignatureSize()
+	if hs.ss.cs.HasKey() {
+		tempLen += hs.ss.cs.aead.Overhead()
+	}
+	if len(payload) < tempLen {
+		hs.status.Err = errTruncatedSig
+		return nil
+	}
+	temp, tail := payload[:tempLen], payload[tempLen:]
+
+	// The signature is over `h` prior to the signature being mixed in.
+	h := append([]byte{}, hs.ss.GetHandshakeHash()...)
+
+	var sigBytes []byte
+	if sigBytes, hs.status.Err = hs.ss.DecryptAndHash(nil, temp); hs.status.Err != nil {
+		return nil
+	}
+	if !verifier.Verify(h, sigBytes) {
+		hs.status.Err = sig.ErrInvalidSignature
+		return nil
+	}
+	return tail
+}
+
+func (hs *HandshakeState) onReplayTokenE(payload []byte) []byte {
+	if hs.e == nil {
+		hs.status.Err = errReplayMissingE
+		return nil
+	}
+	if len(payload) < hs.dhLen {
+		hs.status.Err = errTruncatedE
+		return nil
+	}
+	eBytes, tail := payload[:hs.dhLen], payload[hs.dhLen:]
+	if !bytes.Equal(eBytes, hs.e.Public().Bytes()) {
+		hs.status.Err = errReplayMismatchE
+		return nil
+	}
+	hs.ss.MixHash(eBytes)
+	if hs.cfg.Protocol.Pattern.NumPSKs() > 0 {
+		hs.ss.MixKey(eBytes)
+	}
+	hs.status.LocalEphemeral = hs.e.Public()
+	return tail
+}
+
+func (hs *HandshakeState) onReplayTokenS(payload []byte) []byte {
+	if hs.s == nil {
+		hs.status.Err = errMissingS
+		return nil
+	}
+	tempLen := hs.dhLen
+	if hs.ss.cs.HasKey() {
+		tempLen += hs.ss.cs.aead.Overhead()
+	}
+	if len(payload) < tempLen {
+		hs.status.Err = errTruncatedS
+		return nil
+	}
+	temp, tail := payload[:tempLen], payload[tempLen:]
+
+	var sBytes []byte
+	if sBytes, hs.status.Err = hs.ss.DecryptAndHash(nil, temp); hs.status.Err != nil {
+		return nil
+	}
+	if !bytes.Equal(sBytes, hs.s.Public().Bytes()) {
+		hs.status.Err = errReplayMismatchS
+		return nil
+	}
+	return tail
+}
+
+func (hs *HandshakeState) onReplayTokenV(payload []byte) []byte {
+	if hs.v == nil {
+		hs.status.Err = errMissingV
+		return nil
+	}
+	tempLen := hs.cfg.Protocol.Sig.PublicKeySize()
+	if hs.ss.cs.HasKey() {
+		tempLen += hs.ss.cs.aead.Overhead()
+	}
+	if len(payload) < tempLen {
+		hs.status.Err = errTruncatedV
+		return nil
+	}
+	temp, tail := payload[:tempLen], payload[tempLen:]
+
+	var vBytes []byte
+	if vBytes, hs.status.Err = hs.ss.DecryptAndHash(nil, temp); hs.status.Err != nil {
+		return nil
+	}
+	if !bytes.Equal(vBytes, hs.v.Public().Bytes()) {
+		hs.status.Err = errReplayMismatchV
+		return nil
+	}
+	return tail
+}
+
+func (hs *HandshakeState) onReplayTokenSig(payload []byte) []byte {
+	if hs.v == nil {
+		hs.status.Err = errMissingV
+		return nil
+	}
+	return hs.onReadTokenSig(payload, hs.v.Public())
+}
+
 func (hs *HandshakeState) onTokenEE() {
 	var eeBytes []byte
 	if eeBytes, hs.status.Err = hs.e.DH(hs.re); hs.status.Err != nil {
@@ -405,20 +744,40 @@ func (hs *HandshakeState) onTokenPsk() {
 	hs.pskIndex++
 }
 
-func (hs *HandshakeState) onDone(dst []byte) ([]byte, error) {
-	hs.patternIndex++
-	if hs.patternIndex < len(hs.patterns) {
+func (hs *HandshakeState) onError(op string, token pattern.Token, err error) error {
+	hs.status.Err = &HandshakeError{
+		Op:           op,
+		MessageIndex: hs.patternIndex,
+		Token:        token,
+		IsInitiator:  hs.isInitiator,
+		Err:          err,
+	}
+	return hs.status.Err
+}
+
+func (hs *HandshakeState) onDone(op string, dst []byte) ([]byte, error) {
+	if hs.patternIndex+1 < len(hs.patterns) {
+		hs.patternIndex++
 		return dst, nil
 	}
 
-	hs.status.Err = ErrDone
 	cs1, cs2 := hs.ss.Split()
+	if hs.cfg.KeyLog != nil {
+		hs.onKeyLog(cs1, cs2)
+	}
+
+	hs.patternIndex++
+	hs.status.Err = ErrDone
 	if hs.cfg.Protocol.Pattern.IsOneWay() {
 		cs2.Reset()
 		cs2 = nil
 	}
 	hs.status.CipherStates = []*CipherState{cs1, cs2}
 	hs.status.HandshakeHash = hs.ss.GetHandshakeHash()
+	hs.status.ExporterSecret = hs.ss.exporterSecret()
+	if observer, ok := hs.cfg.Observer.(PeerIdentityObserver); ok {
+		hs.status.PeerIdentity = observer.PeerIdentity()
+	}
 
 	// This will end up being called redundantly if the developer has any
 	// sense at al, but it's cheap foot+gun avoidance.
@@ -430,24 +789,32 @@ func (hs *HandshakeState) onDone(dst []byte) ([]byte, error) {
 // WriteMessage processes a write step of the handshake protocol, appending the
 // handshake protocol message to dst, and returning the potentially new slice.
 //
-// Iff the handshake is complete, the error returned will be `ErrDone`.
+// Iff the handshake is complete, the error returned will be `ErrDone`,
+// otherwise failures will be returned as a `*HandshakeError`.
 func (hs *HandshakeState) WriteMessage(dst, payload []byte) ([]byte, error) {
 	if hs.status.Err != nil {
 		return nil, hs.status.Err
 	}
 
 	if hs.isInitiator != (hs.patternIndex&1 == 0) {
-		hs.status.Err = ErrOutOfOrder
-		return nil, hs.status.Err
+		return nil, hs.onError(opWriteMessage, pattern.Token_invalid, ErrOutOfOrder)
 	}
 
 	baseLen := len(dst)
 	for _, v := range hs.patterns[hs.patternIndex] {
+		if hs.cfg.Tracer != nil {
+			hs.cfg.Tracer.OnToken(opWriteMessage, hs.patternIndex, v)
+		}
+
 		switch v {
 		case pattern.Token_e:
 			dst = hs.onWriteTokenE(dst)
 		case pattern.Token_s:
 			dst = hs.onWriteTokenS(dst)
+		case pattern.Token_v:
+			dst = hs.onWriteTokenV(dst)
+		case pattern.Token_sig:
+			dst = hs.onWriteTokenSig(dst)
 		case pattern.Token_ee:
 			hs.onTokenEE()
 		case pattern.Token_es:
@@ -459,49 +826,55 @@ func (hs *HandshakeState) WriteMessage(dst, payload []byte) ([]byte, error) {
 		case pattern.Token_psk:
 			hs.onTokenPsk()
 		default:
-			hs.status.Err = errors.New("nyquist/HandshakeState/WriteMessage: invalid token: " + v.String())
+			hs.status.Err = errInvalidToken
 		}
 
 		if hs.status.Err != nil {
-			return nil, hs.status.Err
+			return nil, hs.onError(opWriteMessage, v, hs.status.Err)
 		}
 	}
 
 	dst = hs.ss.EncryptAndHash(dst, payload)
 	if hs.maxMessageSize > 0 && len(dst)-baseLen > hs.maxMessageSize {
-		hs.status.Err = ErrMessageSize
-		return nil, hs.status.Err
+		return nil, hs.onError(opWriteMessage, pattern.Token_invalid, ErrMessageSize)
 	}
 
-	return hs.onDone(dst)
+	return hs.onDone(opWriteMessage, dst)
 }
 
 // ReadMessage processes a read step of the handshake protocol, appending the
 // authentiated/decrypted message payload to dst, and returning the potentially
 // new slice.
 //
-// Iff the handshake is complete, the error returned will be `ErrDone`.
+// Iff the handshake is complete, the error returned will be `ErrDone`,
+// otherwise failures will be returned as a `*HandshakeError`.
 func (hs *HandshakeState) ReadMessage(dst, payload []byte) ([]byte, error) {
 	if hs.status.Err != nil {
 		return nil, hs.status.Err
 	}
 
 	if hs.maxMessageSize > 0 && len(payload) > hs.maxMessageSize {
-		hs.status.Err = ErrMessageSize
-		return nil, hs.status.Err
+		return nil, hs.onError(opReadMessage, pattern.Token_invalid, ErrMessageSize)
 	}
 
 	if hs.isInitiator != (hs.patternIndex&1 != 0) {
-		hs.status.Err = ErrOutOfOrder
-		return nil, hs.status.Err
+		return nil, hs.onError(opReadMessage, pattern.Token_invalid, ErrOutOfOrder)
 	}
 
 	for _, v := range hs.patterns[hs.patternIndex] {
+		if hs.cfg.Tracer != nil {
+			hs.cfg.Tracer.OnToken(opReadMessage, hs.patternIndex, v)
+		}
+
 		switch v {
 		case pattern.Token_e:
 			payload = hs.onReadTokenE(payload)
 		case pattern.Token_s:
 			payload = hs.onReadTokenS(payload)
+		case pattern.Token_v:
+			payload = hs.onReadTokenV(payload)
+		case pattern.Token_sig:
+			payload = hs.onReadTokenSig(payload, hs.rv)
 		case pattern.Token_ee:
 			hs.onTokenEE()
 		case pattern.Token_es:
@@ -513,20 +886,87 @@ func (hs *HandshakeState) ReadMessage(dst, payload []byte) ([]byte, error) {
 		case pattern.Token_psk:
 			hs.onTokenPsk()
 		default:
-			hs.status.Err = errors.New("nyquist/HandshakeState/ReadMessage: invalid token: " + v.String())
+			hs.status.Err = errInvalidToken
 		}
 
 		if hs.status.Err != nil {
-			return nil, hs.status.Err
+			return nil, hs.onError(opReadMessage, v, hs.status.Err)
 		}
 	}
 
 	dst, hs.status.Err = hs.ss.DecryptAndHash(dst, payload)
+	if hs.status.Err != nil {
+		return nil, hs.onError(opReadMessage, pattern.Token_invalid, hs.status.Err)
+	}
+
+	return hs.onDone(opReadMessage, dst)
+}
+
+// ReplayMessage processes a handshake protocol message that was previously
+// generated by a WriteMessage call for the local party (eg: one obtained from
+// a packet capture), appending the authenticated/decrypted message payload to
+// dst, and returning the potentially new slice.  This allows a passive
+// observer that has the local party's key material to follow a recorded
+// handshake.
+//
+// As the original ephemeral keypair can not be re-generated, any message
+// containing an `e` token requires `HandshakeConfig.LocalEphemeral` to be
+// the keypair that was used.
+//
+// Iff the handshake is complete, the error returned will be `ErrDone`,
+// otherwise failures will be returned as a `*HandshakeError`.
+func (hs *HandshakeState) ReplayMessage(dst, payload []byte) ([]byte, error) {
 	if hs.status.Err != nil {
 		return nil, hs.status.Err
 	}
 
-	return hs.onDone(dst)
+	if hs.maxMessageSize > 0 && len(payload) > hs.maxMessageSize {
+		return nil, hs.onError(opReplayMessage, pattern.Token_invalid, ErrMessageSize)
+	}
+
+	if hs.isInitiator != (hs.patternIndex&1 == 0) {
+		return nil, hs.onError(opReplayMessage, pattern.Token_invalid, ErrOutOfOrder)
+	}
+
+	for _, v := range hs.patterns[hs.patternIndex] {
+		if hs.cfg.Tracer != nil {
+			hs.cfg.Tracer.OnToken(opReplayMessage, hs.patternIndex, v)
+		}
+
+		switch v {
+		case pattern.Token_e:
+			payload = hs.onReplayTokenE(payload)
+		case pattern.Token_s:
+			payload = hs.onReplayTokenS(payload)
+		case pattern.Token_v:
+			payload = hs.onReplayTokenV(payload)
+		case pattern.Token_sig:
+			payload = hs.onReplayTokenSig(payload)
+		case pattern.Token_ee:
+			hs.onTokenEE()
+		case pattern.Token_es:
+			hs.onTokenES()
+		case pattern.Token_se:
+			hs.onTokenSE()
+		case pattern.Token_ss:
+			hs.onTokenSS()
+		case pattern.Token_psk:
+			hs.onTokenPsk()
+		default:
+			hs.status.Err = errInvalidToken
+		}
+
+		if hs.status.Err != nil {
+			return nil, hs.onError(opReplayMessage, v, hs.status.Err)
+		}
+	}
+
+	dst, hs.status.Err = hs.ss.DecryptAndHash(dst, payload)
+	if hs.status.Err != nil {
+		return nil, hs.onError(opReplayMessage, pattern.Token_invalid, hs.status.Err)
+	}
+
+	return hs.onDone(opReplayMessage, dst)
 }
 
 func (hs *HandshakeState) handlePreMessages() error {
@@ -538,23 +978,28 @@ func (hs *HandshakeState) handlePreMessages() error {
 	// Gather all the public keys from the config, from the initiator's
 	// point of view.
 	var s, e, rs, re dh.PublicKey
-	rs, re = hs.rs, hs.re
+	var v, rv sig.PublicKey
+	rs, re, rv = hs.rs, hs.re, hs.rv
 	if hs.s != nil {
 		s = hs.s.Public()
 	}
 	if hs.e != nil {
 		e = hs.e.Public()
 	}
+	if hs.v != nil {
+		v = hs.v.Public()
+	}
 	if !hs.isInitiator {
-		s, e, rs, re = rs, re, s, e
+		s, e, v, rs, re, rv = rs, re, rv, s, e, v
 	}
 
 	for i, keys := range []struct {
 		s, e dh.PublicKey
+		v    sig.PublicKey
 		side string
 	}{
-		{s, e, "initiator"},
-		{rs, re, "responder"},
+		{s, e, v, "initiator"},
+		{rs, re, rv, "responder"},
 	} {
 		if i+1 > len(preMessages) {
 			break
@@ -583,6 +1028,11 @@ func (hs *HandshakeState) handlePreMessages() error {
 					return fmt.Errorf("nyquist/New: %s s not set", keys.side)
 				}
 				hs.ss.MixHash(keys.s.Bytes())
+			case pattern.Token_v:
+				if keys.v == nil {
+					return fmt.Errorf("nyquist/New: %s v not set", keys.side)
+				}
+				hs.ss.MixHash(keys.v.Bytes())
 			default:
 				return errors.New("nyquist/New: invalid pre-message token: " + v.String())
 			}
@@ -592,6 +1042,19 @@ func (hs *HandshakeState) handlePreMessages() error {
 	return nil
 }
 
+func usesSignatures(pa pattern.Pattern) bool {
+	for _, msgs := range [][]pattern.Message{pa.PreMessages(), pa.Messages()} {
+		for _, msg := range msgs {
+			for _, v := range msg {
+				if v == pattern.Token_v || v == pattern.Token_sig {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // NewHandshake constructs a new HandshakeState with the provided configuration.
 // This call is equivalent to the `Initialize` HandshakeState call in the
 // Noise Protocol Framework specification.
@@ -606,6 +1069,9 @@ func NewHandshake(cfg *HandshakeConfig) (*HandshakeState, error) {
 			return nil, errBadPSK
 		}
 	}
+	if cfg.Protocol.Sig == nil && usesSignatures(cfg.Protocol.Pattern) {
+		return nil, errMissingSig
+	}
 
 	maxMessageSize := cfg.getMaxMessageSize()
 	hs := &HandshakeState{
@@ -617,9 +1083,12 @@ func NewHandshake(cfg *HandshakeConfig) (*HandshakeState, error) {
 		e:        cfg.LocalEphemeral,
 		rs:       cfg.RemoteStatic,
 		re:       cfg.RemoteEphemeral,
+		v:        cfg.LocalSigning,
+		rv:       cfg.RemoteSigning,
 		status: &HandshakeStatus{
 			RemoteStatic:    cfg.RemoteStatic,
 			RemoteEphemeral: cfg.RemoteEphemeral,
+			RemoteSigning:   cfg.RemoteSigning,
 		},
 		maxMessageSize: maxMessageSize,
 		dhLen:          cfg.Protocol.DH.Size(),
@@ -629,6 +1098,7 @@ func NewHandshake(cfg *HandshakeConfig) (*HandshakeState, error) {
 		hs.status.LocalEphemeral = cfg.LocalEphemeral.Public()
 	}
 
+	hs.ss.tracer, hs.ss.traceSecrets = cfg.Tracer, cfg.TraceSecrets
 	hs.ss.InitializeSymmetric([]byte(cfg.Protocol.String()))
 	hs.ss.MixHash(cfg.Prologue)
 	if err := hs.handlePreMessages(); err != nil {
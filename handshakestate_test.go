@@ -38,6 +38,7 @@ import (
 
 	"gitlab.com/yawning/nyquist.git/dh"
 	"gitlab.com/yawning/nyquist.git/pattern"
+	"gitlab.com/yawning/nyquist.git/sig"
 )
 
 const xFixedSize = 32 + 32 + 16 + 16
@@ -98,6 +99,10 @@ func TestHandshakeState(t *testing.T) {
 		{"Observer", testHandshakeStateObserver},
 		{"BadPSK", testHandshakeStateBadPSK},
 		{"MissingS", testHandshakeStateMissingS},
+		{"Error", testHandshakeStateError},
+		{"Replay", testHandshakeStateReplay},
+		{"Signatures", testHandshakeStateSignatures},
+		{"ExporterSecret", testHandshakeStateExporterSecret},
 	} {
 		t.Run(v.n, v.fn)
 	}
@@ -136,7 +141,7 @@ func testHandshakeStateKeygenFailure(t *testing.T) {
 
 	dst, err := aliceHs.WriteMessage(nil, nil)
 	require.Nil(dst, "aliceHs.WriteMessage - e generation will fail")
-	require.Equal(errFailReader, err, "aliceHs.WriteMessage - e generation will fail")
+	require.ErrorIs(err, errFailReader, "aliceHs.WriteMessage - e generation will fail")
 }
 
 func testHandshakeStateTruncatedE(t *testing.T) {
@@ -145,7 +150,7 @@ func testHandshakeStateTruncatedE(t *testing.T) {
 	_, bobHs := mustMakeX(t, 0)
 	dst, err := bobHs.ReadMessage(nil, make([]byte, 31))
 	require.Nil(dst, "bobHs.ReadMessage - truncated E")
-	require.Equal(errTruncatedE, err)
+	require.ErrorIs(err, errTruncatedE)
 }
 
 func testHandshakeStateTruncatedS(t *testing.T) {
@@ -158,7 +163,7 @@ func testHandshakeStateTruncatedS(t *testing.T) {
 
 	dst, err = bobHs.ReadMessage(nil, dst[:32+32]) // Clip off both tags.
 	require.Nil(dst, "bobHs.ReadMessage - truncated s")
-	require.Equal(errTruncatedS, err)
+	require.ErrorIs(err, errTruncatedS)
 }
 
 func testHandshakeStateOutOfOrder(t *testing.T) {
@@ -168,22 +173,22 @@ func testHandshakeStateOutOfOrder(t *testing.T) {
 
 	dst, err := aliceHs.ReadMessage(nil, []byte("never read, whatever"))
 	require.Nil(dst, "aliceHs.ReadMessage - out of order")
-	require.Equal(ErrOutOfOrder, err, "aliceHs.ReadMessage - out of order")
+	require.ErrorIs(err, ErrOutOfOrder, "aliceHs.ReadMessage - out of order")
 
 	dst, err = bobHs.WriteMessage(nil, []byte("placeholder plaintext pls ignore"))
 	require.Nil(dst, "bobHs.WriteMessage - after critical failure")
-	require.Equal(ErrOutOfOrder, err, "bobHs.WriteMessage - after critical failure")
+	require.ErrorIs(err, ErrOutOfOrder, "bobHs.WriteMessage - after critical failure")
 
 	// While we are here and have two busted HandshakeState objects, make
 	// sure that the errors are sticky.
 	dst, err = aliceHs.WriteMessage(nil, []byte("placeholder plaintext pls ignore"))
 	require.Nil(dst, "aliceHs.WriteMessage - after critical failure")
-	require.Equal(ErrOutOfOrder, err, "aliceHs.WriteMessage - after critical failure")
+	require.ErrorIs(err, ErrOutOfOrder, "aliceHs.WriteMessage - after critical failure")
 	require.Equal(err, aliceHs.GetStatus().Err)
 
 	dst, err = bobHs.ReadMessage(nil, []byte("never read, whatever"))
 	require.Nil(dst, "bobHs.ReadMessage - after critical failure")
-	require.Equal(ErrOutOfOrder, err, "bobHs.WriteMessage - after critical failure")
+	require.ErrorIs(err, ErrOutOfOrder, "bobHs.WriteMessage - after critical failure")
 	require.Equal(err, bobHs.GetStatus().Err)
 }
 
@@ -208,11 +213,11 @@ func testHandshakeStateMaxMessageSize(t *testing.T) {
 	aliceHs, bobHs = mustMakeX(t, testMMS)
 	oversizedPayload := append(maxSizedPayload, 23)
 	dst, err = aliceHs.WriteMessage(nil, oversizedPayload)
-	require.Equal(ErrMessageSize, err, "aliceHs.WriteMessage(overSize)")
+	require.ErrorIs(err, ErrMessageSize, "aliceHs.WriteMessage(overSize)")
 	require.Nil(dst, "aliceHs.WriteMessage(overSize)")
 
 	dst, err = bobHs.ReadMessage(nil, make([]byte, testMMS+1))
-	require.Equal(ErrMessageSize, err, "bobHs.ReadMessage(overSize)")
+	require.ErrorIs(err, ErrMessageSize, "bobHs.ReadMessage(overSize)")
 	require.Nil(dst, "bobHs.ReadMessage(overSize)")
 
 	// Ensure that a negative mms disables limit enforcement.
@@ -310,6 +315,316 @@ func testHandshakeStateMissingS(t *testing.T) {
 	aliceHs, _ := mustMakeX(t, 0)
 	aliceHs.s = nil // Not the best way to do this, but this also works.
 	dst, err := aliceHs.WriteMessage(nil, nil)
-	require.Equal(errMissingS, err, "aliceHs.WriteMessage()")
+	require.ErrorIs(err, errMissingS, "aliceHs.WriteMessage()")
 	require.Nil(dst, "aliceHs.WriteMessage()")
 }
+
+func testHandshakeStateError(t *testing.T) {
+	require := require.New(t)
+
+	errRejected := errors.New("nyquist/test: rejected peer")
+
+	aliceHs, bobHs := mustMakeX(t, 0)
+	bobHs.cfg.Observer = &proxyObserver{
+		callbackFn: func(token pattern.Token, pk dh.PublicKey) error {
+			if token == pattern.Token_s {
+				return errRejected
+			}
+			return nil
+		},
+	}
+
+	dst, err := aliceHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage()")
+
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.ErrorIs(err, errRejected, "bobHs.ReadMessage() - observer rejection")
+
+	var hsErr *HandshakeError
+	require.ErrorAs(err, &hsErr, "bobHs.ReadMessage() - HandshakeError")
+	require.Equal(opReadMessage, hsErr.Op, "HandshakeError.Op")
+	require.Equal(0, hsErr.MessageIndex, "HandshakeError.MessageIndex")
+	require.Equal(pattern.Token_s, hsErr.Token, "HandshakeError.Token")
+	require.False(hsErr.IsInitiator, "HandshakeError.IsInitiator")
+	require.Equal(err, bobHs.GetStatus().Err, "Status.Err is the HandshakeError")
+
+	// Authentication failures on the payload are not specific to a token.
+	aliceHs, bobHs = mustMakeX(t, 0)
+	dst, err = aliceHs.WriteMessage(nil, []byte("tampered payload"))
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage()")
+	dst[len(dst)-1] ^= 0xa5
+
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.ErrorIs(err, ErrOpen, "bobHs.ReadMessage() - tampered payload")
+	require.ErrorAs(err, &hsErr, "bobHs.ReadMessage() - HandshakeError")
+	require.Equal(pattern.Token_invalid, hsErr.Token, "HandshakeError.Token")
+	require.EqualError(err, "nyquist/HandshakeState/ReadMessage: responder message 0: decryption failure")
+
+	// Token specific sentinel errors only have the one prefix.
+	_, bobHs = mustMakeX(t, 0)
+	_, err = bobHs.ReadMessage(nil, []byte{0x00})
+	require.ErrorIs(err, errTruncatedE, "bobHs.ReadMessage() - truncated")
+	require.EqualError(err, "nyquist/HandshakeState/ReadMessage: responder message 0, token e: truncated message")
+
+	// Errors from outside the package keep the underlying message.
+	require.Equal("nyquist/HandshakeState/ReadMessage: initiator message 1: rejected", (&HandshakeError{
+		Op:           opReadMessage,
+		MessageIndex: 1,
+		Token:        pattern.Token_invalid,
+		IsInitiator:  true,
+		Err:          errors.New("rejected"),
+	}).Error())
+	require.Equal("nyquist/HandshakeState/ReadMessage: initiator message 1, token s: nyquist/authz: peer denied", (&HandshakeError{
+		Op:           opReadMessage,
+		MessageIndex: 1,
+		Token:        pattern.Token_s,
+		IsInitiator:  true,
+		Err:          errors.New("nyquist/authz: peer denied"),
+	}).Error())
+}
+
+func testHandshakeStateReplay(t *testing.T) {
+	require := require.New(t)
+
+	aliceHs, _ := mustMakeX(t, 0)
+	aliceEphemeral, err := aliceHs.dh.GenerateKeypair(rand.Reader)
+	require.NoError(err, "Generate Alice's ephemeral keypair")
+
+	aliceCfg := *aliceHs.cfg
+	aliceCfg.LocalEphemeral = aliceEphemeral
+	aliceHs, err = NewHandshake(&aliceCfg)
+	require.NoError(err, "NewHandshake(aliceCfg)")
+
+	alicePayload := []byte("alice replayed payload")
+	dst, err := aliceHs.WriteMessage(nil, alicePayload)
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage()")
+
+	// Replaying the message through a fresh HandshakeState with the same
+	// configuration results in the same handshake.
+	replayHs, err := NewHandshake(&aliceCfg)
+	require.NoError(err, "NewHandshake(aliceCfg) - replay")
+	_, err = replayHs.ReadMessage(nil, dst)
+	require.ErrorIs(err, ErrOutOfOrder, "replayHs.ReadMessage()")
+
+	replayHs, err = NewHandshake(&aliceCfg)
+	require.NoError(err, "NewHandshake(aliceCfg) - replay")
+	payload, err := replayHs.ReplayMessage(nil, dst)
+	require.Equal(ErrDone, err, "replayHs.ReplayMessage()")
+	require.Equal(alicePayload, payload, "replayHs.ReplayMessage()")
+	require.Equal(aliceHs.GetStatus().HandshakeHash, replayHs.GetStatus().HandshakeHash, "Handshake hashes match")
+
+	// Replaying without the ephemeral keypair can not work.
+	aliceCfg.LocalEphemeral = nil
+	replayHs, err = NewHandshake(&aliceCfg)
+	require.NoError(err, "NewHandshake(aliceCfg) - replay, no e")
+	_, err = replayHs.ReplayMessage(nil, dst)
+	require.ErrorIs(err, errReplayMissingE, "replayHs.ReplayMessage() - no e")
+
+	// Replaying a message from a different ephemeral keypair is rejected.
+	otherHs, _ := mustMakeX(t, 0)
+	otherDst, err := otherHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "otherHs.WriteMessage()")
+	aliceCfg.LocalEphemeral = aliceEphemeral
+	replayHs, err = NewHandshake(&aliceCfg)
+	require.NoError(err, "NewHandshake(aliceCfg) - replay, wrong e")
+	_, err = replayHs.ReplayMessage(nil, otherDst)
+	require.ErrorIs(err, errReplayMismatchE, "replayHs.ReplayMessage() - wrong e")
+}
+
+type signingKeyObserver struct {
+	proxyObserver
+
+	signingKeys []sig.PublicKey
+}
+
+func (o *signingKeyObserver) OnPeerSigningKey(pk sig.PublicKey) error {
+	o.signingKeys = append(o.signingKeys, pk)
+	return nil
+}
+
+func testHandshakeStateSignatures(t *testing.T) {
+	require := require.New(t)
+
+	protocol, err := NewProtocol("Noise_XXsig_25519_Ed25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+	require.Equal(sig.Ed25519, protocol.Sig, "NewProtocol - Sig")
+	require.Equal("Noise_XXsig_25519_Ed25519_ChaChaPoly_BLAKE2s", protocol.String(), "protocol.String()")
+
+	_, err = NewProtocol("Noise_XXsig_25519_ChaChaPoly_BLAKE2s")
+	require.Equal(ErrProtocolNotSupported, err, "NewProtocol - missing signature scheme")
+	_, err = NewProtocol("Noise_XX_25519_Ed25519_ChaChaPoly_BLAKE2s")
+	require.Equal(ErrProtocolNotSupported, err, "NewProtocol - unused signature scheme")
+
+	aliceSigning, err := sig.Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "Generate Alice's signing keypair")
+	bobSigning, err := sig.Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "Generate Bob's signing keypair")
+
+	newPair := func() (*HandshakeState, *HandshakeState, *signingKeyObserver) {
+		aliceHs, err := NewHandshake(&HandshakeConfig{
+			Protocol:     protocol,
+			LocalSigning: aliceSigning,
+			IsInitiator:  true,
+		})
+		require.NoError(err, "NewHandshake(alice)")
+		observer := &signingKeyObserver{
+			proxyObserver: proxyObserver{
+				callbackFn: func(pattern.Token, dh.PublicKey) error {
+					return nil
+				},
+			},
+		}
+		bobHs, err := NewHandshake(&HandshakeConfig{
+			Protocol:     protocol,
+			LocalSigning: bobSigning,
+			Observer:     observer,
+		})
+		require.NoError(err, "NewHandshake(bob)")
+		return aliceHs, bobHs, observer
+	}
+
+	aliceHs, bobHs, observer := newPair()
+	dst, err := aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage(1)")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.NoError(err, "bobHs.ReadMessage(1)")
+	dst, err = bobHs.WriteMessage(nil, nil)
+	require.NoError(err, "bobHs.WriteMessage(1)")
+	require.Len(dst, 32+32+16+64+16+16, "bobHs.WriteMessage(1)")
+	_, err = aliceHs.ReadMessage(nil, dst)
+	require.NoError(err, "aliceHs.ReadMessage(1)")
+	dst, err = aliceHs.WriteMessage(nil, []byte("alice payload"))
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage(2)")
+	payload, err := bobHs.ReadMessage(nil, dst)
+	require.Equal(ErrDone, err, "bobHs.ReadMessage(2)")
+	require.Equal([]byte("alice payload"), payload, "bobHs.ReadMessage(2)")
+
+	aliceStatus, bobStatus := aliceHs.GetStatus(), bobHs.GetStatus()
+	require.Equal(bobSigning.Public().Bytes(), aliceStatus.RemoteSigning.Bytes(), "alice RemoteSigning")
+	require.Equal(aliceSigning.Public().Bytes(), bobStatus.RemoteSigning.Bytes(), "bob RemoteSigning")
+	require.Equal(aliceStatus.HandshakeHash, bobStatus.HandshakeHash, "Handshake hashes match")
+	require.Len(observer.signingKeys, 1, "bob SigningKeyObserver")
+	require.Equal(aliceSigning.Public().Bytes(), observer.signingKeys[0].Bytes(), "bob SigningKeyObserver")
+
+	// Corrupting the signature must fail the handshake.  The transport
+	// encryption would normally catch this, so the signing key is swapped
+	// out to produce a valid ciphertext with a bad signature.
+	aliceHs, bobHs, _ = newPair()
+	dst, err = aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage(1) - bad sig")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.NoError(err, "bobHs.ReadMessage(1) - bad sig")
+	bobHs.v = &mismatchedSigner{Keypair: bobSigning, signer: aliceSigning}
+	dst, err = bobHs.WriteMessage(nil, nil)
+	require.NoError(err, "bobHs.WriteMessage(1) - bad sig")
+	_, err = aliceHs.ReadMessage(nil, dst)
+	require.ErrorIs(err, sig.ErrInvalidSignature, "aliceHs.ReadMessage(1) - bad sig")
+	var hsErr *HandshakeError
+	require.ErrorAs(err, &hsErr, "aliceHs.ReadMessage(1) - bad sig")
+	require.Equal(pattern.Token_sig, hsErr.Token, "aliceHs.ReadMessage(1) - bad sig")
+
+	// Verification keys known in advance (pre-message `v`).
+	kkProtocol, err := NewProtocol("Noise_KKsig_25519_Ed25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol - KKsig")
+	aliceHs, err = NewHandshake(&HandshakeConfig{
+		Protocol:      kkProtocol,
+		LocalSigning:  aliceSigning,
+		RemoteSigning: bobSigning.Public(),
+		IsInitiator:   true,
+	})
+	require.NoError(err, "NewHandshake(alice) - KKsig")
+	bobHs, err = NewHandshake(&HandshakeConfig{
+		Protocol:      kkProtocol,
+		LocalSigning:  bobSigning,
+		RemoteSigning: aliceSigning.Public(),
+	})
+	require.NoError(err, "NewHandshake(bob) - KKsig")
+	dst, err = aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage(1) - KKsig")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.NoError(err, "bobHs.ReadMessage(1) - KKsig")
+	dst, err = bobHs.WriteMessage(nil, nil)
+	require.NoError(err, "bobHs.WriteMessage(1) - KKsig")
+	_, err = aliceHs.ReadMessage(nil, dst)
+	require.NoError(err, "aliceHs.ReadMessage(1) - KKsig")
+	dst, err = aliceHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage(2) - KKsig")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.Equal(ErrDone, err, "bobHs.ReadMessage(2) - KKsig")
+
+	_, err = NewHandshake(&HandshakeConfig{
+		Protocol:     kkProtocol,
+		LocalSigning: aliceSigning,
+		IsInitiator:  true,
+	})
+	require.EqualError(err, "nyquist/New: responder v not set", "NewHandshake() - KKsig, missing rv")
+
+	// Missing the local signing keypair.
+	_, err = NewHandshake(&HandshakeConfig{
+		Protocol: &Protocol{
+			Pattern: protocol.Pattern,
+			DH:      protocol.DH,
+			Cipher:  protocol.Cipher,
+			Hash:    protocol.Hash,
+		},
+	})
+	require.Equal(errMissingSig, err, "NewHandshake() - missing signature scheme")
+	aliceHs, err = NewHandshake(&HandshakeConfig{
+		Protocol:    protocol,
+		IsInitiator: true,
+	})
+	require.NoError(err, "NewHandshake() - missing v")
+	bobHs, err = NewHandshake(&HandshakeConfig{
+		Protocol: protocol,
+	})
+	require.NoError(err, "NewHandshake() - missing v")
+	dst, err = aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage(1) - missing v")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.NoError(err, "bobHs.ReadMessage(1) - missing v")
+	_, err = bobHs.WriteMessage(nil, nil)
+	require.ErrorIs(err, errMissingV, "bobHs.WriteMessage(1) - missing v")
+}
+
+type mismatchedSigner struct {
+	sig.Keypair
+	signer sig.Keypair
+}
+
+func (s *mismatchedSigner) Sign(msg []byte) ([]byte, error) {
+	return s.signer.Sign(msg)
+}
+
+func testHandshakeStateExporterSecret(t *testing.T) {
+	require := require.New(t)
+
+	protocol, err := NewProtocol("Noise_NN_25519_ChaChaPoly_BLAKE2b")
+	require.NoError(err, "NewProtocol")
+
+	aliceHs, err := NewHandshake(&HandshakeConfig{
+		Protocol:    protocol,
+		IsInitiator: true,
+	})
+	require.NoError(err, "NewHandshake(alice)")
+	bobHs, err := NewHandshake(&HandshakeConfig{
+		Protocol: protocol,
+	})
+	require.NoError(err, "NewHandshake(bob)")
+
+	dst, err := aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage")
+	require.Nil(aliceHs.GetStatus().ExporterSecret, "alice ExporterSecret - in progress")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.NoError(err, "bobHs.ReadMessage")
+	dst, err = bobHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "bobHs.WriteMessage")
+	_, err = aliceHs.ReadMessage(nil, dst)
+	require.Equal(ErrDone, err, "aliceHs.ReadMessage")
+
+	aliceStatus, bobStatus := aliceHs.GetStatus(), bobHs.GetStatus()
+	require.Len(aliceStatus.ExporterSecret, protocol.Hash.Size(), "alice ExporterSecret")
+	require.Equal(aliceStatus.ExporterSecret, bobStatus.ExporterSecret, "ExporterSecrets match")
+	for i, cs := range aliceStatus.CipherStates {
+		require.NotEqual(cs.k, aliceStatus.ExporterSecret[:len(cs.k)], "ExporterSecret != k%d", i+1)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package hdkey implements hierarchical deterministic derivation of static
+// keypairs from a master seed and a label path, with HKDF (RFC 5869).
+//
+// Nodes are derived as follows, where `||` is concatenation and `len(x)`
+// is the length of `x` as a single byte:
+//
+//	k_0     = HKDF-Extract(salt = "NyquistHD", IKM = seed)
+//	k_(i+1) = HKDF-Expand(k_i, "NyquistHD child" || len(label_i) || label_i, HASHLEN)
+//
+// and the private key for a DH function at node `k_n` is:
+//
+//	HKDF-Expand(k_n, "NyquistHD key" || len(dh) || dh, DHLEN)
+//
+// where `dh` is the name of the DH function (eg: "25519"), and the result
+// is passed to `DH.ParsePrivateKey`.  Every node is "hardened", in that
+// knowing a node's public key does not allow deriving the public keys of
+// its children.  Public keys are derived from the node, with
+// `Node.PublicKey`.
+package hdkey // import "gitlab.com/yawning/nyquist.git/hdkey"
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+)
+
+// MinSeedSize is the minimum size of a master seed in bytes.
+const MinSeedSize = 16
+
+var (
+	// ErrSeedTooShort is the error returned when a master seed is shorter
+	// than MinSeedSize.
+	ErrSeedTooShort = errors.New("nyquist/hdkey: seed too short")
+
+	// ErrInvalidLabel is the error returned when a path label is invalid.
+	ErrInvalidLabel = errors.New("nyquist/hdkey: invalid label")
+
+	errNodeReset = errors.New("nyquist/hdkey: node has been reset")
+
+	labelMaster = []byte("NyquistHD")
+	labelChild  = []byte("NyquistHD child")
+	labelKey    = []byte("NyquistHD key")
+)
+
+// Path is a derivation path, as a sequence of labels.  Labels must be
+// non-empty, at most 255 bytes, and must not contain `/`.
+type Path []string
+
+// String returns the string representation of the path, with the labels
+// separated by `/`.
+func (p Path) String() string {
+	return strings.Join(p, "/")
+}
+
+func (p Path) validate() error {
+	for _, label := range p {
+		if label == "" || len(label) > 255 || strings.Contains(label, "/") {
+			return ErrInvalidLabel
+		}
+	}
+	return nil
+}
+
+// ParsePath parses the string representation of a path (eg:
+// `fleet/eu-west/device-0042`).  The empty string is the empty path.
+func ParsePath(s string) (Path, error) {
+	if s == "" {
+		return Path{}, nil
+	}
+	p := Path(strings.Split(s, "/"))
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Node is a node in the derivation hierarchy.  A Node (including the
+// master node) is sufficient to derive every keypair in its subtree, and
+// must be protected accordingly.
+type Node struct {
+	hash hash.Hash
+	key  []byte
+}
+
+// Child derives the child node with the label.
+func (n *Node) Child(label string) (*Node, error) {
+	return n.Derive(Path{label})
+}
+
+// Derive derives the descendant node at the path, relative to the node.
+func (n *Node) Derive(path Path) (*Node, error) {
+	if n.key == nil {
+		return nil, errNodeReset
+	}
+	if err := path.validate(); err != nil {
+		return nil, err
+	}
+
+	key := append([]byte{}, n.key...)
+	for _, label := range path {
+		next := make([]byte, n.hash.Size())
+		expand(n.hash, key, labeledInfo(labelChild, label), next)
+		clear(key)
+		key = next
+	}
+
+	return &Node{
+		hash: n.hash,
+		key:  key,
+	}, nil
+}
+
+// Keypair derives the node's keypair for the DH function.
+func (n *Node) Keypair(dhImpl dh.DH) (dh.Keypair, error) {
+	if n.key == nil {
+		return nil, errNodeReset
+	}
+
+	privateKey := make([]byte, dhImpl.Size())
+	defer clear(privateKey)
+	expand(n.hash, n.key, labeledInfo(labelKey, dhImpl.String()), privateKey)
+
+	return dhImpl.ParsePrivateKey(privateKey)
+}
+
+// PublicKey derives the node's public key for the DH function.
+func (n *Node) PublicKey(dhImpl dh.DH) (dh.PublicKey, error) {
+	keypair, err := n.Keypair(dhImpl)
+	if err != nil {
+		return nil, err
+	}
+	defer keypair.DropPrivate()
+
+	return dhImpl.ParsePublicKey(keypair.Public().Bytes())
+}
+
+// Reset clears the node's key material.  The node must not be used after
+// it has been reset.
+func (n *Node) Reset() {
+	clear(n.key)
+	n.key = nil
+}
+
+// NewMaster creates the master node for the seed, with the hash function
+// used for HKDF.
+func NewMaster(h hash.Hash, seed []byte) (*Node, error) {
+	if len(seed) < MinSeedSize {
+		return nil, ErrSeedTooShort
+	}
+	return &Node{
+		hash: h,
+		key:  hkdf.Extract(h.New, seed, labelMaster),
+	}, nil
+}
+
+// DeriveKeypair derives the keypair for the DH function at the path from
+// the master seed, with the hash function used for HKDF.
+func DeriveKeypair(h hash.Hash, dhImpl dh.DH, seed []byte, path Path) (dh.Keypair, error) {
+	master, err := NewMaster(h, seed)
+	if err != nil {
+		return nil, err
+	}
+	defer master.Reset()
+
+	n, err := master.Derive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer n.Reset()
+
+	return n.Keypair(dhImpl)
+}
+
+func labeledInfo(prefix []byte, s string) []byte {
+	info := make([]byte, 0, len(prefix)+1+len(s))
+	info = append(info, prefix...)
+	info = append(info, byte(len(s)))
+	return append(info, s...)
+}
+
+func expand(h hash.Hash, prk, info, out []byte) {
+	if _, err := io.ReadFull(hkdf.Expand(h.New, prk, info), out); err != nil {
+		panic("nyquist/hdkey: failed to expand: " + err.Error())
+	}
+}
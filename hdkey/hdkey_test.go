@@ -0,0 +1,234 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package hdkey
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+)
+
+// The test vectors were generated with this implementation, and the
+// private keys cross-checked against an independent implementation of
+// the construction in the package documentation.
+var testVectors = []struct {
+	hash       hash.Hash
+	dh         dh.DH
+	path       string
+	privateKey string
+	publicKey  string
+}{
+	{
+		hash:       hash.BLAKE2s,
+		dh:         dh.X25519,
+		path:       "",
+		privateKey: "c2f1743c65a96def478163644d7acc138aebc03a1a3a6e737f8ecff4dcbfb04c",
+		publicKey:  "5dad71e3c16d25268786e7c386c453a674568b78736ffba50aec7e9a08870a3a",
+	},
+	{
+		hash:       hash.BLAKE2s,
+		dh:         dh.X25519,
+		path:       "fleet/eu-west/device-0042",
+		privateKey: "83d5cc19a315982fbae4fcaca7df89001582adb63a638586fc9b2d6a6d57dca8",
+		publicKey:  "553065b932510a57b1a44bc59e4bf49b866b66f059aec71ea03f64f7a5f99a36",
+	},
+	{
+		hash:       hash.BLAKE2s,
+		dh:         dh.X448,
+		path:       "",
+		privateKey: "556494e578b6dbb9d7115d348d7a029aa14ebb25fe7eecb25480495fb3fbc0ee05e3d60e627531996f4ade75160179339e5da465549085b4",
+		publicKey:  "f2f2c76fac5913f914a52d71b96fa665a4352172851d7a111ae5eb79bd950d84381c8aa248a31a05a5995b6e24ad91b1a0d588eab90e13f8",
+	},
+	{
+		hash:       hash.BLAKE2s,
+		dh:         dh.X448,
+		path:       "fleet/eu-west/device-0042",
+		privateKey: "34874f2c851deba7990fffc225b5373a0a8e49e42cc03f4762aa2680095359488af26afc8c294bb3bd2806c63b4adccc3049f9f64d992e87",
+		publicKey:  "b97739946ca8a8f7106ee60ad2f48cc53d0f5b2efce63873f6be5158758f333cab971ebd984910aa377b1e11b8412bc6576deb64af540b49",
+	},
+	{
+		hash:       hash.SHA512,
+		dh:         dh.X25519,
+		path:       "",
+		privateKey: "c8621ad5b93998e74568ee656d353f88b67859eddf5516758f92bc2c6c26fe31",
+		publicKey:  "8502769e814e6af4b0941623cd59b276e8cbf0f8c52060a4dd5328d0bbcd2f12",
+	},
+	{
+		hash:       hash.SHA512,
+		dh:         dh.X25519,
+		path:       "fleet/eu-west/device-0042",
+		privateKey: "c2f6411de6dab7fe862fe55618f0f346b436006f0d213115834d1de72d2b62c7",
+		publicKey:  "329a81704076bc984efdd2af941f02459c817a1ba2836b2d8d60d5b55b329c2b",
+	},
+	{
+		hash:       hash.SHA512,
+		dh:         dh.X448,
+		path:       "",
+		privateKey: "5e338676eb66e46651b9f1448d2dfd49a2ac414a76b9a4b83515b5d1afbcc94f5a34d044f157ff87d7ab52b41a5f2b2258d05117faa83d81",
+		publicKey:  "4e55ff85ccfde8a2e811769ecc4ab60c2f8e0132405de35eff7039f7e9eabaaba1124bf72e54739528e864e0932a72badf0306438abe75cd",
+	},
+	{
+		hash:       hash.SHA512,
+		dh:         dh.X448,
+		path:       "fleet/eu-west/device-0042",
+		privateKey: "324c05fbb9fe366b3ed111445d2caa1dc6aea6e13ce4740eb58b508036727e5a6c6adf7a152d9d3a509167b8c0c4cf004f42e7e532020288",
+		publicKey:  "b8c6113aa58e3d840998ccf47ea995c5bbbd7531aa7191b90b9e58d471a0dea5a7fcc2855f3f81134bc4e1388a115020b5b361cc7c735a37",
+	},
+}
+
+var testSeed = func() []byte {
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	return seed
+}()
+
+func TestHDKey(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Vectors", testHDKeyVectors},
+		{"Hierarchy", testHDKeyHierarchy},
+		{"Path", testHDKeyPath},
+		{"Errors", testHDKeyErrors},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testHDKeyVectors(t *testing.T) {
+	require := require.New(t)
+
+	for i, v := range testVectors {
+		path, err := ParsePath(v.path)
+		require.NoError(err, "ParsePath(%d)", i)
+
+		keypair, err := DeriveKeypair(v.hash, v.dh, testSeed, path)
+		require.NoError(err, "DeriveKeypair(%d)", i)
+
+		privateKey, err := keypair.MarshalBinary()
+		require.NoError(err, "MarshalBinary(%d)", i)
+		require.Equal(v.privateKey, hex.EncodeToString(privateKey), "private key (%d)", i)
+		require.Equal(v.publicKey, hex.EncodeToString(keypair.Public().Bytes()), "public key (%d)", i)
+	}
+}
+
+func testHDKeyHierarchy(t *testing.T) {
+	require := require.New(t)
+
+	master, err := NewMaster(hash.BLAKE2s, testSeed)
+	require.NoError(err, "NewMaster")
+
+	// Deriving a path one label at a time must match deriving it at once.
+	region, err := master.Derive(Path{"fleet", "eu-west"})
+	require.NoError(err, "Derive: region")
+	device, err := region.Child("device-0042")
+	require.NoError(err, "Child: device")
+	publicKey, err := device.PublicKey(dh.X25519)
+	require.NoError(err, "PublicKey")
+	require.Equal(testVectors[1].publicKey, hex.EncodeToString(publicKey.Bytes()), "PublicKey: vector")
+
+	// Siblings must derive different keys.
+	sibling, err := region.Child("device-0043")
+	require.NoError(err, "Child: sibling")
+	siblingPublicKey, err := sibling.PublicKey(dh.X25519)
+	require.NoError(err, "PublicKey: sibling")
+	require.NotEqual(publicKey.Bytes(), siblingPublicKey.Bytes(), "sibling public key")
+
+	// Labels are length prefixed, so moving the separator changes the key.
+	other, err := master.Derive(Path{"fleet", "eu-west", "device", "0042"})
+	require.NoError(err, "Derive: other")
+	otherPublicKey, err := other.PublicKey(dh.X25519)
+	require.NoError(err, "PublicKey: other")
+	require.NotEqual(publicKey.Bytes(), otherPublicKey.Bytes(), "other public key")
+
+	device.Reset()
+	_, err = device.Keypair(dh.X25519)
+	require.ErrorIs(err, errNodeReset, "Keypair: after Reset")
+	_, err = device.Child("x")
+	require.ErrorIs(err, errNodeReset, "Child: after Reset")
+
+	// Resetting a child must not affect the parent.
+	_, err = region.Keypair(dh.X448)
+	require.NoError(err, "Keypair: parent after child Reset")
+}
+
+func testHDKeyPath(t *testing.T) {
+	require := require.New(t)
+
+	path, err := ParsePath("fleet/eu-west/device-0042")
+	require.NoError(err, "ParsePath")
+	require.Equal(Path{"fleet", "eu-west", "device-0042"}, path, "ParsePath")
+	require.Equal("fleet/eu-west/device-0042", path.String(), "String")
+
+	path, err = ParsePath("")
+	require.NoError(err, "ParsePath: empty")
+	require.Empty(path, "ParsePath: empty")
+
+	for _, s := range []string{
+		"/fleet",
+		"fleet/",
+		"fleet//device",
+		string(make([]byte, 256)),
+	} {
+		_, err = ParsePath(s)
+		require.ErrorIs(err, ErrInvalidLabel, "ParsePath(%q)", s)
+	}
+
+	master, err := NewMaster(hash.SHA256, testSeed)
+	require.NoError(err, "NewMaster")
+	_, err = master.Child("a/b")
+	require.ErrorIs(err, ErrInvalidLabel, "Child: separator")
+	_, err = master.Child("")
+	require.ErrorIs(err, ErrInvalidLabel, "Child: empty")
+}
+
+func testHDKeyErrors(t *testing.T) {
+	require := require.New(t)
+
+	_, err := NewMaster(hash.BLAKE2s, make([]byte, MinSeedSize-1))
+	require.ErrorIs(err, ErrSeedTooShort, "NewMaster: short seed")
+
+	_, err = DeriveKeypair(hash.BLAKE2s, dh.X25519, make([]byte, MinSeedSize-1), nil)
+	require.ErrorIs(err, ErrSeedTooShort, "DeriveKeypair: short seed")
+
+	seed := make([]byte, 32)
+	_, err = rand.Read(seed)
+	require.NoError(err, "rand.Read")
+	_, err = DeriveKeypair(hash.BLAKE2b, dh.X448, seed, Path{"a", ""})
+	require.ErrorIs(err, ErrInvalidLabel, "DeriveKeypair: invalid label")
+}
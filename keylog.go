@@ -0,0 +1,159 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package nyquist
+
+import (
+	"encoding/hex"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Key log labels.
+//
+// The key log is a text format inspired by `SSLKEYLOGFILE`, that allows
+// tooling to decrypt captured Noise transport messages.  Each entry is a
+// single line of space separated fields, with binary values hex encoded,
+// and fields that are not applicable written as `-`.
+//
+// On handshake completion, the following entry is written:
+//
+//	NOISE_SPLIT <protocol_name> <initiator_e> <responder_e> <h> <k1> <k2>
+//
+// where `initiator_e` and `responder_e` are the ephemeral public keys of
+// each party (which serve as the identifier for the session), `h` is the
+// handshake hash, and `k1` and `k2` are the keys of the CipherStates
+// returned by `Split()` (`k2` is omitted for one-way patterns).
+//
+// Each successful `CipherState.Rekey` call on a CipherState returned by the
+// handshake will write the following entry:
+//
+//	NOISE_REKEY <protocol_name> <initiator_e> <responder_e> <index> <generation> <k>
+//
+// where `index` is `1` or `2` depending on which of the CipherStates was
+// rekeyed, `generation` is the number of times that CipherState has been
+// rekeyed (starting at `1`), and `k` is the new key.
+//
+// Entries are only written for the local end of each session, and entries
+// from concurrent sessions may be interleaved.
+const (
+	// KeyLogLabelSplit is the key log label for entries written on
+	// handshake completion.
+	KeyLogLabelSplit = "NOISE_SPLIT"
+
+	// KeyLogLabelRekey is the key log label for entries written on
+	// `CipherState.Rekey`.
+	KeyLogLabelRekey = "NOISE_REKEY"
+
+	keyLogAbsent = "-"
+)
+
+var keyLogMutex sync.Mutex
+
+type keyLogger struct {
+	w io.Writer
+
+	protocolName string
+	initiatorE   string
+	responderE   string
+}
+
+type cipherStateKeyLog struct {
+	l *keyLogger
+
+	index      int
+	generation uint64
+}
+
+func (l *keyLogger) writeSplit(h, k1, k2 []byte) error {
+	return l.writeEntry(KeyLogLabelSplit, keyLogHex(h), keyLogHex(k1), keyLogHex(k2))
+}
+
+func (l *keyLogger) writeEntry(label string, fields ...string) error {
+	line := label + " " + l.protocolName + " " + l.initiatorE + " " + l.responderE
+	for _, v := range fields {
+		line += " " + v
+	}
+	line += "\n"
+
+	keyLogMutex.Lock()
+	defer keyLogMutex.Unlock()
+
+	_, err := io.WriteString(l.w, line)
+	return err
+}
+
+func (kl *cipherStateKeyLog) writeRekey(k []byte) error {
+	kl.generation++
+	return kl.l.writeEntry(KeyLogLabelRekey, strconv.Itoa(kl.index), strconv.FormatUint(kl.generation, 10), keyLogHex(k))
+}
+
+func keyLogHex(b []byte) string {
+	if b == nil {
+		return keyLogAbsent
+	}
+	return hex.EncodeToString(b)
+}
+
+func (hs *HandshakeState) newKeyLogger() *keyLogger {
+	var e, re []byte
+	if hs.e != nil {
+		e = hs.e.Public().Bytes()
+	}
+	if hs.re != nil {
+		re = hs.re.Bytes()
+	}
+	if !hs.isInitiator {
+		e, re = re, e
+	}
+
+	return &keyLogger{
+		w:            hs.cfg.KeyLog,
+		protocolName: hs.cfg.Protocol.String(),
+		initiatorE:   keyLogHex(e),
+		responderE:   keyLogHex(re),
+	}
+}
+
+func (hs *HandshakeState) onKeyLog(cs1, cs2 *CipherState) {
+	l := hs.newKeyLogger()
+
+	k2 := cs2.k
+	if hs.cfg.Protocol.Pattern.IsOneWay() {
+		k2 = nil
+	}
+
+	// Key logging is best-effort, as a debugging aid should never cause
+	// the handshake to fail.
+	_ = l.writeSplit(hs.ss.GetHandshakeHash(), cs1.k, k2)
+
+	cs1.keyLog = &cipherStateKeyLog{l: l, index: 1}
+	cs2.keyLog = &cipherStateKeyLog{l: l, index: 2}
+}
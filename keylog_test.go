@@ -0,0 +1,179 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package nyquist
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errFailWriter = errors.New("nyquist/test: failWriter.Write")
+
+type failWriter struct{}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	return 0, errFailWriter
+}
+
+func TestKeyLog(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Interactive", testKeyLogInteractive},
+		{"OneWay", testKeyLogOneWay},
+		{"WriteFailure", testKeyLogWriteFailure},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testKeyLogInteractive(t *testing.T) {
+	require := require.New(t)
+
+	protocol, err := NewProtocol("Noise_NN_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+
+	var aliceLog, bobLog bytes.Buffer
+	aliceHs, err := NewHandshake(&HandshakeConfig{
+		Protocol:    protocol,
+		KeyLog:      &aliceLog,
+		IsInitiator: true,
+	})
+	require.NoError(err, "NewHandshake(aliceCfg)")
+	bobHs, err := NewHandshake(&HandshakeConfig{
+		Protocol: protocol,
+		KeyLog:   &bobLog,
+	})
+	require.NoError(err, "NewHandshake(bobCfg)")
+
+	msg, err := aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage()")
+	_, err = bobHs.ReadMessage(nil, msg)
+	require.NoError(err, "bobHs.ReadMessage()")
+	msg, err = bobHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "bobHs.WriteMessage()")
+	require.Zero(aliceLog.Len(), "No key log entry before completion")
+	_, err = aliceHs.ReadMessage(nil, msg)
+	require.Equal(ErrDone, err, "aliceHs.ReadMessage()")
+
+	aliceStatus := aliceHs.GetStatus()
+	cs1, cs2 := aliceStatus.CipherStates[0], aliceStatus.CipherStates[1]
+	id := strings.Join([]string{
+		protocol.String(),
+		hex.EncodeToString(aliceStatus.LocalEphemeral.Bytes()),
+		hex.EncodeToString(aliceStatus.RemoteEphemeral.Bytes()),
+	}, " ")
+	expected := strings.Join([]string{
+		KeyLogLabelSplit,
+		id,
+		hex.EncodeToString(aliceStatus.HandshakeHash),
+		hex.EncodeToString(cs1.k),
+		hex.EncodeToString(cs2.k),
+	}, " ") + "\n"
+	require.Equal(expected, aliceLog.String(), "Initiator NOISE_SPLIT entry")
+	require.Equal(expected, bobLog.String(), "Responder NOISE_SPLIT entry")
+
+	aliceLog.Reset()
+	for i := 1; i <= 2; i++ {
+		err = cs2.Rekey()
+		require.NoError(err, "cs2.Rekey()")
+		require.Equal(
+			KeyLogLabelRekey+" "+id+" 2 "+strconv.Itoa(i)+" "+hex.EncodeToString(cs2.k)+"\n",
+			aliceLog.String(),
+			"NOISE_REKEY entry",
+		)
+		aliceLog.Reset()
+	}
+}
+
+func testKeyLogOneWay(t *testing.T) {
+	require := require.New(t)
+
+	protocol, err := NewProtocol("Noise_N_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+
+	bobStatic, err := protocol.DH.GenerateKeypair(rand.Reader)
+	require.NoError(err, "Generate Bob's static keypair")
+
+	var aliceLog bytes.Buffer
+	aliceHs, err := NewHandshake(&HandshakeConfig{
+		Protocol:     protocol,
+		RemoteStatic: bobStatic.Public(),
+		KeyLog:       &aliceLog,
+		IsInitiator:  true,
+	})
+	require.NoError(err, "NewHandshake(aliceCfg)")
+
+	_, err = aliceHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage()")
+
+	fields := strings.Fields(aliceLog.String())
+	require.Len(fields, 7, "NOISE_SPLIT entry")
+	require.Equal(keyLogAbsent, fields[3], "No responder e")
+	require.Equal(hex.EncodeToString(aliceHs.GetStatus().CipherStates[0].k), fields[5], "k1")
+	require.Equal(keyLogAbsent, fields[6], "No k2")
+}
+
+func testKeyLogWriteFailure(t *testing.T) {
+	require := require.New(t)
+
+	// Failing to write the key log must not affect the handshake.
+	aliceHs, bobHs := mustMakeX(t, 0)
+	aliceHs.cfg.KeyLog = &failWriter{}
+
+	dst, err := aliceHs.WriteMessage(nil, nil)
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage()")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.Equal(ErrDone, err, "bobHs.ReadMessage()")
+
+	aliceCs := aliceHs.GetStatus().CipherStates[0]
+	bobCs := bobHs.GetStatus().CipherStates[0]
+	require.NotNil(aliceCs, "aliceHs CipherStates")
+
+	// Nor must it affect rekeying.
+	err = aliceCs.Rekey()
+	require.NoError(err, "aliceCs.Rekey()")
+	err = bobCs.Rekey()
+	require.NoError(err, "bobCs.Rekey()")
+
+	ct, err := aliceCs.EncryptWithAd(nil, nil, []byte("after rekey"))
+	require.NoError(err, "aliceCs.EncryptWithAd()")
+	pt, err := bobCs.DecryptWithAd(nil, nil, ct)
+	require.NoError(err, "bobCs.DecryptWithAd()")
+	require.Equal([]byte("after rekey"), pt, "Peers in sync after rekey")
+}
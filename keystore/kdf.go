@@ -0,0 +1,190 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package keystore
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// KDFArgon2id is the name of the Argon2id KDF.
+	KDFArgon2id = "argon2id"
+
+	// KDFScrypt is the name of the scrypt KDF.
+	KDFScrypt = "scrypt"
+
+	// Argon2id defaults, per RFC 9106 section 4's second recommended
+	// option.
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+
+	defaultScryptN = 1 << 15
+	defaultScryptR = 8
+	defaultScryptP = 1
+
+	saltSize = 32
+
+	// Limits on the parameters accepted when decrypting, to bound the
+	// resources a malicious keystore can consume.  The scrypt cost is
+	// `N * r * p`, which is 64 times the default at the limit.
+	maxArgon2Time   = 10
+	maxArgon2Memory = 1024 * 1024 // KiB
+	maxScryptP      = 16
+	maxScryptCost   = 1 << 24
+	maxScryptMemory = 1 << 30 // Bytes
+	minSaltSize     = 16
+	maxSaltSize     = 64
+)
+
+var errInvalidKDF = errors.New("nyquist/keystore: invalid KDF parameters")
+
+// KDFParams are the passphrase-based key derivation function and
+// parameters.  Zero valued parameters are replaced with the defaults for
+// the KDF when encrypting.
+type KDFParams struct {
+	// Name is the name of the KDF (KDFArgon2id or KDFScrypt).
+	Name string `json:"name"`
+
+	// Salt is the salt.
+	Salt []byte `json:"salt"`
+
+	// Time is the Argon2id number of passes.
+	Time uint32 `json:"time,omitempty"`
+
+	// Memory is the Argon2id memory size in KiB.
+	Memory uint32 `json:"memory,omitempty"`
+
+	// Threads is the Argon2id degree of parallelism.
+	Threads uint8 `json:"threads,omitempty"`
+
+	// N is the scrypt CPU/memory cost parameter.
+	N int `json:"n,omitempty"`
+
+	// R is the scrypt block size parameter.
+	R int `json:"r,omitempty"`
+
+	// P is the scrypt parallelization parameter.
+	P int `json:"p,omitempty"`
+}
+
+func (p *KDFParams) withSalt(rng io.Reader) (*KDFParams, error) {
+	params := &KDFParams{
+		Name: p.Name,
+		Salt: make([]byte, saltSize),
+	}
+	switch p.Name {
+	case KDFArgon2id:
+		params.Time = orDefault(p.Time, defaultArgon2Time)
+		params.Memory = orDefault(p.Memory, defaultArgon2Memory)
+		params.Threads = orDefault(p.Threads, defaultArgon2Threads)
+	case KDFScrypt:
+		params.N = orDefault(p.N, defaultScryptN)
+		params.R = orDefault(p.R, defaultScryptR)
+		params.P = orDefault(p.P, defaultScryptP)
+	default:
+		return nil, errInvalidKDF
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(rng, params.Salt); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+func (p *KDFParams) validate() error {
+	if len(p.Salt) < minSaltSize || len(p.Salt) > maxSaltSize {
+		return errInvalidKDF
+	}
+
+	switch p.Name {
+	case KDFArgon2id:
+		if p.N != 0 || p.R != 0 || p.P != 0 {
+			return errInvalidKDF
+		}
+		if p.Time == 0 || p.Time > maxArgon2Time {
+			return errInvalidKDF
+		}
+		if p.Threads == 0 || p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+			return errInvalidKDF
+		}
+	case KDFScrypt:
+		if p.Time != 0 || p.Memory != 0 || p.Threads != 0 {
+			return errInvalidKDF
+		}
+		if p.N <= 1 || p.N&(p.N-1) != 0 || p.R <= 0 || p.P <= 0 || p.P > maxScryptP {
+			return errInvalidKDF
+		}
+		if p.N > maxScryptCost || p.R > maxScryptCost {
+			// Avoid overflow below.
+			return errInvalidKDF
+		}
+		nr := uint64(p.N) * uint64(p.R)
+		if 128*nr > maxScryptMemory || nr*uint64(p.P) > maxScryptCost {
+			return errInvalidKDF
+		}
+	default:
+		return errInvalidKDF
+	}
+
+	return nil
+}
+
+func (p *KDFParams) deriveKey(passphrase []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	switch p.Name {
+	case KDFArgon2id:
+		return argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize), nil
+	case KDFScrypt:
+		return scrypt.Key(passphrase, p.Salt, p.N, p.R, p.P, chacha20poly1305.KeySize)
+	default:
+		return nil, errInvalidKDF
+	}
+}
+
+func orDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
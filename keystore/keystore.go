@@ -0,0 +1,492 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package keystore implements a passphrase-encrypted file format for
+// static keypairs.
+//
+// A keystore holds one or more keypairs, the first of which is the current
+// key, with the remainder being retired keys retained after rotation.  The
+// binary serialization (`Keypair.MarshalBinary`) of each keypair is
+// encrypted with XChaCha20-Poly1305 under a key derived from the
+// passphrase (Argon2id or scrypt) and a per-key random salt, with the DH
+// function name and public key authenticated as associated data.
+//
+// Keystores are serialized as JSON:
+//
+//	{
+//	  "version": 1,
+//	  "keys": [
+//	    {
+//	      "dh": "25519",
+//	      "public_key": "<base64>",
+//	      "created": "2026-10-18T00:00:00Z",
+//	      "kdf": {
+//	        "name": "argon2id",
+//	        "salt": "<base64>",
+//	        "time": 3,
+//	        "memory": 65536,
+//	        "threads": 4
+//	      },
+//	      "nonce": "<base64>",
+//	      "ciphertext": "<base64>"
+//	    }
+//	  ]
+//	}
+package keystore // import "gitlab.com/yawning/nyquist.git/keystore"
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+// Version is the keystore format version.
+const Version = 1
+
+var (
+	// ErrIncorrectPassphrase is the error returned when a key fails to
+	// decrypt, due to an incorrect passphrase or a corrupted keystore.
+	ErrIncorrectPassphrase = errors.New("nyquist/keystore: incorrect passphrase or corrupted key")
+
+	// ErrInvalidKeystore is the error returned when a keystore is
+	// malformed.
+	ErrInvalidKeystore = errors.New("nyquist/keystore: invalid keystore")
+
+	// ErrUnsupportedVersion is the error returned when a keystore has an
+	// unsupported format version.
+	ErrUnsupportedVersion = errors.New("nyquist/keystore: unsupported version")
+
+	errMismatchedPublicKey = errors.New("nyquist/keystore: decrypted key does not match public key")
+	errInvalidPrune        = errors.New("nyquist/keystore: must keep at least the current key")
+
+	adPrefix = []byte("NyquistKeystore")
+)
+
+// Keystore is a passphrase-encrypted collection of static keypairs.  All
+// keys in a keystore share the same passphrase.
+type Keystore struct {
+	// Version is the keystore format version.
+	Version int `json:"version"`
+
+	// Keys are the encrypted keys, with the current key first, followed
+	// by retired keys in order of most recently retired.
+	Keys []*Entry `json:"keys"`
+}
+
+// Entry is a single encrypted keypair.
+type Entry struct {
+	// DH is the name of the DH function the keypair is for.
+	DH string `json:"dh"`
+
+	// PublicKey is the binary serialized public key.
+	PublicKey []byte `json:"public_key"`
+
+	// Created is the time the entry was created.
+	Created time.Time `json:"created"`
+
+	// KDF is the passphrase-based key derivation function and parameters.
+	KDF *KDFParams `json:"kdf"`
+
+	// Nonce is the XChaCha20-Poly1305 nonce.
+	Nonce []byte `json:"nonce"`
+
+	// Ciphertext is the encrypted binary serialized keypair.
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Decrypt decrypts the entry with the passphrase, and returns the DH
+// function and keypair.
+func (e *Entry) Decrypt(passphrase []byte) (dh.DH, dh.Keypair, error) {
+	dhImpl := dh.FromString(e.DH)
+	if dhImpl == nil {
+		return nil, nil, dh.ErrUnknownDH
+	}
+	if err := e.validate(dhImpl); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := e.KDF.deriveKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := aead.Open(nil, e.Nonce, e.Ciphertext, e.associatedData())
+	if err != nil {
+		return nil, nil, ErrIncorrectPassphrase
+	}
+	defer clear(plaintext)
+
+	keypair, err := dhImpl.ParsePrivateKey(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(keypair.Public().Bytes(), e.PublicKey) {
+		keypair.DropPrivate()
+		return nil, nil, errMismatchedPublicKey
+	}
+
+	return dhImpl, keypair, nil
+}
+
+func (e *Entry) validate(dhImpl dh.DH) error {
+	if _, err := dhImpl.ParsePublicKey(e.PublicKey); err != nil {
+		return ErrInvalidKeystore
+	}
+	if e.KDF == nil || e.KDF.validate() != nil {
+		return ErrInvalidKeystore
+	}
+	if len(e.Nonce) != chacha20poly1305.NonceSizeX || len(e.Ciphertext) < chacha20poly1305.Overhead {
+		return ErrInvalidKeystore
+	}
+	return nil
+}
+
+func (e *Entry) associatedData() []byte {
+	ad := make([]byte, 0, len(adPrefix)+2+len(e.DH)+len(e.PublicKey))
+	ad = append(ad, adPrefix...)
+	ad = append(ad, Version, byte(len(e.DH)))
+	ad = append(ad, e.DH...)
+	return append(ad, e.PublicKey...)
+}
+
+func newEntry(dhImpl dh.DH, keypair dh.Keypair, passphrase []byte, opts *Options) (*Entry, error) {
+	rng := opts.getRng()
+
+	kdf, err := opts.getKDF().withSalt(rng)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{
+		DH:        dhImpl.String(),
+		PublicKey: bytes.Clone(keypair.Public().Bytes()),
+		Created:   opts.getNow()().UTC(),
+		KDF:       kdf,
+		Nonce:     make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err = io.ReadFull(rng, e.Nonce); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := keypair.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plaintext)
+
+	key, err := kdf.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	e.Ciphertext = aead.Seal(nil, e.Nonce, plaintext, e.associatedData())
+
+	return e, nil
+}
+
+// Options are the options used when encrypting keys.  A nil Options is
+// equivalent to the default options.
+type Options struct {
+	// KDF is the key derivation function and parameters, with the salt
+	// ignored.  If nil, Argon2id with the default parameters is used.
+	KDF *KDFParams
+
+	// Rng is the entropy source used for key generation, salts, and
+	// nonces.  If nil, `crypto/rand.Reader` will be used.
+	Rng io.Reader
+
+	// Now is the function used to get the current time.  If nil,
+	// `time.Now` will be used.
+	Now func() time.Time
+}
+
+func (opts *Options) getKDF() *KDFParams {
+	if opts == nil || opts.KDF == nil {
+		return &KDFParams{Name: KDFArgon2id}
+	}
+	return opts.KDF
+}
+
+func (opts *Options) getRng() io.Reader {
+	if opts == nil || opts.Rng == nil {
+		return rand.Reader
+	}
+	return opts.Rng
+}
+
+func (opts *Options) getNow() func() time.Time {
+	if opts == nil || opts.Now == nil {
+		return time.Now
+	}
+	return opts.Now
+}
+
+// New creates a new keystore containing the provided keypair, encrypted
+// with the passphrase.
+func New(dhImpl dh.DH, keypair dh.Keypair, passphrase []byte, opts *Options) (*Keystore, error) {
+	e, err := newEntry(dhImpl, keypair, passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{
+		Version: Version,
+		Keys:    []*Entry{e},
+	}, nil
+}
+
+// Generate creates a new keystore containing a freshly generated keypair,
+// encrypted with the passphrase, and returns the keystore and keypair.
+func Generate(dhImpl dh.DH, passphrase []byte, opts *Options) (*Keystore, dh.Keypair, error) {
+	keypair, err := dhImpl.GenerateKeypair(opts.getRng())
+	if err != nil {
+		return nil, nil, err
+	}
+	ks, err := New(dhImpl, keypair, passphrase, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ks, keypair, nil
+}
+
+// Keypair decrypts and returns the current keypair, suitable for use as
+// `HandshakeConfig.LocalStatic`.
+func (ks *Keystore) Keypair(passphrase []byte) (dh.DH, dh.Keypair, error) {
+	if err := ks.validate(); err != nil {
+		return nil, nil, err
+	}
+	return ks.Keys[0].Decrypt(passphrase)
+}
+
+// Keypairs decrypts and returns all of the keypairs, with the current
+// keypair first.
+func (ks *Keystore) Keypairs(passphrase []byte) ([]dh.DH, []dh.Keypair, error) {
+	if err := ks.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	dhImpls := make([]dh.DH, 0, len(ks.Keys))
+	keypairs := make([]dh.Keypair, 0, len(ks.Keys))
+	for _, e := range ks.Keys {
+		dhImpl, keypair, err := e.Decrypt(passphrase)
+		if err != nil {
+			for _, v := range keypairs {
+				v.DropPrivate()
+			}
+			return nil, nil, err
+		}
+		dhImpls = append(dhImpls, dhImpl)
+		keypairs = append(keypairs, keypair)
+	}
+
+	return dhImpls, keypairs, nil
+}
+
+// ChangePassphrase re-encrypts all of the keys with a new passphrase, and
+// the KDF parameters from opts.  The keystore is left unaltered on failure.
+func (ks *Keystore) ChangePassphrase(oldPassphrase, newPassphrase []byte, opts *Options) error {
+	dhImpls, keypairs, err := ks.Keypairs(oldPassphrase)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, v := range keypairs {
+			v.DropPrivate()
+		}
+	}()
+
+	entries := make([]*Entry, 0, len(keypairs))
+	for i, keypair := range keypairs {
+		e, err := newEntry(dhImpls[i], keypair, newPassphrase, opts)
+		if err != nil {
+			return err
+		}
+		e.Created = ks.Keys[i].Created
+		entries = append(entries, e)
+	}
+	ks.Keys = entries
+
+	return nil
+}
+
+// Rotate generates a new current keypair, encrypted with the passphrase,
+// retaining the previous keys as retired keys, and returns the new
+// keypair.  The passphrase must be correct for the existing current key.
+func (ks *Keystore) Rotate(dhImpl dh.DH, passphrase []byte, opts *Options) (dh.Keypair, error) {
+	_, current, err := ks.Keypair(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	current.DropPrivate()
+
+	keypair, err := dhImpl.GenerateKeypair(opts.getRng())
+	if err != nil {
+		return nil, err
+	}
+	e, err := newEntry(dhImpl, keypair, passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+	ks.Keys = append([]*Entry{e}, ks.Keys...)
+
+	return keypair, nil
+}
+
+// Prune discards all but the `keep` most recent keys.
+func (ks *Keystore) Prune(keep int) error {
+	if keep < 1 {
+		return errInvalidPrune
+	}
+	if keep < len(ks.Keys) {
+		clear(ks.Keys[keep:])
+		ks.Keys = ks.Keys[:keep]
+	}
+	return nil
+}
+
+func (ks *Keystore) validate() error {
+	if ks.Version != Version {
+		return ErrUnsupportedVersion
+	}
+	if len(ks.Keys) == 0 {
+		return ErrInvalidKeystore
+	}
+	for _, e := range ks.Keys {
+		if e == nil {
+			return ErrInvalidKeystore
+		}
+	}
+	return nil
+}
+
+// MarshalBinary serializes the keystore.
+func (ks *Keystore) MarshalBinary() ([]byte, error) {
+	if err := ks.validate(); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// UnmarshalBinary deserializes the keystore.
+func (ks *Keystore) UnmarshalBinary(data []byte) error {
+	var tmp Keystore
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return ErrInvalidKeystore
+	}
+	if err := tmp.validate(); err != nil {
+		return err
+	}
+	for _, e := range tmp.Keys {
+		dhImpl := dh.FromString(e.DH)
+		if dhImpl == nil {
+			return dh.ErrUnknownDH
+		}
+		if err := e.validate(dhImpl); err != nil {
+			return err
+		}
+	}
+	*ks = tmp
+	return nil
+}
+
+// Save atomically writes the keystore to the file at `path`, via writing a
+// temporary file and renaming it over the original.
+func (ks *Keystore) Save(path string) error {
+	b, err := ks.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	// os.CreateTemp creates files with mode 0600.
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	return err
+}
+
+// Load reads the keystore from the file at `path`.
+func Load(path string) (*Keystore, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks Keystore
+	if err = ks.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &ks, nil
+}
+
+// LoadKeypair reads the keystore from the file at `path`, and decrypts and
+// returns the current keypair, suitable for use as
+// `HandshakeConfig.LocalStatic`.
+func LoadKeypair(path string, passphrase []byte) (dh.DH, dh.Keypair, error) {
+	ks, err := Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ks.Keypair(passphrase)
+}
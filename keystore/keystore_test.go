@@ -0,0 +1,354 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package keystore
+
+import (
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/cipher"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var (
+	testPassphrase = []byte("correct horse battery staple")
+
+	// Deliberately weak parameters, so that the tests run quickly.
+	testArgon2idOpts = &Options{
+		KDF: &KDFParams{
+			Name:    KDFArgon2id,
+			Time:    1,
+			Memory:  64,
+			Threads: 1,
+		},
+	}
+	testScryptOpts = &Options{
+		KDF: &KDFParams{
+			Name: KDFScrypt,
+			N:    1 << 10,
+			R:    8,
+			P:    1,
+		},
+	}
+)
+
+func TestKeystore(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"RoundTrip", testKeystoreRoundTrip},
+		{"ChangePassphrase", testKeystoreChangePassphrase},
+		{"Rotate", testKeystoreRotate},
+		{"File", testKeystoreFile},
+		{"Tampering", testKeystoreTampering},
+		{"KDFLimits", testKeystoreKDFLimits},
+		{"Handshake", testKeystoreHandshake},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testKeystoreRoundTrip(t *testing.T) {
+	for _, dhImpl := range []dh.DH{dh.X25519, dh.X448} {
+		for _, opts := range []*Options{testArgon2idOpts, testScryptOpts} {
+			t.Run(dhImpl.String()+"/"+opts.KDF.Name, func(t *testing.T) {
+				require := require.New(t)
+
+				ks, keypair, err := Generate(dhImpl, testPassphrase, opts)
+				require.NoError(err, "Generate")
+				require.Len(ks.Keys, 1, "Generate: number of keys")
+				require.Equal(dhImpl.String(), ks.Keys[0].DH, "Generate: DH")
+				require.Equal(keypair.Public().Bytes(), ks.Keys[0].PublicKey, "Generate: public key")
+
+				b, err := ks.MarshalBinary()
+				require.NoError(err, "MarshalBinary")
+
+				var ks2 Keystore
+				err = ks2.UnmarshalBinary(b)
+				require.NoError(err, "UnmarshalBinary")
+				require.Equal(ks.Keys[0].KDF, ks2.Keys[0].KDF, "UnmarshalBinary: KDF")
+
+				dhImpl2, keypair2, err := ks2.Keypair(testPassphrase)
+				require.NoError(err, "Keypair")
+				require.Equal(dhImpl, dhImpl2, "Keypair: DH")
+
+				expected, _ := keypair.MarshalBinary()
+				actual, _ := keypair2.MarshalBinary()
+				require.Equal(expected, actual, "Keypair: private key")
+
+				_, _, err = ks2.Keypair([]byte("incorrect passphrase"))
+				require.ErrorIs(err, ErrIncorrectPassphrase, "Keypair: incorrect passphrase")
+			})
+		}
+	}
+}
+
+func testKeystoreChangePassphrase(t *testing.T) {
+	require := require.New(t)
+
+	ks, keypair, err := Generate(dh.X25519, testPassphrase, testArgon2idOpts)
+	require.NoError(err, "Generate")
+	_, err = ks.Rotate(dh.X448, testPassphrase, testArgon2idOpts)
+	require.NoError(err, "Rotate")
+	created := ks.Keys[1].Created
+
+	newPassphrase := []byte("Tr0ub4dor&3")
+	err = ks.ChangePassphrase([]byte("incorrect passphrase"), newPassphrase, testScryptOpts)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "ChangePassphrase: incorrect passphrase")
+	require.Equal(KDFArgon2id, ks.Keys[0].KDF.Name, "ChangePassphrase: keystore altered on failure")
+
+	err = ks.ChangePassphrase(testPassphrase, newPassphrase, testScryptOpts)
+	require.NoError(err, "ChangePassphrase")
+	require.Len(ks.Keys, 2, "ChangePassphrase: number of keys")
+	require.Equal(created, ks.Keys[1].Created, "ChangePassphrase: creation time preserved")
+	for _, e := range ks.Keys {
+		require.Equal(KDFScrypt, e.KDF.Name, "ChangePassphrase: KDF")
+	}
+
+	_, _, err = ks.Keypairs(testPassphrase)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "Keypairs: old passphrase")
+
+	dhImpls, keypairs, err := ks.Keypairs(newPassphrase)
+	require.NoError(err, "Keypairs: new passphrase")
+	require.Equal([]dh.DH{dh.X448, dh.X25519}, dhImpls, "Keypairs: DH")
+	require.Equal(keypair.Public().Bytes(), keypairs[1].Public().Bytes(), "Keypairs: retired key")
+}
+
+func testKeystoreRotate(t *testing.T) {
+	require := require.New(t)
+
+	ks, oldKeypair, err := Generate(dh.X25519, testPassphrase, testArgon2idOpts)
+	require.NoError(err, "Generate")
+
+	_, err = ks.Rotate(dh.X25519, []byte("incorrect passphrase"), testArgon2idOpts)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "Rotate: incorrect passphrase")
+	require.Len(ks.Keys, 1, "Rotate: keystore altered on failure")
+
+	newKeypair, err := ks.Rotate(dh.X25519, testPassphrase, testArgon2idOpts)
+	require.NoError(err, "Rotate")
+	require.Len(ks.Keys, 2, "Rotate: number of keys")
+	require.NotEqual(oldKeypair.Public().Bytes(), newKeypair.Public().Bytes(), "Rotate: new key")
+
+	_, current, err := ks.Keypair(testPassphrase)
+	require.NoError(err, "Keypair")
+	require.Equal(newKeypair.Public().Bytes(), current.Public().Bytes(), "Keypair: is new key")
+	require.Equal(oldKeypair.Public().Bytes(), ks.Keys[1].PublicKey, "Rotate: old key retired")
+
+	err = ks.Prune(0)
+	require.Error(err, "Prune(0)")
+	err = ks.Prune(1)
+	require.NoError(err, "Prune(1)")
+	require.Len(ks.Keys, 1, "Prune: number of keys")
+	require.Equal(newKeypair.Public().Bytes(), ks.Keys[0].PublicKey, "Prune: retained current key")
+}
+
+func testKeystoreFile(t *testing.T) {
+	require := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "static.json")
+	ks, keypair, err := Generate(dh.X448, testPassphrase, testScryptOpts)
+	require.NoError(err, "Generate")
+	err = ks.Save(path)
+	require.NoError(err, "Save")
+
+	_, err = ks.Rotate(dh.X448, testPassphrase, testScryptOpts)
+	require.NoError(err, "Rotate")
+	err = ks.Save(path)
+	require.NoError(err, "Save: overwrite")
+
+	ks2, err := Load(path)
+	require.NoError(err, "Load")
+	require.Len(ks2.Keys, 2, "Load: number of keys")
+	require.Equal(keypair.Public().Bytes(), ks2.Keys[1].PublicKey, "Load: retired key")
+
+	dhImpl, current, err := LoadKeypair(path, testPassphrase)
+	require.NoError(err, "LoadKeypair")
+	require.Equal(dh.X448, dhImpl, "LoadKeypair: DH")
+	require.Equal(ks.Keys[0].PublicKey, current.Public().Bytes(), "LoadKeypair: current key")
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), ".*"))
+	require.NoError(err, "Glob")
+	require.Empty(matches, "Save: temporary files removed")
+}
+
+func testKeystoreTampering(t *testing.T) {
+	require := require.New(t)
+
+	ks, _, err := Generate(dh.X25519, testPassphrase, testArgon2idOpts)
+	require.NoError(err, "Generate")
+	e := ks.Keys[0]
+
+	// Substituting the public key must fail authentication.
+	other, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	origPublicKey := e.PublicKey
+	e.PublicKey = other.Public().Bytes()
+	_, _, err = ks.Keypair(testPassphrase)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "Keypair: substituted public key")
+	e.PublicKey = origPublicKey
+
+	// As must altering the DH name (to one with the same key size).
+	dh.Register(&renamedDH{dh.X25519})
+	e.DH = "renamed25519"
+	_, _, err = ks.Keypair(testPassphrase)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "Keypair: substituted DH")
+	e.DH = "25519"
+
+	// As must altering the KDF parameters.
+	e.KDF.Time++
+	_, _, err = ks.Keypair(testPassphrase)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "Keypair: altered KDF parameters")
+	e.KDF.Time--
+
+	e.Ciphertext[0] ^= 0x01
+	_, _, err = ks.Keypair(testPassphrase)
+	require.ErrorIs(err, ErrIncorrectPassphrase, "Keypair: altered ciphertext")
+	e.Ciphertext[0] ^= 0x01
+
+	_, _, err = ks.Keypair(testPassphrase)
+	require.NoError(err, "Keypair: restored")
+
+	// Excessive KDF parameters must be rejected before deriving a key.
+	b, err := ks.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	e.KDF.Memory = maxArgon2Memory + 1
+	_, _, err = ks.Keypair(testPassphrase)
+	require.ErrorIs(err, ErrInvalidKeystore, "Keypair: excessive memory")
+	bad, err := ks.MarshalBinary()
+	require.NoError(err, "MarshalBinary: excessive memory")
+	var ks2 Keystore
+	err = ks2.UnmarshalBinary(bad)
+	require.ErrorIs(err, ErrInvalidKeystore, "UnmarshalBinary: excessive memory")
+
+	for _, v := range []struct {
+		n   string
+		b   string
+		err error
+	}{
+		{"Garbage", "not json", ErrInvalidKeystore},
+		{"Version", `{"version":2,"keys":[]}`, ErrUnsupportedVersion},
+		{"NoKeys", `{"version":1,"keys":[]}`, ErrInvalidKeystore},
+	} {
+		err = ks2.UnmarshalBinary([]byte(v.b))
+		require.ErrorIs(err, v.err, "UnmarshalBinary: %s", v.n)
+	}
+
+	err = ks2.UnmarshalBinary(b)
+	require.NoError(err, "UnmarshalBinary: valid")
+}
+
+func testKeystoreKDFLimits(t *testing.T) {
+	require := require.New(t)
+
+	salt := make([]byte, saltSize)
+	argon2Params := func(time, memory uint32) *KDFParams {
+		return &KDFParams{Name: KDFArgon2id, Salt: salt, Time: time, Memory: memory, Threads: 4}
+	}
+	scryptParams := func(n, r, p int) *KDFParams {
+		return &KDFParams{Name: KDFScrypt, Salt: salt, N: n, R: r, P: p}
+	}
+
+	for _, v := range []struct {
+		n      string
+		params *KDFParams
+		ok     bool
+	}{
+		{"Argon2id: default", argon2Params(defaultArgon2Time, defaultArgon2Memory), true},
+		{"Argon2id: max", argon2Params(maxArgon2Time, maxArgon2Memory), true},
+		{"Argon2id: time", argon2Params(maxArgon2Time+1, defaultArgon2Memory), false},
+		{"Argon2id: memory", argon2Params(defaultArgon2Time, maxArgon2Memory+1), false},
+		{"scrypt: default", scryptParams(defaultScryptN, defaultScryptR, defaultScryptP), true},
+		{"scrypt: max", scryptParams(1<<20, 8, 2), true},
+		{"scrypt: p", scryptParams(1<<10, 1, maxScryptP+1), false},
+		{"scrypt: memory", scryptParams(1<<21, 8, 1), false},
+		{"scrypt: cost", scryptParams(1<<20, 8, 4), false},
+		{"scrypt: overflow", scryptParams(1<<30, 1<<30, 1), false},
+	} {
+		err := v.params.validate()
+		if v.ok {
+			require.NoError(err, "validate(%s)", v.n)
+		} else {
+			require.ErrorIs(err, errInvalidKDF, "validate(%s)", v.n)
+		}
+	}
+}
+
+func testKeystoreHandshake(t *testing.T) {
+	require := require.New(t)
+
+	ks, _, err := Generate(dh.X25519, testPassphrase, testArgon2idOpts)
+	require.NoError(err, "Generate")
+	_, localStatic, err := ks.Keypair(testPassphrase)
+	require.NoError(err, "Keypair")
+
+	protocol := &nyquist.Protocol{
+		Pattern: pattern.N,
+		DH:      dh.X25519,
+		Cipher:  cipher.ChaChaPoly,
+		Hash:    hash.BLAKE2s,
+	}
+	remoteStatic, err := dh.X25519.ParsePublicKey(ks.Keys[0].PublicKey)
+	require.NoError(err, "ParsePublicKey")
+
+	initiator, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:     protocol,
+		RemoteStatic: remoteStatic,
+		IsInitiator:  true,
+	})
+	require.NoError(err, "NewHandshake: initiator")
+	defer initiator.Reset()
+	responder, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: localStatic,
+	})
+	require.NoError(err, "NewHandshake: responder")
+	defer responder.Reset()
+
+	msg, err := initiator.WriteMessage(nil, []byte("hello"))
+	require.ErrorIs(err, nyquist.ErrDone, "WriteMessage")
+	payload, err := responder.ReadMessage(nil, msg)
+	require.ErrorIs(err, nyquist.ErrDone, "ReadMessage")
+	require.Equal([]byte("hello"), payload, "ReadMessage: payload")
+}
+
+type renamedDH struct {
+	dh.DH
+}
+
+func (d *renamedDH) String() string {
+	return "renamed25519"
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package knownpeers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// FileStore is a Store backed by a text file, with one peer per line:
+//
+//	<name> <dh> <base64 encoded public key>
+//
+// Empty lines and lines starting with `#` are ignored (and discarded when
+// the file is updated).  Updates are atomic, via writing a temporary file
+// and renaming it over the original.
+type FileStore struct {
+	l    sync.Mutex
+	path string
+}
+
+// Lookup implements the Store interface.
+func (s *FileStore) Lookup(name string) (*KnownKey, error) {
+	if !isValidName(name) {
+		return nil, ErrInvalidName
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	names, keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range names {
+		if v == name {
+			return keys[i], nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// Add implements the Store interface.
+func (s *FileStore) Add(name string, key *KnownKey) error {
+	if !isValidName(name) {
+		return ErrInvalidName
+	}
+	if !isValidName(key.DH) || len(key.PublicKey) == 0 {
+		return errInvalidKey
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	names, keys, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, v := range names {
+		if v == name {
+			if !keys[i].Equal(key) {
+				return ErrKeyChanged
+			}
+			return nil
+		}
+	}
+
+	return s.store(append(names, name), append(keys, key))
+}
+
+// Remove implements the Store interface.
+func (s *FileStore) Remove(name string) error {
+	if !isValidName(name) {
+		return ErrInvalidName
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	names, keys, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, v := range names {
+		if v == name {
+			names = append(names[:i], names[i+1:]...)
+			keys = append(keys[:i], keys[i+1:]...)
+			return s.store(names, keys)
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) load() ([]string, []*KnownKey, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return parseFile(f)
+}
+
+func parseFile(r io.Reader) ([]string, []*KnownKey, error) {
+	var (
+		names []string
+		keys  []*KnownKey
+	)
+
+	scanner := bufio.NewScanner(r)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, nil, fmt.Errorf("nyquist/knownpeers: malformed entry on line %d", lineNr)
+		}
+		publicKey, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil || len(publicKey) == 0 {
+			return nil, nil, fmt.Errorf("nyquist/knownpeers: malformed public key on line %d", lineNr)
+		}
+		names = append(names, fields[0])
+		keys = append(keys, &KnownKey{
+			DH:        fields[1],
+			PublicKey: publicKey,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return names, keys, nil
+}
+
+func (s *FileStore) store(names []string, keys []*KnownKey) error {
+	var buf bytes.Buffer
+	for i, name := range names {
+		fmt.Fprintf(&buf, "%s %s %s\n", name, keys[i].DH, base64.StdEncoding.EncodeToString(keys[i].PublicKey))
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	return err
+}
+
+func isValidName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "#") {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewFileStore creates a new FileStore backed by the file at `path`.  The
+// file will be created on the first update if it does not exist.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{
+		path: path,
+	}
+}
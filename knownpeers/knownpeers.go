@@ -0,0 +1,209 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package knownpeers implements a trust-on-first-use (TOFU) store of peer
+// static public keys, similar to SSH's `known_hosts`.
+package knownpeers // import "gitlab.com/yawning/nyquist.git/knownpeers"
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var (
+	// ErrKeyChanged is the error returned when a peer presents a static
+	// public key that differs from the one previously recorded.
+	ErrKeyChanged = errors.New("nyquist/knownpeers: peer static public key changed")
+
+	// ErrNotFound is the error returned by Store.Lookup when there is no
+	// key recorded for a peer.
+	ErrNotFound = errors.New("nyquist/knownpeers: peer not found")
+
+	// ErrInvalidName is the error returned when a peer name is invalid.
+	ErrInvalidName = errors.New("nyquist/knownpeers: invalid peer name")
+
+	errInvalidKey      = errors.New("nyquist/knownpeers: invalid key")
+	errNoPeerKey       = errors.New("nyquist/knownpeers: no peer static public key observed")
+	errHandshakeFailed = errors.New("nyquist/knownpeers: handshake not complete")
+)
+
+// KnownKey is a recorded peer static public key.
+type KnownKey struct {
+	// DH is the name of the DH function the key is for.
+	DH string
+
+	// PublicKey is the binary serialized static public key.
+	PublicKey []byte
+}
+
+// Equal returns true iff the two KnownKeys are identical.
+func (k *KnownKey) Equal(other *KnownKey) bool {
+	return k.DH == other.DH && bytes.Equal(k.PublicKey, other.PublicKey)
+}
+
+// Store is a known peers store backend.  Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Lookup returns the key recorded for the peer, or ErrNotFound.
+	Lookup(name string) (*KnownKey, error)
+
+	// Add records the key for a peer.  If a key is already recorded for the
+	// peer, it must be left intact, and ErrKeyChanged returned iff the
+	// recorded key differs from the one being added.
+	Add(name string, key *KnownKey) error
+
+	// Remove removes the key recorded for the peer, if any (eg: to allow an
+	// operator approved key change).
+	Remove(name string) error
+}
+
+// MemoryStore is an in-memory Store.
+type MemoryStore struct {
+	l    sync.Mutex
+	keys map[string]*KnownKey
+}
+
+// Lookup implements the Store interface.
+func (s *MemoryStore) Lookup(name string) (*KnownKey, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	k, ok := s.keys[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return k, nil
+}
+
+// Add implements the Store interface.
+func (s *MemoryStore) Add(name string, key *KnownKey) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if existing, ok := s.keys[name]; ok {
+		if !existing.Equal(key) {
+			return ErrKeyChanged
+		}
+		return nil
+	}
+	s.keys[name] = &KnownKey{
+		DH:        key.DH,
+		PublicKey: append([]byte{}, key.PublicKey...),
+	}
+
+	return nil
+}
+
+// Remove implements the Store interface.
+func (s *MemoryStore) Remove(name string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	delete(s.keys, name)
+
+	return nil
+}
+
+// NewMemoryStore creates a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys: make(map[string]*KnownKey),
+	}
+}
+
+// NewObserver creates a new per-handshake Observer for the named peer (eg:
+// the peer's address or host name), using the provided DH function.
+func NewObserver(store Store, name string, dhImpl dh.DH) *Observer {
+	return &Observer{
+		store: store,
+		name:  name,
+		dh:    dhImpl,
+	}
+}
+
+// Observer is a `nyquist.HandshakeObserver` that enforces trust-on-first-use
+// for the peer's static public key.  Keys that differ from the one recorded
+// for the peer are rejected with ErrKeyChanged as soon as they are received.
+//
+// Keys for previously unknown peers are only recorded when Commit is called
+// after the handshake completes, so that aborted handshakes do not record
+// keys.
+type Observer struct {
+	store Store
+	name  string
+	dh    dh.DH
+
+	seen *KnownKey
+}
+
+// OnPeerPublicKey implements the `nyquist.HandshakeObserver` interface.
+func (o *Observer) OnPeerPublicKey(token pattern.Token, publicKey dh.PublicKey) error {
+	if token != pattern.Token_s {
+		return nil
+	}
+
+	k := &KnownKey{
+		DH:        o.dh.String(),
+		PublicKey: append([]byte{}, publicKey.Bytes()...),
+	}
+	known, err := o.store.Lookup(o.name)
+	switch err {
+	case nil:
+		if !known.Equal(k) {
+			return ErrKeyChanged
+		}
+	case ErrNotFound:
+	default:
+		return err
+	}
+	o.seen = k
+
+	return nil
+}
+
+// Commit records the peer's static public key (`HandshakeStatus.RemoteStatic`)
+// if this is the first time it was seen.  It must be called after the
+// handshake completes successfully.
+func (o *Observer) Commit(status *nyquist.HandshakeStatus) error {
+	if status.Err != nyquist.ErrDone {
+		return errHandshakeFailed
+	}
+	if o.seen == nil || status.RemoteStatic == nil || !bytes.Equal(o.seen.PublicKey, status.RemoteStatic.Bytes()) {
+		return errNoPeerKey
+	}
+
+	// This will fail with ErrKeyChanged if a concurrent handshake recorded
+	// a different key since OnPeerPublicKey was called.
+	return o.store.Add(o.name, o.seen)
+}
@@ -0,0 +1,210 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package knownpeers
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+func mustGenerateKeypair(t *testing.T) dh.Keypair {
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+func doHandshake(t *testing.T, aliceStatic, bobStatic dh.Keypair, observer *Observer) error {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol("Noise_XX_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+
+	aliceHs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: aliceStatic,
+		Observer:    observer,
+		IsInitiator: true,
+	})
+	require.NoError(err, "NewHandshake(alice)")
+	defer aliceHs.Reset()
+	bobHs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: bobStatic,
+	})
+	require.NoError(err, "NewHandshake(bob)")
+	defer bobHs.Reset()
+
+	msg, err := aliceHs.WriteMessage(nil, nil)
+	require.NoError(err, "aliceHs.WriteMessage(1)")
+	_, err = bobHs.ReadMessage(nil, msg)
+	require.NoError(err, "bobHs.ReadMessage(1)")
+	msg, err = bobHs.WriteMessage(nil, nil)
+	require.NoError(err, "bobHs.WriteMessage(1)")
+	if _, err = aliceHs.ReadMessage(nil, msg); err != nil {
+		return err
+	}
+	_, err = aliceHs.WriteMessage(nil, nil)
+	require.Equal(nyquist.ErrDone, err, "aliceHs.WriteMessage(2)")
+
+	return observer.Commit(aliceHs.GetStatus())
+}
+
+func TestKnownPeers(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T, Store)
+	}{
+		{"TOFU", testKnownPeersTOFU},
+		{"Store", testKnownPeersStore},
+	} {
+		t.Run(v.n, func(t *testing.T) {
+			t.Run("MemoryStore", func(t *testing.T) {
+				v.fn(t, NewMemoryStore())
+			})
+			t.Run("FileStore", func(t *testing.T) {
+				v.fn(t, NewFileStore(filepath.Join(t.TempDir(), "known_peers")))
+			})
+		})
+	}
+}
+
+func testKnownPeersTOFU(t *testing.T, store Store) {
+	require := require.New(t)
+
+	aliceStatic := mustGenerateKeypair(t)
+	bobStatic, mallory := mustGenerateKeypair(t), mustGenerateKeypair(t)
+
+	// First use, the key is recorded.
+	_, err := store.Lookup("bob.example")
+	require.ErrorIs(err, ErrNotFound, "Lookup - before first use")
+	err = doHandshake(t, aliceStatic, bobStatic, NewObserver(store, "bob.example", dh.X25519))
+	require.NoError(err, "Handshake - first use")
+
+	k, err := store.Lookup("bob.example")
+	require.NoError(err, "Lookup - after first use")
+	require.Equal(dh.X25519.String(), k.DH, "KnownKey.DH")
+	require.Equal(bobStatic.Public().Bytes(), k.PublicKey, "KnownKey.PublicKey")
+
+	// Subsequent use with the same key.
+	err = doHandshake(t, aliceStatic, bobStatic, NewObserver(store, "bob.example", dh.X25519))
+	require.NoError(err, "Handshake - same key")
+
+	// Subsequent use with a different key.
+	err = doHandshake(t, aliceStatic, mallory, NewObserver(store, "bob.example", dh.X25519))
+	require.ErrorIs(err, ErrKeyChanged, "Handshake - key changed")
+	var hsErr *nyquist.HandshakeError
+	require.ErrorAs(err, &hsErr, "Handshake - key changed")
+
+	k, err = store.Lookup("bob.example")
+	require.NoError(err, "Lookup - after key change")
+	require.Equal(bobStatic.Public().Bytes(), k.PublicKey, "KnownKey.PublicKey - unaltered")
+
+	// Removing the entry allows the new key to be recorded.
+	err = store.Remove("bob.example")
+	require.NoError(err, "Remove")
+	err = doHandshake(t, aliceStatic, mallory, NewObserver(store, "bob.example", dh.X25519))
+	require.NoError(err, "Handshake - after Remove")
+
+	// Commit without a completed handshake must fail.
+	obs := NewObserver(store, "carol.example", dh.X25519)
+	err = obs.Commit(&nyquist.HandshakeStatus{})
+	require.Error(err, "Commit - incomplete")
+	_, err = store.Lookup("carol.example")
+	require.ErrorIs(err, ErrNotFound, "Lookup - incomplete")
+}
+
+func testKnownPeersStore(t *testing.T, store Store) {
+	require := require.New(t)
+
+	k1 := &KnownKey{DH: "25519", PublicKey: []byte("key one")}
+	k2 := &KnownKey{DH: "25519", PublicKey: []byte("key two")}
+
+	require.NoError(store.Add("peer1", k1), "Add(peer1)")
+	require.NoError(store.Add("peer2", k2), "Add(peer2)")
+	require.NoError(store.Add("peer1", k1), "Add(peer1) - same key")
+	require.ErrorIs(store.Add("peer1", k2), ErrKeyChanged, "Add(peer1) - different key")
+
+	k, err := store.Lookup("peer1")
+	require.NoError(err, "Lookup(peer1)")
+	require.True(k.Equal(k1), "Lookup(peer1)")
+	k, err = store.Lookup("peer2")
+	require.NoError(err, "Lookup(peer2)")
+	require.True(k.Equal(k2), "Lookup(peer2)")
+
+	require.NoError(store.Remove("peer1"), "Remove(peer1)")
+	_, err = store.Lookup("peer1")
+	require.ErrorIs(err, ErrNotFound, "Lookup(peer1) - removed")
+	require.NoError(store.Remove("peer1"), "Remove(peer1) - not present")
+}
+
+func TestFileStore(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "known_peers")
+	err := os.WriteFile(path, []byte("# comment\n\npeer1 25519 a2V5IG9uZQ==\n"), 0o600)
+	require.NoError(err, "WriteFile")
+
+	store := NewFileStore(path)
+	k, err := store.Lookup("peer1")
+	require.NoError(err, "Lookup(peer1)")
+	require.Equal([]byte("key one"), k.PublicKey, "Lookup(peer1)")
+
+	err = store.Add("peer2", &KnownKey{DH: "448", PublicKey: []byte("key two")})
+	require.NoError(err, "Add(peer2)")
+	b, err := os.ReadFile(path)
+	require.NoError(err, "ReadFile")
+	require.Equal("peer1 25519 a2V5IG9uZQ==\npeer2 448 a2V5IHR3bw==\n", string(b), "File contents")
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(err, "ReadDir")
+	require.Len(entries, 1, "ReadDir")
+
+	// A second store on the same file sees the update.
+	k, err = NewFileStore(path).Lookup("peer2")
+	require.NoError(err, "Lookup(peer2) - new store")
+	require.Equal("448", k.DH, "Lookup(peer2) - new store")
+
+	_, err = store.Lookup("bad name")
+	require.ErrorIs(err, ErrInvalidName, "Lookup - invalid name")
+
+	err = os.WriteFile(path, []byte("peer1 25519\n"), 0o600)
+	require.NoError(err, "WriteFile - malformed")
+	_, err = store.Lookup("peer1")
+	require.Error(err, "Lookup - malformed")
+}
@@ -0,0 +1,401 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package libp2p
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/sig"
+)
+
+// MaxPlaintextSize is the maximum transport message plaintext size.
+const MaxPlaintextSize = nyquist.DefaultMaxMessageSize - 16
+
+var (
+	// ErrPeerIDMismatch is the error returned when the peer's identity does
+	// not match the expected peer ID.
+	ErrPeerIDMismatch = errors.New("nyquist/libp2p: peer ID mismatch")
+
+	errNoIdentity = errors.New("nyquist/libp2p: local identity required")
+)
+
+// Config is the libp2p Noise handshake configuration.
+type Config struct {
+	// Identity is the local libp2p identity key.
+	Identity sig.Keypair
+
+	// StaticKey is the local Noise static keypair, if nil an ephemeral
+	// X25519 keypair will be generated for the connection.
+	StaticKey dh.Keypair
+
+	// RemotePeer is the expected peer ID of the remote peer, if set the
+	// handshake will fail if the remote peer's identity does not match.
+	RemotePeer PeerID
+
+	// Extensions are the local handshake extensions, if any.
+	Extensions *Extensions
+
+	// Rng is the entropy source to be used, if nil `crypto/rand.Reader`
+	// will be used.
+	Rng io.Reader
+}
+
+func (cfg *Config) getRng() io.Reader {
+	if cfg.Rng != nil {
+		return cfg.Rng
+	}
+	return rand.Reader
+}
+
+// Client performs a libp2p Noise handshake as the initiator over conn.  On
+// failure, it is the caller's responsibility to close conn.
+//
+// The initiator sends an empty first message, receives and verifies the
+// responder's payload in the second message, and sends its own payload in
+// the third.
+func Client(conn net.Conn, cfg *Config) (*Conn, error) {
+	hs, err := newHandshake(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	defer hs.reset()
+
+	msg, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+
+	if msg, err = readFrame(conn); err != nil {
+		return nil, err
+	}
+	remotePayload, err := hs.ReadMessage(nil, msg)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{Conn: conn}
+	if err = c.verifyPeer(cfg, hs, remotePayload); err != nil {
+		return nil, err
+	}
+
+	if msg, err = hs.WriteMessage(nil, hs.payload); err != nyquist.ErrDone {
+		return nil, handshakeIncomplete(err)
+	}
+	if err = writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+
+	return c.finish(hs, true), nil
+}
+
+// Server performs a libp2p Noise handshake as the responder over conn.  On
+// failure, it is the caller's responsibility to close conn.
+func Server(conn net.Conn, cfg *Config) (*Conn, error) {
+	hs, err := newHandshake(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	defer hs.reset()
+
+	msg, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	// The first message's payload is unused, and is ignored.
+	if _, err = hs.ReadMessage(nil, msg); err != nil {
+		return nil, err
+	}
+
+	if msg, err = hs.WriteMessage(nil, hs.payload); err != nil {
+		return nil, err
+	}
+	if err = writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+
+	if msg, err = readFrame(conn); err != nil {
+		return nil, err
+	}
+	remotePayload, err := hs.ReadMessage(nil, msg)
+	if err != nyquist.ErrDone {
+		return nil, handshakeIncomplete(err)
+	}
+	c := &Conn{Conn: conn}
+	if err = c.verifyPeer(cfg, hs, remotePayload); err != nil {
+		return nil, err
+	}
+
+	return c.finish(hs, false), nil
+}
+
+type handshake struct {
+	*nyquist.HandshakeState
+
+	payload         []byte
+	generatedStatic dh.Keypair
+}
+
+func (hs *handshake) reset() {
+	if hs.HandshakeState != nil {
+		hs.Reset()
+	}
+	if hs.generatedStatic != nil {
+		hs.generatedStatic.DropPrivate()
+	}
+}
+
+func newHandshake(cfg *Config, isInitiator bool) (*handshake, error) {
+	if cfg.Identity == nil {
+		return nil, errNoIdentity
+	}
+
+	var (
+		h   handshake
+		err error
+	)
+	staticKey := cfg.StaticKey
+	if staticKey == nil {
+		if staticKey, err = protocol.DH.GenerateKeypair(cfg.getRng()); err != nil {
+			return nil, err
+		}
+		h.generatedStatic = staticKey
+	}
+
+	p, err := NewHandshakePayload(cfg.Identity, staticKey.Public(), cfg.Extensions)
+	if err != nil {
+		h.reset()
+		return nil, err
+	}
+	if h.payload, err = p.MarshalBinary(); err != nil {
+		h.reset()
+		return nil, err
+	}
+
+	if h.HandshakeState, err = nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: staticKey,
+		Rng:         cfg.Rng,
+		IsInitiator: isInitiator,
+	}); err != nil {
+		h.reset()
+		return nil, err
+	}
+
+	return &h, nil
+}
+
+func handshakeIncomplete(err error) error {
+	if err == nil {
+		return ErrMalformed
+	}
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeFrame(w io.Writer, b []byte) error {
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(b)), uint16(len(b)))
+	_, err := w.Write(append(frame, b...))
+	return err
+}
+
+// Conn is a libp2p Noise secured connection, that implements the
+// `net.Conn` interface.
+type Conn struct {
+	net.Conn
+
+	status           *nyquist.HandshakeStatus
+	remotePeer       PeerID
+	remotePublicKey  *PublicKey
+	remoteExtensions *Extensions
+
+	rdLock sync.Mutex
+	rx     *nyquist.CipherState
+	rdBuf  []byte
+	rdErr  error
+
+	wrLock sync.Mutex
+	tx     *nyquist.CipherState
+}
+
+// HandshakeStatus returns the status of the completed handshake.
+func (c *Conn) HandshakeStatus() *nyquist.HandshakeStatus {
+	return c.status
+}
+
+// RemotePeer returns the remote peer's peer ID.
+func (c *Conn) RemotePeer() PeerID {
+	return c.remotePeer
+}
+
+// RemotePublicKey returns the remote peer's identity public key.
+func (c *Conn) RemotePublicKey() *PublicKey {
+	return c.remotePublicKey
+}
+
+// RemoteExtensions returns the remote peer's handshake extensions, if any.
+func (c *Conn) RemoteExtensions() *Extensions {
+	return c.remoteExtensions
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rdLock.Lock()
+	defer c.rdLock.Unlock()
+
+	for len(c.rdBuf) == 0 {
+		if c.rdErr != nil {
+			return 0, c.rdErr
+		}
+		if c.rx == nil {
+			return 0, io.EOF
+		}
+
+		c.rdBuf, c.rdErr = c.readMessage()
+	}
+
+	n := copy(p, c.rdBuf)
+	c.rdBuf = c.rdBuf[n:]
+
+	return n, nil
+}
+
+func (c *Conn) readMessage() ([]byte, error) {
+	ciphertext, err := readFrame(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	return c.rx.DecryptWithAd(nil, nil, ciphertext)
+}
+
+// Write writes data to the connection.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wrLock.Lock()
+	defer c.wrLock.Unlock()
+
+	if c.tx == nil {
+		return 0, net.ErrClosed
+	}
+
+	var n int
+	for len(p) > 0 {
+		plaintext := p
+		if len(plaintext) > MaxPlaintextSize {
+			plaintext = plaintext[:MaxPlaintextSize]
+		}
+		ciphertext, err := c.tx.EncryptWithAd(nil, nil, plaintext)
+		if err != nil {
+			return n, err
+		}
+		if err = writeFrame(c.Conn, ciphertext); err != nil {
+			return n, err
+		}
+		n += len(plaintext)
+		p = p[len(plaintext):]
+	}
+
+	return n, nil
+}
+
+// Close closes the connection, and clears the transport CipherStates.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+
+	c.wrLock.Lock()
+	if c.tx != nil {
+		c.tx.Reset()
+		c.tx = nil
+	}
+	c.wrLock.Unlock()
+
+	c.rdLock.Lock()
+	if c.rx != nil {
+		c.rx.Reset()
+		c.rx = nil
+	}
+	c.rdBuf = nil
+	c.rdLock.Unlock()
+
+	return err
+}
+
+func (c *Conn) verifyPeer(cfg *Config, hs *handshake, payload []byte) error {
+	var p HandshakePayload
+	if err := p.UnmarshalBinary(payload); err != nil {
+		return err
+	}
+	pk, err := p.Verify(hs.GetStatus().RemoteStatic)
+	if err != nil {
+		return err
+	}
+	id, err := pk.PeerID()
+	if err != nil {
+		return err
+	}
+	if cfg.RemotePeer != "" && cfg.RemotePeer != id {
+		return ErrPeerIDMismatch
+	}
+
+	c.remotePeer, c.remotePublicKey, c.remoteExtensions = id, pk, p.Extensions
+
+	return nil
+}
+
+func (c *Conn) finish(hs *handshake, isInitiator bool) *Conn {
+	c.status = hs.GetStatus()
+
+	cs1, cs2 := c.status.CipherStates[0], c.status.CipherStates[1]
+	if isInitiator {
+		c.tx, c.rx = cs1, cs2
+	} else {
+		c.tx, c.rx = cs2, cs1
+	}
+
+	return c
+}
@@ -0,0 +1,412 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package libp2p implements the libp2p Noise secure channel handshake
+// (`/noise`), including the handshake payload that binds the Noise static
+// key to a libp2p identity, peer IDs, and the 16-bit length-prefixed
+// message framing.
+//
+// Only Ed25519 libp2p identities are supported for signing and signature
+// verification, though peer IDs may be derived from any identity key.
+package libp2p // import "gitlab.com/yawning/nyquist.git/libp2p"
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/sig"
+)
+
+const (
+	// ProtocolID is the libp2p protocol ID for the Noise secure channel.
+	ProtocolID = "/noise"
+
+	// ProtocolName is the Noise protocol used by libp2p.
+	ProtocolName = "Noise_XX_25519_ChaChaPoly_SHA256"
+
+	// SignaturePrefix is the prefix prepended to the Noise static public
+	// key, before it is signed with the libp2p identity key.
+	SignaturePrefix = "noise-libp2p-static-key:"
+
+	multihashIdentity  = 0x00
+	multihashSHA256    = 0x12
+	maxInlineKeyLength = 42
+
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+var (
+	// ErrUnsupportedKeyType is the error returned when an operation is
+	// attempted with an unsupported identity key type.
+	ErrUnsupportedKeyType = errors.New("nyquist/libp2p: unsupported key type")
+
+	// ErrInvalidPeerID is the error returned when a peer ID is malformed.
+	ErrInvalidPeerID = errors.New("nyquist/libp2p: invalid peer ID")
+
+	errMalformedPrivateKey = errors.New("nyquist/libp2p: malformed private key")
+	errNoInlineKey         = errors.New("nyquist/libp2p: peer ID does not contain a public key")
+
+	protocol *nyquist.Protocol
+)
+
+// KeyType is a libp2p identity key type.
+type KeyType int32
+
+const (
+	// KeyTypeRSA is a RSA key.
+	KeyTypeRSA KeyType = 0
+
+	// KeyTypeEd25519 is an Ed25519 key.
+	KeyTypeEd25519 KeyType = 1
+
+	// KeyTypeSecp256k1 is a secp256k1 key.
+	KeyTypeSecp256k1 KeyType = 2
+
+	// KeyTypeECDSA is an ECDSA key.
+	KeyTypeECDSA KeyType = 3
+)
+
+// String returns the string representation of a KeyType.
+func (t KeyType) String() string {
+	switch t {
+	case KeyTypeRSA:
+		return "RSA"
+	case KeyTypeEd25519:
+		return "Ed25519"
+	case KeyTypeSecp256k1:
+		return "Secp256k1"
+	case KeyTypeECDSA:
+		return "ECDSA"
+	default:
+		return "[unknown key type]"
+	}
+}
+
+// PublicKey is a libp2p identity public key.
+type PublicKey struct {
+	// Type is the key type.
+	Type KeyType
+
+	// Data is the type specific serialized public key.
+	Data []byte
+}
+
+// MarshalBinary marshals the public key to the libp2p protobuf form.
+func (pk *PublicKey) MarshalBinary() ([]byte, error) {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(pk.Type))
+	b = appendBytesField(b, 2, pk.Data)
+	return b, nil
+}
+
+// UnmarshalBinary unmarshals the public key from the libp2p protobuf form.
+func (pk *PublicKey) UnmarshalBinary(data []byte) error {
+	keyType, keyData, err := unmarshalKey(data)
+	if err != nil {
+		return err
+	}
+	pk.Type, pk.Data = keyType, keyData
+	return nil
+}
+
+// Verify verifies the signature over the message.
+func (pk *PublicKey) Verify(msg, signature []byte) error {
+	switch pk.Type {
+	case KeyTypeEd25519:
+		sigPk, err := sig.Ed25519.ParsePublicKey(pk.Data)
+		if err != nil {
+			return err
+		}
+		if !sigPk.Verify(msg, signature) {
+			return sig.ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedKeyType
+	}
+}
+
+// PeerID returns the peer ID corresponding to the public key.
+func (pk *PublicKey) PeerID() (PeerID, error) {
+	b, err := pk.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+
+	var mh []byte
+	if len(b) <= maxInlineKeyLength {
+		mh = appendVarint([]byte{multihashIdentity}, uint64(len(b)))
+		mh = append(mh, b...)
+	} else {
+		digest := sha256.Sum256(b)
+		mh = append([]byte{multihashSHA256, sha256.Size}, digest[:]...)
+	}
+
+	return PeerID(mh), nil
+}
+
+// NewPublicKey returns the libp2p identity public key corresponding to a
+// signature verification public key.
+func NewPublicKey(pk sig.PublicKey) (*PublicKey, error) {
+	switch pk.(type) {
+	case *sig.PublicKeyEd25519:
+		return &PublicKey{
+			Type: KeyTypeEd25519,
+			Data: append([]byte{}, pk.Bytes()...),
+		}, nil
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
+
+// MarshalPrivateKey marshals a signing keypair to the libp2p protobuf
+// private key form.
+func MarshalPrivateKey(kp sig.Keypair) ([]byte, error) {
+	if _, ok := kp.(*sig.KeypairEd25519); !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	seed, err := kp.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	keyData := make([]byte, 0, ed25519.PrivateKeySize)
+	keyData = append(keyData, seed...)
+	keyData = append(keyData, kp.Public().Bytes()...)
+	for i := range seed {
+		seed[i] = 0
+	}
+
+	var b []byte
+	b = appendVarintField(b, 1, uint64(KeyTypeEd25519))
+	b = appendBytesField(b, 2, keyData)
+
+	for i := range keyData {
+		keyData[i] = 0
+	}
+
+	return b, nil
+}
+
+// UnmarshalPrivateKey unmarshals a signing keypair from the libp2p protobuf
+// private key form.
+func UnmarshalPrivateKey(data []byte) (sig.Keypair, error) {
+	keyType, keyData, err := unmarshalKey(data)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != KeyTypeEd25519 {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	// Some implementations append a redundant copy of the public key.
+	switch len(keyData) {
+	case ed25519.PrivateKeySize:
+	case ed25519.PrivateKeySize + ed25519.PublicKeySize:
+		if string(keyData[ed25519.PrivateKeySize:]) != string(keyData[ed25519.SeedSize:ed25519.PrivateKeySize]) {
+			return nil, errMalformedPrivateKey
+		}
+	default:
+		return nil, errMalformedPrivateKey
+	}
+
+	kp, err := sig.Ed25519.ParsePrivateKey(keyData[:ed25519.SeedSize])
+	if err != nil {
+		return nil, err
+	}
+	if string(kp.Public().Bytes()) != string(keyData[ed25519.SeedSize:ed25519.PrivateKeySize]) {
+		kp.DropPrivate()
+		return nil, errMalformedPrivateKey
+	}
+
+	return kp, nil
+}
+
+func unmarshalKey(data []byte) (KeyType, []byte, error) {
+	var (
+		keyType         KeyType
+		keyData         []byte
+		hasType, hasKey bool
+	)
+	err := walkFields(data, func(field int, v uint64, b []byte) error {
+		switch field {
+		case 1:
+			if b != nil {
+				return ErrMalformed
+			}
+			keyType, hasType = KeyType(v), true
+		case 2:
+			if b == nil {
+				return ErrMalformed
+			}
+			keyData, hasKey = append([]byte{}, b...), true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if !hasType || !hasKey {
+		return 0, nil, ErrMalformed
+	}
+
+	return keyType, keyData, nil
+}
+
+// PeerID is a libp2p peer ID, the binary multihash of the peer's protobuf
+// serialized identity public key.
+type PeerID string
+
+// String returns the base58 encoded peer ID.
+func (id PeerID) String() string {
+	return encodeBase58([]byte(id))
+}
+
+// ExtractPublicKey returns the identity public key inlined into the peer
+// ID, if any.
+func (id PeerID) ExtractPublicKey() (*PublicKey, error) {
+	code, digest, err := parseMultihash([]byte(id))
+	if err != nil {
+		return nil, err
+	}
+	if code != multihashIdentity {
+		return nil, errNoInlineKey
+	}
+
+	var pk PublicKey
+	if err = pk.UnmarshalBinary(digest); err != nil {
+		return nil, err
+	}
+
+	return &pk, nil
+}
+
+// MatchesPublicKey returns true iff the peer ID corresponds to the public
+// key.
+func (id PeerID) MatchesPublicKey(pk *PublicKey) bool {
+	other, err := pk.PeerID()
+	if err != nil {
+		return false
+	}
+	return id == other
+}
+
+// DecodePeerID decodes a base58 encoded peer ID.
+func DecodePeerID(s string) (PeerID, error) {
+	b, err := decodeBase58(s)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err = parseMultihash(b); err != nil {
+		return "", err
+	}
+
+	return PeerID(b), nil
+}
+
+func parseMultihash(b []byte) (uint64, []byte, error) {
+	code, n := readVarint(b)
+	if n <= 0 {
+		return 0, nil, ErrInvalidPeerID
+	}
+	b = b[n:]
+	length, n := readVarint(b)
+	if n <= 0 || uint64(len(b)-n) != length {
+		return 0, nil, ErrInvalidPeerID
+	}
+	b = b[n:]
+
+	switch code {
+	case multihashIdentity:
+		if len(b) > maxInlineKeyLength {
+			return 0, nil, ErrInvalidPeerID
+		}
+	case multihashSHA256:
+		if len(b) != sha256.Size {
+			return 0, nil, ErrInvalidPeerID
+		}
+	default:
+		return 0, nil, ErrInvalidPeerID
+	}
+
+	return code, b, nil
+}
+
+func encodeBase58(b []byte) string {
+	var zeros int
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	x := new(big.Int).SetBytes(b)
+	radix, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	var zeros int
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	x, radix := new(big.Int), big.NewInt(58)
+	for i := zeros; i < len(s); i++ {
+		idx := strings.IndexByte(base58Alphabet, s[i])
+		if idx < 0 {
+			return nil, ErrInvalidPeerID
+		}
+		x.Mul(x, radix)
+		x.Add(x, big.NewInt(int64(idx)))
+	}
+
+	return append(make([]byte, zeros), x.Bytes()...), nil
+}
+
+func init() {
+	var err error
+	if protocol, err = nyquist.NewProtocol(ProtocolName); err != nil {
+		panic("nyquist/libp2p: failed to initialize protocol: " + err.Error())
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package libp2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/sig"
+	"gitlab.com/yawning/nyquist.git/vectors"
+)
+
+// The Ed25519 test vector from the libp2p peer ID specification.
+const (
+	specPrivateKey = "080112407e0830617c4a7de83925dfb2694556b12936c477a0e1feb2e148ec9da60fee7d1ed1e8fae2c4a144b8be8fd4b47bf3d3b34b871c3cacf6010f0e42d474fce27e"
+	specPublicKey  = "080112201ed1e8fae2c4a144b8be8fd4b47bf3d3b34b871c3cacf6010f0e42d474fce27e"
+	specPeerID     = "12D3KooWBtg3aaRMjxwedh83aGiUkwSxDwUZkzuJcfaqUmo7R3pq"
+)
+
+type testResult struct {
+	conn *Conn
+	err  error
+}
+
+// recordingConn records the frames written to the underlying connection.
+type recordingConn struct {
+	net.Conn
+
+	sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.Lock()
+	_, _ = c.written.Write(p)
+	c.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *recordingConn) frames(t *testing.T) [][]byte {
+	c.Lock()
+	defer c.Unlock()
+
+	var frames [][]byte
+	b := c.written.Bytes()
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 2, "truncated frame header")
+		l := int(binary.BigEndian.Uint16(b))
+		require.GreaterOrEqual(t, len(b)-2, l, "truncated frame")
+		frames = append(frames, b[2:2+l])
+		b = b[2+l:]
+	}
+	return frames
+}
+
+func newTestIdentity(t *testing.T) sig.Keypair {
+	kp, err := sig.Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+func newTestPeerID(t *testing.T, identity sig.Keypair) PeerID {
+	pk, err := NewPublicKey(identity.Public())
+	require.NoError(t, err, "NewPublicKey")
+	id, err := pk.PeerID()
+	require.NoError(t, err, "PeerID")
+	return id
+}
+
+func runHandshake(clientConn, serverConn net.Conn, clientCfg, serverCfg *Config) (*Conn, *Conn, error, error) {
+	ch := make(chan testResult)
+	go func() {
+		conn, err := Server(serverConn, serverCfg)
+		if err != nil {
+			// Unblock the client if it is waiting on a response.
+			serverConn.Close()
+		}
+		ch <- testResult{conn, err}
+	}()
+
+	conn, err := Client(clientConn, clientCfg)
+	if err != nil {
+		clientConn.Close()
+	}
+	res := <-ch
+
+	return conn, res.conn, err, res.err
+}
+
+func TestLibp2p(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Keys", testLibp2pKeys},
+		{"PeerID", testLibp2pPeerID},
+		{"Payload", testLibp2pPayload},
+		{"Handshake", testLibp2pHandshake},
+		{"GoLibp2p", testLibp2pGoLibp2p},
+		{"PeerIDMismatch", testLibp2pPeerIDMismatch},
+		{"BadSignature", testLibp2pBadSignature},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testLibp2pKeys(t *testing.T) {
+	require := require.New(t)
+
+	rawPrivateKey, _ := hex.DecodeString(specPrivateKey)
+	rawPublicKey, _ := hex.DecodeString(specPublicKey)
+
+	kp, err := UnmarshalPrivateKey(rawPrivateKey)
+	require.NoError(err, "UnmarshalPrivateKey")
+	b, err := MarshalPrivateKey(kp)
+	require.NoError(err, "MarshalPrivateKey")
+	require.Equal(rawPrivateKey, b, "MarshalPrivateKey - round trip")
+
+	pk, err := NewPublicKey(kp.Public())
+	require.NoError(err, "NewPublicKey")
+	b, err = pk.MarshalBinary()
+	require.NoError(err, "PublicKey.MarshalBinary")
+	require.Equal(rawPublicKey, b, "PublicKey.MarshalBinary")
+
+	var pk2 PublicKey
+	require.NoError(pk2.UnmarshalBinary(rawPublicKey), "PublicKey.UnmarshalBinary")
+	require.Equal(pk, &pk2, "PublicKey.UnmarshalBinary")
+
+	signature, err := kp.Sign([]byte("test message"))
+	require.NoError(err, "Sign")
+	require.NoError(pk.Verify([]byte("test message"), signature), "Verify")
+	require.ErrorIs(pk.Verify([]byte("other message"), signature), sig.ErrInvalidSignature, "Verify - wrong message")
+
+	// The legacy encoding with a redundant trailing public key.
+	legacy := append(append([]byte{}, rawPrivateKey...), rawPrivateKey[len(rawPrivateKey)-32:]...)
+	legacy[3] = 0x60
+	kp2, err := UnmarshalPrivateKey(legacy)
+	require.NoError(err, "UnmarshalPrivateKey - legacy")
+	require.Equal(kp.Public().Bytes(), kp2.Public().Bytes(), "UnmarshalPrivateKey - legacy")
+
+	// A private key that does not match the public key.
+	bad := append([]byte{}, rawPrivateKey...)
+	bad[len(bad)-1] ^= 0x01
+	_, err = UnmarshalPrivateKey(bad)
+	require.Error(err, "UnmarshalPrivateKey - mismatched public key")
+
+	// Other key types can be parsed, and have peer IDs, but are not
+	// supported for verification.
+	secp := &PublicKey{Type: KeyTypeSecp256k1, Data: make([]byte, 33)}
+	require.ErrorIs(secp.Verify(nil, nil), ErrUnsupportedKeyType, "Verify - secp256k1")
+	id, err := secp.PeerID()
+	require.NoError(err, "PeerID - secp256k1")
+	require.True(strings.HasPrefix(id.String(), "16Uiu2"), "PeerID - secp256k1 prefix")
+}
+
+func testLibp2pPeerID(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal("2NEpo7TZRRrLZSi2U", encodeBase58([]byte("Hello World!")), "encodeBase58")
+	require.Equal("1112", encodeBase58([]byte{0, 0, 0, 1}), "encodeBase58 - leading zeros")
+	b, err := decodeBase58("2NEpo7TZRRrLZSi2U")
+	require.NoError(err, "decodeBase58")
+	require.Equal([]byte("Hello World!"), b, "decodeBase58")
+	_, err = decodeBase58("0OIl")
+	require.Error(err, "decodeBase58 - invalid alphabet")
+
+	rawPublicKey, _ := hex.DecodeString(specPublicKey)
+	var pk PublicKey
+	require.NoError(pk.UnmarshalBinary(rawPublicKey), "UnmarshalBinary")
+
+	id, err := pk.PeerID()
+	require.NoError(err, "PeerID")
+	require.Equal(append([]byte{0x00, 0x24}, rawPublicKey...), []byte(id), "PeerID - identity multihash")
+	require.Equal(specPeerID, id.String(), "PeerID - String")
+	require.True(id.MatchesPublicKey(&pk), "MatchesPublicKey")
+
+	decoded, err := DecodePeerID(id.String())
+	require.NoError(err, "DecodePeerID")
+	require.Equal(id, decoded, "DecodePeerID")
+
+	extracted, err := decoded.ExtractPublicKey()
+	require.NoError(err, "ExtractPublicKey")
+	require.Equal(&pk, extracted, "ExtractPublicKey")
+
+	// Large keys are hashed.
+	rsa := &PublicKey{Type: KeyTypeRSA, Data: make([]byte, 270)}
+	id, err = rsa.PeerID()
+	require.NoError(err, "PeerID - RSA")
+	require.Len([]byte(id), 34, "PeerID - RSA")
+	require.True(strings.HasPrefix(id.String(), "Qm"), "PeerID - RSA prefix")
+	require.False(id.MatchesPublicKey(&pk), "MatchesPublicKey - mismatch")
+	_, err = id.ExtractPublicKey()
+	require.Error(err, "ExtractPublicKey - hashed")
+
+	_, err = DecodePeerID("2NEpo7TZRRrLZSi2U")
+	require.ErrorIs(err, ErrInvalidPeerID, "DecodePeerID - not a multihash")
+}
+
+func testLibp2pPayload(t *testing.T) {
+	require := require.New(t)
+
+	identity := newTestIdentity(t)
+	staticKey, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	ext := &Extensions{
+		WebTransportCertHashes: [][]byte{{0x12, 0x20, 0x01}},
+		StreamMuxers:           []string{"/yamux/1.0.0", "/mplex/6.7.0"},
+	}
+	p, err := NewHandshakePayload(identity, staticKey.Public(), ext)
+	require.NoError(err, "NewHandshakePayload")
+	b, err := p.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+
+	// identity_key (1), identity_sig (2), extensions (4).
+	require.Equal([]byte{0x0a, 36, 0x08, 0x01, 0x12, 0x20}, b[:6], "MarshalBinary - identity_key")
+	require.Equal([]byte{0x12, 64}, b[38:40], "MarshalBinary - identity_sig")
+	require.Equal(byte(0x22), b[104], "MarshalBinary - extensions")
+
+	// Unknown fields (including the deprecated `data` field) are skipped.
+	b = appendBytesField(b, 3, []byte("early data"))
+	b = appendVarintField(b, 15, 1234)
+	b = append(b, 0x2d, 1, 2, 3, 4) // Field 5, fixed32.
+
+	var p2 HandshakePayload
+	require.NoError(p2.UnmarshalBinary(b), "UnmarshalBinary")
+	require.Equal(p, &p2, "UnmarshalBinary")
+
+	pk, err := p2.Verify(staticKey.Public())
+	require.NoError(err, "Verify")
+	require.True(newTestPeerID(t, identity).MatchesPublicKey(pk), "Verify - identity")
+
+	otherKey, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	_, err = p2.Verify(otherKey.Public())
+	require.ErrorIs(err, sig.ErrInvalidSignature, "Verify - wrong static key")
+
+	for _, v := range [][]byte{
+		{0x0a, 0x05, 0x00},       // Truncated bytes.
+		{0x0a},                   // Truncated length.
+		{0x08, 0x80},             // Truncated varint.
+		{0x0b},                   // Invalid wire type.
+		{0x00, 0x00},             // Field 0.
+		{0x08, 0x01},             // identity_key as a varint.
+		{0x29, 0x00, 0x00, 0x00}, // Truncated fixed64.
+	} {
+		err = p2.UnmarshalBinary(v)
+		require.ErrorIs(err, ErrMalformed, "UnmarshalBinary - malformed: %x", v)
+	}
+
+	_, err = (&HandshakePayload{}).Verify(staticKey.Public())
+	require.Error(err, "Verify - empty payload")
+}
+
+func testLibp2pHandshake(t *testing.T) {
+	require := require.New(t)
+
+	clientIdentity, serverIdentity := newTestIdentity(t), newTestIdentity(t)
+	clientPipe, serverPipe := net.Pipe()
+	clientConn := &recordingConn{Conn: clientPipe}
+	serverConn := &recordingConn{Conn: serverPipe}
+
+	client, server, clientErr, serverErr := runHandshake(
+		clientConn,
+		serverConn,
+		&Config{
+			Identity:   clientIdentity,
+			RemotePeer: newTestPeerID(t, serverIdentity),
+		},
+		&Config{
+			Identity: serverIdentity,
+			Extensions: &Extensions{
+				StreamMuxers: []string{"/yamux/1.0.0"},
+			},
+		},
+	)
+	require.NoError(clientErr, "Client")
+	require.NoError(serverErr, "Server")
+	defer client.Close()
+	defer server.Close()
+
+	require.Equal(newTestPeerID(t, serverIdentity), client.RemotePeer(), "client.RemotePeer")
+	require.Equal(newTestPeerID(t, clientIdentity), server.RemotePeer(), "server.RemotePeer")
+	require.Equal([]string{"/yamux/1.0.0"}, client.RemoteExtensions().StreamMuxers, "client.RemoteExtensions")
+	require.Nil(server.RemoteExtensions(), "server.RemoteExtensions")
+	require.Equal(KeyTypeEd25519, server.RemotePublicKey().Type, "server.RemotePublicKey")
+	require.Equal(client.HandshakeStatus().HandshakeHash, server.HandshakeStatus().HandshakeHash, "HandshakeHash")
+
+	// -> e
+	// <- e, ee, s, es (+ payload)
+	// -> s, se (+ payload)
+	clientFrames, serverFrames := clientConn.frames(t), serverConn.frames(t)
+	require.Len(clientFrames, 2, "client frames")
+	require.Len(serverFrames, 1, "server frames")
+	require.Len(clientFrames[0], 32, "message 1")
+	require.Greater(len(serverFrames[0]), 32+48+16, "message 2")
+	require.Greater(len(clientFrames[1]), 48+16, "message 3")
+
+	msg := make([]byte, 3*MaxPlaintextSize/2)
+	_, err := rand.Read(msg)
+	require.NoError(err, "rand.Read")
+
+	errCh := make(chan error)
+	go func() {
+		_, wrErr := client.Write(msg)
+		errCh <- wrErr
+	}()
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(server, b)
+	require.NoError(err, "server.Read")
+	require.NoError(<-errCh, "client.Write")
+	require.Equal(msg, b, "client -> server")
+
+	go func() {
+		_, wrErr := server.Write([]byte("hello"))
+		errCh <- wrErr
+	}()
+	b = make([]byte, 5)
+	_, err = io.ReadFull(client, b)
+	require.NoError(err, "client.Read")
+	require.NoError(<-errCh, "server.Write")
+	require.Equal([]byte("hello"), b, "server -> client")
+
+	// Transport messages are framed the same way as handshake messages.
+	serverFrames = serverConn.frames(t)
+	require.Len(serverFrames, 2, "server frames")
+	require.Len(serverFrames[1], 5+16, "transport message")
+}
+
+// goLibp2pTranscript is a handshake transcript recorded from go-libp2p, see
+// testdata/go-libp2p.json.
+type goLibp2pTranscript struct {
+	InitiatorIdentity         vectors.HexBuffer   `json:"initiator_identity"`
+	InitiatorPeerID           string              `json:"initiator_peer_id"`
+	InitiatorStaticPrivate    vectors.HexBuffer   `json:"initiator_static_private"`
+	InitiatorEphemeralPrivate vectors.HexBuffer   `json:"initiator_ephemeral_private"`
+	ResponderIdentity         vectors.HexBuffer   `json:"responder_identity"`
+	ResponderPeerID           string              `json:"responder_peer_id"`
+	ResponderStaticPrivate    vectors.HexBuffer   `json:"responder_static_private"`
+	ResponderEphemeralPrivate vectors.HexBuffer   `json:"responder_ephemeral_private"`
+	InitiatorFrames           []vectors.HexBuffer `json:"initiator_frames"`
+	ResponderFrames           []vectors.HexBuffer `json:"responder_frames"`
+}
+
+func testLibp2pGoLibp2p(t *testing.T) {
+	require := require.New(t)
+
+	fn := filepath.Join("./testdata/", "go-libp2p.json")
+	b, err := os.ReadFile(fn)
+	require.NoError(err, "ReadFile(%v)", fn)
+	var v goLibp2pTranscript
+	err = json.Unmarshal(b, &v)
+	require.NoError(err, "json.Unmarshal")
+
+	newCfg := func(rawIdentity, staticPrivate, ephemeralPrivate []byte) *Config {
+		identity, err := UnmarshalPrivateKey(rawIdentity)
+		require.NoError(err, "UnmarshalPrivateKey")
+		staticKey, err := dh.X25519.ParsePrivateKey(staticPrivate)
+		require.NoError(err, "ParsePrivateKey")
+		return &Config{
+			Identity:  identity,
+			StaticKey: staticKey,
+			Extensions: &Extensions{
+				StreamMuxers: []string{"/yamux/1.0.0"},
+			},
+			Rng: bytes.NewReader(ephemeralPrivate),
+		}
+	}
+	clientCfg := newCfg(v.InitiatorIdentity, v.InitiatorStaticPrivate, v.InitiatorEphemeralPrivate)
+	clientCfg.RemotePeer, err = DecodePeerID(v.ResponderPeerID)
+	require.NoError(err, "DecodePeerID")
+	serverCfg := newCfg(v.ResponderIdentity, v.ResponderStaticPrivate, v.ResponderEphemeralPrivate)
+
+	clientPipe, serverPipe := net.Pipe()
+	clientConn := &recordingConn{Conn: clientPipe}
+	serverConn := &recordingConn{Conn: serverPipe}
+
+	client, server, clientErr, serverErr := runHandshake(clientConn, serverConn, clientCfg, serverCfg)
+	require.NoError(clientErr, "Client")
+	require.NoError(serverErr, "Server")
+	defer client.Close()
+	defer server.Close()
+
+	require.Equal(v.ResponderPeerID, client.RemotePeer().String(), "client.RemotePeer")
+	require.Equal(v.InitiatorPeerID, server.RemotePeer().String(), "server.RemotePeer")
+	require.Equal([]string{"/yamux/1.0.0"}, client.RemoteExtensions().StreamMuxers, "client.RemoteExtensions")
+
+	errCh := make(chan error)
+	go func() {
+		_, wrErr := client.Write([]byte("hello responder"))
+		errCh <- wrErr
+	}()
+	b = make([]byte, len("hello responder"))
+	_, err = io.ReadFull(server, b)
+	require.NoError(err, "server.Read")
+	require.NoError(<-errCh, "client.Write")
+	require.Equal([]byte("hello responder"), b, "client -> server")
+
+	go func() {
+		_, wrErr := server.Write([]byte("hello initiator"))
+		errCh <- wrErr
+	}()
+	b = make([]byte, len("hello initiator"))
+	_, err = io.ReadFull(client, b)
+	require.NoError(err, "client.Read")
+	require.NoError(<-errCh, "server.Write")
+	require.Equal([]byte("hello initiator"), b, "server -> client")
+
+	// Every handshake and transport message is byte for byte identical
+	// to the messages sent by go-libp2p.
+	clientFrames, serverFrames := clientConn.frames(t), serverConn.frames(t)
+	require.Len(clientFrames, len(v.InitiatorFrames), "client frames")
+	for i, frame := range v.InitiatorFrames {
+		require.EqualValues(frame, clientFrames[i], "client frame %d", i)
+	}
+	require.Len(serverFrames, len(v.ResponderFrames), "server frames")
+	for i, frame := range v.ResponderFrames {
+		require.EqualValues(frame, serverFrames[i], "server frame %d", i)
+	}
+}
+
+func testLibp2pPeerIDMismatch(t *testing.T) {
+	require := require.New(t)
+
+	clientIdentity, serverIdentity := newTestIdentity(t), newTestIdentity(t)
+	clientConn, serverConn := net.Pipe()
+
+	_, _, clientErr, serverErr := runHandshake(
+		clientConn,
+		serverConn,
+		&Config{
+			Identity:   clientIdentity,
+			RemotePeer: newTestPeerID(t, newTestIdentity(t)),
+		},
+		&Config{
+			Identity: serverIdentity,
+		},
+	)
+	require.ErrorIs(clientErr, ErrPeerIDMismatch, "Client")
+	require.Error(serverErr, "Server")
+}
+
+func testLibp2pBadSignature(t *testing.T) {
+	require := require.New(t)
+
+	clientIdentity, serverIdentity := newTestIdentity(t), newTestIdentity(t)
+	clientConn, serverConn := net.Pipe()
+
+	// The server signs a different static key than the one it uses.
+	serverStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	forgedStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	forgedPayload, err := NewHandshakePayload(serverIdentity, forgedStatic.Public(), nil)
+	require.NoError(err, "NewHandshakePayload")
+	forged, err := forgedPayload.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+
+	ch := make(chan error)
+	go func() {
+		hs, hsErr := newHandshake(&Config{
+			Identity:  serverIdentity,
+			StaticKey: serverStatic,
+		}, false)
+		if hsErr != nil {
+			ch <- hsErr
+			return
+		}
+		defer hs.reset()
+		hs.payload = forged
+
+		msg, hsErr := readFrame(serverConn)
+		if hsErr == nil {
+			_, hsErr = hs.ReadMessage(nil, msg)
+		}
+		if hsErr == nil {
+			msg, hsErr = hs.WriteMessage(nil, hs.payload)
+		}
+		if hsErr == nil {
+			hsErr = writeFrame(serverConn, msg)
+		}
+		serverConn.Close()
+		ch <- hsErr
+	}()
+
+	_, err = Client(clientConn, &Config{
+		Identity: clientIdentity,
+	})
+	require.ErrorIs(err, sig.ErrInvalidSignature, "Client")
+	require.NoError(<-ch, "forging server")
+}
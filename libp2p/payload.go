@@ -0,0 +1,273 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package libp2p
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/sig"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	// ErrMalformed is the error returned when a protobuf message is
+	// malformed.
+	ErrMalformed = errors.New("nyquist/libp2p: malformed protobuf message")
+
+	errMissingIdentity = errors.New("nyquist/libp2p: handshake payload missing identity")
+)
+
+// Extensions are the optional Noise handshake extensions.
+type Extensions struct {
+	// WebTransportCertHashes are the WebTransport certificate hashes.
+	WebTransportCertHashes [][]byte
+
+	// StreamMuxers are the supported stream multiplexers, in order of
+	// preference, for early multiplexer negotiation.
+	StreamMuxers []string
+}
+
+// HandshakePayload is the libp2p `NoiseHandshakePayload`, sent in the
+// handshake messages that carry each party's static key.
+type HandshakePayload struct {
+	// IdentityKey is the protobuf serialized identity public key.
+	IdentityKey []byte
+
+	// IdentitySig is the identity key's signature over the Noise static
+	// public key, prefixed with `SignaturePrefix`.
+	IdentitySig []byte
+
+	// Extensions are the optional extensions.
+	Extensions *Extensions
+}
+
+// MarshalBinary marshals the payload to the protobuf form.
+func (p *HandshakePayload) MarshalBinary() ([]byte, error) {
+	var b []byte
+	if len(p.IdentityKey) > 0 {
+		b = appendBytesField(b, 1, p.IdentityKey)
+	}
+	if len(p.IdentitySig) > 0 {
+		b = appendBytesField(b, 2, p.IdentitySig)
+	}
+	if ext := p.Extensions; ext != nil {
+		var eb []byte
+		for _, v := range ext.WebTransportCertHashes {
+			eb = appendBytesField(eb, 1, v)
+		}
+		for _, v := range ext.StreamMuxers {
+			eb = appendBytesField(eb, 2, []byte(v))
+		}
+		b = appendBytesField(b, 4, eb)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary unmarshals the payload from the protobuf form.  Unknown
+// fields are ignored.
+func (p *HandshakePayload) UnmarshalBinary(data []byte) error {
+	var dec HandshakePayload
+	err := walkFields(data, func(field int, _ uint64, b []byte) error {
+		switch field {
+		case 1, 2, 4:
+			if b == nil {
+				return ErrMalformed
+			}
+		default:
+			return nil
+		}
+
+		switch field {
+		case 1:
+			dec.IdentityKey = append([]byte{}, b...)
+		case 2:
+			dec.IdentitySig = append([]byte{}, b...)
+		case 4:
+			if dec.Extensions == nil {
+				dec.Extensions = &Extensions{}
+			}
+			return dec.Extensions.unmarshal(b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	*p = dec
+	return nil
+}
+
+func (ext *Extensions) unmarshal(data []byte) error {
+	return walkFields(data, func(field int, _ uint64, b []byte) error {
+		switch field {
+		case 1:
+			if b == nil {
+				return ErrMalformed
+			}
+			ext.WebTransportCertHashes = append(ext.WebTransportCertHashes, append([]byte{}, b...))
+		case 2:
+			if b == nil {
+				return ErrMalformed
+			}
+			ext.StreamMuxers = append(ext.StreamMuxers, string(b))
+		}
+		return nil
+	})
+}
+
+// Verify verifies that the payload binds the Noise static public key to the
+// identity key, and returns the identity key.
+func (p *HandshakePayload) Verify(staticKey dh.PublicKey) (*PublicKey, error) {
+	if len(p.IdentityKey) == 0 || len(p.IdentitySig) == 0 {
+		return nil, errMissingIdentity
+	}
+
+	var pk PublicKey
+	if err := pk.UnmarshalBinary(p.IdentityKey); err != nil {
+		return nil, err
+	}
+	if err := pk.Verify(signedMessage(staticKey), p.IdentitySig); err != nil {
+		return nil, err
+	}
+
+	return &pk, nil
+}
+
+// NewHandshakePayload creates a new handshake payload, binding the Noise
+// static public key to the identity key.
+func NewHandshakePayload(identity sig.Keypair, staticKey dh.PublicKey, ext *Extensions) (*HandshakePayload, error) {
+	pk, err := NewPublicKey(identity.Public())
+	if err != nil {
+		return nil, err
+	}
+	identityKey, err := pk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	identitySig, err := identity.Sign(signedMessage(staticKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &HandshakePayload{
+		IdentityKey: identityKey,
+		IdentitySig: identitySig,
+		Extensions:  ext,
+	}, nil
+}
+
+func signedMessage(staticKey dh.PublicKey) []byte {
+	return append([]byte(SignaturePrefix), staticKey.Bytes()...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func readVarint(b []byte) (uint64, int) {
+	return binary.Uvarint(b)
+}
+
+// walkFields calls fn for each field in a protobuf message.  Varint fields
+// are passed as v with a nil b, length-delimited fields as a non-nil b, and
+// fixed-width fields are skipped.
+func walkFields(data []byte, fn func(field int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		tag, n := readVarint(data)
+		if n <= 0 {
+			return ErrMalformed
+		}
+		data = data[n:]
+
+		field, wireType := tag>>3, tag&7
+		if field == 0 || field > 1<<29-1 {
+			return ErrMalformed
+		}
+
+		var (
+			v uint64
+			b []byte
+		)
+		switch wireType {
+		case wireVarint:
+			if v, n = readVarint(data); n <= 0 {
+				return ErrMalformed
+			}
+			data = data[n:]
+		case wireBytes:
+			l, n := readVarint(data)
+			if n <= 0 || l > uint64(len(data)-n) {
+				return ErrMalformed
+			}
+			b = data[n : n+int(l) : n+int(l)]
+			data = data[n+int(l):]
+		case wireFixed64:
+			if len(data) < 8 {
+				return ErrMalformed
+			}
+			data = data[8:]
+			continue
+		case wireFixed32:
+			if len(data) < 4 {
+				return ErrMalformed
+			}
+			data = data[4:]
+			continue
+		default:
+			return ErrMalformed
+		}
+
+		if err := fn(int(field), v, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
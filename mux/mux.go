@@ -0,0 +1,676 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package mux implements a yamux-style stream multiplexer over a single
+// completed Noise session.
+//
+// Every multiplexer frame (a 12 byte header, and for data frames, the
+// data) is sent as a single Noise transport message, framed on the wire
+// with a 16-bit big-endian length, so that all of the framing is
+// encrypted and authenticated.  Data frames are split as required so that
+// each Noise transport message fits within the session's maximum message
+// size.
+//
+// The frame header consists of a version (0), type, flags, stream ID and
+// length, all big-endian, with the same semantics as yamux.  The client
+// (initiator) opens odd numbered streams, and the server (responder)
+// opens even numbered streams.
+package mux // import "gitlab.com/yawning/nyquist.git/mux"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+const (
+	// DefaultInitialWindowSize is the default initial per-stream receive
+	// window size.
+	DefaultInitialWindowSize = 256 * 1024
+
+	// DefaultKeepAliveInterval is the default keepalive interval.
+	DefaultKeepAliveInterval = 30 * time.Second
+
+	// DefaultAcceptBacklog is the default number of remotely opened
+	// streams that may be pending `AcceptStream`.
+	DefaultAcceptBacklog = 256
+
+	protoVersion = 0
+
+	typeData         = 0
+	typeWindowUpdate = 1
+	typePing         = 2
+	typeGoAway       = 3
+
+	flagSYN = 1 << 0
+	flagACK = 1 << 1
+	flagFIN = 1 << 2
+	flagRST = 1 << 3
+
+	goAwayNormal        = 0
+	goAwayProtocolError = 1
+
+	headerSize        = 12
+	maxControlFrames  = 1024
+	closeFlushTimeout = 100 * time.Millisecond
+)
+
+var (
+	// ErrSessionClosed is the error returned when the session is closed.
+	ErrSessionClosed = errors.New("nyquist/mux: session closed")
+
+	// ErrStreamClosed is the error returned when writing to a stream that
+	// has been closed.
+	ErrStreamClosed = errors.New("nyquist/mux: stream closed")
+
+	// ErrStreamReset is the error returned when a stream is reset.
+	ErrStreamReset = errors.New("nyquist/mux: stream reset")
+
+	// ErrRemoteGoAway is the error returned when opening a stream after
+	// the peer has stopped accepting new streams.
+	ErrRemoteGoAway = errors.New("nyquist/mux: remote end is not accepting streams")
+
+	// ErrKeepAliveTimeout is the error the session is closed with when
+	// the peer fails to respond to a keepalive.
+	ErrKeepAliveTimeout = errors.New("nyquist/mux: keepalive timeout")
+
+	// ErrProtocol is the error the session is closed with when the peer
+	// violates the multiplexer protocol.
+	ErrProtocol = errors.New("nyquist/mux: protocol violation")
+
+	// ErrTimeout is the error returned when an I/O deadline is exceeded.
+	ErrTimeout net.Error = &timeoutError{}
+
+	errStreamsExhausted = errors.New("nyquist/mux: stream IDs exhausted")
+	errInvalidStatus    = errors.New("nyquist/mux: handshake not complete, or one-way pattern")
+	errMessageTooSmall  = errors.New("nyquist/mux: maximum message size too small")
+)
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "nyquist/mux: i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// Config is the multiplexer configuration.
+type Config struct {
+	// InitialWindowSize is the initial per-stream receive window size,
+	// which must match on both peers.  If 0, `DefaultInitialWindowSize`
+	// will be used.
+	InitialWindowSize uint32
+
+	// KeepAliveInterval is the interval at which keepalives are sent, and
+	// the time the peer has to respond before the session is closed.  If
+	// 0, `DefaultKeepAliveInterval` will be used, a negative value will
+	// disable keepalives.
+	KeepAliveInterval time.Duration
+
+	// AcceptBacklog is the number of remotely opened streams that may be
+	// pending `AcceptStream`, before new streams are reset.  If 0,
+	// `DefaultAcceptBacklog` will be used.
+	AcceptBacklog int
+}
+
+type sendReq struct {
+	frame []byte
+	errCh chan error
+}
+
+// Session is a multiplexed session over a single Noise session.  It
+// implements the `net.Listener` interface, to accept remotely opened
+// streams.
+type Session struct {
+	conn         net.Conn
+	windowSize   uint32
+	maxFrameData int
+
+	tx *nyquist.CipherState
+	rx *nyquist.CipherState
+
+	l            sync.Mutex
+	streams      map[uint32]*Stream
+	nextID       uint32
+	pings        map[uint32]chan struct{}
+	nextPing     uint32
+	localGoAway  bool
+	remoteGoAway bool
+	closeErr     error
+
+	ctrlLock   sync.Mutex
+	ctrlQueue  [][]byte
+	ctrlNotify chan struct{}
+	sendCh     chan *sendReq
+
+	acceptCh chan *Stream
+	sendDone chan struct{}
+	closedCh chan struct{}
+}
+
+// OpenStream opens a new stream.
+func (s *Session) OpenStream() (*Stream, error) {
+	s.l.Lock()
+	switch {
+	case s.closeErr != nil:
+		s.l.Unlock()
+		return nil, ErrSessionClosed
+	case s.remoteGoAway:
+		s.l.Unlock()
+		return nil, ErrRemoteGoAway
+	case s.nextID > math.MaxUint32-2:
+		s.l.Unlock()
+		return nil, errStreamsExhausted
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.l.Unlock()
+
+	if err := s.send(typeWindowUpdate, flagSYN, id, 0, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// AcceptStream waits for and returns the next remotely opened stream.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.acceptCh:
+		if err := s.send(typeWindowUpdate, flagACK, st.id, 0, nil); err != nil {
+			return nil, err
+		}
+		return st, nil
+	case <-s.closedCh:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Accept implements the `net.Listener` interface.
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// Addr implements the `net.Listener` interface, and returns the local
+// address of the underlying connection.
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return len(s.streams)
+}
+
+// IsClosed returns true iff the session is closed.
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closedCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseChan returns a channel that is closed when the session is closed.
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.closedCh
+}
+
+// Err returns the error the session was closed with, if any.
+func (s *Session) Err() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.closeErr
+}
+
+// Ping sends a ping to the peer, and returns the round trip time.
+func (s *Session) Ping() (time.Duration, error) {
+	return s.ping(0)
+}
+
+// GoAway notifies the peer that no new streams will be accepted.
+func (s *Session) GoAway() error {
+	s.l.Lock()
+	s.localGoAway = true
+	s.l.Unlock()
+
+	return s.send(typeGoAway, 0, 0, goAwayNormal, nil)
+}
+
+// Close closes the session, and all of its streams.
+func (s *Session) Close() error {
+	if s.IsClosed() {
+		return nil
+	}
+
+	// Notify the peer, but do not wait for the write to complete, since
+	// the peer may not be reading.
+	s.enqueueControl(encodeHeader(typeGoAway, 0, 0, goAwayNormal))
+	s.closeWithErr(ErrSessionClosed, true)
+
+	return nil
+}
+
+func (s *Session) closeWithErr(err error, graceful bool) {
+	// Only the first caller closes the session, without blocking any
+	// concurrent callers (eg: the send loop, which may be waited on).
+	s.l.Lock()
+	if s.closeErr != nil {
+		s.l.Unlock()
+		return
+	}
+	s.closeErr = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.l.Unlock()
+
+	if graceful {
+		// Give the send loop a bounded amount of time to flush the queued
+		// control frames (eg: GoAway).
+		_ = s.conn.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+		close(s.closedCh)
+		<-s.sendDone
+	} else {
+		close(s.closedCh)
+	}
+	_ = s.conn.Close()
+
+	for _, st := range streams {
+		st.notifyAll()
+	}
+}
+
+func (s *Session) ping(timeout time.Duration) (time.Duration, error) {
+	ch := make(chan struct{})
+
+	s.l.Lock()
+	id := s.nextPing
+	s.nextPing++
+	s.pings[id] = ch
+	s.l.Unlock()
+
+	defer func() {
+		s.l.Lock()
+		delete(s.pings, id)
+		s.l.Unlock()
+	}()
+
+	start := time.Now()
+	if err := s.send(typePing, flagSYN, 0, id, nil); err != nil {
+		return 0, err
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-timer:
+		return 0, ErrTimeout
+	case <-s.closedCh:
+		return 0, ErrSessionClosed
+	}
+}
+
+func (s *Session) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.ping(interval); err != nil {
+				if err == ErrTimeout {
+					s.closeWithErr(ErrKeepAliveTimeout, false)
+				}
+				return
+			}
+		case <-s.closedCh:
+			return
+		}
+	}
+}
+
+// send sends a frame, and waits for it to be written.
+func (s *Session) send(typ, flags byte, id, length uint32, data []byte) error {
+	frame := make([]byte, 0, headerSize+len(data))
+	frame = append(frame, encodeHeader(typ, flags, id, length)...)
+	frame = append(frame, data...)
+
+	req := &sendReq{
+		frame: frame,
+		errCh: make(chan error, 1),
+	}
+	select {
+	case s.sendCh <- req:
+	case <-s.closedCh:
+		return ErrSessionClosed
+	}
+	select {
+	case err := <-req.errCh:
+		return err
+	case <-s.closedCh:
+		return ErrSessionClosed
+	}
+}
+
+// enqueueControl queues a control frame, without waiting for it to be
+// written, so that the receive loop never blocks on the peer reading.
+func (s *Session) enqueueControl(frame []byte) {
+	s.ctrlLock.Lock()
+	s.ctrlQueue = append(s.ctrlQueue, frame)
+	overflow := len(s.ctrlQueue) > maxControlFrames
+	s.ctrlLock.Unlock()
+
+	if overflow {
+		// The peer is generating control frames faster than it is
+		// reading them.
+		s.closeWithErr(ErrProtocol, false)
+		return
+	}
+
+	select {
+	case s.ctrlNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) sendLoop() {
+	defer close(s.sendDone)
+
+	for {
+		if err := s.writeControl(); err != nil {
+			s.closeWithErr(err, false)
+			return
+		}
+
+		select {
+		case <-s.ctrlNotify:
+		case req := <-s.sendCh:
+			err := s.writeMessage(req.frame)
+			req.errCh <- err
+			if err != nil {
+				s.closeWithErr(err, false)
+				return
+			}
+		case <-s.closedCh:
+			_ = s.writeControl()
+			return
+		}
+	}
+}
+
+func (s *Session) writeControl() error {
+	s.ctrlLock.Lock()
+	queue := s.ctrlQueue
+	s.ctrlQueue = nil
+	s.ctrlLock.Unlock()
+
+	for _, frame := range queue {
+		if err := s.writeMessage(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) writeMessage(frame []byte) error {
+	ciphertext, err := s.tx.EncryptWithAd(nil, nil, frame)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(ciphertext)), uint16(len(ciphertext)))
+	_, err = s.conn.Write(append(msg, ciphertext...))
+	return err
+}
+
+func (s *Session) recvLoop() {
+	var hdr [2]byte
+	for {
+		if _, err := io.ReadFull(s.conn, hdr[:]); err != nil {
+			s.closeWithErr(err, false)
+			return
+		}
+		ciphertext := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(s.conn, ciphertext); err != nil {
+			s.closeWithErr(err, false)
+			return
+		}
+		frame, err := s.rx.DecryptWithAd(ciphertext[:0], nil, ciphertext)
+		if err != nil {
+			s.closeWithErr(err, false)
+			return
+		}
+		if err = s.handleFrame(frame); err != nil {
+			s.enqueueControl(encodeHeader(typeGoAway, 0, 0, goAwayProtocolError))
+			s.closeWithErr(err, true)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(frame []byte) error {
+	if len(frame) < headerSize || frame[0] != protoVersion {
+		return ErrProtocol
+	}
+	typ, flags := frame[1], binary.BigEndian.Uint16(frame[2:])
+	id, length := binary.BigEndian.Uint32(frame[4:]), binary.BigEndian.Uint32(frame[8:])
+	data := frame[headerSize:]
+
+	switch typ {
+	case typeData:
+		if uint32(len(data)) != length {
+			return ErrProtocol
+		}
+	case typeWindowUpdate:
+		if len(data) != 0 {
+			return ErrProtocol
+		}
+	case typePing:
+		if flags&flagSYN != 0 {
+			s.enqueueControl(encodeHeader(typePing, flagACK, 0, length))
+		} else if flags&flagACK != 0 {
+			s.l.Lock()
+			if ch := s.pings[length]; ch != nil {
+				close(ch)
+				delete(s.pings, length)
+			}
+			s.l.Unlock()
+		}
+		return nil
+	case typeGoAway:
+		s.l.Lock()
+		s.remoteGoAway = true
+		s.l.Unlock()
+		return nil
+	default:
+		return ErrProtocol
+	}
+
+	if id == 0 {
+		return ErrProtocol
+	}
+
+	st, reject, err := s.getStream(id, flags)
+	if reject {
+		s.enqueueControl(encodeHeader(typeWindowUpdate, flagRST, id, 0))
+	}
+	if err != nil || st == nil {
+		return err
+	}
+	if typ == typeData {
+		if err = st.onData(data); err != nil {
+			return err
+		}
+	} else {
+		st.onWindowUpdate(length)
+	}
+	if flags&flagFIN != 0 {
+		st.onFIN()
+	}
+	if flags&flagRST != 0 {
+		st.onRST()
+	}
+
+	return nil
+}
+
+// getStream returns the stream for a data or window update frame, creating
+// it if the frame opens a new stream.  If the new stream should be
+// rejected, reject will be true.
+func (s *Session) getStream(id uint32, flags uint16) (st *Stream, reject bool, err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	st = s.streams[id]
+	if flags&flagSYN == 0 {
+		// Frames for unknown streams (eg: closed locally) are ignored.
+		return st, false, nil
+	}
+
+	isRemoteID := (id&1 == 1) == (s.nextID&1 == 0)
+	if st != nil || !isRemoteID {
+		return nil, false, ErrProtocol
+	}
+	if s.localGoAway || s.closeErr != nil {
+		return nil, true, nil
+	}
+
+	st = newStream(s, id)
+	select {
+	case s.acceptCh <- st:
+	default:
+		return nil, true, nil
+	}
+	s.streams[id] = st
+
+	return st, false, nil
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.l.Lock()
+	delete(s.streams, id)
+	s.l.Unlock()
+}
+
+func encodeHeader(typ, flags byte, id, length uint32) []byte {
+	b := make([]byte, headerSize)
+	b[0], b[1] = protoVersion, typ
+	binary.BigEndian.PutUint16(b[2:], uint16(flags))
+	binary.BigEndian.PutUint32(b[4:], id)
+	binary.BigEndian.PutUint32(b[8:], length)
+	return b
+}
+
+// New creates a new multiplexed session over conn, using the completed
+// handshake's CipherStates.  The handshake configuration is used to
+// determine the role, AEAD overhead, and maximum message size.
+func New(conn net.Conn, status *nyquist.HandshakeStatus, hsCfg *nyquist.HandshakeConfig, cfg *Config) (*Session, error) {
+	if status.Err != nyquist.ErrDone || len(status.CipherStates) != 2 || status.CipherStates[1] == nil {
+		return nil, errInvalidStatus
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	aead, err := hsCfg.Protocol.Cipher.New(make([]byte, nyquist.SymmetricKeySize))
+	if err != nil {
+		return nil, err
+	}
+	maxMessageSize := hsCfg.MaxMessageSize
+	switch {
+	case maxMessageSize == 0:
+		maxMessageSize = nyquist.DefaultMaxMessageSize
+	case maxMessageSize < 0, maxMessageSize > math.MaxUint16:
+		maxMessageSize = math.MaxUint16
+	}
+	maxFrameData := maxMessageSize - aead.Overhead() - headerSize
+	if maxFrameData <= 0 {
+		return nil, errMessageTooSmall
+	}
+
+	windowSize := cfg.InitialWindowSize
+	if windowSize == 0 {
+		windowSize = DefaultInitialWindowSize
+	}
+	backlog := cfg.AcceptBacklog
+	if backlog <= 0 {
+		backlog = DefaultAcceptBacklog
+	}
+	keepAliveInterval := cfg.KeepAliveInterval
+	if keepAliveInterval == 0 {
+		keepAliveInterval = DefaultKeepAliveInterval
+	}
+
+	s := &Session{
+		conn:         conn,
+		windowSize:   windowSize,
+		maxFrameData: maxFrameData,
+		streams:      make(map[uint32]*Stream),
+		nextID:       2,
+		pings:        make(map[uint32]chan struct{}),
+		ctrlNotify:   make(chan struct{}, 1),
+		sendCh:       make(chan *sendReq),
+		acceptCh:     make(chan *Stream, backlog),
+		sendDone:     make(chan struct{}),
+		closedCh:     make(chan struct{}),
+	}
+	cs1, cs2 := status.CipherStates[0], status.CipherStates[1]
+	if hsCfg.IsInitiator {
+		s.tx, s.rx = cs1, cs2
+		s.nextID = 1
+	} else {
+		s.tx, s.rx = cs2, cs1
+	}
+
+	go s.recvLoop()
+	go s.sendLoop()
+	if keepAliveInterval > 0 {
+		go s.keepalive(keepAliveInterval)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,401 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+// recordingConn records the bytes written to the underlying connection.
+type recordingConn struct {
+	net.Conn
+
+	l       sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.l.Lock()
+	_, _ = c.written.Write(p)
+	c.l.Unlock()
+	return c.Conn.Write(p)
+}
+
+func (c *recordingConn) messageSizes(t *testing.T) []int {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	var sizes []int
+	b := c.written.Bytes()
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 2, "truncated message header")
+		l := int(binary.BigEndian.Uint16(b))
+		require.GreaterOrEqual(t, len(b)-2, l, "truncated message")
+		sizes = append(sizes, l)
+		b = b[2+l:]
+	}
+	return sizes
+}
+
+func handshake(t *testing.T, maxMessageSize int) (*nyquist.HandshakeConfig, *nyquist.HandshakeStatus, *nyquist.HandshakeConfig, *nyquist.HandshakeStatus) {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol("Noise_NN_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+
+	clientCfg := &nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		MaxMessageSize: maxMessageSize,
+		IsInitiator:    true,
+	}
+	serverCfg := &nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		MaxMessageSize: maxMessageSize,
+	}
+	client, err := nyquist.NewHandshake(clientCfg)
+	require.NoError(err, "NewHandshake(client)")
+	server, err := nyquist.NewHandshake(serverCfg)
+	require.NoError(err, "NewHandshake(server)")
+
+	msg, err := client.WriteMessage(nil, nil)
+	require.NoError(err, "client.WriteMessage")
+	_, err = server.ReadMessage(nil, msg)
+	require.NoError(err, "server.ReadMessage")
+	msg, err = server.WriteMessage(nil, nil)
+	require.Equal(nyquist.ErrDone, err, "server.WriteMessage")
+	_, err = client.ReadMessage(nil, msg)
+	require.Equal(nyquist.ErrDone, err, "client.ReadMessage")
+
+	return clientCfg, client.GetStatus(), serverCfg, server.GetStatus()
+}
+
+func newTestPair(t *testing.T, maxMessageSize int, cfg *Config) (*Session, *Session, *recordingConn) {
+	clientHsCfg, clientStatus, serverHsCfg, serverStatus := handshake(t, maxMessageSize)
+	clientPipe, serverConn := net.Pipe()
+	clientConn := &recordingConn{Conn: clientPipe}
+
+	client, err := New(clientConn, clientStatus, clientHsCfg, cfg)
+	require.NoError(t, err, "New(client)")
+	server, err := New(serverConn, serverStatus, serverHsCfg, cfg)
+	require.NoError(t, err, "New(server)")
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return client, server, clientConn
+}
+
+func TestMux(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Streams", testMuxStreams},
+		{"HalfClose", testMuxHalfClose},
+		{"FlowControl", testMuxFlowControl},
+		{"Framing", testMuxFraming},
+		{"Reset", testMuxReset},
+		{"Backlog", testMuxBacklog},
+		{"KeepAlive", testMuxKeepAlive},
+		{"GoAway", testMuxGoAway},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testMuxStreams(t *testing.T) {
+	require := require.New(t)
+
+	client, server, _ := newTestPair(t, 0, nil)
+
+	// Echo server.
+	go func() {
+		for {
+			st, err := server.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(st, st)
+				st.Close()
+			}()
+		}
+	}()
+
+	const nrStreams, streamSize = 8, 1024 * 1024 // Larger than the window.
+	var wg sync.WaitGroup
+	errCh := make(chan error, nrStreams)
+	for i := 0; i < nrStreams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			st, err := client.OpenStream()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			msg := make([]byte, streamSize)
+			_, _ = rand.Read(msg)
+
+			go func() {
+				_, _ = st.Write(msg)
+				st.Close()
+			}()
+			b, err := io.ReadAll(st)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if !bytes.Equal(msg, b) {
+				errCh <- errors.New("echo mismatch")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(err, "stream")
+	}
+
+	require.Eventually(func() bool {
+		return client.NumStreams() == 0 && server.NumStreams() == 0
+	}, 5*time.Second, 10*time.Millisecond, "streams removed once closed")
+
+	rtt, err := client.Ping()
+	require.NoError(err, "Ping")
+	require.Greater(rtt, time.Duration(0), "Ping")
+}
+
+func testMuxHalfClose(t *testing.T) {
+	require := require.New(t)
+
+	client, server, _ := newTestPair(t, 0, nil)
+
+	cst, err := client.OpenStream()
+	require.NoError(err, "OpenStream")
+	require.EqualValues(1, cst.StreamID(), "client stream ID")
+	_, err = cst.Write([]byte("request"))
+	require.NoError(err, "cst.Write")
+	require.NoError(cst.Close(), "cst.Close")
+	_, err = cst.Write([]byte("more"))
+	require.ErrorIs(err, ErrStreamClosed, "cst.Write - closed")
+
+	sst, err := server.AcceptStream()
+	require.NoError(err, "AcceptStream")
+	b, err := io.ReadAll(sst)
+	require.NoError(err, "sst.ReadAll")
+	require.Equal([]byte("request"), b, "sst.ReadAll")
+
+	// The server can still respond after the client closed its half.
+	_, err = sst.Write([]byte("response"))
+	require.NoError(err, "sst.Write")
+	require.NoError(sst.Close(), "sst.Close")
+	b, err = io.ReadAll(cst)
+	require.NoError(err, "cst.ReadAll")
+	require.Equal([]byte("response"), b, "cst.ReadAll")
+
+	// Server opened streams use even IDs.
+	sst, err = server.OpenStream()
+	require.NoError(err, "server.OpenStream")
+	require.EqualValues(2, sst.StreamID(), "server stream ID")
+}
+
+func testMuxFlowControl(t *testing.T) {
+	require := require.New(t)
+
+	const windowSize = 4096
+	client, server, _ := newTestPair(t, 0, &Config{
+		InitialWindowSize: windowSize,
+	})
+
+	cst, err := client.OpenStream()
+	require.NoError(err, "OpenStream")
+	sst, err := server.AcceptStream()
+	require.NoError(err, "AcceptStream")
+
+	// The writer blocks once the window is exhausted.
+	require.NoError(cst.SetWriteDeadline(time.Now().Add(100*time.Millisecond)), "SetWriteDeadline")
+	n, err := cst.Write(make([]byte, 2*windowSize))
+	require.ErrorIs(err, ErrTimeout, "cst.Write - window exhausted")
+	require.Equal(windowSize, n, "cst.Write - window exhausted")
+	var netErr net.Error
+	require.ErrorAs(err, &netErr, "cst.Write - net.Error")
+	require.True(netErr.Timeout(), "cst.Write - Timeout()")
+
+	// Reading returns credit to the writer.
+	b := make([]byte, windowSize)
+	_, err = io.ReadFull(sst, b)
+	require.NoError(err, "sst.Read")
+	require.NoError(cst.SetWriteDeadline(time.Now().Add(5*time.Second)), "SetWriteDeadline")
+	n, err = cst.Write(make([]byte, windowSize))
+	require.NoError(err, "cst.Write - window updated")
+	require.Equal(windowSize, n, "cst.Write - window updated")
+
+	// Read deadlines.
+	require.NoError(cst.SetReadDeadline(time.Now().Add(10*time.Millisecond)), "SetReadDeadline")
+	_, err = cst.Read(b)
+	require.ErrorIs(err, ErrTimeout, "cst.Read - deadline")
+}
+
+func testMuxFraming(t *testing.T) {
+	require := require.New(t)
+
+	const maxMessageSize = 1024
+	client, server, clientConn := newTestPair(t, maxMessageSize, nil)
+
+	marker := bytes.Repeat([]byte("plaintext marker "), 1024)
+	go func() {
+		st, err := client.OpenStream()
+		if err != nil {
+			return
+		}
+		_, _ = st.Write(marker)
+		st.Close()
+	}()
+
+	sst, err := server.AcceptStream()
+	require.NoError(err, "AcceptStream")
+	b, err := io.ReadAll(sst)
+	require.NoError(err, "sst.ReadAll")
+	require.Equal(marker, b, "sst.ReadAll")
+
+	sizes := clientConn.messageSizes(t)
+	require.Greater(len(sizes), len(marker)/maxMessageSize, "data split into multiple messages")
+	for _, size := range sizes {
+		require.LessOrEqual(size, maxMessageSize, "message size")
+	}
+
+	clientConn.l.Lock()
+	defer clientConn.l.Unlock()
+	require.False(bytes.Contains(clientConn.written.Bytes(), []byte("plaintext")), "no plaintext on the wire")
+}
+
+func testMuxReset(t *testing.T) {
+	require := require.New(t)
+
+	client, server, _ := newTestPair(t, 0, nil)
+
+	cst, err := client.OpenStream()
+	require.NoError(err, "OpenStream")
+	sst, err := server.AcceptStream()
+	require.NoError(err, "AcceptStream")
+
+	require.NoError(cst.Reset(), "cst.Reset")
+	_, err = sst.Read(make([]byte, 1))
+	require.ErrorIs(err, ErrStreamReset, "sst.Read - reset")
+	_, err = cst.Write([]byte("data"))
+	require.ErrorIs(err, ErrStreamReset, "cst.Write - reset")
+}
+
+func testMuxBacklog(t *testing.T) {
+	require := require.New(t)
+
+	client, server, _ := newTestPair(t, 0, &Config{
+		AcceptBacklog: 1,
+	})
+
+	st1, err := client.OpenStream()
+	require.NoError(err, "OpenStream(1)")
+	st2, err := client.OpenStream()
+	require.NoError(err, "OpenStream(2)")
+
+	// The second stream exceeds the backlog, and is reset.
+	_, err = st2.Read(make([]byte, 1))
+	require.ErrorIs(err, ErrStreamReset, "st2.Read")
+
+	sst, err := server.AcceptStream()
+	require.NoError(err, "AcceptStream")
+	require.Equal(st1.StreamID(), sst.StreamID(), "AcceptStream")
+}
+
+func testMuxKeepAlive(t *testing.T) {
+	require := require.New(t)
+
+	clientHsCfg, clientStatus, _, _ := handshake(t, 0)
+	clientConn, peerConn := net.Pipe()
+	defer peerConn.Close()
+
+	// The peer reads, but never responds.
+	go func() {
+		_, _ = io.Copy(io.Discard, peerConn)
+	}()
+
+	client, err := New(clientConn, clientStatus, clientHsCfg, &Config{
+		KeepAliveInterval: 20 * time.Millisecond,
+	})
+	require.NoError(err, "New")
+
+	select {
+	case <-client.CloseChan():
+	case <-time.After(5 * time.Second):
+		require.FailNow("keepalive did not time out")
+	}
+	require.ErrorIs(client.Err(), ErrKeepAliveTimeout, "Err")
+
+	_, err = client.OpenStream()
+	require.ErrorIs(err, ErrSessionClosed, "OpenStream - closed")
+	_, err = client.AcceptStream()
+	require.ErrorIs(err, ErrSessionClosed, "AcceptStream - closed")
+}
+
+func testMuxGoAway(t *testing.T) {
+	require := require.New(t)
+
+	client, server, _ := newTestPair(t, 0, nil)
+
+	require.NoError(server.GoAway(), "GoAway")
+	_, err := client.Ping() // Ensure that the GoAway was processed.
+	require.NoError(err, "Ping")
+	_, err = client.OpenStream()
+	require.ErrorIs(err, ErrRemoteGoAway, "OpenStream - remote GoAway")
+
+	// Closing the session closes the peer's session.
+	require.NoError(server.Close(), "Close")
+	select {
+	case <-client.CloseChan():
+	case <-time.After(5 * time.Second):
+		require.FailNow("session did not close")
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mux
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a multiplexed stream, that implements the `net.Conn`
+// interface.
+type Stream struct {
+	id      uint32
+	session *Session
+
+	l             sync.Mutex
+	recvBuf       []byte
+	recvWindow    uint32 // Remaining receive credit granted to the peer.
+	consumed      uint32 // Bytes read since the last window update.
+	sendWindow    uint32 // Remaining send credit granted by the peer.
+	localClosed   bool
+	remoteClosed  bool
+	reset         bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	recvNotify chan struct{}
+	sendNotify chan struct{}
+}
+
+// StreamID returns the stream's ID.
+func (st *Stream) StreamID() uint32 {
+	return st.id
+}
+
+// Session returns the stream's session.
+func (st *Stream) Session() *Session {
+	return st.session
+}
+
+// Read reads data from the stream.
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.l.Lock()
+		if len(st.recvBuf) > 0 {
+			n := copy(p, st.recvBuf)
+			st.recvBuf = st.recvBuf[n:]
+			if len(st.recvBuf) == 0 {
+				st.recvBuf = nil
+			}
+
+			// Return credit to the peer once half the window has been
+			// consumed, to avoid a window update per read.
+			st.consumed += uint32(n)
+			var delta uint32
+			if st.consumed >= st.session.windowSize/2 && !st.remoteClosed && !st.reset {
+				delta, st.consumed = st.consumed, 0
+				st.recvWindow += delta
+			}
+			st.l.Unlock()
+
+			if delta > 0 {
+				st.session.enqueueControl(encodeHeader(typeWindowUpdate, 0, st.id, delta))
+			}
+			return n, nil
+		}
+
+		var err error
+		switch {
+		case st.reset:
+			err = ErrStreamReset
+		case st.remoteClosed:
+			err = io.EOF
+		case st.session.IsClosed():
+			err = ErrSessionClosed
+		}
+		deadline := st.readDeadline
+		st.l.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		if err = st.wait(st.recvNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes data to the stream, blocking while the peer's receive
+// window is exhausted.
+func (st *Stream) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		st.l.Lock()
+		var err error
+		switch {
+		case st.reset:
+			err = ErrStreamReset
+		case st.localClosed:
+			err = ErrStreamClosed
+		case st.session.IsClosed():
+			err = ErrSessionClosed
+		}
+		if err != nil {
+			st.l.Unlock()
+			return written, err
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.l.Unlock()
+			if err = st.wait(st.sendNotify, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		n := len(p)
+		if n > st.session.maxFrameData {
+			n = st.session.maxFrameData
+		}
+		if uint32(n) > st.sendWindow {
+			n = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(n)
+		st.l.Unlock()
+
+		if err = st.session.send(typeData, 0, st.id, uint32(n), p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Close closes the stream for writing, and notifies the peer.  Data
+// sent by the peer may still be read until the peer closes the stream.
+func (st *Stream) Close() error {
+	st.l.Lock()
+	if st.localClosed || st.reset {
+		st.l.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	remove := st.remoteClosed
+	st.l.Unlock()
+
+	if remove {
+		st.session.removeStream(st.id)
+	}
+	st.notifyAll()
+
+	return st.session.send(typeData, flagFIN, st.id, 0, nil)
+}
+
+// Reset aborts the stream in both directions, and notifies the peer.
+func (st *Stream) Reset() error {
+	st.l.Lock()
+	if st.reset {
+		st.l.Unlock()
+		return nil
+	}
+	st.reset = true
+	st.recvBuf = nil
+	st.l.Unlock()
+
+	st.session.removeStream(st.id)
+	st.notifyAll()
+
+	return st.session.send(typeWindowUpdate, flagRST, st.id, 0, nil)
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection.
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.l.Lock()
+	st.readDeadline, st.writeDeadline = t, t
+	st.l.Unlock()
+	st.notifyAll()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.l.Lock()
+	st.readDeadline = t
+	st.l.Unlock()
+	notify(st.recvNotify)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.l.Lock()
+	st.writeDeadline = t
+	st.l.Unlock()
+	notify(st.sendNotify)
+	return nil
+}
+
+func (st *Stream) wait(ch <-chan struct{}, deadline time.Time) error {
+	var timer <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case <-ch:
+	case <-timer:
+		return ErrTimeout
+	case <-st.session.closedCh:
+	}
+	return nil
+}
+
+func (st *Stream) onData(data []byte) error {
+	st.l.Lock()
+	defer st.l.Unlock()
+
+	if uint32(len(data)) > st.recvWindow {
+		return ErrProtocol
+	}
+	st.recvWindow -= uint32(len(data))
+	if len(data) == 0 || st.reset || st.remoteClosed {
+		return nil
+	}
+	st.recvBuf = append(st.recvBuf, data...)
+	notify(st.recvNotify)
+
+	return nil
+}
+
+func (st *Stream) onWindowUpdate(delta uint32) {
+	if delta == 0 {
+		return
+	}
+
+	st.l.Lock()
+	st.sendWindow += delta
+	st.l.Unlock()
+	notify(st.sendNotify)
+}
+
+func (st *Stream) onFIN() {
+	st.l.Lock()
+	st.remoteClosed = true
+	remove := st.localClosed
+	st.l.Unlock()
+
+	if remove {
+		st.session.removeStream(st.id)
+	}
+	notify(st.recvNotify)
+}
+
+func (st *Stream) onRST() {
+	st.l.Lock()
+	st.reset = true
+	st.l.Unlock()
+
+	st.session.removeStream(st.id)
+	st.notifyAll()
+}
+
+func (st *Stream) notifyAll() {
+	notify(st.recvNotify)
+	notify(st.sendNotify)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		session:    s,
+		recvWindow: s.windowSize,
+		sendWindow: s.windowSize,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+	}
+}
@@ -0,0 +1,471 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package noiseconn
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/noisesocket"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+const (
+	maxProtocols       = 32
+	maxProtocolNameLen = math.MaxUint8
+)
+
+var (
+	errHandshakeIncomplete = errors.New("nyquist/noiseconn: handshake not complete")
+	errExporterTooLong     = errors.New("nyquist/noiseconn: exporter label or context too long")
+)
+
+// ConnectionState is the state of a connection.
+type ConnectionState struct {
+	// HandshakeComplete is true iff the handshake has completed.
+	HandshakeComplete bool
+
+	// Protocol is the negotiated protocol name.
+	Protocol string
+
+	// PeerStatic is the peer's static public key, if any.
+	PeerStatic dh.PublicKey
+
+	// HandshakeHash is the handshake hash (`h`), which uniquely
+	// identifies the session.
+	HandshakeHash []byte
+
+	protocol       *nyquist.Protocol
+	exporterSecret []byte
+}
+
+// ExportKeyingMaterial returns length bytes of keying material derived
+// from the session, bound to the label and context.  Both peers derive
+// identical values for the same label and context.
+func (cs *ConnectionState) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	if !cs.HandshakeComplete {
+		return nil, errHandshakeIncomplete
+	}
+	if len(label) > math.MaxUint16 || len(context) > math.MaxUint16 {
+		return nil, errExporterTooLong
+	}
+
+	info := binary.BigEndian.AppendUint16(nil, uint16(len(label)))
+	info = append(info, label...)
+	info = binary.BigEndian.AppendUint16(info, uint16(len(context)))
+	info = append(info, context...)
+
+	out := make([]byte, length)
+	r := hkdf.Expand(cs.protocol.Hash.New, cs.exporterSecret, info)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Conn is a connection, that implements the `net.Conn` interface.  The
+// handshake is performed on the first call to Read or Write, if it has not
+// been performed explicitly with Handshake.
+type Conn struct {
+	conn     net.Conn
+	cfg      *Config
+	isClient bool
+
+	hsDone atomic.Bool
+	hsLock sync.Mutex
+	hsErr  error
+	inner  *noisesocket.Conn
+	state  ConnectionState
+}
+
+// Handshake runs the handshake if it has not yet been run.
+func (c *Conn) Handshake() error {
+	return c.HandshakeContext(context.Background())
+}
+
+// HandshakeContext runs the handshake if it has not yet been run.  If the
+// context is canceled or expires before the handshake completes, the
+// handshake is aborted, and the connection is unusable.
+func (c *Conn) HandshakeContext(ctx context.Context) error {
+	if c.hsDone.Load() {
+		return nil
+	}
+
+	c.hsLock.Lock()
+	defer c.hsLock.Unlock()
+
+	if c.hsErr != nil || c.hsDone.Load() {
+		return c.hsErr
+	}
+
+	if c.cfg.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.HandshakeTimeout)
+		defer cancel()
+	}
+
+	if err := c.handshakeWithContext(ctx); err != nil {
+		if c.inner != nil {
+			_ = c.inner.Close()
+			c.inner, c.state = nil, ConnectionState{}
+		}
+		c.hsErr = err
+		return err
+	}
+	c.hsDone.Store(true)
+
+	return nil
+}
+
+func (c *Conn) handshakeWithContext(ctx context.Context) error {
+	if ctx.Done() == nil {
+		return c.handshake()
+	}
+
+	// Interrupt any blocking I/O if the context is done.
+	doneCh, ctxErrCh := make(chan struct{}), make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetDeadline(time.Unix(1, 0))
+			ctxErrCh <- ctx.Err()
+		case <-doneCh:
+			ctxErrCh <- nil
+		}
+	}()
+
+	err := c.handshake()
+	close(doneCh)
+	if ctxErr := <-ctxErrCh; ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (c *Conn) handshake() error {
+	if err := c.cfg.validate(); err != nil {
+		return err
+	}
+	protocols, err := c.cfg.protocols()
+	if err != nil {
+		return err
+	}
+
+	var (
+		conn     *noisesocket.Conn
+		selected *nyquist.Protocol
+		obs      = &observer{cfg: c.cfg}
+	)
+	if c.isClient {
+		initiatorCfg := &noisesocket.InitiatorConfig{
+			HandshakeConfig: c.handshakeConfig(protocols[0], obs),
+			NegotiationData: encodeProtocols(protocols),
+			OnNegotiation: func(negotiationData []byte, isEmpty bool) (*noisesocket.Negotiation, error) {
+				switch {
+				case !isEmpty:
+					if len(negotiationData) != 0 {
+						return nil, ErrProtocol
+					}
+					selected = protocols[0]
+					return nil, nil
+				case len(negotiationData) == 0:
+					return nil, nil
+				}
+
+				// The server selected a different protocol.
+				if selected = findProtocol(protocols, string(negotiationData)); selected == nil || selected == protocols[0] {
+					return nil, ErrProtocol
+				}
+				return &noisesocket.Negotiation{
+					Decision:        noisesocket.Retry,
+					HandshakeConfig: c.handshakeConfig(selected, obs),
+					NegotiationData: negotiationData,
+				}, nil
+			},
+			RekeyInterval: c.cfg.RekeyInterval,
+		}
+		conn, err = noisesocket.Client(c.conn, initiatorCfg)
+	} else {
+		var negErr error
+		responderCfg := &noisesocket.ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*noisesocket.Negotiation, error) {
+				if selected != nil {
+					// The client's retry must use the selected protocol.
+					if string(negotiationData) != selected.String() {
+						negErr = ErrProtocol
+						return &noisesocket.Negotiation{Decision: noisesocket.Reject}, nil
+					}
+					return &noisesocket.Negotiation{
+						Decision:        noisesocket.Accept,
+						HandshakeConfig: c.handshakeConfig(selected, obs),
+					}, nil
+				}
+
+				offered, err := decodeProtocols(negotiationData)
+				if err != nil {
+					negErr = err
+					return &noisesocket.Negotiation{Decision: noisesocket.Reject}, nil
+				}
+				for _, protocol := range protocols {
+					if slices.Contains(offered, protocol.String()) {
+						selected = protocol
+						break
+					}
+				}
+				switch {
+				case selected == nil:
+					negErr = ErrNoCommonProtocol
+					return &noisesocket.Negotiation{Decision: noisesocket.Reject}, nil
+				case selected.String() == offered[0]:
+					return &noisesocket.Negotiation{
+						Decision:        noisesocket.Accept,
+						HandshakeConfig: c.handshakeConfig(selected, obs),
+					}, nil
+				default:
+					return &noisesocket.Negotiation{
+						Decision:        noisesocket.Retry,
+						NegotiationData: []byte(selected.String()),
+					}, nil
+				}
+			},
+			RekeyInterval: c.cfg.RekeyInterval,
+		}
+		if conn, err = noisesocket.Server(c.conn, responderCfg); err == noisesocket.ErrRejected && negErr != nil {
+			err = negErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	status := conn.HandshakeStatus()
+	if !c.cfg.InsecureSkipVerify {
+		switch {
+		case status.RemoteStatic == nil:
+			err = ErrPeerNotAuthenticated
+		case !obs.verified:
+			// The peer's static key was known in advance.
+			err = c.cfg.verifyPeer(status.RemoteStatic)
+		}
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	c.inner = conn
+	c.state = ConnectionState{
+		HandshakeComplete: true,
+		Protocol:          selected.String(),
+		PeerStatic:        status.RemoteStatic,
+		HandshakeHash:     status.HandshakeHash,
+		protocol:          selected,
+		exporterSecret:    status.ExporterSecret,
+	}
+
+	return nil
+}
+
+func (c *Conn) handshakeConfig(protocol *nyquist.Protocol, obs *observer) *nyquist.HandshakeConfig {
+	hsCfg := &nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		Prologue:    c.cfg.Prologue,
+		LocalStatic: c.cfg.LocalStatic,
+		Rng:         c.cfg.Rng,
+		IsInitiator: c.isClient,
+	}
+	if hasRemoteStaticPreMessage(protocol.Pattern, c.isClient) {
+		hsCfg.RemoteStatic = c.cfg.RemoteStatic
+	}
+	if !c.cfg.InsecureSkipVerify {
+		hsCfg.Observer = obs
+	}
+	return hsCfg
+}
+
+// ConnectionState returns the state of the connection.
+func (c *Conn) ConnectionState() ConnectionState {
+	c.hsLock.Lock()
+	defer c.hsLock.Unlock()
+
+	return c.state
+}
+
+// HandshakeStatus returns the status of the completed handshake, or nil.
+func (c *Conn) HandshakeStatus() *nyquist.HandshakeStatus {
+	if !c.hsDone.Load() {
+		return nil
+	}
+	return c.inner.HandshakeStatus()
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.inner.Read(p)
+}
+
+// Write writes data to the connection.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.inner.Write(p)
+}
+
+// Close closes the connection, and clears the transport CipherStates.
+func (c *Conn) Close() error {
+	// Closing the underlying connection will abort an in-progress
+	// handshake.
+	err := c.conn.Close()
+
+	c.hsLock.Lock()
+	defer c.hsLock.Unlock()
+
+	if c.inner != nil {
+		_ = c.inner.Close()
+	}
+	return err
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// Client returns a new client side connection using conn as the
+// underlying transport.
+func Client(conn net.Conn, cfg *Config) *Conn {
+	return &Conn{
+		conn:     conn,
+		cfg:      cfg,
+		isClient: true,
+	}
+}
+
+// Server returns a new server side connection using conn as the
+// underlying transport.
+func Server(conn net.Conn, cfg *Config) *Conn {
+	return &Conn{
+		conn: conn,
+		cfg:  cfg,
+	}
+}
+
+// observer verifies the peer's static key as soon as it is received.
+type observer struct {
+	cfg      *Config
+	verified bool
+}
+
+func (obs *observer) OnPeerPublicKey(token pattern.Token, publicKey dh.PublicKey) error {
+	if token != pattern.Token_s {
+		return nil
+	}
+	if err := obs.cfg.verifyPeer(publicKey); err != nil {
+		return err
+	}
+	obs.verified = true
+	return nil
+}
+
+func encodeProtocols(protocols []*nyquist.Protocol) []byte {
+	var b []byte
+	for _, protocol := range protocols {
+		name := protocol.String()
+		b = append(b, uint8(len(name)))
+		b = append(b, name...)
+	}
+	return b
+}
+
+func decodeProtocols(b []byte) ([]string, error) {
+	var names []string
+	for len(b) > 0 {
+		l := int(b[0])
+		if l == 0 || len(b) < 1+l || len(names) == maxProtocols {
+			return nil, ErrProtocol
+		}
+		names = append(names, string(b[1:1+l]))
+		b = b[1+l:]
+	}
+	if len(names) == 0 {
+		return nil, ErrProtocol
+	}
+	return names, nil
+}
+
+func findProtocol(protocols []*nyquist.Protocol, name string) *nyquist.Protocol {
+	for _, protocol := range protocols {
+		if protocol.String() == name {
+			return protocol
+		}
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package noiseconn implements a high level connection oriented API in the
+// style of `crypto/tls`, on top of the NoiseSocket encoding.
+//
+// Peers offer and select a protocol from a preference list, authenticate
+// each other's static keys against a set of trusted keys and/or a
+// verification callback, and expose the result of the handshake via
+// `ConnectionState`.
+package noiseconn // import "gitlab.com/yawning/nyquist.git/noiseconn"
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+// DefaultProtocol is the protocol used if none are configured.
+const DefaultProtocol = "Noise_XX_25519_ChaChaPoly_BLAKE2s"
+
+var (
+	// ErrNoCommonProtocol is the error returned by the server when none of
+	// the client's protocols are supported.
+	ErrNoCommonProtocol = errors.New("nyquist/noiseconn: no common protocol")
+
+	// ErrPeerNotTrusted is the error returned when the peer's static key
+	// is not trusted.
+	ErrPeerNotTrusted = errors.New("nyquist/noiseconn: peer static key not trusted")
+
+	// ErrPeerNotAuthenticated is the error returned when the negotiated
+	// protocol does not authenticate the peer's static key, and
+	// verification is required.
+	ErrPeerNotAuthenticated = errors.New("nyquist/noiseconn: peer static key not authenticated")
+
+	// ErrProtocol is the error returned when the peer violates the
+	// negotiation protocol.
+	ErrProtocol = errors.New("nyquist/noiseconn: protocol violation")
+
+	errNoLocalStatic    = errors.New("nyquist/noiseconn: no local static key")
+	errNoVerification   = errors.New("nyquist/noiseconn: no peer verification configured")
+	errInvalidProtocol  = errors.New("nyquist/noiseconn: unsupported protocol")
+	errTooManyProtocols = errors.New("nyquist/noiseconn: too many protocols")
+)
+
+// Config is the connection configuration.  A Config may be reused, and
+// must not be modified after it has been passed to this package.
+type Config struct {
+	// LocalStatic is the local static keypair.  All of the protocols must
+	// use the same DH function as the keypair.
+	LocalStatic dh.Keypair
+
+	// RemoteStatic is the peer's static public key, required for patterns
+	// where it is known in advance (eg: `IK` and `NK` for the client).
+	// If set, the peer must use this key, and it is implicitly trusted.
+	RemoteStatic dh.PublicKey
+
+	// TrustedPeers are the trusted peer static public keys.
+	TrustedPeers []dh.PublicKey
+
+	// VerifyPeer, if non-nil, is called with the peer's static public key
+	// during the handshake, after the key has been checked against
+	// `RemoteStatic` and `TrustedPeers` (if either is set).  Returning a
+	// non-nil error aborts the handshake.
+	VerifyPeer func(publicKey dh.PublicKey) error
+
+	// InsecureSkipVerify disables peer verification, and allows protocols
+	// that do not authenticate the peer's static key.
+	InsecureSkipVerify bool
+
+	// Protocols are the supported protocol names, in order of preference.
+	// The server selects the first of its protocols that the client
+	// offers.  If empty, `DefaultProtocol` is used.  Protocols with
+	// one-way patterns or pre-shared keys are not supported.
+	Protocols []string
+
+	// Prologue is the optional prologue, which must match the peer's.
+	Prologue []byte
+
+	// HandshakeTimeout is the maximum amount of time the handshake may
+	// take, if non-zero.
+	HandshakeTimeout time.Duration
+
+	// RekeyInterval is the number of transport messages after which each
+	// CipherState is rekeyed, if non-zero.  The peer must use the same
+	// interval.
+	RekeyInterval uint64
+
+	// Rng is the entropy source used for the handshake.  If nil,
+	// `crypto/rand.Reader` will be used.
+	Rng io.Reader
+}
+
+func (cfg *Config) protocols() ([]*nyquist.Protocol, error) {
+	names := cfg.Protocols
+	if len(names) == 0 {
+		names = []string{DefaultProtocol}
+	}
+	if len(names) > maxProtocols {
+		return nil, errTooManyProtocols
+	}
+
+	protocols := make([]*nyquist.Protocol, 0, len(names))
+	for _, name := range names {
+		if len(name) == 0 || len(name) > maxProtocolNameLen {
+			return nil, errInvalidProtocol
+		}
+		protocol, err := nyquist.NewProtocol(name)
+		if err != nil {
+			return nil, err
+		}
+		if protocol.Pattern.IsOneWay() || protocol.Pattern.NumPSKs() > 0 {
+			return nil, errInvalidProtocol
+		}
+		protocols = append(protocols, protocol)
+	}
+
+	return protocols, nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.LocalStatic == nil {
+		return errNoLocalStatic
+	}
+	if !cfg.InsecureSkipVerify && cfg.RemoteStatic == nil && len(cfg.TrustedPeers) == 0 && cfg.VerifyPeer == nil {
+		return errNoVerification
+	}
+	return nil
+}
+
+func (cfg *Config) verifyPeer(publicKey dh.PublicKey) error {
+	if cfg.RemoteStatic != nil || len(cfg.TrustedPeers) > 0 {
+		if !cfg.isTrusted(publicKey) {
+			return ErrPeerNotTrusted
+		}
+	}
+	if cfg.VerifyPeer != nil {
+		return cfg.VerifyPeer(publicKey)
+	}
+	return nil
+}
+
+func (cfg *Config) isTrusted(publicKey dh.PublicKey) bool {
+	b := publicKey.Bytes()
+	if cfg.RemoteStatic != nil && bytes.Equal(cfg.RemoteStatic.Bytes(), b) {
+		return true
+	}
+	for _, v := range cfg.TrustedPeers {
+		if bytes.Equal(v.Bytes(), b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial connects to the address on the named network, and performs the
+// handshake as the client.
+func Dial(network, addr string, cfg *Config) (*Conn, error) {
+	rawConn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := Client(rawConn, cfg)
+	if err = conn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+type listener struct {
+	net.Listener
+	cfg *Config
+}
+
+// Accept waits for and returns the next connection.  The handshake is
+// performed lazily, as with `Server`.
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return Server(conn, l.cfg), nil
+}
+
+// NewListener creates a listener that accepts connections from the inner
+// listener, and wraps each with `Server`.
+func NewListener(inner net.Listener, cfg *Config) net.Listener {
+	return &listener{
+		Listener: inner,
+		cfg:      cfg,
+	}
+}
+
+// Listen creates a listener on the local network address, that accepts
+// connections and wraps each with `Server`.
+func Listen(network, laddr string, cfg *Config) (net.Listener, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.protocols(); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(l, cfg), nil
+}
+
+// hasRemoteStaticPreMessage returns true iff the peer's static key is
+// known in advance in the pattern, from the local party's point of view.
+func hasRemoteStaticPreMessage(pa pattern.Pattern, isInitiator bool) bool {
+	preMessages := pa.PreMessages()
+	idx := 1
+	if !isInitiator {
+		idx = 0
+	}
+	if len(preMessages) <= idx {
+		return false
+	}
+	for _, v := range preMessages[idx] {
+		if v == pattern.Token_s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,478 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package noiseconn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/noisesocket"
+)
+
+func mustGenerateKeypair(t *testing.T) dh.Keypair {
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+// runPipe runs the client and server handshakes over an in-memory
+// connection.
+func runPipe(t *testing.T, clientCfg, serverCfg *Config) (*Conn, error, *Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	client, server := Client(clientConn, clientCfg), Server(serverConn, serverCfg)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	ch := make(chan error)
+	go func() {
+		err := server.Handshake()
+		if err != nil {
+			// Unblock the client if it is waiting on a response.
+			serverConn.Close()
+		}
+		ch <- err
+	}()
+
+	clientErr := client.Handshake()
+	if clientErr != nil {
+		clientConn.Close()
+	}
+	serverErr := <-ch
+
+	return client, clientErr, server, serverErr
+}
+
+func TestNoiseConn(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Handshake", testNoiseConnHandshake},
+		{"Negotiation", testNoiseConnNegotiation},
+		{"Verification", testNoiseConnVerification},
+		{"Prologue", testNoiseConnPrologue},
+		{"Rekey", testNoiseConnRekey},
+		{"Timeout", testNoiseConnTimeout},
+		{"Listener", testNoiseConnListener},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testNoiseConnHandshake(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	client, clientErr, server, serverErr := runPipe(
+		t,
+		&Config{
+			LocalStatic:  clientKey,
+			TrustedPeers: []dh.PublicKey{serverKey.Public()},
+		},
+		&Config{
+			LocalStatic:  serverKey,
+			TrustedPeers: []dh.PublicKey{clientKey.Public()},
+		},
+	)
+	require.NoError(clientErr, "client.Handshake")
+	require.NoError(serverErr, "server.Handshake")
+
+	clientState, serverState := client.ConnectionState(), server.ConnectionState()
+	require.True(clientState.HandshakeComplete, "client: HandshakeComplete")
+	require.Equal(DefaultProtocol, clientState.Protocol, "client: Protocol")
+	require.Equal(DefaultProtocol, serverState.Protocol, "server: Protocol")
+	require.Equal(serverKey.Public().Bytes(), clientState.PeerStatic.Bytes(), "client: PeerStatic")
+	require.Equal(clientKey.Public().Bytes(), serverState.PeerStatic.Bytes(), "server: PeerStatic")
+	require.Equal(clientState.HandshakeHash, serverState.HandshakeHash, "HandshakeHash")
+
+	clientEKM, err := clientState.ExportKeyingMaterial("test label", []byte("context"), 64)
+	require.NoError(err, "client: ExportKeyingMaterial")
+	serverEKM, err := serverState.ExportKeyingMaterial("test label", []byte("context"), 64)
+	require.NoError(err, "server: ExportKeyingMaterial")
+	require.Equal(clientEKM, serverEKM, "ExportKeyingMaterial")
+	require.Len(clientEKM, 64, "ExportKeyingMaterial")
+
+	otherEKM, err := clientState.ExportKeyingMaterial("test label", []byte("other context"), 64)
+	require.NoError(err, "ExportKeyingMaterial - other context")
+	require.NotEqual(clientEKM, otherEKM, "ExportKeyingMaterial - other context")
+	otherEKM, err = clientState.ExportKeyingMaterial("other label", []byte("context"), 64)
+	require.NoError(err, "ExportKeyingMaterial - other label")
+	require.NotEqual(clientEKM, otherEKM, "ExportKeyingMaterial - other label")
+
+	exchangeData(t, client, server)
+
+	// Known responder static key (IK).
+	client, clientErr, server, serverErr = runPipe(
+		t,
+		&Config{
+			LocalStatic:  clientKey,
+			RemoteStatic: serverKey.Public(),
+			Protocols:    []string{"Noise_IK_25519_ChaChaPoly_BLAKE2s"},
+		},
+		&Config{
+			LocalStatic:  serverKey,
+			TrustedPeers: []dh.PublicKey{clientKey.Public()},
+			Protocols:    []string{"Noise_IK_25519_ChaChaPoly_BLAKE2s"},
+		},
+	)
+	require.NoError(clientErr, "client.Handshake - IK")
+	require.NoError(serverErr, "server.Handshake - IK")
+	require.Equal(serverKey.Public().Bytes(), client.ConnectionState().PeerStatic.Bytes(), "client: PeerStatic - IK")
+	exchangeData(t, client, server)
+
+	var unstarted ConnectionState
+	_, err = unstarted.ExportKeyingMaterial("test label", nil, 32)
+	require.Error(err, "ExportKeyingMaterial - incomplete")
+}
+
+func exchangeData(t *testing.T, client, server *Conn) {
+	require := require.New(t)
+
+	// Large enough to require multiple transport messages.
+	msg := make([]byte, 200000)
+	_, err := rand.Read(msg)
+	require.NoError(err, "rand.Read")
+
+	errCh := make(chan error)
+	go func() {
+		_, wrErr := client.Write(msg)
+		errCh <- wrErr
+	}()
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(server, b)
+	require.NoError(err, "server.Read")
+	require.NoError(<-errCh, "client.Write")
+	require.Equal(msg, b, "client -> server")
+
+	go func() {
+		_, wrErr := server.Write([]byte("hello"))
+		errCh <- wrErr
+	}()
+	b = make([]byte, 5)
+	_, err = io.ReadFull(client, b)
+	require.NoError(err, "client.Read")
+	require.NoError(<-errCh, "server.Write")
+	require.Equal([]byte("hello"), b, "server -> client")
+}
+
+func testNoiseConnNegotiation(t *testing.T) {
+	require := require.New(t)
+
+	const (
+		protoChaPoly = "Noise_XX_25519_ChaChaPoly_BLAKE2s"
+		protoAESGCM  = "Noise_XX_25519_AESGCM_SHA256"
+		protoNN      = "Noise_NN_25519_ChaChaPoly_BLAKE2s"
+	)
+
+	clientKey, serverKey := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	clientCfg := &Config{
+		LocalStatic:  clientKey,
+		TrustedPeers: []dh.PublicKey{serverKey.Public()},
+		Protocols:    []string{protoChaPoly, protoAESGCM},
+	}
+	serverCfg := &Config{
+		LocalStatic:  serverKey,
+		TrustedPeers: []dh.PublicKey{clientKey.Public()},
+		Protocols:    []string{protoAESGCM, protoChaPoly},
+	}
+
+	// The server's preference wins, requiring a retry.
+	client, clientErr, server, serverErr := runPipe(t, clientCfg, serverCfg)
+	require.NoError(clientErr, "client.Handshake - retry")
+	require.NoError(serverErr, "server.Handshake - retry")
+	require.Equal(protoAESGCM, client.ConnectionState().Protocol, "client: Protocol - retry")
+	require.Equal(protoAESGCM, server.ConnectionState().Protocol, "server: Protocol - retry")
+	exchangeData(t, client, server)
+
+	// No common protocol.
+	serverCfg.Protocols = []string{"Noise_XX_25519_ChaChaPoly_SHA512"}
+	_, clientErr, _, serverErr = runPipe(t, clientCfg, serverCfg)
+	require.ErrorIs(clientErr, noisesocket.ErrRejected, "client.Handshake - no common protocol")
+	require.ErrorIs(serverErr, ErrNoCommonProtocol, "server.Handshake - no common protocol")
+
+	// Unsupported protocols.
+	for _, name := range []string{
+		"Noise_X_25519_ChaChaPoly_BLAKE2s",
+		"Noise_XXpsk3_25519_ChaChaPoly_BLAKE2s",
+		"Noise_XX_25519_ChaChaPoly_Invalid",
+	} {
+		clientCfg.Protocols = []string{name}
+		err := Client(nil, clientCfg).Handshake()
+		require.Error(err, "Handshake - unsupported: %s", name)
+	}
+
+	// Patterns without a peer static key require InsecureSkipVerify.
+	clientCfg.Protocols = []string{protoNN}
+	serverCfg.Protocols = []string{protoNN}
+	_, clientErr, _, _ = runPipe(t, clientCfg, serverCfg)
+	require.ErrorIs(clientErr, ErrPeerNotAuthenticated, "client.Handshake - NN")
+
+	clientCfg.InsecureSkipVerify = true
+	serverCfg.InsecureSkipVerify = true
+	client, clientErr, server, serverErr = runPipe(t, clientCfg, serverCfg)
+	require.NoError(clientErr, "client.Handshake - NN, InsecureSkipVerify")
+	require.NoError(serverErr, "server.Handshake - NN, InsecureSkipVerify")
+	require.Nil(client.ConnectionState().PeerStatic, "client: PeerStatic - NN")
+	exchangeData(t, client, server)
+}
+
+func testNoiseConnVerification(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey, otherKey := mustGenerateKeypair(t), mustGenerateKeypair(t), mustGenerateKeypair(t)
+
+	// No verification configured.
+	err := Client(nil, &Config{LocalStatic: clientKey}).Handshake()
+	require.Error(err, "Handshake - no verification")
+
+	// Untrusted server.
+	_, clientErr, _, serverErr := runPipe(
+		t,
+		&Config{
+			LocalStatic:  clientKey,
+			TrustedPeers: []dh.PublicKey{otherKey.Public()},
+		},
+		&Config{
+			LocalStatic:        serverKey,
+			InsecureSkipVerify: true,
+		},
+	)
+	require.ErrorIs(clientErr, ErrPeerNotTrusted, "client.Handshake - untrusted server")
+	require.Error(serverErr, "server.Handshake - untrusted server")
+
+	// Untrusted client.  With `XX`, the client completes the handshake
+	// before the server sees the client's static key.
+	_, _, _, serverErr = runPipe(
+		t,
+		&Config{
+			LocalStatic:  clientKey,
+			RemoteStatic: serverKey.Public(),
+		},
+		&Config{
+			LocalStatic:  serverKey,
+			TrustedPeers: []dh.PublicKey{otherKey.Public()},
+		},
+	)
+	require.ErrorIs(serverErr, ErrPeerNotTrusted, "server.Handshake - untrusted client")
+
+	// Verification callback.
+	errCallback := errors.New("rejected by callback")
+	var seen []byte
+	client, clientErr, server, serverErr := runPipe(
+		t,
+		&Config{
+			LocalStatic: clientKey,
+			VerifyPeer: func(publicKey dh.PublicKey) error {
+				seen = publicKey.Bytes()
+				return nil
+			},
+		},
+		&Config{
+			LocalStatic:        serverKey,
+			InsecureSkipVerify: true,
+		},
+	)
+	require.NoError(clientErr, "client.Handshake - callback")
+	require.NoError(serverErr, "server.Handshake - callback")
+	require.Equal(serverKey.Public().Bytes(), seen, "VerifyPeer - public key")
+	exchangeData(t, client, server)
+
+	_, clientErr, _, _ = runPipe(
+		t,
+		&Config{
+			LocalStatic:  clientKey,
+			TrustedPeers: []dh.PublicKey{serverKey.Public()},
+			VerifyPeer: func(dh.PublicKey) error {
+				return errCallback
+			},
+		},
+		&Config{
+			LocalStatic:        serverKey,
+			InsecureSkipVerify: true,
+		},
+	)
+	require.ErrorIs(clientErr, errCallback, "client.Handshake - callback rejects")
+}
+
+func testNoiseConnPrologue(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	clientCfg := &Config{
+		LocalStatic:  clientKey,
+		TrustedPeers: []dh.PublicKey{serverKey.Public()},
+		Prologue:     []byte("prologue"),
+	}
+	serverCfg := &Config{
+		LocalStatic:  serverKey,
+		TrustedPeers: []dh.PublicKey{clientKey.Public()},
+		Prologue:     []byte("prologue"),
+	}
+
+	_, clientErr, _, serverErr := runPipe(t, clientCfg, serverCfg)
+	require.NoError(clientErr, "client.Handshake")
+	require.NoError(serverErr, "server.Handshake")
+
+	serverCfg.Prologue = []byte("other prologue")
+	_, clientErr, _, _ = runPipe(t, clientCfg, serverCfg)
+	require.Error(clientErr, "client.Handshake - prologue mismatch")
+}
+
+func testNoiseConnRekey(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	clientCfg := &Config{
+		LocalStatic:   clientKey,
+		TrustedPeers:  []dh.PublicKey{serverKey.Public()},
+		RekeyInterval: 2,
+	}
+	serverCfg := &Config{
+		LocalStatic:   serverKey,
+		TrustedPeers:  []dh.PublicKey{clientKey.Public()},
+		RekeyInterval: 2,
+	}
+
+	client, clientErr, server, serverErr := runPipe(t, clientCfg, serverCfg)
+	require.NoError(clientErr, "client.Handshake")
+	require.NoError(serverErr, "server.Handshake")
+	exchangeData(t, client, server)
+
+	// Mismatched rekey intervals break the connection.
+	serverCfg.RekeyInterval = 0
+	client, clientErr, server, serverErr = runPipe(t, clientCfg, serverCfg)
+	require.NoError(clientErr, "client.Handshake - mismatch")
+	require.NoError(serverErr, "server.Handshake - mismatch")
+
+	go func() {
+		_, _ = client.Write(make([]byte, 200000))
+	}()
+	_, err := io.ReadFull(server, make([]byte, 200000))
+	require.Error(err, "server.Read - mismatch")
+}
+
+func testNoiseConnTimeout(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	clientConn, peerConn := net.Pipe()
+	defer peerConn.Close()
+
+	// The peer reads, but never responds.
+	go func() {
+		_, _ = io.Copy(io.Discard, peerConn)
+	}()
+
+	client := Client(clientConn, &Config{
+		LocalStatic:      clientKey,
+		TrustedPeers:     []dh.PublicKey{serverKey.Public()},
+		HandshakeTimeout: 50 * time.Millisecond,
+	})
+	defer client.Close()
+
+	err := client.Handshake()
+	require.ErrorIs(err, context.DeadlineExceeded, "Handshake - timeout")
+	_, err = client.Write([]byte("data"))
+	require.ErrorIs(err, context.DeadlineExceeded, "Write - after failed handshake")
+	require.False(client.ConnectionState().HandshakeComplete, "HandshakeComplete")
+
+	// Canceled context.
+	clientConn2, peerConn2 := net.Pipe()
+	defer peerConn2.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, peerConn2)
+	}()
+	client = Client(clientConn2, &Config{
+		LocalStatic:  clientKey,
+		TrustedPeers: []dh.PublicKey{serverKey.Public()},
+	})
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = client.HandshakeContext(ctx)
+	require.ErrorIs(err, context.Canceled, "HandshakeContext - canceled")
+}
+
+func testNoiseConnListener(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey := mustGenerateKeypair(t), mustGenerateKeypair(t)
+	l, err := Listen("tcp", "127.0.0.1:0", &Config{
+		LocalStatic:  serverKey,
+		TrustedPeers: []dh.PublicKey{clientKey.Public()},
+	})
+	require.NoError(err, "Listen")
+	defer l.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+
+		// The handshake is performed lazily.
+		_, err = io.Copy(conn, conn)
+		errCh <- err
+	}()
+
+	conn, err := Dial("tcp", l.Addr().String(), &Config{
+		LocalStatic:  clientKey,
+		TrustedPeers: []dh.PublicKey{serverKey.Public()},
+	})
+	require.NoError(err, "Dial")
+	defer conn.Close()
+	require.True(conn.ConnectionState().HandshakeComplete, "Dial - HandshakeComplete")
+
+	msg := []byte("hello world")
+	_, err = conn.Write(msg)
+	require.NoError(err, "Write")
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(err, "Read")
+	require.True(bytes.Equal(msg, b), "echo")
+
+	conn.Close()
+	require.NoError(<-errCh, "server")
+}
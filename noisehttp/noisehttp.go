@@ -0,0 +1,129 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package noisehttp provides `net/http` integration for serving and
+// requesting HTTP/1.1 over connections secured by the noiseconn package,
+// without TLS certificates.
+//
+// Requests must use `http` URLs, as the Noise handshake takes the place of
+// TLS.
+package noisehttp // import "gitlab.com/yawning/nyquist.git/noisehttp"
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"gitlab.com/yawning/nyquist.git/noiseconn"
+)
+
+type connContextKey struct{}
+
+// Dialer dials connections, and performs the handshake as the client.
+type Dialer struct {
+	// Config is the connection configuration, which must specify how the
+	// server's static key is verified (eg: `RemoteStatic` or
+	// `TrustedPeers`).
+	Config *noiseconn.Config
+
+	// NetDial is used to establish the underlying connections.  If nil,
+	// a zero `net.Dialer` will be used.
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// DialContext connects to the address on the named network, and performs
+// the handshake.  It is suitable for use as `http.Transport.DialContext`.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	netDial := d.NetDial
+	if netDial == nil {
+		var nd net.Dialer
+		netDial = nd.DialContext
+	}
+
+	rawConn, err := netDial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := noiseconn.Client(rawConn, d.Config)
+	if err = conn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// NewTransport returns a new `http.Transport`, based on
+// `http.DefaultTransport`, that dials connections with the Dialer.
+// Proxies are not supported.
+func NewTransport(d *Dialer) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = d.DialContext
+	t.DialTLSContext = nil
+	t.TLSClientConfig = nil
+	t.ForceAttemptHTTP2 = false
+	return t
+}
+
+// ConnContext associates the connection with the context, so that
+// `ConnectionStateFromContext` can be used by handlers.  It is suitable
+// for use as `http.Server.ConnContext`.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if conn, ok := c.(*noiseconn.Conn); ok {
+		return context.WithValue(ctx, connContextKey{}, conn)
+	}
+	return ctx
+}
+
+// ConnectionStateFromContext returns the state of the connection that the
+// request was received over, including the client's authenticated static
+// key and the handshake hash.
+func ConnectionStateFromContext(ctx context.Context) (noiseconn.ConnectionState, bool) {
+	conn, ok := ctx.Value(connContextKey{}).(*noiseconn.Conn)
+	if !ok {
+		return noiseconn.ConnectionState{}, false
+	}
+
+	// The handshake is complete by the time a request has been read.
+	state := conn.ConnectionState()
+	return state, state.HandshakeComplete
+}
+
+// Serve accepts connections on the listener, performs the handshake as
+// the server, and serves HTTP requests with the handler.  It always
+// returns a non-nil error, and closes the listener.
+func Serve(l net.Listener, cfg *noiseconn.Config, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:     handler,
+		ConnContext: ConnContext,
+	}
+	return srv.Serve(noiseconn.NewListener(l, cfg))
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package noisehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/noiseconn"
+)
+
+// memListener is an in-memory listener.
+type memListener struct {
+	connCh    chan net.Conn
+	closeOnce sync.Once
+	closedCh  chan struct{}
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closedCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closedCh)
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return memAddr{}
+}
+
+func (l *memListener) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	select {
+	case l.connCh <- serverConn:
+		return clientConn, nil
+	case <-l.closedCh:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type memAddr struct{}
+
+func (memAddr) Network() string { return "memory" }
+func (memAddr) String() string  { return "memory" }
+
+func newMemListener() *memListener {
+	return &memListener{
+		connCh:   make(chan net.Conn),
+		closedCh: make(chan struct{}),
+	}
+}
+
+func mustGenerateKeypair(t *testing.T) dh.Keypair {
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+func TestNoiseHTTP(t *testing.T) {
+	require := require.New(t)
+
+	clientKey, serverKey, otherKey := mustGenerateKeypair(t), mustGenerateKeypair(t), mustGenerateKeypair(t)
+
+	l := newMemListener()
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- Serve(
+			l,
+			&noiseconn.Config{
+				LocalStatic:  serverKey,
+				TrustedPeers: []dh.PublicKey{clientKey.Public()},
+			},
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				state, ok := ConnectionStateFromContext(r.Context())
+				if !ok {
+					http.Error(w, "no connection state", http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintf(w, "%x %x", state.PeerStatic.Bytes(), state.HandshakeHash)
+			}),
+		)
+	}()
+
+	newClient := func(localStatic dh.Keypair, remoteStatic dh.PublicKey) *http.Client {
+		return &http.Client{
+			Transport: NewTransport(&Dialer{
+				Config: &noiseconn.Config{
+					LocalStatic:  localStatic,
+					RemoteStatic: remoteStatic,
+				},
+				NetDial: l.DialContext,
+			}),
+		}
+	}
+
+	get := func(client *http.Client) (string, error) {
+		resp, err := client.Get("http://server.example/")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return string(b), nil
+	}
+
+	client := newClient(clientKey, serverKey.Public())
+	body, err := get(client)
+	require.NoError(err, "Get")
+
+	var peer, handshakeHash string
+	_, err = fmt.Sscanf(body, "%s %s", &peer, &handshakeHash)
+	require.NoError(err, "Sscanf")
+	require.Equal(hex.EncodeToString(clientKey.Public().Bytes()), peer, "handler: PeerStatic")
+	require.NotEmpty(handshakeHash, "handler: HandshakeHash")
+
+	// Each connection has a distinct handshake.
+	client.CloseIdleConnections()
+	body2, err := get(client)
+	require.NoError(err, "Get - new connection")
+	require.NotEqual(body, body2, "Get - new connection")
+
+	// Untrusted client.
+	_, err = get(newClient(otherKey, serverKey.Public()))
+	require.Error(err, "Get - untrusted client")
+
+	// Unexpected server key.
+	_, err = get(newClient(clientKey, otherKey.Public()))
+	require.Error(err, "Get - unexpected server")
+
+	// Connection state is absent outside of Noise connections.
+	_, ok := ConnectionStateFromContext(context.Background())
+	require.False(ok, "ConnectionStateFromContext - background")
+
+	l.Close()
+	require.ErrorIs(<-serveErrCh, net.ErrClosed, "Serve")
+}
@@ -0,0 +1,230 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package noisesocket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+var errBodyLength = errors.New("nyquist/noisesocket: invalid body length")
+
+// Conn is a NoiseSocket connection, that implements the `net.Conn`
+// interface.
+//
+// Transport message plaintexts consist of a 16-bit big-endian body length,
+// the body, and padding.
+type Conn struct {
+	net.Conn
+
+	status *nyquist.HandshakeStatus
+
+	rdLock sync.Mutex
+	rx     *nyquist.CipherState
+	rxMsgs uint64
+	rdBuf  []byte
+	rdErr  error
+
+	wrLock           sync.Mutex
+	tx               *nyquist.CipherState
+	txMsgs           uint64
+	maxBodySize      int
+	paddingBlockSize int
+
+	rekeyInterval uint64
+}
+
+// HandshakeStatus returns the status of the completed handshake.
+func (c *Conn) HandshakeStatus() *nyquist.HandshakeStatus {
+	return c.status
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rdLock.Lock()
+	defer c.rdLock.Unlock()
+
+	for len(c.rdBuf) == 0 {
+		if c.rdErr != nil {
+			return 0, c.rdErr
+		}
+		if c.rx == nil {
+			return 0, io.EOF
+		}
+		c.rdBuf, c.rdErr = c.readBody()
+	}
+
+	n := copy(p, c.rdBuf)
+	c.rdBuf = c.rdBuf[n:]
+
+	return n, nil
+}
+
+func (c *Conn) readBody() ([]byte, error) {
+	ciphertext, err := readField(c.Conn)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.rx.DecryptWithAd(nil, nil, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.maybeRekey(c.rx, &c.rxMsgs); err != nil {
+		return nil, err
+	}
+	if len(plaintext) < 2 {
+		return nil, errBodyLength
+	}
+	bodyLen := int(binary.BigEndian.Uint16(plaintext))
+	if bodyLen > len(plaintext)-2 {
+		return nil, errBodyLength
+	}
+
+	// Padding is ignored.
+	return plaintext[2 : 2+bodyLen], nil
+}
+
+// Write writes data to the connection.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wrLock.Lock()
+	defer c.wrLock.Unlock()
+
+	if c.tx == nil {
+		return 0, net.ErrClosed
+	}
+
+	var n int
+	for len(p) > 0 {
+		body := p
+		if len(body) > c.maxBodySize {
+			body = body[:c.maxBodySize]
+		}
+		if err := c.writeBody(body); err != nil {
+			return n, err
+		}
+		n += len(body)
+		p = p[len(body):]
+	}
+
+	return n, nil
+}
+
+func (c *Conn) writeBody(body []byte) error {
+	plaintextLen := 2 + len(body)
+	if c.paddingBlockSize > 0 {
+		if rem := plaintextLen % c.paddingBlockSize; rem != 0 {
+			plaintextLen += c.paddingBlockSize - rem
+		}
+		if maxLen := c.maxBodySize + 2; plaintextLen > maxLen {
+			plaintextLen = maxLen
+		}
+	}
+
+	plaintext := make([]byte, plaintextLen)
+	binary.BigEndian.PutUint16(plaintext, uint16(len(body)))
+	copy(plaintext[2:], body)
+
+	ciphertext, err := c.tx.EncryptWithAd(nil, nil, plaintext)
+	if err != nil {
+		return err
+	}
+	if err = c.maybeRekey(c.tx, &c.txMsgs); err != nil {
+		return err
+	}
+
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(ciphertext)), uint16(len(ciphertext)))
+	_, err = c.Conn.Write(append(frame, ciphertext...))
+	return err
+}
+
+func (c *Conn) maybeRekey(cs *nyquist.CipherState, nrMsgs *uint64) error {
+	*nrMsgs++
+	if c.rekeyInterval == 0 || *nrMsgs%c.rekeyInterval != 0 {
+		return nil
+	}
+	return cs.Rekey()
+}
+
+// Close closes the connection, and clears the transport CipherStates.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+
+	c.wrLock.Lock()
+	if c.tx != nil {
+		c.tx.Reset()
+		c.tx = nil
+	}
+	c.wrLock.Unlock()
+
+	c.rdLock.Lock()
+	if c.rx != nil {
+		c.rx.Reset()
+		c.rx = nil
+	}
+	c.rdBuf = nil
+	c.rdLock.Unlock()
+
+	return err
+}
+
+func newConn(conn net.Conn, hs *nyquist.HandshakeState, cfg *nyquist.HandshakeConfig, paddingBlockSize int, rekeyInterval uint64) (*Conn, error) {
+	status := hs.GetStatus()
+	if status.Err != nyquist.ErrDone {
+		return nil, ErrProtocol
+	}
+
+	aead, err := cfg.Protocol.Cipher.New(make([]byte, nyquist.SymmetricKeySize))
+	if err != nil {
+		return nil, err
+	}
+	aeadOverhead := aead.Overhead()
+
+	c := &Conn{
+		Conn:             conn,
+		status:           status,
+		maxBodySize:      nyquist.DefaultMaxMessageSize - aeadOverhead - 2,
+		paddingBlockSize: paddingBlockSize,
+		rekeyInterval:    rekeyInterval,
+	}
+
+	cs1, cs2 := status.CipherStates[0], status.CipherStates[1]
+	if cfg.IsInitiator {
+		c.tx, c.rx = cs1, cs2
+	} else {
+		c.tx, c.rx = cs2, cs1
+	}
+
+	return c, nil
+}
@@ -0,0 +1,504 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package noisesocket implements the NoiseSocket protocol, an encoding layer
+// for Noise handshake and transport messages over reliable stream
+// transports, with support for protocol negotiation.
+//
+// The prologue of each handshake is the NoiseSocket prologue, followed by
+// the `Prologue` of the handshake configuration, if any.  With no
+// `Prologue`, this is identical to the specification.  Earlier versions of
+// this package ignored the `Prologue`, so peers that set one will not
+// interoperate with them.
+//
+// As an extension to the specification, each CipherState may be rekeyed
+// after a fixed number of transport messages (`RekeyInterval`).  This is
+// disabled by default, and if enabled, must be enabled with the same
+// interval on both peers.
+package noisesocket // import "gitlab.com/yawning/nyquist.git/noisesocket"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+const (
+	prologueInit1 = "NoiseSocketInit1"
+	prologueInit2 = "NoiseSocketInit2"
+	prologueInit3 = "NoiseSocketInit3"
+
+	maxFieldLen = math.MaxUint16
+)
+
+var (
+	// ErrRejected is the error returned when the responder rejects the
+	// handshake.
+	ErrRejected = errors.New("nyquist/noisesocket: handshake rejected")
+
+	// ErrProtocol is the error returned when the peer violates the
+	// NoiseSocket protocol.
+	ErrProtocol = errors.New("nyquist/noisesocket: protocol violation")
+
+	errFieldTooLong   = errors.New("nyquist/noisesocket: field too long")
+	errNoNegotiation  = errors.New("nyquist/noisesocket: no negotiation callback")
+	errInvalidDecison = errors.New("nyquist/noisesocket: invalid negotiation decision")
+	errInvalidRole    = errors.New("nyquist/noisesocket: invalid role for negotiation decision")
+)
+
+// Decision is a negotiation decision.
+type Decision int
+
+const (
+	// Accept continues the handshake with the initiator's protocol.
+	Accept Decision = iota
+
+	// Switch abandons the initiator's handshake, and starts a new handshake
+	// with a different protocol, with the roles reversed.
+	Switch
+
+	// Retry requests that the initiator restart the handshake with a
+	// different protocol.
+	Retry
+
+	// Reject aborts the handshake.
+	Reject
+)
+
+// String returns the string representation of a Decision.
+func (d Decision) String() string {
+	switch d {
+	case Accept:
+		return "Accept"
+	case Switch:
+		return "Switch"
+	case Retry:
+		return "Retry"
+	case Reject:
+		return "Reject"
+	default:
+		return "[invalid decision]"
+	}
+}
+
+// Negotiation is the result of a negotiation callback.
+type Negotiation struct {
+	// Decision is the negotiation decision.
+	Decision Decision
+
+	// HandshakeConfig is the handshake configuration to use going forward,
+	// required for the responder's `Accept` and `Switch`, and the
+	// initiator's `Switch` and `Retry`.  The `Prologue` is appended to
+	// the NoiseSocket prologue, and `IsInitiator` must match the role in
+	// the new handshake.
+	HandshakeConfig *nyquist.HandshakeConfig
+
+	// NegotiationData is the negotiation data to send to the peer.  It is
+	// used by the responder for all decisions, and by the initiator for
+	// `Retry`.
+	NegotiationData []byte
+}
+
+// InitiatorConfig is the initiator (client) configuration.
+type InitiatorConfig struct {
+	// HandshakeConfig is the initial handshake configuration.  The
+	// `Prologue` is appended to the NoiseSocket prologue.
+	HandshakeConfig *nyquist.HandshakeConfig
+
+	// NegotiationData is the negotiation data sent with the initial
+	// handshake message.
+	NegotiationData []byte
+
+	// OnNegotiation is called with the responder's negotiation data, and
+	// if the responder's message is empty (`Retry` or `Reject`), and
+	// returns how to proceed.  Initiators must be able to distinguish all
+	// of the responder's decisions from the negotiation data.
+	//
+	// A nil Negotiation is equivalent to `Accept`, if the message is not
+	// empty, and `Reject` otherwise.
+	OnNegotiation func(negotiationData []byte, isEmpty bool) (*Negotiation, error)
+
+	// PaddingBlockSize is the block size that transport message bodies
+	// are padded to a multiple of, if non-zero.
+	PaddingBlockSize int
+
+	// RekeyInterval is the number of transport messages after which each
+	// CipherState is rekeyed, if non-zero.  This is not part of the
+	// NoiseSocket specification, and both peers must use the same
+	// interval.
+	RekeyInterval uint64
+}
+
+// ResponderConfig is the responder (server) configuration.
+type ResponderConfig struct {
+	// OnNegotiation is called with the initiator's negotiation data, and
+	// returns how to proceed.  If the initiator retries, it will be called
+	// a second time with the retry negotiation data, where only `Accept`
+	// and `Reject` are allowed.
+	//
+	// A nil Negotiation is equivalent to `Reject`.
+	OnNegotiation func(negotiationData []byte) (*Negotiation, error)
+
+	// PaddingBlockSize is the block size that transport message bodies
+	// are padded to a multiple of, if non-zero.
+	PaddingBlockSize int
+
+	// RekeyInterval is the number of transport messages after which each
+	// CipherState is rekeyed, if non-zero.  This is not part of the
+	// NoiseSocket specification, and both peers must use the same
+	// interval.
+	RekeyInterval uint64
+}
+
+// Client performs a NoiseSocket handshake as the initiator over conn.  On
+// failure, it is the caller's responsibility to close conn.
+func Client(conn net.Conn, cfg *InitiatorConfig) (*Conn, error) {
+	if cfg.HandshakeConfig == nil || !cfg.HandshakeConfig.IsInitiator {
+		return nil, errInvalidRole
+	}
+
+	initNeg, hsCfg := cfg.NegotiationData, cfg.HandshakeConfig
+	hs, initMsg, err := startHandshake(hsCfg, prologue(prologueInit1, initNeg))
+	if err != nil {
+		return nil, err
+	}
+	if err = writeHandshakeFrame(conn, initNeg, initMsg); err != nil {
+		return nil, err
+	}
+	if isDone(hs) {
+		return newConn(conn, hs, hsCfg, cfg.PaddingBlockSize, cfg.RekeyInterval)
+	}
+
+	respNeg, respMsg, err := readHandshakeFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Negotiation{Decision: Accept}
+	if cfg.OnNegotiation != nil {
+		var cbN *Negotiation
+		if cbN, err = cfg.OnNegotiation(respNeg, len(respMsg) == 0); err != nil {
+			return nil, err
+		}
+		if cbN != nil {
+			n = cbN
+		}
+	}
+	if len(respMsg) == 0 && n.Decision != Retry {
+		n.Decision = Reject
+	}
+
+	switch n.Decision {
+	case Accept:
+		if _, err = hs.ReadMessage(nil, respMsg); err != nil && err != nyquist.ErrDone {
+			return nil, err
+		}
+	case Switch:
+		if n.HandshakeConfig == nil || n.HandshakeConfig.IsInitiator {
+			return nil, errInvalidRole
+		}
+		hs.Reset()
+		hsCfg = n.HandshakeConfig
+		p := prologue(prologueInit2, initNeg, initMsg, respNeg)
+		if hs, err = newHandshake(hsCfg, p); err != nil {
+			return nil, err
+		}
+		if _, err = hs.ReadMessage(nil, respMsg); err != nil && err != nyquist.ErrDone {
+			return nil, err
+		}
+	case Retry:
+		if len(respMsg) != 0 {
+			return nil, ErrProtocol
+		}
+		if n.HandshakeConfig == nil || !n.HandshakeConfig.IsInitiator {
+			return nil, errInvalidRole
+		}
+		hs.Reset()
+		var retryNeg []byte
+		retryNeg, hsCfg = n.NegotiationData, n.HandshakeConfig
+		p := prologue(prologueInit3, initNeg, initMsg, respNeg, retryNeg)
+		if hs, initMsg, err = startHandshake(hsCfg, p); err != nil {
+			return nil, err
+		}
+		if err = writeHandshakeFrame(conn, retryNeg, initMsg); err != nil {
+			return nil, err
+		}
+		if isDone(hs) {
+			return newConn(conn, hs, hsCfg, cfg.PaddingBlockSize, cfg.RekeyInterval)
+		}
+		if respNeg, respMsg, err = readHandshakeFrame(conn); err != nil {
+			return nil, err
+		}
+		if len(respMsg) == 0 {
+			return nil, ErrRejected
+		}
+		if len(respNeg) != 0 {
+			return nil, ErrProtocol
+		}
+		if _, err = hs.ReadMessage(nil, respMsg); err != nil && err != nyquist.ErrDone {
+			return nil, err
+		}
+	case Reject:
+		hs.Reset()
+		return nil, ErrRejected
+	default:
+		hs.Reset()
+		return nil, errInvalidDecison
+	}
+
+	if err = finishHandshake(conn, hs, true); err != nil {
+		return nil, err
+	}
+
+	return newConn(conn, hs, hsCfg, cfg.PaddingBlockSize, cfg.RekeyInterval)
+}
+
+// Server performs a NoiseSocket handshake as the responder over conn.  On
+// failure, it is the caller's responsibility to close conn.
+func Server(conn net.Conn, cfg *ResponderConfig) (*Conn, error) {
+	if cfg.OnNegotiation == nil {
+		return nil, errNoNegotiation
+	}
+
+	initNeg, initMsg, err := readHandshakeFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(initMsg) == 0 {
+		return nil, ErrProtocol
+	}
+
+	n, err := cfg.OnNegotiation(initNeg)
+	if err != nil {
+		return nil, err
+	}
+	if n == nil {
+		n = &Negotiation{Decision: Reject}
+	}
+
+	var hs *nyquist.HandshakeState
+	switch n.Decision {
+	case Accept:
+		if hs, err = acceptHandshake(conn, n, initMsg, prologue(prologueInit1, initNeg)); err != nil {
+			return nil, err
+		}
+	case Switch:
+		if n.HandshakeConfig == nil || !n.HandshakeConfig.IsInitiator {
+			return nil, errInvalidRole
+		}
+		p := prologue(prologueInit2, initNeg, initMsg, n.NegotiationData)
+		var msg []byte
+		if hs, msg, err = startHandshake(n.HandshakeConfig, p); err != nil {
+			return nil, err
+		}
+		if err = writeHandshakeFrame(conn, n.NegotiationData, msg); err != nil {
+			return nil, err
+		}
+	case Retry:
+		respNeg := n.NegotiationData
+		if err = writeHandshakeFrame(conn, respNeg, nil); err != nil {
+			return nil, err
+		}
+		retryNeg, retryMsg, err := readHandshakeFrame(conn)
+		if err != nil {
+			return nil, err
+		}
+		if len(retryMsg) == 0 {
+			return nil, ErrProtocol
+		}
+		if n, err = cfg.OnNegotiation(retryNeg); err != nil {
+			return nil, err
+		}
+		if n == nil {
+			n = &Negotiation{Decision: Reject}
+		}
+		switch n.Decision {
+		case Accept:
+		case Reject:
+			_ = writeHandshakeFrame(conn, nil, nil)
+			return nil, ErrRejected
+		default:
+			return nil, errInvalidDecison
+		}
+		// Only the initial negotiation may include responder negotiation
+		// data.
+		n = &Negotiation{
+			Decision:        Accept,
+			HandshakeConfig: n.HandshakeConfig,
+		}
+		p := prologue(prologueInit3, initNeg, initMsg, respNeg, retryNeg)
+		if hs, err = acceptHandshake(conn, n, retryMsg, p); err != nil {
+			return nil, err
+		}
+	case Reject:
+		_ = writeHandshakeFrame(conn, n.NegotiationData, nil)
+		return nil, ErrRejected
+	default:
+		return nil, errInvalidDecison
+	}
+
+	if err = finishHandshake(conn, hs, false); err != nil {
+		return nil, err
+	}
+
+	return newConn(conn, hs, n.HandshakeConfig, cfg.PaddingBlockSize, cfg.RekeyInterval)
+}
+
+func acceptHandshake(conn net.Conn, n *Negotiation, initMsg, p []byte) (*nyquist.HandshakeState, error) {
+	if n.HandshakeConfig == nil || n.HandshakeConfig.IsInitiator {
+		return nil, errInvalidRole
+	}
+	hs, err := newHandshake(n.HandshakeConfig, p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = hs.ReadMessage(nil, initMsg); err != nil {
+		if err == nyquist.ErrDone {
+			return hs, nil
+		}
+		return nil, err
+	}
+
+	msg, err := hs.WriteMessage(nil, nil)
+	if err != nil && err != nyquist.ErrDone {
+		return nil, err
+	}
+	if err = writeHandshakeFrame(conn, n.NegotiationData, msg); err != nil {
+		return nil, err
+	}
+
+	return hs, nil
+}
+
+func newHandshake(cfg *nyquist.HandshakeConfig, p []byte) (*nyquist.HandshakeState, error) {
+	hsCfg := *cfg
+	hsCfg.Prologue = make([]byte, 0, len(p)+len(cfg.Prologue))
+	hsCfg.Prologue = append(hsCfg.Prologue, p...)
+	hsCfg.Prologue = append(hsCfg.Prologue, cfg.Prologue...)
+	return nyquist.NewHandshake(&hsCfg)
+}
+
+func startHandshake(cfg *nyquist.HandshakeConfig, p []byte) (*nyquist.HandshakeState, []byte, error) {
+	hs, err := newHandshake(cfg, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := hs.WriteMessage(nil, nil)
+	if err != nil && err != nyquist.ErrDone {
+		return nil, nil, err
+	}
+	return hs, msg, nil
+}
+
+// finishHandshake runs the remainder of the handshake, where the
+// negotiation data is always empty.  After the negotiation, the party that
+// was originally the initiator is always the next to write.
+func finishHandshake(conn net.Conn, hs *nyquist.HandshakeState, isWrite bool) error {
+	for !isDone(hs) {
+		if isWrite {
+			msg, err := hs.WriteMessage(nil, nil)
+			if err != nil && err != nyquist.ErrDone {
+				return err
+			}
+			if err = writeHandshakeFrame(conn, nil, msg); err != nil {
+				return err
+			}
+		} else {
+			neg, msg, err := readHandshakeFrame(conn)
+			if err != nil {
+				return err
+			}
+			if len(neg) != 0 || len(msg) == 0 {
+				hs.Reset()
+				return ErrProtocol
+			}
+			if _, err = hs.ReadMessage(nil, msg); err != nil && err != nyquist.ErrDone {
+				return err
+			}
+		}
+		isWrite = !isWrite
+	}
+	return nil
+}
+
+func isDone(hs *nyquist.HandshakeState) bool {
+	return hs.GetStatus().Err == nyquist.ErrDone
+}
+
+func prologue(label string, fields ...[]byte) []byte {
+	p := []byte(label)
+	for _, f := range fields {
+		p = binary.BigEndian.AppendUint16(p, uint16(len(f)))
+		p = append(p, f...)
+	}
+	return p
+}
+
+func writeHandshakeFrame(w io.Writer, negotiationData, msg []byte) error {
+	if len(negotiationData) > maxFieldLen || len(msg) > maxFieldLen {
+		return errFieldTooLong
+	}
+
+	frame := make([]byte, 0, 4+len(negotiationData)+len(msg))
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(negotiationData)))
+	frame = append(frame, negotiationData...)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(msg)))
+	frame = append(frame, msg...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func readHandshakeFrame(r io.Reader) ([]byte, []byte, error) {
+	negotiationData, err := readField(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg, err := readField(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return negotiationData, msg, nil
+}
+
+func readField(r io.Reader) ([]byte, error) {
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
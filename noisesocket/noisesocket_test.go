@@ -0,0 +1,497 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package noisesocket
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const (
+	protoXX25519 = "Noise_XX_25519_ChaChaPoly_BLAKE2s"
+	protoXX448   = "Noise_XX_448_ChaChaPoly_BLAKE2s"
+	protoIK      = "Noise_IK_25519_ChaChaPoly_BLAKE2s"
+)
+
+type testPeer struct {
+	static25519 dh.Keypair
+	static448   dh.Keypair
+}
+
+func newTestPeer(t *testing.T) *testPeer {
+	require := require.New(t)
+
+	static25519, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair(25519)")
+	static448, err := dh.X448.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair(448)")
+
+	return &testPeer{
+		static25519: static25519,
+		static448:   static448,
+	}
+}
+
+func (p *testPeer) config(t *testing.T, protocolName string, isInitiator bool) *nyquist.HandshakeConfig {
+	protocol, err := nyquist.NewProtocol(protocolName)
+	require.NoError(t, err, "NewProtocol")
+
+	cfg := &nyquist.HandshakeConfig{
+		Protocol:    protocol,
+		LocalStatic: p.static25519,
+		IsInitiator: isInitiator,
+	}
+	if protocol.DH == dh.X448 {
+		cfg.LocalStatic = p.static448
+	}
+	return cfg
+}
+
+type testResult struct {
+	conn *Conn
+	err  error
+}
+
+// runPipe runs the client and server over an in-memory connection.
+func runPipe(t *testing.T, clientCfg *InitiatorConfig, serverCfg *ResponderConfig) (*Conn, error, *Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	t.Cleanup(func() {
+		clientConn.Close()
+		serverConn.Close()
+	})
+
+	ch := make(chan testResult)
+	go func() {
+		conn, err := Server(serverConn, serverCfg)
+		if err != nil {
+			// Unblock the client if it is waiting on a response.
+			serverConn.Close()
+		}
+		ch <- testResult{conn, err}
+	}()
+
+	conn, err := Client(clientConn, clientCfg)
+	if err != nil {
+		clientConn.Close()
+	}
+	result := <-ch
+
+	return conn, err, result.conn, result.err
+}
+
+func TestNoiseSocket(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Accept", testNoiseSocketAccept},
+		{"Switch", testNoiseSocketSwitch},
+		{"Retry", testNoiseSocketRetry},
+		{"Reject", testNoiseSocketReject},
+		{"Prologue", testNoiseSocketPrologue},
+		{"HandshakePrologue", testNoiseSocketHandshakePrologue},
+		{"Rekey", testNoiseSocketRekey},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testNoiseSocketAccept(t *testing.T) {
+	require := require.New(t)
+
+	alice, bob := newTestPeer(t), newTestPeer(t)
+
+	var seenNeg []byte
+	client, clientErr, server, serverErr := runPipe(
+		t,
+		&InitiatorConfig{
+			HandshakeConfig:  alice.config(t, protoXX25519, true),
+			NegotiationData:  []byte(protoXX25519),
+			PaddingBlockSize: 64,
+		},
+		&ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+				seenNeg = negotiationData
+				return &Negotiation{
+					Decision:        Accept,
+					HandshakeConfig: bob.config(t, string(negotiationData), false),
+				}, nil
+			},
+			PaddingBlockSize: 128,
+		},
+	)
+	require.NoError(clientErr, "Client")
+	require.NoError(serverErr, "Server")
+	require.Equal([]byte(protoXX25519), seenNeg, "Server - negotiation data")
+	require.Equal(bob.static25519.Public().Bytes(), client.HandshakeStatus().RemoteStatic.Bytes(), "Client - RemoteStatic")
+	require.Equal(alice.static25519.Public().Bytes(), server.HandshakeStatus().RemoteStatic.Bytes(), "Server - RemoteStatic")
+
+	exchangeData(t, client, server)
+}
+
+func exchangeData(t *testing.T, client, server *Conn) {
+	require := require.New(t)
+
+	// Large enough to require multiple transport messages.
+	msg := make([]byte, 100000)
+	_, err := rand.Read(msg)
+	require.NoError(err, "rand.Read")
+
+	errCh := make(chan error)
+	go func() {
+		_, wrErr := client.Write(msg)
+		errCh <- wrErr
+	}()
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(server, b)
+	require.NoError(err, "server.Read")
+	require.NoError(<-errCh, "client.Write")
+	require.Equal(msg, b, "client -> server")
+
+	go func() {
+		_, wrErr := server.Write([]byte("hello"))
+		errCh <- wrErr
+	}()
+	b = make([]byte, 5)
+	_, err = io.ReadFull(client, b)
+	require.NoError(err, "client.Read")
+	require.NoError(<-errCh, "server.Write")
+	require.Equal([]byte("hello"), b, "server -> client")
+}
+
+func testNoiseSocketSwitch(t *testing.T) {
+	require := require.New(t)
+
+	alice, bob, oldBob := newTestPeer(t), newTestPeer(t), newTestPeer(t)
+
+	// Alice has a stale static key for Bob, so the IK handshake can not
+	// succeed, and Bob switches to XX.
+	clientCfg := alice.config(t, protoIK, true)
+	clientCfg.RemoteStatic = oldBob.static25519.Public()
+
+	var switchNeg []byte
+	client, clientErr, server, serverErr := runPipe(
+		t,
+		&InitiatorConfig{
+			HandshakeConfig: clientCfg,
+			NegotiationData: []byte(protoIK),
+			OnNegotiation: func(negotiationData []byte, isEmpty bool) (*Negotiation, error) {
+				switchNeg = negotiationData
+				require.False(isEmpty, "Client - isEmpty")
+				protocolName, ok := strings.CutPrefix(string(negotiationData), "switch:")
+				require.True(ok, "Client - switch")
+				return &Negotiation{
+					Decision:        Switch,
+					HandshakeConfig: alice.config(t, protocolName, false),
+				}, nil
+			},
+		},
+		&ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+				require.Equal([]byte(protoIK), negotiationData, "Server - negotiation data")
+				return &Negotiation{
+					Decision:        Switch,
+					HandshakeConfig: bob.config(t, protoXX25519, true),
+					NegotiationData: []byte("switch:" + protoXX25519),
+				}, nil
+			},
+		},
+	)
+	require.NoError(clientErr, "Client")
+	require.NoError(serverErr, "Server")
+	require.Equal([]byte("switch:"+protoXX25519), switchNeg, "Client - negotiation data")
+	require.Equal(bob.static25519.Public().Bytes(), client.HandshakeStatus().RemoteStatic.Bytes(), "Client - RemoteStatic")
+	require.Equal(alice.static25519.Public().Bytes(), server.HandshakeStatus().RemoteStatic.Bytes(), "Server - RemoteStatic")
+
+	exchangeData(t, client, server)
+}
+
+func testNoiseSocketRetry(t *testing.T) {
+	require := require.New(t)
+
+	alice, bob := newTestPeer(t), newTestPeer(t)
+
+	// Bob only supports X25519, so Alice is asked to retry.
+	var serverNegs [][]byte
+	client, clientErr, server, serverErr := runPipe(
+		t,
+		&InitiatorConfig{
+			HandshakeConfig: alice.config(t, protoXX448, true),
+			NegotiationData: []byte(protoXX448),
+			OnNegotiation: func(negotiationData []byte, isEmpty bool) (*Negotiation, error) {
+				require.True(isEmpty, "Client - isEmpty")
+				protocolName, ok := strings.CutPrefix(string(negotiationData), "retry:")
+				require.True(ok, "Client - retry")
+				return &Negotiation{
+					Decision:        Retry,
+					HandshakeConfig: alice.config(t, protocolName, true),
+					NegotiationData: []byte(protocolName),
+				}, nil
+			},
+		},
+		&ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+				serverNegs = append(serverNegs, negotiationData)
+				if string(negotiationData) != protoXX25519 {
+					return &Negotiation{
+						Decision:        Retry,
+						NegotiationData: []byte("retry:" + protoXX25519),
+					}, nil
+				}
+				return &Negotiation{
+					Decision:        Accept,
+					HandshakeConfig: bob.config(t, protoXX25519, false),
+				}, nil
+			},
+		},
+	)
+	require.NoError(clientErr, "Client")
+	require.NoError(serverErr, "Server")
+	require.Equal([][]byte{[]byte(protoXX448), []byte(protoXX25519)}, serverNegs, "Server - negotiation data")
+	require.Equal(bob.static25519.Public().Bytes(), client.HandshakeStatus().RemoteStatic.Bytes(), "Client - RemoteStatic")
+
+	exchangeData(t, client, server)
+}
+
+func testNoiseSocketReject(t *testing.T) {
+	require := require.New(t)
+
+	alice := newTestPeer(t)
+
+	var rejectNeg []byte
+	_, clientErr, _, serverErr := runPipe(
+		t,
+		&InitiatorConfig{
+			HandshakeConfig: alice.config(t, protoXX25519, true),
+			NegotiationData: []byte(protoXX25519),
+			OnNegotiation: func(negotiationData []byte, isEmpty bool) (*Negotiation, error) {
+				require.True(isEmpty, "Client - isEmpty")
+				rejectNeg = negotiationData
+				return nil, nil
+			},
+		},
+		&ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+				return &Negotiation{
+					Decision:        Reject,
+					NegotiationData: []byte("go away"),
+				}, nil
+			},
+		},
+	)
+	require.ErrorIs(clientErr, ErrRejected, "Client")
+	require.ErrorIs(serverErr, ErrRejected, "Server")
+	require.Equal([]byte("go away"), rejectNeg, "Client - negotiation data")
+
+	// A nil Negotiation from the responder is equivalent to `Reject`, for
+	// both the initial and the retry negotiation data.
+	_, clientErr, _, serverErr = runPipe(
+		t,
+		&InitiatorConfig{
+			HandshakeConfig: alice.config(t, protoXX25519, true),
+			NegotiationData: []byte(protoXX25519),
+		},
+		&ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+				return nil, nil
+			},
+		},
+	)
+	require.ErrorIs(clientErr, ErrRejected, "Client - nil Negotiation")
+	require.ErrorIs(serverErr, ErrRejected, "Server - nil Negotiation")
+
+	_, clientErr, _, serverErr = runPipe(
+		t,
+		&InitiatorConfig{
+			HandshakeConfig: alice.config(t, protoXX448, true),
+			NegotiationData: []byte(protoXX448),
+			OnNegotiation: func(negotiationData []byte, isEmpty bool) (*Negotiation, error) {
+				return &Negotiation{
+					Decision:        Retry,
+					HandshakeConfig: alice.config(t, protoXX25519, true),
+					NegotiationData: []byte(protoXX25519),
+				}, nil
+			},
+		},
+		&ResponderConfig{
+			OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+				if string(negotiationData) == protoXX448 {
+					return &Negotiation{Decision: Retry}, nil
+				}
+				return nil, nil
+			},
+		},
+	)
+	require.ErrorIs(clientErr, ErrRejected, "Client - nil retry Negotiation")
+	require.ErrorIs(serverErr, ErrRejected, "Server - nil retry Negotiation")
+}
+
+func testNoiseSocketPrologue(t *testing.T) {
+	require := require.New(t)
+
+	p := prologue(prologueInit2, []byte("ab"), nil, []byte("c"))
+	expected := []byte("NoiseSocketInit2\x00\x02ab\x00\x00\x00\x01c")
+	require.True(bytes.Equal(expected, p), "prologue()")
+
+	var buf bytes.Buffer
+	err := writeHandshakeFrame(&buf, []byte("neg"), []byte("message"))
+	require.NoError(err, "writeHandshakeFrame")
+	require.Equal([]byte("\x00\x03neg\x00\x07message"), buf.Bytes(), "writeHandshakeFrame")
+
+	neg, msg, err := readHandshakeFrame(&buf)
+	require.NoError(err, "readHandshakeFrame")
+	require.Equal([]byte("neg"), neg, "readHandshakeFrame - negotiation data")
+	require.Equal([]byte("message"), msg, "readHandshakeFrame - message")
+}
+
+func testNoiseSocketHandshakePrologue(t *testing.T) {
+	require := require.New(t)
+
+	alice, bob := newTestPeer(t), newTestPeer(t)
+
+	run := func(clientPrologue, serverPrologue []byte) (*Conn, error, *Conn, error) {
+		clientCfg := alice.config(t, protoXX25519, true)
+		clientCfg.Prologue = clientPrologue
+		return runPipe(
+			t,
+			&InitiatorConfig{
+				HandshakeConfig: clientCfg,
+				NegotiationData: []byte(protoXX25519),
+			},
+			&ResponderConfig{
+				OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+					serverCfg := bob.config(t, string(negotiationData), false)
+					serverCfg.Prologue = serverPrologue
+					return &Negotiation{
+						Decision:        Accept,
+						HandshakeConfig: serverCfg,
+					}, nil
+				},
+			},
+		)
+	}
+
+	// The handshake configuration's prologue is appended to the
+	// NoiseSocket prologue, so it must match.
+	client, clientErr, server, serverErr := run([]byte("app prologue"), []byte("app prologue"))
+	require.NoError(clientErr, "Client")
+	require.NoError(serverErr, "Server")
+	require.Equal(client.HandshakeStatus().HandshakeHash, server.HandshakeStatus().HandshakeHash, "HandshakeHash")
+
+	// The HandshakeConfig passed in is not modified.
+	cfg := alice.config(t, protoXX25519, true)
+	cfg.Prologue = []byte("app prologue")
+	hs, err := newHandshake(cfg, prologue(prologueInit1, nil))
+	require.NoError(err, "newHandshake")
+	hs.Reset()
+	require.Equal([]byte("app prologue"), cfg.Prologue, "newHandshake - Prologue")
+
+	// Nor is the NoiseSocket prologue, even with spare capacity.
+	p := make([]byte, 0, 64)
+	p = append(p, prologue(prologueInit1, nil)...)
+	hs, err = newHandshake(cfg, p)
+	require.NoError(err, "newHandshake - spare capacity")
+	hs.Reset()
+	require.Equal(make([]byte, cap(p)-len(p)), p[len(p):cap(p)], "newHandshake - NoiseSocket prologue")
+
+	exchangeData(t, client, server)
+
+	_, clientErr, _, serverErr = run([]byte("app prologue"), []byte("other prologue"))
+	require.Error(clientErr, "Client - prologue mismatch")
+	require.Error(serverErr, "Server - prologue mismatch")
+}
+
+func testNoiseSocketRekey(t *testing.T) {
+	require := require.New(t)
+
+	alice, bob := newTestPeer(t), newTestPeer(t)
+
+	run := func(clientInterval, serverInterval uint64) (*Conn, *Conn) {
+		client, clientErr, server, serverErr := runPipe(
+			t,
+			&InitiatorConfig{
+				HandshakeConfig: alice.config(t, protoXX25519, true),
+				NegotiationData: []byte(protoXX25519),
+				RekeyInterval:   clientInterval,
+			},
+			&ResponderConfig{
+				OnNegotiation: func(negotiationData []byte) (*Negotiation, error) {
+					return &Negotiation{
+						Decision:        Accept,
+						HandshakeConfig: bob.config(t, string(negotiationData), false),
+					}, nil
+				},
+				RekeyInterval: serverInterval,
+			},
+		)
+		require.NoError(clientErr, "Client")
+		require.NoError(serverErr, "Server")
+		return client, server
+	}
+
+	client, server := run(1, 1)
+	for i := 0; i < 3; i++ {
+		exchangeData(t, client, server)
+	}
+	require.EqualValues(6, client.txMsgs, "client.txMsgs")
+	require.EqualValues(6, server.rxMsgs, "server.rxMsgs")
+
+	// If only one side rekeys, the first message after the rekey fails
+	// to decrypt.
+	client, server = run(2, 0)
+	errCh := make(chan error)
+	go func() {
+		for _, msg := range []string{"one", "two", "three"} {
+			if _, err := client.Write([]byte(msg)); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		errCh <- nil
+	}()
+	b := make([]byte, 3)
+	_, err := io.ReadFull(server, b)
+	require.NoError(err, "server.Read - one")
+	_, err = io.ReadFull(server, b)
+	require.NoError(err, "server.Read - two")
+	_, err = server.Read(b)
+	require.ErrorIs(err, nyquist.ErrOpen, "server.Read - after rekey")
+	server.Close()
+	<-errCh
+}
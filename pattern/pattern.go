@@ -47,6 +47,8 @@ const (
 	Token_se
 	Token_ss
 	Token_psk
+	Token_v
+	Token_sig
 )
 
 // String returns the string representation of a Token.
@@ -66,6 +68,10 @@ func (t Token) String() string {
 		return "ss"
 	case Token_psk:
 		return "psk"
+	case Token_v:
+		return "v"
+	case Token_sig:
+		return "sig"
 	default:
 		return fmt.Sprintf("[invalid token: %d]", int(t))
 	}
@@ -200,6 +206,12 @@ func init() {
 		I1X,
 		IX1,
 		I1X1,
+
+		// Signature patterns.
+		NXsig,
+		XNsig,
+		XXsig,
+		KKsig,
 	} {
 		if err := Register(v); err != nil {
 			panic("nyquist/pattern: failed to register built-in pattern: " + err.Error())
@@ -0,0 +1,85 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package pattern
+
+// The signature patterns authenticate parties with signing keys instead of
+// static DH keys.  The `v` token transmits the sender's signature
+// verification key, and the `sig` token transmits the sender's signature
+// over the handshake hash `h` at that point of the handshake.
+//
+// Protocols using these patterns must specify a signature scheme.
+var (
+	// NXsig is the NX pattern, with the responder authenticated by a
+	// signature.
+	NXsig Pattern = &builtIn{
+		name: "NXsig",
+		messages: []Message{
+			{Token_e},
+			{Token_e, Token_ee, Token_v, Token_sig},
+		},
+	}
+
+	// XNsig is the XN pattern, with the initiator authenticated by a
+	// signature.
+	XNsig Pattern = &builtIn{
+		name: "XNsig",
+		messages: []Message{
+			{Token_e},
+			{Token_e, Token_ee},
+			{Token_v, Token_sig},
+		},
+	}
+
+	// XXsig is the XX pattern, with both parties authenticated by
+	// signatures.
+	XXsig Pattern = &builtIn{
+		name: "XXsig",
+		messages: []Message{
+			{Token_e},
+			{Token_e, Token_ee, Token_v, Token_sig},
+			{Token_v, Token_sig},
+		},
+	}
+
+	// KKsig is the KK pattern, with both parties authenticated by
+	// signatures, with verification keys known in advance.
+	KKsig Pattern = &builtIn{
+		name: "KKsig",
+		preMessages: []Message{
+			{Token_v},
+			{Token_v},
+		},
+		messages: []Message{
+			{Token_e},
+			{Token_e, Token_ee, Token_sig},
+			{Token_sig},
+		},
+	}
+)
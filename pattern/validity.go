@@ -68,7 +68,7 @@ func IsValid(pa Pattern) error {
 		m, _, side := getSide(i)
 		for _, v := range msg {
 			switch v {
-			case Token_e, Token_s:
+			case Token_e, Token_s, Token_v:
 				// 2. Parties must not send their static public key or ephemeral
 				// public key more than once per handshake.
 				if m[v] {
@@ -94,12 +94,27 @@ func IsValid(pa Pattern) error {
 		m, isInitiator, side := getSide(i)
 		for _, v := range msg {
 			switch v {
-			case Token_e, Token_s:
+			case Token_e, Token_s, Token_v:
 				// 2. Parties must not send their static public key or ephemeral
 				// public key more than once per handshake.
 				if m[v] {
 					return fmt.Errorf("nyquist/pattern: redundant public key (%s): %s", side, v)
 				}
+			case Token_sig:
+				// Signature extension: Parties must not sign more than once
+				// per handshake, can only sign with a signing key that the
+				// peer has (either via a pre-message or a `v` token), and
+				// must have sent an ephemeral public key so that the
+				// signature is over a fresh handshake hash.
+				if m[v] {
+					return fmt.Errorf("nyquist/pattern: redundant signature (%s)", side)
+				}
+				if !m[Token_v] {
+					return fmt.Errorf("nyquist/pattern: signature without verification key (%s)", side)
+				}
+				if !m[Token_e] {
+					return fmt.Errorf("nyquist/pattern: signature without ephemeral (%s)", side)
+				}
 			case Token_ee, Token_es, Token_se, Token_ss:
 				// 3. Parties must not perform a DH calculation more than once
 				// per handshake.
@@ -45,3 +45,66 @@ func TestBuiltInPatternValidity(t *testing.T) {
 		})
 	}
 }
+
+func TestSignaturePatternValidity(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		pa Pattern
+	}{
+		{
+			"NoVerificationKey",
+			&builtIn{
+				name: "NXsigNoV",
+				messages: []Message{
+					{Token_e},
+					{Token_e, Token_ee, Token_sig},
+				},
+			},
+		},
+		{
+			"NoEphemeral",
+			&builtIn{
+				name: "XNsigNoE",
+				preMessages: []Message{
+					{Token_v},
+				},
+				messages: []Message{
+					{Token_sig},
+					{Token_e, Token_ee},
+				},
+			},
+		},
+		{
+			"RedundantSignature",
+			&builtIn{
+				name: "XXsigRedundant",
+				messages: []Message{
+					{Token_e},
+					{Token_e, Token_ee, Token_v, Token_sig},
+					{Token_v, Token_sig},
+					{Token_sig},
+				},
+			},
+		},
+		{
+			"RedundantVerificationKey",
+			&builtIn{
+				name: "KXsigRedundant",
+				preMessages: []Message{
+					{Token_v},
+				},
+				messages: []Message{
+					{Token_e, Token_v},
+					{Token_e, Token_ee},
+				},
+			},
+		},
+	} {
+		t.Run(v.n, func(t *testing.T) {
+			require := require.New(t)
+
+			err := IsValid(v.pa)
+			require.Error(err, "IsValid(pattern)")
+		})
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package replay implements a responder side replay cache for the
+// initiator's first handshake message, for patterns where the first
+// message carries an encrypted (zero-RTT) payload (eg: `IK`, `IKpsk2`,
+// `K`, `X`).
+//
+// The cache is a time-bucketed Bloom filter with bounded memory, that
+// remembers each key for at least the configured window.  False positives
+// are possible (a fresh message may be rejected as a replay), false
+// negatives within the window are not.  Since keys are forgotten after
+// the window, applications that require replay protection beyond the
+// window should include a timestamp in the payload, and reject payloads
+// older than the window via `Cache.CheckTimestamp`.
+package replay // import "gitlab.com/yawning/nyquist.git/replay"
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"math"
+	"sync"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+	"gitlab.com/yawning/nyquist.git/sig"
+)
+
+const (
+	// DefaultWindow is the default replay window.
+	DefaultWindow = 2 * time.Minute
+
+	// DefaultCapacity is the default number of keys per window.
+	DefaultCapacity = 1 << 16
+
+	// DefaultFalsePositiveRate is the default Bloom filter false positive
+	// rate.
+	DefaultFalsePositiveRate = 1e-6
+
+	// DefaultBuckets is the default number of buckets the window is split
+	// into.
+	DefaultBuckets = 4
+
+	// TimestampSize is the size of a payload timestamp in bytes.
+	TimestampSize = 8
+)
+
+var (
+	// ErrReplay is the error returned when a key (and thus a handshake
+	// message) has possibly been seen before.
+	ErrReplay = errors.New("nyquist/replay: replayed handshake message")
+
+	// ErrStaleTimestamp is the error returned when a payload timestamp is
+	// outside of the replay window.
+	ErrStaleTimestamp = errors.New("nyquist/replay: timestamp outside of replay window")
+
+	errTruncatedTimestamp = errors.New("nyquist/replay: truncated timestamp")
+)
+
+// Config is the replay cache configuration.
+type Config struct {
+	// Window is the minimum duration for which keys are remembered, and
+	// the maximum clock skew allowed for payload timestamps.  If 0,
+	// `DefaultWindow` will be used.
+	Window time.Duration
+
+	// Capacity is the expected maximum number of keys added per window.
+	// Exceeding the capacity increases the false positive rate.  Memory
+	// usage is approximately `(Buckets + 1) * Capacity * -ln(rate) / ln(2)^2`
+	// bits.  If 0,
+	// `DefaultCapacity` will be used.
+	Capacity int
+
+	// FalsePositiveRate is the target false positive rate at capacity.
+	// If 0, `DefaultFalsePositiveRate` will be used.
+	FalsePositiveRate float64
+
+	// Buckets is the number of buckets the window is split into, higher
+	// values reduce the maximum duration for which keys are remembered
+	// past the window, at the cost of memory.  If 0, `DefaultBuckets`
+	// will be used.
+	Buckets int
+
+	// Now is the clock, if nil `time.Now` will be used.
+	Now func() time.Time
+}
+
+// Cache is a replay cache.  It is safe for concurrent use.
+type Cache struct {
+	l sync.Mutex
+
+	seeds   [2]maphash.Seed
+	buckets []bloomFilter
+	current int
+	epoch   int64
+
+	window time.Duration
+	span   time.Duration
+	now    func() time.Time
+}
+
+// Check checks if the key has possibly been seen within the window, and
+// returns `ErrReplay` if so.  Otherwise the key is added to the cache.
+func (c *Cache) Check(key []byte) error {
+	h1, h2 := c.hash(key)
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.advance()
+	if c.contains(h1, h2) {
+		return ErrReplay
+	}
+	c.buckets[c.current].add(h1, h2)
+
+	return nil
+}
+
+// seen returns true iff the key has possibly been seen within the window,
+// without adding it to the cache.
+func (c *Cache) seen(key []byte) bool {
+	h1, h2 := c.hash(key)
+
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.advance()
+	return c.contains(h1, h2)
+}
+
+func (c *Cache) hash(key []byte) (uint64, uint64) {
+	// The second hash must be odd, so that the probe sequence visits
+	// distinct bits.
+	return maphash.Bytes(c.seeds[0], key), maphash.Bytes(c.seeds[1], key) | 1
+}
+
+// contains returns true iff any bucket possibly contains the key.  It must
+// be called with the lock held.
+func (c *Cache) contains(h1, h2 uint64) bool {
+	for i := range c.buckets {
+		if c.buckets[i].contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTimestamp checks that the payload timestamp prefixed to payload by
+// `AddTimestamp` is within the replay window, and returns the remainder of
+// the payload.
+func (c *Cache) CheckTimestamp(payload []byte) ([]byte, error) {
+	if len(payload) < TimestampSize {
+		return nil, errTruncatedTimestamp
+	}
+
+	ts := time.UnixMilli(int64(binary.BigEndian.Uint64(payload)))
+	now := c.now()
+	if ts.Before(now.Add(-c.window)) || ts.After(now.Add(c.window)) {
+		return nil, ErrStaleTimestamp
+	}
+
+	return payload[TimestampSize:], nil
+}
+
+// advance clears the buckets that have expired, and updates the current
+// bucket.  It must be called with the lock held.
+func (c *Cache) advance() {
+	epoch := c.now().UnixNano() / int64(c.span)
+	delta := epoch - c.epoch
+	if delta <= 0 {
+		return
+	}
+
+	n := int64(len(c.buckets))
+	if delta > n {
+		delta = n
+	}
+	for i := int64(0); i < delta; i++ {
+		c.current = (c.current + 1) % len(c.buckets)
+		c.buckets[c.current].reset()
+	}
+	c.epoch = epoch
+}
+
+// NewCache creates a new replay cache.
+func NewCache(cfg *Config) *Cache {
+	window, capacity, fpRate, nrBuckets := cfg.Window, cfg.Capacity, cfg.FalsePositiveRate, cfg.Buckets
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = DefaultFalsePositiveRate
+	}
+	if nrBuckets <= 0 {
+		nrBuckets = DefaultBuckets
+	}
+	now := cfg.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	// The window is split into nrBuckets spans, with an additional bucket
+	// for the partial span at the start of the window, so each key is
+	// remembered for between window and window + span.  Each bucket is
+	// sized for the full capacity to tolerate bursts, and keys are checked
+	// against every bucket, so the per-bucket false positive rate is
+	// scaled accordingly.
+	span := window / time.Duration(nrBuckets)
+	if span <= 0 {
+		span = 1
+	}
+	nrBuckets++
+	bucketRate := fpRate / float64(nrBuckets)
+
+	c := &Cache{
+		seeds:   [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+		buckets: make([]bloomFilter, nrBuckets),
+		window:  window,
+		span:    span,
+		now:     now,
+	}
+	for i := range c.buckets {
+		c.buckets[i] = newBloomFilter(capacity, bucketRate)
+	}
+	c.epoch = now().UnixNano() / int64(span)
+
+	return c
+}
+
+// AddTimestamp prefixes payload with a timestamp, for use with
+// `Cache.CheckTimestamp`.
+func AddTimestamp(payload []byte, now time.Time) []byte {
+	out := make([]byte, 0, TimestampSize+len(payload))
+	out = binary.BigEndian.AppendUint64(out, uint64(now.UnixMilli()))
+	return append(out, payload...)
+}
+
+// Observer is a `nyquist.HandshakeObserver` for a single responder side
+// handshake, that checks the initiator's ephemeral public key against a
+// replay cache, and rejects replayed messages with `ErrReplay` before the
+// payload is decrypted.
+//
+// The ephemeral public key is only looked up when the `e` token is
+// received, and is added to the cache once the message is authenticated,
+// so that unauthenticated messages can neither pre-empt a genuine message
+// by reusing its ephemeral public key, nor fill the cache.  The key is
+// added when the `s` token is successfully decrypted, which requires
+// knowledge of the ephemeral private key (eg: `IK`, `X`).  For patterns
+// where the first message has no `s` token (eg: `K`), `Commit` must be
+// called after `ReadMessage` succeeds.
+type Observer struct {
+	cache     *Cache
+	next      nyquist.HandshakeObserver
+	ephemeral []byte
+	committed bool
+}
+
+// OnPeerPublicKey implements the `nyquist.HandshakeObserver` interface.
+func (o *Observer) OnPeerPublicKey(token pattern.Token, publicKey dh.PublicKey) error {
+	switch {
+	case token == pattern.Token_e && o.ephemeral == nil:
+		o.ephemeral = publicKey.Bytes()
+		if o.cache.seen(o.ephemeral) {
+			return ErrReplay
+		}
+	case token == pattern.Token_s:
+		if err := o.Commit(); err != nil {
+			return err
+		}
+	}
+	if o.next != nil {
+		return o.next.OnPeerPublicKey(token, publicKey)
+	}
+	return nil
+}
+
+// Commit adds the initiator's ephemeral public key to the replay cache,
+// and returns `ErrReplay` if it has possibly been seen before.  It must
+// only be called after the first message has been authenticated, and is
+// called automatically when the `s` token is received.
+func (o *Observer) Commit() error {
+	if o.committed || o.ephemeral == nil {
+		return nil
+	}
+	o.committed = true
+	return o.cache.Check(o.ephemeral)
+}
+
+// OnPeerSigningKey implements the `nyquist.SigningKeyObserver` interface,
+// and calls the next observer if it also implements the interface.
+func (o *Observer) OnPeerSigningKey(publicKey sig.PublicKey) error {
+	if next, ok := o.next.(nyquist.SigningKeyObserver); ok {
+		return next.OnPeerSigningKey(publicKey)
+	}
+	return nil
+}
+
+// NewObserver creates a new per-handshake Observer, backed by cache.  If
+// next is non-nil, it will be called after the replay check.
+func NewObserver(cache *Cache, next nyquist.HandshakeObserver) *Observer {
+	return &Observer{
+		cache: cache,
+		next:  next,
+	}
+}
+
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := 0; i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		f.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	for i := 0; i < f.k; i++ {
+		idx := (h1 + uint64(i)*h2) % f.m
+		if f.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) reset() {
+	clear(f.bits)
+}
+
+func newBloomFilter(n int, p float64) bloomFilter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) &^ 63
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return bloomFilter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+	}
+}
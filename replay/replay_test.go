@@ -0,0 +1,274 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package replay
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+type recordingObserver struct {
+	tokens []pattern.Token
+}
+
+func (o *recordingObserver) OnPeerPublicKey(token pattern.Token, _ dh.PublicKey) error {
+	o.tokens = append(o.tokens, token)
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Cache", testReplayCache},
+		{"Expiry", testReplayExpiry},
+		{"FalsePositives", testReplayFalsePositives},
+		{"Timestamp", testReplayTimestamp},
+		{"Observer", testReplayObserver},
+		{"ObserverCommit", testReplayObserverCommit},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testReplayCache(t *testing.T) {
+	require := require.New(t)
+
+	c := NewCache(&Config{})
+	require.NoError(c.Check([]byte("key 1")), "Check - new key")
+	require.ErrorIs(c.Check([]byte("key 1")), ErrReplay, "Check - replay")
+	require.NoError(c.Check([]byte("key 2")), "Check - another key")
+	require.ErrorIs(c.Check([]byte("key 2")), ErrReplay, "Check - another replay")
+}
+
+func testReplayExpiry(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	c := NewCache(&Config{
+		Window:  time.Minute,
+		Buckets: 4,
+		Now: func() time.Time {
+			return now
+		},
+	})
+
+	require.NoError(c.Check([]byte("key")), "Check")
+	for i := 0; i < 4; i++ {
+		now = now.Add(15 * time.Second)
+		require.ErrorIs(c.Check([]byte("key")), ErrReplay, "Check - within window: %d", i)
+	}
+
+	// The key is forgotten by window + span.
+	now = now.Add(15 * time.Second)
+	require.NoError(c.Check([]byte("key")), "Check - after window")
+
+	// A large jump in time clears every bucket.
+	now = now.Add(time.Hour)
+	require.NoError(c.Check([]byte("key")), "Check - after large jump")
+	require.ErrorIs(c.Check([]byte("key")), ErrReplay, "Check - after large jump replay")
+}
+
+func testReplayFalsePositives(t *testing.T) {
+	require := require.New(t)
+
+	const capacity = 10000
+	c := NewCache(&Config{
+		Capacity:          capacity,
+		FalsePositiveRate: 1e-3,
+	})
+
+	key := make([]byte, 32)
+	for i := 0; i < capacity; i++ {
+		_, _ = rand.Read(key)
+		_ = c.Check(key)
+	}
+
+	// Probe without adding keys, so the cache stays at capacity.
+	var falsePositives int
+	for i := 0; i < 100*capacity; i++ {
+		_, _ = rand.Read(key)
+		if c.contains(c.hash(key)) {
+			falsePositives++
+		}
+	}
+
+	// The expected number of false positives is at most 1000.
+	require.Less(falsePositives, 1500, "false positives")
+}
+
+func testReplayTimestamp(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Unix(1700000000, 0)
+	c := NewCache(&Config{
+		Window: time.Minute,
+		Now: func() time.Time {
+			return now
+		},
+	})
+
+	payload := AddTimestamp([]byte("payload"), now.Add(-30*time.Second))
+	require.Len(payload, TimestampSize+len("payload"), "AddTimestamp")
+	b, err := c.CheckTimestamp(payload)
+	require.NoError(err, "CheckTimestamp")
+	require.Equal([]byte("payload"), b, "CheckTimestamp")
+
+	_, err = c.CheckTimestamp(AddTimestamp(nil, now.Add(-2*time.Minute)))
+	require.ErrorIs(err, ErrStaleTimestamp, "CheckTimestamp - past")
+	_, err = c.CheckTimestamp(AddTimestamp(nil, now.Add(2*time.Minute)))
+	require.ErrorIs(err, ErrStaleTimestamp, "CheckTimestamp - future")
+	_, err = c.CheckTimestamp([]byte("short"))
+	require.Error(err, "CheckTimestamp - truncated")
+}
+
+func testReplayObserver(t *testing.T) {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol("Noise_IK_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+	aliceStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	bobStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	alice, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:     protocol,
+		LocalStatic:  aliceStatic,
+		RemoteStatic: bobStatic.Public(),
+		IsInitiator:  true,
+	})
+	require.NoError(err, "NewHandshake - alice")
+	defer alice.Reset()
+	msg, err := alice.WriteMessage(nil, []byte("zero-RTT payload"))
+	require.NoError(err, "alice.WriteMessage")
+
+	cache := NewCache(&Config{})
+	newBob := func(next nyquist.HandshakeObserver) *nyquist.HandshakeState {
+		bob, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+			Protocol:    protocol,
+			LocalStatic: bobStatic,
+			Observer:    NewObserver(cache, next),
+		})
+		require.NoError(err, "NewHandshake - bob")
+		return bob
+	}
+
+	// A forged message that reuses the genuine ephemeral public key fails
+	// to authenticate, and does not pre-empt the genuine message.
+	forged := append([]byte{}, msg[:32]...)
+	forged = append(forged, make([]byte, len(msg)-32)...)
+	_, err = rand.Read(forged[32:])
+	require.NoError(err, "rand.Read")
+	forgedBob := newBob(nil)
+	defer forgedBob.Reset()
+	_, err = forgedBob.ReadMessage(nil, forged)
+	require.Error(err, "forgedBob.ReadMessage")
+	require.NotErrorIs(err, ErrReplay, "forgedBob.ReadMessage")
+
+	next := &recordingObserver{}
+	bob := newBob(next)
+	defer bob.Reset()
+	payload, err := bob.ReadMessage(nil, msg)
+	require.NoError(err, "bob.ReadMessage")
+	require.Equal([]byte("zero-RTT payload"), payload, "bob.ReadMessage")
+	require.Equal([]pattern.Token{pattern.Token_e, pattern.Token_s}, next.tokens, "next observer")
+
+	// Replaying the first message is rejected before the payload is
+	// released.
+	replayBob := newBob(nil)
+	defer replayBob.Reset()
+	payload, err = replayBob.ReadMessage(nil, msg)
+	require.ErrorIs(err, ErrReplay, "replayBob.ReadMessage")
+	require.Nil(payload, "replayBob.ReadMessage")
+
+	// Replays of forged messages are rejected too, once the genuine
+	// message has been accepted.
+	forgedBob = newBob(nil)
+	defer forgedBob.Reset()
+	_, err = forgedBob.ReadMessage(nil, forged)
+	require.ErrorIs(err, ErrReplay, "forgedBob.ReadMessage - after genuine")
+
+	// Alternatively, the first message itself may be used as the key.
+	msgCache := NewCache(&Config{})
+	require.NoError(msgCache.Check(msg), "Check - message")
+	require.ErrorIs(msgCache.Check(msg), ErrReplay, "Check - message replay")
+}
+
+func testReplayObserverCommit(t *testing.T) {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol("Noise_K_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+	aliceStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	bobStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	alice, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:     protocol,
+		LocalStatic:  aliceStatic,
+		RemoteStatic: bobStatic.Public(),
+		IsInitiator:  true,
+	})
+	require.NoError(err, "NewHandshake - alice")
+	defer alice.Reset()
+	msg, err := alice.WriteMessage(nil, []byte("zero-RTT payload"))
+	require.Equal(nyquist.ErrDone, err, "alice.WriteMessage")
+
+	cache := NewCache(&Config{})
+	readMessage := func() error {
+		obs := NewObserver(cache, nil)
+		bob, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+			Protocol:     protocol,
+			LocalStatic:  bobStatic,
+			RemoteStatic: aliceStatic.Public(),
+			Observer:     obs,
+		})
+		require.NoError(err, "NewHandshake - bob")
+		defer bob.Reset()
+		if _, err = bob.ReadMessage(nil, msg); err != nyquist.ErrDone {
+			return err
+		}
+		return obs.Commit()
+	}
+
+	// Without an `s` token, the key is only added by Commit.
+	require.NoError(readMessage(), "ReadMessage")
+	require.ErrorIs(readMessage(), ErrReplay, "ReadMessage - replay")
+}
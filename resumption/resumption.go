@@ -0,0 +1,526 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package resumption implements session resumption via encrypted session
+// tickets and PSK handshakes.
+//
+// After a full handshake completes, the server issues a ticket containing
+// a resumption secret derived from the handshake's `ExporterSecret`
+// (and thus the final chaining key), encrypted with a periodically
+// rotated key that only the server knows.  On reconnect, the client
+// presents the ticket with the first message of a `NNpsk0` handshake
+// keyed by the resumption secret, with the ticket bound to the
+// handshake via the prologue.  If the server rejects the ticket (eg: it
+// expired, or the encryption key was retired), both sides fall back to a
+// full handshake.
+//
+// All messages are framed with a 16-bit big-endian length.  The first
+// client message additionally carries the handshake mode and the ticket,
+// and the server's response to a resumption attempt carries whether the
+// ticket was accepted.  After each handshake, the server sends one
+// transport message containing a new ticket (or nothing).
+package resumption // import "gitlab.com/yawning/nyquist.git/resumption"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+const (
+	modeFull   = 0x01
+	modeResume = 0x02
+
+	ticketAccepted = 0x00
+	ticketRejected = 0x01
+
+	prologueLabel = "NyquistResumption"
+	secretLabel   = "nyquist/resumption: resumption secret"
+)
+
+var (
+	// ErrProtocol is the error returned when the peer violates the
+	// resumption protocol.
+	ErrProtocol = errors.New("nyquist/resumption: protocol violation")
+
+	errInvalidRole    = errors.New("nyquist/resumption: invalid role")
+	errInvalidPattern = errors.New("nyquist/resumption: one-way patterns are not supported")
+	errFrameTooLarge  = errors.New("nyquist/resumption: frame too large")
+	errRejected       = errors.New("nyquist/resumption: ticket rejected")
+
+	resumptionPattern pattern.Pattern
+)
+
+// Session is the client side state required to resume a session.
+type Session struct {
+	// Ticket is the opaque session ticket.
+	Ticket []byte
+
+	// Secret is the resumption secret.
+	Secret []byte
+
+	// ExpiresAt is the ticket's expiration time.
+	ExpiresAt time.Time
+
+	// RemoteStatic is the server's static public key, authenticated by
+	// the full handshake that the session was established with, if any.
+	RemoteStatic dh.PublicKey
+}
+
+// Result is the result of a handshake.
+type Result struct {
+	// Status is the status of the completed handshake.  The server's
+	// first transport message (`cs2`) is used to send the new ticket.
+	Status *nyquist.HandshakeStatus
+
+	// Resumed is true iff the session was resumed.
+	Resumed bool
+
+	// RemoteStatic is the peer's static public key, authenticated by the
+	// full handshake that the session was established with, if any.
+	RemoteStatic dh.PublicKey
+
+	// Session is the new session that can be used to resume this session,
+	// if a ticket was issued.  It is only set for clients.
+	Session *Session
+}
+
+// ClientConfig is the client configuration.
+type ClientConfig struct {
+	// HandshakeConfig is the full handshake configuration.  The
+	// resumption handshake uses the same DH, cipher, and hash functions.
+	HandshakeConfig *nyquist.HandshakeConfig
+
+	// Session is the session to resume, if any.
+	Session *Session
+
+	// Now is the clock used to check the session expiration, if nil
+	// `time.Now` will be used.
+	Now func() time.Time
+}
+
+// ServerConfig is the server configuration.
+type ServerConfig struct {
+	// HandshakeConfig is the full handshake configuration.
+	HandshakeConfig *nyquist.HandshakeConfig
+
+	// Issuer is the ticket issuer, if nil resumption is disabled.
+	Issuer *Issuer
+}
+
+// Client performs a handshake as the client over conn, resuming
+// `cfg.Session` if possible.  On failure, it is the caller's
+// responsibility to close conn.
+func Client(conn net.Conn, cfg *ClientConfig) (*Result, error) {
+	hsCfg := cfg.HandshakeConfig
+	if hsCfg == nil || !hsCfg.IsInitiator {
+		return nil, errInvalidRole
+	}
+	if hsCfg.Protocol.Pattern.IsOneWay() {
+		return nil, errInvalidPattern
+	}
+
+	if sess := cfg.Session; sess != nil {
+		now := time.Now
+		if cfg.Now != nil {
+			now = cfg.Now
+		}
+		if now().Before(sess.ExpiresAt) {
+			res, err := clientResume(conn, hsCfg, sess)
+			if err != errRejected {
+				return res, err
+			}
+		}
+	}
+
+	hs, err := nyquist.NewHandshake(hsCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	msg, err := hs.WriteMessage(nil, nil)
+	if err != nil && err != nyquist.ErrDone {
+		return nil, err
+	}
+	if err = writeFrame(conn, helloFrame(modeFull, nil, msg)); err != nil {
+		return nil, err
+	}
+	if err = finishHandshake(conn, hs, false); err != nil {
+		return nil, err
+	}
+
+	status := hs.GetStatus()
+	res := &Result{
+		Status:       status,
+		RemoteStatic: status.RemoteStatic,
+	}
+	if err = res.readTicket(conn, hsCfg.Protocol, status.RemoteStatic); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func clientResume(conn net.Conn, hsCfg *nyquist.HandshakeConfig, sess *Session) (*Result, error) {
+	protocol := resumptionProtocol(hsCfg.Protocol)
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		Prologue:       resumptionPrologue(sess.Ticket, hsCfg.Prologue),
+		PreSharedKeys:  [][]byte{sess.Secret},
+		Rng:            hsCfg.Rng,
+		MaxMessageSize: hsCfg.MaxMessageSize,
+		IsInitiator:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	msg, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeFrame(conn, helloFrame(modeResume, sess.Ticket, msg)); err != nil {
+		return nil, err
+	}
+
+	reply, err := readFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(reply) == 1 && reply[0] == ticketRejected:
+		return nil, errRejected
+	case len(reply) > 1 && reply[0] == ticketAccepted:
+	default:
+		return nil, ErrProtocol
+	}
+	if _, err = hs.ReadMessage(nil, reply[1:]); err != nyquist.ErrDone {
+		return nil, handshakeIncomplete(err)
+	}
+
+	res := &Result{
+		Status:       hs.GetStatus(),
+		Resumed:      true,
+		RemoteStatic: sess.RemoteStatic,
+	}
+	if err = res.readTicket(conn, hsCfg.Protocol, sess.RemoteStatic); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (res *Result) readTicket(conn net.Conn, protocol *nyquist.Protocol, remoteStatic dh.PublicKey) error {
+	ciphertext, err := readFrame(conn)
+	if err != nil {
+		return err
+	}
+	rx := res.Status.CipherStates[1]
+	b, err := rx.DecryptWithAd(nil, nil, ciphertext)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	if len(b) < 8 {
+		return ErrProtocol
+	}
+
+	res.Session = &Session{
+		Ticket:       b[8:],
+		Secret:       deriveSecret(protocol, res.Status.ExporterSecret),
+		ExpiresAt:    time.Unix(int64(binary.BigEndian.Uint64(b)), 0),
+		RemoteStatic: remoteStatic,
+	}
+
+	return nil
+}
+
+// Server performs a handshake as the server over conn.  On failure, it is
+// the caller's responsibility to close conn.
+func Server(conn net.Conn, cfg *ServerConfig) (*Result, error) {
+	hsCfg := cfg.HandshakeConfig
+	if hsCfg == nil || hsCfg.IsInitiator {
+		return nil, errInvalidRole
+	}
+	if hsCfg.Protocol.Pattern.IsOneWay() {
+		return nil, errInvalidPattern
+	}
+
+	mode, ticketBytes, msg, err := readHelloFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	if mode == modeResume {
+		var t *ticket
+		if cfg.Issuer != nil {
+			t, err = cfg.Issuer.open(ticketBytes)
+		}
+		if t != nil && err == nil {
+			return serverResume(conn, cfg, t, ticketBytes, msg)
+		}
+
+		// Reject the ticket, and fall back to a full handshake.
+		if err = writeFrame(conn, []byte{ticketRejected}); err != nil {
+			return nil, err
+		}
+		if mode, _, msg, err = readHelloFrame(conn); err != nil {
+			return nil, err
+		}
+		if mode != modeFull {
+			return nil, ErrProtocol
+		}
+	}
+
+	hs, err := nyquist.NewHandshake(hsCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	if _, err = hs.ReadMessage(nil, msg); err != nil && err != nyquist.ErrDone {
+		return nil, err
+	}
+	if err = finishHandshake(conn, hs, true); err != nil {
+		return nil, err
+	}
+
+	status := hs.GetStatus()
+	res := &Result{
+		Status:       status,
+		RemoteStatic: status.RemoteStatic,
+	}
+	var remoteStatic []byte
+	if status.RemoteStatic != nil {
+		remoteStatic = status.RemoteStatic.Bytes()
+	}
+	if err = res.writeTicket(conn, cfg, remoteStatic); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func serverResume(conn net.Conn, cfg *ServerConfig, t *ticket, ticketBytes, msg []byte) (*Result, error) {
+	hsCfg := cfg.HandshakeConfig
+	protocol := resumptionProtocol(hsCfg.Protocol)
+
+	res := &Result{
+		Resumed: true,
+	}
+	if t.remoteStatic != nil {
+		var err error
+		if res.RemoteStatic, err = hsCfg.Protocol.DH.ParsePublicKey(t.remoteStatic); err != nil {
+			return nil, ErrInvalidTicket
+		}
+	}
+
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		Prologue:       resumptionPrologue(ticketBytes, hsCfg.Prologue),
+		PreSharedKeys:  [][]byte{t.secret},
+		Rng:            hsCfg.Rng,
+		MaxMessageSize: hsCfg.MaxMessageSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	// A valid ticket presented by a client that does not know the
+	// resumption secret fails the handshake, rather than falling back.
+	if _, err = hs.ReadMessage(nil, msg); err != nil {
+		return nil, err
+	}
+	reply, err := hs.WriteMessage([]byte{ticketAccepted}, nil)
+	if err != nyquist.ErrDone {
+		return nil, handshakeIncomplete(err)
+	}
+	if err = writeFrame(conn, reply); err != nil {
+		return nil, err
+	}
+
+	res.Status = hs.GetStatus()
+	if err = res.writeTicket(conn, cfg, t.remoteStatic); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (res *Result) writeTicket(conn net.Conn, cfg *ServerConfig, remoteStatic []byte) error {
+	var b []byte
+	if cfg.Issuer != nil {
+		secret := deriveSecret(cfg.HandshakeConfig.Protocol, res.Status.ExporterSecret)
+		ticketBytes, expiresAt, err := cfg.Issuer.issue(secret, remoteStatic)
+		if err != nil {
+			return err
+		}
+		b = binary.BigEndian.AppendUint64(b, uint64(expiresAt.Unix()))
+		b = append(b, ticketBytes...)
+	}
+
+	tx := res.Status.CipherStates[1]
+	ciphertext, err := tx.EncryptWithAd(nil, nil, b)
+	if err != nil {
+		return err
+	}
+	return writeFrame(conn, ciphertext)
+}
+
+// finishHandshake runs the remainder of the handshake, after the first
+// message.
+func finishHandshake(conn net.Conn, hs *nyquist.HandshakeState, isWrite bool) error {
+	for hs.GetStatus().Err == nil {
+		if isWrite {
+			msg, err := hs.WriteMessage(nil, nil)
+			if err != nil && err != nyquist.ErrDone {
+				return err
+			}
+			if err = writeFrame(conn, msg); err != nil {
+				return err
+			}
+		} else {
+			msg, err := readFrame(conn)
+			if err != nil {
+				return err
+			}
+			if _, err = hs.ReadMessage(nil, msg); err != nil && err != nyquist.ErrDone {
+				return err
+			}
+		}
+		isWrite = !isWrite
+	}
+	if err := hs.GetStatus().Err; err != nyquist.ErrDone {
+		return err
+	}
+	return nil
+}
+
+func resumptionProtocol(full *nyquist.Protocol) *nyquist.Protocol {
+	return &nyquist.Protocol{
+		Pattern: resumptionPattern,
+		DH:      full.DH,
+		Cipher:  full.Cipher,
+		Hash:    full.Hash,
+	}
+}
+
+func resumptionPrologue(ticketBytes, prologue []byte) []byte {
+	b := make([]byte, 0, len(prologueLabel)+2+len(ticketBytes)+len(prologue))
+	b = append(b, prologueLabel...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(ticketBytes)))
+	b = append(b, ticketBytes...)
+	return append(b, prologue...)
+}
+
+func deriveSecret(protocol *nyquist.Protocol, exporterSecret []byte) []byte {
+	secret := make([]byte, nyquist.PreSharedKeySize)
+	r := hkdf.Expand(protocol.Hash.New, exporterSecret, []byte(secretLabel))
+	_, _ = io.ReadFull(r, secret)
+	return secret
+}
+
+func handshakeIncomplete(err error) error {
+	if err == nil {
+		return ErrProtocol
+	}
+	return err
+}
+
+func helloFrame(mode byte, ticketBytes, msg []byte) []byte {
+	b := make([]byte, 0, 3+len(ticketBytes)+len(msg))
+	b = append(b, mode)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(ticketBytes)))
+	b = append(b, ticketBytes...)
+	return append(b, msg...)
+}
+
+func readHelloFrame(r io.Reader) (byte, []byte, []byte, error) {
+	b, err := readFrame(r)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if len(b) < 3 {
+		return 0, nil, nil, ErrProtocol
+	}
+	mode, ticketLen := b[0], int(binary.BigEndian.Uint16(b[1:]))
+	b = b[3:]
+	if len(b) < ticketLen {
+		return 0, nil, nil, ErrProtocol
+	}
+	switch {
+	case mode == modeFull && ticketLen == 0:
+	case mode == modeResume && ticketLen > 0:
+	default:
+		return 0, nil, nil, ErrProtocol
+	}
+
+	return mode, b[:ticketLen], b[ticketLen:], nil
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeFrame(w io.Writer, b []byte) error {
+	if len(b) > math.MaxUint16 {
+		return errFrameTooLarge
+	}
+	frame := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(b)), uint16(len(b)))
+	_, err := w.Write(append(frame, b...))
+	return err
+}
+
+func init() {
+	var err error
+	if resumptionPattern, err = pattern.MakePSK(pattern.NN, "psk0"); err != nil {
+		panic("nyquist/resumption: failed to initialize pattern: " + err.Error())
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package resumption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const protoXX = "Noise_XX_25519_ChaChaPoly_BLAKE2s"
+
+type testResult struct {
+	res *Result
+	err error
+}
+
+type testEnv struct {
+	protocol     *nyquist.Protocol
+	clientStatic dh.Keypair
+	serverStatic dh.Keypair
+	now          time.Time
+	issuer       *Issuer
+}
+
+func (env *testEnv) clock() time.Time {
+	return env.now
+}
+
+func (env *testEnv) run(t *testing.T, sess *Session, issuer *Issuer) (*Result, *Result, error, error) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	ch := make(chan testResult)
+	go func() {
+		res, err := Server(serverConn, &ServerConfig{
+			HandshakeConfig: &nyquist.HandshakeConfig{
+				Protocol:    env.protocol,
+				Prologue:    []byte("test prologue"),
+				LocalStatic: env.serverStatic,
+			},
+			Issuer: issuer,
+		})
+		if err != nil {
+			// Unblock the client if it is waiting on a response.
+			serverConn.Close()
+		}
+		ch <- testResult{res, err}
+	}()
+
+	res, err := Client(clientConn, &ClientConfig{
+		HandshakeConfig: &nyquist.HandshakeConfig{
+			Protocol:    env.protocol,
+			Prologue:    []byte("test prologue"),
+			LocalStatic: env.clientStatic,
+			IsInitiator: true,
+		},
+		Session: sess,
+		Now:     env.clock,
+	})
+	if err != nil {
+		clientConn.Close()
+	}
+	serverRes := <-ch
+
+	return res, serverRes.res, err, serverRes.err
+}
+
+func (env *testEnv) requireTransport(t *testing.T, client, server *Result) {
+	require := require.New(t)
+
+	ciphertext, err := client.Status.CipherStates[0].EncryptWithAd(nil, nil, []byte("client to server"))
+	require.NoError(err, "client.EncryptWithAd")
+	plaintext, err := server.Status.CipherStates[0].DecryptWithAd(nil, nil, ciphertext)
+	require.NoError(err, "server.DecryptWithAd")
+	require.Equal([]byte("client to server"), plaintext, "client -> server")
+
+	ciphertext, err = server.Status.CipherStates[1].EncryptWithAd(nil, nil, []byte("server to client"))
+	require.NoError(err, "server.EncryptWithAd")
+	plaintext, err = client.Status.CipherStates[1].DecryptWithAd(nil, nil, ciphertext)
+	require.NoError(err, "client.DecryptWithAd")
+	require.Equal([]byte("server to client"), plaintext, "server -> client")
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	require := require.New(t)
+
+	protocol, err := nyquist.NewProtocol(protoXX)
+	require.NoError(err, "NewProtocol")
+	clientStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	serverStatic, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	env := &testEnv{
+		protocol:     protocol,
+		clientStatic: clientStatic,
+		serverStatic: serverStatic,
+		now:          time.Unix(1700000000, 0),
+	}
+	env.issuer = NewIssuer(&IssuerConfig{
+		TicketLifetime:   time.Hour,
+		RotationInterval: 10 * time.Minute,
+		Now:              env.clock,
+	})
+
+	return env
+}
+
+func TestResumption(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Resume", testResumptionResume},
+		{"Expired", testResumptionExpired},
+		{"Rotation", testResumptionRotation},
+		{"Concurrent", testResumptionConcurrent},
+		{"Disabled", testResumptionDisabled},
+		{"WrongSecret", testResumptionWrongSecret},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testResumptionResume(t *testing.T) {
+	require := require.New(t)
+	env := newTestEnv(t)
+
+	client, server, clientErr, serverErr := env.run(t, nil, env.issuer)
+	require.NoError(clientErr, "Client - full")
+	require.NoError(serverErr, "Server - full")
+	require.False(client.Resumed, "client.Resumed - full")
+	require.False(server.Resumed, "server.Resumed - full")
+	require.Equal(env.serverStatic.Public().Bytes(), client.RemoteStatic.Bytes(), "client.RemoteStatic - full")
+	require.Equal(env.clientStatic.Public().Bytes(), server.RemoteStatic.Bytes(), "server.RemoteStatic - full")
+	require.NotNil(client.Session, "client.Session - full")
+	require.Nil(server.Session, "server.Session - full")
+	require.Equal(env.now.Add(time.Hour).Unix(), client.Session.ExpiresAt.Unix(), "client.Session.ExpiresAt")
+	env.requireTransport(t, client, server)
+
+	sess := client.Session
+	for i := 0; i < 3; i++ {
+		env.now = env.now.Add(time.Minute)
+		client, server, clientErr, serverErr = env.run(t, sess, env.issuer)
+		require.NoError(clientErr, "Client - resume %d", i)
+		require.NoError(serverErr, "Server - resume %d", i)
+		require.True(client.Resumed, "client.Resumed - resume %d", i)
+		require.True(server.Resumed, "server.Resumed - resume %d", i)
+		require.Nil(client.Status.RemoteStatic, "client.Status.RemoteStatic - resume %d", i)
+		require.Equal(env.serverStatic.Public().Bytes(), client.RemoteStatic.Bytes(), "client.RemoteStatic - resume %d", i)
+		require.Equal(env.clientStatic.Public().Bytes(), server.RemoteStatic.Bytes(), "server.RemoteStatic - resume %d", i)
+		require.NotNil(client.Session, "client.Session - resume %d", i)
+		require.NotEqual(sess.Ticket, client.Session.Ticket, "client.Session.Ticket - resume %d", i)
+		require.NotEqual(sess.Secret, client.Session.Secret, "client.Session.Secret - resume %d", i)
+		env.requireTransport(t, client, server)
+		sess = client.Session
+	}
+}
+
+func testResumptionExpired(t *testing.T) {
+	require := require.New(t)
+	env := newTestEnv(t)
+
+	client, _, clientErr, serverErr := env.run(t, nil, env.issuer)
+	require.NoError(clientErr, "Client - full")
+	require.NoError(serverErr, "Server - full")
+	sess := client.Session
+
+	// The server rejects the expired ticket, and both sides fall back to a
+	// full handshake.
+	sess.ExpiresAt = sess.ExpiresAt.Add(time.Hour)
+	env.now = env.now.Add(time.Hour + time.Second)
+	client, server, clientErr, serverErr := env.run(t, sess, env.issuer)
+	require.NoError(clientErr, "Client - expired")
+	require.NoError(serverErr, "Server - expired")
+	require.False(client.Resumed, "client.Resumed - expired")
+	require.False(server.Resumed, "server.Resumed - expired")
+	require.NotNil(client.Session, "client.Session - expired")
+	env.requireTransport(t, client, server)
+
+	// The client does not attempt to use a session that it knows is
+	// expired.
+	_, err := env.issuer.open(sess.Ticket)
+	require.ErrorIs(err, ErrTicketExpired, "open - expired")
+	sess.ExpiresAt = env.now
+	client, server, clientErr, serverErr = env.run(t, sess, nil)
+	require.NoError(clientErr, "Client - client expired")
+	require.NoError(serverErr, "Server - client expired")
+	require.False(client.Resumed, "client.Resumed - client expired")
+	require.Nil(client.Session, "client.Session - no issuer")
+	env.requireTransport(t, client, server)
+}
+
+func testResumptionRotation(t *testing.T) {
+	require := require.New(t)
+	env := newTestEnv(t)
+
+	client, _, clientErr, serverErr := env.run(t, nil, env.issuer)
+	require.NoError(clientErr, "Client - full")
+	require.NoError(serverErr, "Server - full")
+	oldSess := client.Session
+
+	// After a rotation, tickets encrypted with the retired key are still
+	// accepted.
+	env.now = env.now.Add(15 * time.Minute)
+	client, _, clientErr, serverErr = env.run(t, oldSess, env.issuer)
+	require.NoError(clientErr, "Client - rotated")
+	require.NoError(serverErr, "Server - rotated")
+	require.True(client.Resumed, "client.Resumed - rotated")
+	require.Len(env.issuer.keys, 2, "keys - rotated")
+	require.NotEqual(oldSess.Ticket[:keyIDSize], client.Session.Ticket[:keyIDSize], "key ID - rotated")
+
+	// Once every ticket encrypted with a retired key has expired, the key
+	// is discarded.
+	env.now = env.now.Add(time.Hour + time.Second)
+	_, err := env.issuer.open(oldSess.Ticket)
+	require.ErrorIs(err, ErrInvalidTicket, "open - discarded key")
+	require.Len(env.issuer.keys, 1, "keys - discarded")
+
+	// Tickets from other issuers are rejected.
+	other := NewIssuer(&IssuerConfig{Now: env.clock})
+	client, server, clientErr, serverErr := env.run(t, nil, other)
+	require.NoError(clientErr, "Client - other issuer")
+	require.NoError(serverErr, "Server - other issuer")
+	client, server, clientErr, serverErr = env.run(t, client.Session, env.issuer)
+	require.NoError(clientErr, "Client - other issuer")
+	require.NoError(serverErr, "Server - other issuer")
+	require.False(client.Resumed, "client.Resumed - other issuer")
+	env.requireTransport(t, client, server)
+}
+
+func testResumptionConcurrent(t *testing.T) {
+	require := require.New(t)
+
+	// Open tickets while other goroutines rotate and expire keys, so that
+	// data races are caught by the race detector.
+	var (
+		l   sync.Mutex
+		now = time.Unix(1700000000, 0)
+	)
+	issuer := NewIssuer(&IssuerConfig{
+		TicketLifetime:   10 * time.Second,
+		RotationInterval: time.Second,
+		Now: func() time.Time {
+			l.Lock()
+			defer l.Unlock()
+			now = now.Add(time.Second)
+			return now
+		},
+	})
+	secret := make([]byte, nyquist.PreSharedKeySize)
+
+	var (
+		wg       sync.WaitGroup
+		nrOpened atomic.Int32
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var tickets [][]byte
+			for j := 0; j < 200; j++ {
+				b, _, err := issuer.issue(secret, nil)
+				if err != nil {
+					continue
+				}
+				tickets = append(tickets, b)
+				for _, b := range tickets[max(0, len(tickets)-20):] {
+					if tk, err := issuer.open(b); err == nil && bytes.Equal(secret, tk.secret) {
+						nrOpened.Add(1)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NotZero(nrOpened.Load(), "open")
+}
+
+func testResumptionDisabled(t *testing.T) {
+	require := require.New(t)
+	env := newTestEnv(t)
+
+	client, _, clientErr, serverErr := env.run(t, nil, env.issuer)
+	require.NoError(clientErr, "Client - full")
+	require.NoError(serverErr, "Server - full")
+
+	client, server, clientErr, serverErr := env.run(t, client.Session, nil)
+	require.NoError(clientErr, "Client - disabled")
+	require.NoError(serverErr, "Server - disabled")
+	require.False(client.Resumed, "client.Resumed - disabled")
+	require.Nil(client.Session, "client.Session - disabled")
+	env.requireTransport(t, client, server)
+}
+
+func testResumptionWrongSecret(t *testing.T) {
+	require := require.New(t)
+	env := newTestEnv(t)
+
+	client, _, clientErr, serverErr := env.run(t, nil, env.issuer)
+	require.NoError(clientErr, "Client - full")
+	require.NoError(serverErr, "Server - full")
+
+	// A stolen ticket without the resumption secret fails the handshake,
+	// instead of falling back to a full handshake.
+	sess := *client.Session
+	sess.Secret = make([]byte, len(sess.Secret))
+	_, _, clientErr, serverErr = env.run(t, &sess, env.issuer)
+	require.Error(clientErr, "Client - wrong secret")
+	require.ErrorIs(serverErr, nyquist.ErrOpen, "Server - wrong secret")
+}
@@ -0,0 +1,273 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package resumption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+const (
+	// DefaultTicketLifetime is the default ticket lifetime.
+	DefaultTicketLifetime = 24 * time.Hour
+
+	// DefaultRotationInterval is the default ticket encryption key
+	// rotation interval.
+	DefaultRotationInterval = 6 * time.Hour
+
+	ticketVersion = 1
+	keyIDSize     = 8
+	maxStaticSize = 255
+)
+
+var (
+	// ErrInvalidTicket is the error returned when a ticket is malformed,
+	// or was not issued by the Issuer (or its key has been retired).
+	ErrInvalidTicket = errors.New("nyquist/resumption: invalid ticket")
+
+	// ErrTicketExpired is the error returned when a ticket has expired.
+	ErrTicketExpired = errors.New("nyquist/resumption: ticket expired")
+
+	errStaticTooLarge = errors.New("nyquist/resumption: remote static key too large")
+)
+
+// IssuerConfig is the ticket issuer configuration.
+type IssuerConfig struct {
+	// TicketLifetime is the duration for which issued tickets are valid.
+	// If 0, `DefaultTicketLifetime` will be used.
+	TicketLifetime time.Duration
+
+	// RotationInterval is the interval at which the ticket encryption key
+	// is rotated.  Retired keys are kept until every ticket encrypted with
+	// them has expired.  If 0, `DefaultRotationInterval` will be used.
+	RotationInterval time.Duration
+
+	// Now is the clock, if nil `time.Now` will be used.
+	Now func() time.Time
+
+	// Rng is the entropy source, if nil `crypto/rand.Reader` will be used.
+	Rng io.Reader
+}
+
+type ticketKey struct {
+	id        [keyIDSize]byte
+	key       [chacha20poly1305.KeySize]byte
+	createdAt time.Time
+}
+
+// Issuer issues and opens encrypted session tickets.  It is safe for
+// concurrent use.
+type Issuer struct {
+	l sync.Mutex
+
+	keys []*ticketKey // Newest first.
+
+	lifetime time.Duration
+	interval time.Duration
+	now      func() time.Time
+	rng      io.Reader
+}
+
+// ticket is the decrypted contents of a session ticket.
+type ticket struct {
+	issuedAt     time.Time
+	expiresAt    time.Time
+	secret       []byte
+	remoteStatic []byte
+}
+
+// issue encrypts a new ticket for the resumption secret, and the remote
+// static public key authenticated by the handshake (if any).
+func (iss *Issuer) issue(secret, remoteStatic []byte) ([]byte, time.Time, error) {
+	if len(remoteStatic) > maxStaticSize {
+		return nil, time.Time{}, errStaticTooLarge
+	}
+
+	iss.l.Lock()
+	defer iss.l.Unlock()
+
+	now := iss.now()
+	k, err := iss.currentKey(now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expiresAt := now.Add(iss.lifetime)
+
+	pt := make([]byte, 0, 1+8+8+len(secret)+1+len(remoteStatic))
+	pt = append(pt, ticketVersion)
+	pt = binary.BigEndian.AppendUint64(pt, uint64(now.Unix()))
+	pt = binary.BigEndian.AppendUint64(pt, uint64(expiresAt.Unix()))
+	pt = append(pt, secret...)
+	pt = append(pt, byte(len(remoteStatic)))
+	pt = append(pt, remoteStatic...)
+
+	aead, err := chacha20poly1305.NewX(k.key[:])
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	out := make([]byte, keyIDSize+chacha20poly1305.NonceSizeX, keyIDSize+chacha20poly1305.NonceSizeX+len(pt)+aead.Overhead())
+	copy(out, k.id[:])
+	if _, err = io.ReadFull(iss.rng, out[keyIDSize:]); err != nil {
+		return nil, time.Time{}, err
+	}
+	out = aead.Seal(out, out[keyIDSize:], pt, out[:keyIDSize])
+
+	return out, expiresAt, nil
+}
+
+// open decrypts and validates a ticket.
+func (iss *Issuer) open(b []byte) (*ticket, error) {
+	if len(b) < keyIDSize+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return nil, ErrInvalidTicket
+	}
+
+	now, aead, err := iss.ticketAEAD(b[:keyIDSize])
+	if err != nil {
+		return nil, err
+	}
+	nonce := b[keyIDSize : keyIDSize+chacha20poly1305.NonceSizeX]
+	pt, err := aead.Open(nil, nonce, b[keyIDSize+chacha20poly1305.NonceSizeX:], b[:keyIDSize])
+	if err != nil {
+		return nil, ErrInvalidTicket
+	}
+
+	const fixedSize = 1 + 8 + 8 + nyquist.PreSharedKeySize + 1
+	if len(pt) < fixedSize || pt[0] != ticketVersion {
+		return nil, ErrInvalidTicket
+	}
+	t := &ticket{
+		issuedAt:  time.Unix(int64(binary.BigEndian.Uint64(pt[1:])), 0),
+		expiresAt: time.Unix(int64(binary.BigEndian.Uint64(pt[9:])), 0),
+		secret:    pt[17 : 17+nyquist.PreSharedKeySize],
+	}
+	rest := pt[fixedSize:]
+	if staticLen := int(pt[fixedSize-1]); len(rest) != staticLen {
+		return nil, ErrInvalidTicket
+	}
+	if len(rest) > 0 {
+		t.remoteStatic = rest
+	}
+	if now.After(t.expiresAt) {
+		return nil, ErrTicketExpired
+	}
+
+	return t, nil
+}
+
+// ticketAEAD returns the current time, and the AEAD instance for the key
+// with the key ID.  The AEAD instance is created with the lock held, as
+// expired keys are cleared.
+func (iss *Issuer) ticketAEAD(id []byte) (time.Time, cipher.AEAD, error) {
+	iss.l.Lock()
+	defer iss.l.Unlock()
+
+	now := iss.now()
+	iss.expireKeys(now)
+	for _, k := range iss.keys {
+		if string(k.id[:]) == string(id) {
+			aead, err := chacha20poly1305.NewX(k.key[:])
+			return now, aead, err
+		}
+	}
+
+	return now, nil, ErrInvalidTicket
+}
+
+// currentKey returns the key to use for issuing tickets, rotating keys if
+// required.  It must be called with the lock held.
+func (iss *Issuer) currentKey(now time.Time) (*ticketKey, error) {
+	iss.expireKeys(now)
+	if len(iss.keys) > 0 && now.Sub(iss.keys[0].createdAt) < iss.interval {
+		return iss.keys[0], nil
+	}
+
+	k := &ticketKey{
+		createdAt: now,
+	}
+	if _, err := io.ReadFull(iss.rng, k.id[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(iss.rng, k.key[:]); err != nil {
+		return nil, err
+	}
+	iss.keys = append([]*ticketKey{k}, iss.keys...)
+
+	return k, nil
+}
+
+// expireKeys discards keys that were retired long enough ago that every
+// ticket encrypted with them has expired.  It must be called with the lock
+// held.
+func (iss *Issuer) expireKeys(now time.Time) {
+	for i := 1; i < len(iss.keys); i++ {
+		// A key is retired when its successor was created.
+		retiredAt := iss.keys[i-1].createdAt
+		if now.Sub(retiredAt) > iss.lifetime {
+			for _, k := range iss.keys[i:] {
+				k.key = [chacha20poly1305.KeySize]byte{}
+			}
+			iss.keys = iss.keys[:i]
+			break
+		}
+	}
+}
+
+// NewIssuer creates a new ticket Issuer.
+func NewIssuer(cfg *IssuerConfig) *Issuer {
+	iss := &Issuer{
+		lifetime: cfg.TicketLifetime,
+		interval: cfg.RotationInterval,
+		now:      cfg.Now,
+		rng:      cfg.Rng,
+	}
+	if iss.lifetime <= 0 {
+		iss.lifetime = DefaultTicketLifetime
+	}
+	if iss.interval <= 0 {
+		iss.interval = DefaultRotationInterval
+	}
+	if iss.now == nil {
+		iss.now = time.Now
+	}
+	if iss.rng == nil {
+		iss.rng = rand.Reader
+	}
+
+	return iss
+}
@@ -0,0 +1,319 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package sealedbox implements one-shot "encrypt to a public key" messages
+// using the one-way handshake patterns.
+//
+// The pattern is chosen based on the sender:
+//
+//   - Anonymous senders use `N`.
+//   - Authenticated senders use `X`, which transmits the sender's static
+//     key to the recipient.
+//   - Senders whose static key is known to the recipient use `K`.
+//
+// The `psk` variants (`Npsk0`, `Kpsk0`, `Xpsk1`) are used if a pre-shared
+// key is provided.  Boxes are not forward secret with respect to the
+// recipient's static key, and provide no replay protection.
+package sealedbox // import "gitlab.com/yawning/nyquist.git/sealedbox"
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/cipher"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+const (
+	// Version is the envelope format version.
+	Version = 1
+
+	prologueLabel = "NyquistSealedBox"
+)
+
+var (
+	// ErrInvalidBox is the error returned when a box is malformed, or uses
+	// an unsupported version or protocol.
+	ErrInvalidBox = errors.New("nyquist/sealedbox: invalid box")
+
+	// ErrOpen is the error returned when a box fails to authenticate.
+	ErrOpen = errors.New("nyquist/sealedbox: failed to open box")
+
+	// ErrUnexpectedSender is the error returned when the box's sender does
+	// not match the expected sender.
+	ErrUnexpectedSender = errors.New("nyquist/sealedbox: unexpected sender")
+
+	// ErrPreSharedKeyMismatch is the error returned when the presence of a
+	// pre-shared key does not match the box's protocol.
+	ErrPreSharedKeyMismatch = errors.New("nyquist/sealedbox: pre-shared key mismatch")
+
+	errKnownAnonymous = errors.New("nyquist/sealedbox: known sender requires a sender keypair")
+
+	supportedPatterns = map[string]pattern.Pattern{
+		pattern.N.String():     pattern.N,
+		pattern.K.String():     pattern.K,
+		pattern.X.String():     pattern.X,
+		pattern.Npsk0.String(): pattern.Npsk0,
+		pattern.Kpsk0.String(): pattern.Kpsk0,
+		pattern.Xpsk1.String(): pattern.Xpsk1,
+	}
+)
+
+// SealOptions are the options for sealing a box.
+type SealOptions struct {
+	// Sender is the sender's static keypair.  If nil, the sender is
+	// anonymous.
+	Sender dh.Keypair
+
+	// SenderKnown indicates that the recipient already knows the sender's
+	// static public key, so it is not transmitted.
+	SenderKnown bool
+
+	// PreSharedKey is the optional pre-shared key.
+	PreSharedKey []byte
+
+	// AssociatedData is the optional associated data, which must be
+	// provided when opening the box.
+	AssociatedData []byte
+
+	// DH is the DH function.  If nil, `dh.X25519` will be used.
+	DH dh.DH
+
+	// Cipher is the cipher function.  If nil, `cipher.ChaChaPoly` will be
+	// used.
+	Cipher cipher.Cipher
+
+	// Hash is the hash function.  If nil, `hash.BLAKE2s` will be used.
+	Hash hash.Hash
+
+	// Rng is the entropy source.  If nil, `crypto/rand.Reader` will be
+	// used.
+	Rng io.Reader
+}
+
+func (opts *SealOptions) protocol() (*nyquist.Protocol, error) {
+	var pa pattern.Pattern
+	switch {
+	case opts.Sender == nil:
+		if opts.SenderKnown {
+			return nil, errKnownAnonymous
+		}
+		pa = pattern.N
+	case opts.SenderKnown:
+		pa = pattern.K
+	default:
+		pa = pattern.X
+	}
+	if opts.PreSharedKey != nil {
+		switch pa {
+		case pattern.N:
+			pa = pattern.Npsk0
+		case pattern.K:
+			pa = pattern.Kpsk0
+		case pattern.X:
+			pa = pattern.Xpsk1
+		}
+	}
+
+	protocol := &nyquist.Protocol{
+		Pattern: pa,
+		DH:      opts.DH,
+		Cipher:  opts.Cipher,
+		Hash:    opts.Hash,
+	}
+	if protocol.DH == nil {
+		protocol.DH = dh.X25519
+	}
+	if protocol.Cipher == nil {
+		protocol.Cipher = cipher.ChaChaPoly
+	}
+	if protocol.Hash == nil {
+		protocol.Hash = hash.BLAKE2s
+	}
+
+	return protocol, nil
+}
+
+// OpenOptions are the options for opening a box.
+type OpenOptions struct {
+	// Sender is the expected sender's static public key.  It is required
+	// to open boxes sealed with `SealOptions.SenderKnown`.  If set, boxes
+	// from anonymous or other senders are rejected.
+	Sender dh.PublicKey
+
+	// PreSharedKey is the pre-shared key, which must be provided iff the
+	// box was sealed with one.
+	PreSharedKey []byte
+
+	// AssociatedData is the associated data provided when the box was
+	// sealed.
+	AssociatedData []byte
+}
+
+// Seal encrypts and authenticates the plaintext to the recipient's static
+// public key, and returns the box.  The options may be nil, in which case
+// the sender is anonymous.
+func Seal(recipient dh.PublicKey, plaintext []byte, opts *SealOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SealOptions{}
+	}
+	protocol, err := opts.protocol()
+	if err != nil {
+		return nil, err
+	}
+
+	hsCfg := &nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		Prologue:       prologue(opts.AssociatedData),
+		LocalStatic:    opts.Sender,
+		RemoteStatic:   recipient,
+		Rng:            opts.Rng,
+		MaxMessageSize: -1,
+		IsInitiator:    true,
+	}
+	if opts.PreSharedKey != nil {
+		hsCfg.PreSharedKeys = [][]byte{opts.PreSharedKey}
+	}
+	hs, err := nyquist.NewHandshake(hsCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	name := protocol.String()
+	if len(name) > math.MaxUint8 {
+		return nil, ErrInvalidBox
+	}
+	box := make([]byte, 0, 2+len(name)+len(plaintext)+256)
+	box = append(box, Version, uint8(len(name)))
+	box = append(box, name...)
+	if box, err = hs.WriteMessage(box, plaintext); err != nyquist.ErrDone {
+		return nil, err
+	}
+
+	return box, nil
+}
+
+// Open authenticates and decrypts the box with the recipient's static
+// keypair, and returns the plaintext, and the sender's static public key
+// (nil if the sender is anonymous).  The options may be nil.
+func Open(recipient dh.Keypair, box []byte, opts *OpenOptions) ([]byte, dh.PublicKey, error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	protocol, msg, err := parseBox(box)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hsCfg := &nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		Prologue:       prologue(opts.AssociatedData),
+		LocalStatic:    recipient,
+		MaxMessageSize: -1,
+	}
+	if (protocol.Pattern.NumPSKs() > 0) != (opts.PreSharedKey != nil) {
+		return nil, nil, ErrPreSharedKeyMismatch
+	}
+	if opts.PreSharedKey != nil {
+		hsCfg.PreSharedKeys = [][]byte{opts.PreSharedKey}
+	}
+	switch protocol.Pattern {
+	case pattern.N, pattern.Npsk0:
+		if opts.Sender != nil {
+			return nil, nil, ErrUnexpectedSender
+		}
+	case pattern.K, pattern.Kpsk0:
+		if opts.Sender == nil {
+			return nil, nil, ErrUnexpectedSender
+		}
+		hsCfg.RemoteStatic = opts.Sender
+	}
+
+	hs, err := nyquist.NewHandshake(hsCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer hs.Reset()
+
+	plaintext, err := hs.ReadMessage(nil, msg)
+	if err != nyquist.ErrDone {
+		return nil, nil, ErrOpen
+	}
+
+	sender := hs.GetStatus().RemoteStatic
+	if opts.Sender != nil && !bytes.Equal(opts.Sender.Bytes(), sender.Bytes()) {
+		return nil, nil, ErrUnexpectedSender
+	}
+
+	return plaintext, sender, nil
+}
+
+// ProtocolName returns the protocol name recorded in the box's envelope.
+func ProtocolName(box []byte) (string, error) {
+	protocol, _, err := parseBox(box)
+	if err != nil {
+		return "", err
+	}
+	return protocol.String(), nil
+}
+
+func parseBox(box []byte) (*nyquist.Protocol, []byte, error) {
+	if len(box) < 2 || box[0] != Version {
+		return nil, nil, ErrInvalidBox
+	}
+	nameLen := int(box[1])
+	if len(box) < 2+nameLen {
+		return nil, nil, ErrInvalidBox
+	}
+
+	protocol, err := nyquist.NewProtocol(string(box[2 : 2+nameLen]))
+	if err != nil {
+		return nil, nil, ErrInvalidBox
+	}
+	pa, ok := supportedPatterns[protocol.Pattern.String()]
+	if !ok || protocol.Sig != nil {
+		return nil, nil, ErrInvalidBox
+	}
+	protocol.Pattern = pa
+
+	return protocol, box[2+nameLen:], nil
+}
+
+func prologue(ad []byte) []byte {
+	p := make([]byte, 0, len(prologueLabel)+1+len(ad))
+	p = append(p, prologueLabel...)
+	p = append(p, Version)
+	return append(p, ad...)
+}
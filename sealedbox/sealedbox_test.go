@@ -0,0 +1,234 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sealedbox
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/cipher"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+)
+
+func mustGenerateKeypair(t *testing.T, dhImpl dh.DH) dh.Keypair {
+	kp, err := dhImpl.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+func TestSealedBox(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Modes", testSealedBoxModes},
+		{"Options", testSealedBoxOptions},
+		{"Failures", testSealedBoxFailures},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testSealedBoxModes(t *testing.T) {
+	recipient, sender := mustGenerateKeypair(t, dh.X25519), mustGenerateKeypair(t, dh.X25519)
+	psk := make([]byte, nyquist.PreSharedKeySize)
+	_, _ = rand.Read(psk)
+
+	for _, v := range []struct {
+		n              string
+		sealOpts       *SealOptions
+		openOpts       *OpenOptions
+		expectedSender dh.PublicKey
+	}{
+		{"N", nil, nil, nil},
+		{
+			"X",
+			&SealOptions{Sender: sender},
+			nil,
+			sender.Public(),
+		},
+		{
+			"K",
+			&SealOptions{Sender: sender, SenderKnown: true},
+			&OpenOptions{Sender: sender.Public()},
+			sender.Public(),
+		},
+		{
+			"Npsk0",
+			&SealOptions{PreSharedKey: psk},
+			&OpenOptions{PreSharedKey: psk},
+			nil,
+		},
+		{
+			"Xpsk1",
+			&SealOptions{Sender: sender, PreSharedKey: psk},
+			&OpenOptions{Sender: sender.Public(), PreSharedKey: psk},
+			sender.Public(),
+		},
+		{
+			"Kpsk0",
+			&SealOptions{Sender: sender, SenderKnown: true, PreSharedKey: psk},
+			&OpenOptions{Sender: sender.Public(), PreSharedKey: psk},
+			sender.Public(),
+		},
+	} {
+		t.Run(v.n, func(t *testing.T) {
+			require := require.New(t)
+
+			plaintext := []byte("sealed box plaintext: " + v.n)
+			box, err := Seal(recipient.Public(), plaintext, v.sealOpts)
+			require.NoError(err, "Seal")
+
+			name, err := ProtocolName(box)
+			require.NoError(err, "ProtocolName")
+			require.Equal("Noise_"+v.n+"_25519_ChaChaPoly_BLAKE2s", name, "ProtocolName")
+
+			opened, openedSender, err := Open(recipient, box, v.openOpts)
+			require.NoError(err, "Open")
+			require.Equal(plaintext, opened, "Open - plaintext")
+			if v.expectedSender == nil {
+				require.Nil(openedSender, "Open - anonymous sender")
+			} else {
+				require.Equal(v.expectedSender.Bytes(), openedSender.Bytes(), "Open - sender")
+			}
+
+			// Each box uses a fresh ephemeral key.
+			box2, err := Seal(recipient.Public(), plaintext, v.sealOpts)
+			require.NoError(err, "Seal - again")
+			require.NotEqual(box, box2, "Seal - again")
+		})
+	}
+}
+
+func testSealedBoxOptions(t *testing.T) {
+	require := require.New(t)
+
+	recipient := mustGenerateKeypair(t, dh.X448)
+	sealOpts := &SealOptions{
+		AssociatedData: []byte("associated data"),
+		DH:             dh.X448,
+		Cipher:         cipher.AESGCM,
+		Hash:           hash.SHA512,
+	}
+
+	// Larger than the default maximum message size.
+	plaintext := make([]byte, 2*nyquist.DefaultMaxMessageSize)
+	_, _ = rand.Read(plaintext)
+
+	box, err := Seal(recipient.Public(), plaintext, sealOpts)
+	require.NoError(err, "Seal")
+	name, err := ProtocolName(box)
+	require.NoError(err, "ProtocolName")
+	require.Equal("Noise_N_448_AESGCM_SHA512", name, "ProtocolName")
+
+	opened, _, err := Open(recipient, box, &OpenOptions{AssociatedData: sealOpts.AssociatedData})
+	require.NoError(err, "Open")
+	require.Equal(plaintext, opened, "Open - plaintext")
+
+	_, _, err = Open(recipient, box, &OpenOptions{AssociatedData: []byte("other data")})
+	require.ErrorIs(err, ErrOpen, "Open - wrong associated data")
+	_, _, err = Open(recipient, box, nil)
+	require.ErrorIs(err, ErrOpen, "Open - missing associated data")
+
+	_, err = Seal(recipient.Public(), plaintext, &SealOptions{SenderKnown: true})
+	require.Error(err, "Seal - known anonymous sender")
+}
+
+func testSealedBoxFailures(t *testing.T) {
+	require := require.New(t)
+
+	recipient, sender, other := mustGenerateKeypair(t, dh.X25519), mustGenerateKeypair(t, dh.X25519), mustGenerateKeypair(t, dh.X25519)
+	psk := make([]byte, nyquist.PreSharedKeySize)
+
+	plaintext := []byte("sealed box plaintext")
+	box, err := Seal(recipient.Public(), plaintext, &SealOptions{Sender: sender})
+	require.NoError(err, "Seal")
+
+	_, _, err = Open(other, box, nil)
+	require.ErrorIs(err, ErrOpen, "Open - wrong recipient")
+
+	for i := range box {
+		tampered := append([]byte{}, box...)
+		tampered[i] ^= 0x01
+		_, _, err = Open(recipient, tampered, nil)
+		require.Error(err, "Open - tampered byte %d", i)
+	}
+
+	_, _, err = Open(recipient, box[:len(box)-1], nil)
+	require.ErrorIs(err, ErrOpen, "Open - truncated")
+
+	// Sender expectations.
+	_, _, err = Open(recipient, box, &OpenOptions{Sender: other.Public()})
+	require.ErrorIs(err, ErrUnexpectedSender, "Open - X, other sender")
+
+	anonBox, err := Seal(recipient.Public(), plaintext, nil)
+	require.NoError(err, "Seal - anonymous")
+	_, _, err = Open(recipient, anonBox, &OpenOptions{Sender: sender.Public()})
+	require.ErrorIs(err, ErrUnexpectedSender, "Open - N, expected sender")
+
+	knownBox, err := Seal(recipient.Public(), plaintext, &SealOptions{Sender: sender, SenderKnown: true})
+	require.NoError(err, "Seal - known")
+	_, _, err = Open(recipient, knownBox, nil)
+	require.ErrorIs(err, ErrUnexpectedSender, "Open - K, no sender")
+	_, _, err = Open(recipient, knownBox, &OpenOptions{Sender: other.Public()})
+	require.ErrorIs(err, ErrOpen, "Open - K, other sender")
+
+	// Pre-shared keys.
+	_, _, err = Open(recipient, box, &OpenOptions{PreSharedKey: psk})
+	require.ErrorIs(err, ErrPreSharedKeyMismatch, "Open - unexpected PSK")
+	pskBox, err := Seal(recipient.Public(), plaintext, &SealOptions{PreSharedKey: psk})
+	require.NoError(err, "Seal - PSK")
+	_, _, err = Open(recipient, pskBox, nil)
+	require.ErrorIs(err, ErrPreSharedKeyMismatch, "Open - missing PSK")
+	otherPSK := make([]byte, nyquist.PreSharedKeySize)
+	otherPSK[0] = 1
+	_, _, err = Open(recipient, pskBox, &OpenOptions{PreSharedKey: otherPSK})
+	require.ErrorIs(err, ErrOpen, "Open - wrong PSK")
+
+	// Malformed envelopes.
+	for _, v := range []struct {
+		n   string
+		box []byte
+	}{
+		{"empty", nil},
+		{"version", append([]byte{Version + 1}, box[1:]...)},
+		{"truncated name", box[:10]},
+		{"interactive pattern", append([]byte{Version, 33}, "Noise_NN_25519_ChaChaPoly_BLAKE2s"...)},
+	} {
+		_, _, err = Open(recipient, v.box, nil)
+		require.ErrorIs(err, ErrInvalidBox, "Open - %s", v.n)
+		_, err = ProtocolName(v.box)
+		require.ErrorIs(err, ErrInvalidBox, "ProtocolName - %s", v.n)
+	}
+}
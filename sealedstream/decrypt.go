@@ -0,0 +1,238 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sealedstream
+
+import (
+	"bufio"
+	"io"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+// Decryptor decrypts a stream sequentially.
+type Decryptor struct {
+	r *bufio.Reader
+	h *header
+
+	ctBuf []byte
+	ptBuf []byte
+	pt    []byte
+	index uint64
+	err   error
+}
+
+// Sender returns the sender's static public key, or nil if the sender is
+// anonymous.
+func (d *Decryptor) Sender() dh.PublicKey {
+	return d.h.sender
+}
+
+// Read reads and decrypts data from the stream.  Plaintext is only
+// returned after the chunk containing it has been authenticated, and
+// `io.EOF` is only returned after the final chunk.
+func (d *Decryptor) Read(p []byte) (int, error) {
+	for len(d.pt) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.pt, d.err = d.readChunk()
+	}
+
+	n := copy(p, d.pt)
+	d.pt = d.pt[n:]
+	return n, nil
+}
+
+func (d *Decryptor) readChunk() ([]byte, error) {
+	n, err := io.ReadFull(d.r, d.ctBuf)
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		if n < d.h.overhead {
+			return nil, ErrTruncated
+		}
+	default:
+		return nil, err
+	}
+
+	isFinal := n < len(d.ctBuf)
+	if !isFinal {
+		// A full chunk is the final chunk iff it is followed by EOF.
+		if _, err = d.r.Peek(1); err == io.EOF {
+			isFinal = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	pt, err := d.h.decryptChunk(d.ptBuf[:0], d.ctBuf[:n], d.index, isFinal)
+	if err != nil {
+		return nil, err
+	}
+	d.index++
+	if isFinal {
+		d.h.cs.Reset()
+		return pt, io.EOF
+	}
+
+	return pt, nil
+}
+
+// NewDecryptor reads the header from r, and returns a Decryptor using the
+// recipient's static keypair.  The options may be nil.
+func NewDecryptor(r io.Reader, recipient dh.Keypair, opts *DecryptOptions) (*Decryptor, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br, recipient, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decryptor{
+		r:     br,
+		h:     h,
+		ctBuf: make([]byte, h.chunkSize+h.overhead),
+		ptBuf: make([]byte, 0, h.chunkSize),
+	}, nil
+}
+
+// RandomAccessDecryptor decrypts a stream with random access, by chunk
+// index.  It is not safe for concurrent use.
+type RandomAccessDecryptor struct {
+	r io.ReaderAt
+	h *header
+
+	numChunks uint64
+	lastLen   int64
+	ctBuf     []byte
+}
+
+// Sender returns the sender's static public key, or nil if the sender is
+// anonymous.
+func (d *RandomAccessDecryptor) Sender() dh.PublicKey {
+	return d.h.sender
+}
+
+// ChunkSize returns the plaintext size of each chunk except the final
+// chunk.
+func (d *RandomAccessDecryptor) ChunkSize() int {
+	return d.h.chunkSize
+}
+
+// NumChunks returns the number of chunks in the stream.
+func (d *RandomAccessDecryptor) NumChunks() uint64 {
+	return d.numChunks
+}
+
+// Size returns the plaintext size of the stream.
+func (d *RandomAccessDecryptor) Size() int64 {
+	return int64(d.numChunks-1)*int64(d.h.chunkSize) + d.lastLen - int64(d.h.overhead)
+}
+
+// ReadChunk decrypts the chunk at the index, appending the plaintext to
+// dst, and returning the potentially new slice.
+func (d *RandomAccessDecryptor) ReadChunk(dst []byte, index uint64) ([]byte, error) {
+	if index >= d.numChunks {
+		return nil, errChunkIndex
+	}
+
+	encChunkSize := int64(d.h.chunkSize + d.h.overhead)
+	ct := d.ctBuf
+	isFinal := index == d.numChunks-1
+	if isFinal {
+		ct = ct[:d.lastLen]
+	}
+	if n, err := d.r.ReadAt(ct, d.h.size+int64(index)*encChunkSize); n != len(ct) {
+		if err == io.EOF {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	return d.h.decryptChunk(dst, ct, index, isFinal)
+}
+
+// ReadAt decrypts len(p) bytes of plaintext starting at offset off.  The
+// returned data is authenticated, and it implements `io.ReaderAt`, so
+// `io.NewSectionReader(d, 0, d.Size())` can be used to seek.
+func (d *RandomAccessDecryptor) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	var (
+		n     int
+		chunk []byte
+	)
+	chunkSize := int64(d.h.chunkSize)
+	for len(p) > 0 {
+		if off >= d.Size() {
+			return n, io.EOF
+		}
+		var err error
+		if chunk, err = d.ReadChunk(chunk[:0], uint64(off/chunkSize)); err != nil {
+			return n, err
+		}
+		copied := copy(p, chunk[off%chunkSize:])
+		n += copied
+		off += int64(copied)
+		p = p[copied:]
+	}
+
+	return n, nil
+}
+
+// NewRandomAccessDecryptor reads the header from r, which contains size
+// bytes, and returns a RandomAccessDecryptor using the recipient's static
+// keypair.  The options may be nil.
+func NewRandomAccessDecryptor(r io.ReaderAt, size int64, recipient dh.Keypair, opts *DecryptOptions) (*RandomAccessDecryptor, error) {
+	h, err := readHeader(io.NewSectionReader(r, 0, size), recipient, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadSize := size - h.size
+	if payloadSize < int64(h.overhead) {
+		return nil, ErrTruncated
+	}
+	encChunkSize := int64(h.chunkSize + h.overhead)
+	numChunks := (payloadSize + encChunkSize - 1) / encChunkSize
+	lastLen := payloadSize - (numChunks-1)*encChunkSize
+	if lastLen < int64(h.overhead) {
+		return nil, ErrTruncated
+	}
+
+	return &RandomAccessDecryptor{
+		r:         r,
+		h:         h,
+		numChunks: uint64(numChunks),
+		lastLen:   lastLen,
+		ctBuf:     make([]byte, encChunkSize),
+	}, nil
+}
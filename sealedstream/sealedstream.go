@@ -0,0 +1,404 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package sealedstream implements a streaming encryption format for large
+// files, encrypted to a recipient's static key using the one-way handshake
+// patterns.
+//
+// The format consists of a header, followed by a sequence of chunks:
+//
+//	header = version (1 byte, 0x01) ||
+//	         protocol name length (1 byte) || protocol name ||
+//	         chunk size (4 bytes, big-endian) ||
+//	         handshake message length (2 bytes, big-endian) ||
+//	         handshake message
+//	chunks = chunk[0] || chunk[1] || ... || chunk[n-1]
+//
+// The handshake message uses `N` for anonymous senders, and `X` for
+// authenticated senders, with an empty payload, and the prologue:
+//
+//	"NyquistSealedStream" || version || chunk size (4 bytes, big-endian)
+//
+// Chunk `i` is the plaintext encrypted with the resulting `cs1`, using `i`
+// as the nonce, and a single byte of associated data, which is 0x01 for the
+// final chunk and 0x00 otherwise.  Every chunk except the final chunk
+// contains exactly chunk size bytes of plaintext.  The final chunk contains
+// between 1 and chunk size bytes of plaintext, and is only empty if the
+// entire plaintext is empty.  A stream without a final chunk is truncated,
+// and is rejected.
+package sealedstream // import "gitlab.com/yawning/nyquist.git/sealedstream"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/cipher"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/hash"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+const (
+	// Version is the format version.
+	Version = 1
+
+	// DefaultChunkSize is the default chunk size.
+	DefaultChunkSize = 64 * 1024
+
+	// MaxChunkSize is the maximum chunk size.
+	MaxChunkSize = 16 * 1024 * 1024
+
+	prologueLabel = "NyquistSealedStream"
+
+	adChunk      = 0x00
+	adFinalChunk = 0x01
+)
+
+var (
+	// ErrInvalidHeader is the error returned when a header is malformed,
+	// or uses an unsupported version or protocol.
+	ErrInvalidHeader = errors.New("nyquist/sealedstream: invalid header")
+
+	// ErrTruncated is the error returned when a stream is truncated.
+	ErrTruncated = errors.New("nyquist/sealedstream: truncated stream")
+
+	// ErrInvalidChunk is the error returned when a chunk fails to
+	// authenticate.
+	ErrInvalidChunk = errors.New("nyquist/sealedstream: invalid chunk")
+
+	// ErrUnexpectedSender is the error returned when the stream's sender
+	// does not match the expected sender.
+	ErrUnexpectedSender = errors.New("nyquist/sealedstream: unexpected sender")
+
+	errInvalidChunkSize = errors.New("nyquist/sealedstream: invalid chunk size")
+	errClosed           = errors.New("nyquist/sealedstream: encryptor closed")
+	errChunkIndex       = errors.New("nyquist/sealedstream: chunk index out of range")
+	errNegativeOffset   = errors.New("nyquist/sealedstream: negative offset")
+)
+
+// EncryptOptions are the options for encrypting a stream.
+type EncryptOptions struct {
+	// Sender is the sender's static keypair.  If nil, the sender is
+	// anonymous.
+	Sender dh.Keypair
+
+	// ChunkSize is the plaintext chunk size.  If 0, `DefaultChunkSize`
+	// will be used.
+	ChunkSize int
+
+	// DH is the DH function.  If nil, `dh.X25519` will be used.
+	DH dh.DH
+
+	// Cipher is the cipher function.  If nil, `cipher.ChaChaPoly` will be
+	// used.
+	Cipher cipher.Cipher
+
+	// Hash is the hash function.  If nil, `hash.BLAKE2s` will be used.
+	Hash hash.Hash
+
+	// Rng is the entropy source.  If nil, `crypto/rand.Reader` will be
+	// used.
+	Rng io.Reader
+}
+
+// DecryptOptions are the options for decrypting a stream.
+type DecryptOptions struct {
+	// Sender is the expected sender's static public key.  If set, streams
+	// from anonymous or other senders are rejected.
+	Sender dh.PublicKey
+}
+
+// Encryptor encrypts a stream.
+type Encryptor struct {
+	w  io.Writer
+	cs *nyquist.CipherState
+
+	buf       []byte
+	chunkSize int
+	index     uint64
+	err       error
+}
+
+// Write encrypts and writes p.
+func (e *Encryptor) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	var n int
+	for len(p) > 0 {
+		// A full chunk is only written once more data is available, as
+		// it may be the final chunk.
+		if len(e.buf) == e.chunkSize {
+			if e.err = e.writeChunk(false); e.err != nil {
+				return n, e.err
+			}
+		}
+		toCopy := min(len(p), e.chunkSize-len(e.buf))
+		e.buf = append(e.buf, p[:toCopy]...)
+		n += toCopy
+		p = p[toCopy:]
+	}
+
+	return n, nil
+}
+
+// Close writes the final chunk.  It does not close the underlying writer.
+func (e *Encryptor) Close() error {
+	if e.err != nil {
+		if e.err == errClosed {
+			return nil
+		}
+		return e.err
+	}
+
+	err := e.writeChunk(true)
+	e.cs.Reset()
+	e.err = errClosed
+	return err
+}
+
+func (e *Encryptor) writeChunk(isFinal bool) error {
+	e.cs.SetNonce(e.index)
+	ct, err := e.cs.EncryptWithAd(nil, chunkAD(isFinal), e.buf)
+	if err != nil {
+		return err
+	}
+	if _, err = e.w.Write(ct); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// NewEncryptor writes the header to w, and returns an Encryptor that
+// encrypts to the recipient's static public key.  Close must be called to
+// write the final chunk.  The options may be nil.
+func NewEncryptor(w io.Writer, recipient dh.PublicKey, opts *EncryptOptions) (*Encryptor, error) {
+	if opts == nil {
+		opts = &EncryptOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > MaxChunkSize {
+		return nil, errInvalidChunkSize
+	}
+
+	protocol := &nyquist.Protocol{
+		Pattern: pattern.N,
+		DH:      opts.DH,
+		Cipher:  opts.Cipher,
+		Hash:    opts.Hash,
+	}
+	if opts.Sender != nil {
+		protocol.Pattern = pattern.X
+	}
+	if protocol.DH == nil {
+		protocol.DH = dh.X25519
+	}
+	if protocol.Cipher == nil {
+		protocol.Cipher = cipher.ChaChaPoly
+	}
+	if protocol.Hash == nil {
+		protocol.Hash = hash.BLAKE2s
+	}
+
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		Prologue:       prologue(uint32(chunkSize)),
+		LocalStatic:    opts.Sender,
+		RemoteStatic:   recipient,
+		Rng:            opts.Rng,
+		MaxMessageSize: -1,
+		IsInitiator:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	msg, err := hs.WriteMessage(nil, nil)
+	if err != nyquist.ErrDone {
+		return nil, err
+	}
+	cs := hs.GetStatus().CipherStates[0]
+
+	name := protocol.String()
+	if len(name) > math.MaxUint8 || len(msg) > math.MaxUint16 {
+		cs.Reset()
+		return nil, ErrInvalidHeader
+	}
+	header := make([]byte, 0, 8+len(name)+len(msg))
+	header = append(header, Version, uint8(len(name)))
+	header = append(header, name...)
+	header = binary.BigEndian.AppendUint32(header, uint32(chunkSize))
+	header = binary.BigEndian.AppendUint16(header, uint16(len(msg)))
+	header = append(header, msg...)
+	if _, err = w.Write(header); err != nil {
+		cs.Reset()
+		return nil, err
+	}
+
+	return &Encryptor{
+		w:         w,
+		cs:        cs,
+		buf:       make([]byte, 0, chunkSize),
+		chunkSize: chunkSize,
+	}, nil
+}
+
+type header struct {
+	cs        *nyquist.CipherState
+	sender    dh.PublicKey
+	chunkSize int
+	overhead  int
+	size      int64
+}
+
+func readHeader(r io.Reader, recipient dh.Keypair, opts *DecryptOptions) (*header, error) {
+	if opts == nil {
+		opts = &DecryptOptions{}
+	}
+
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, headerErr(err)
+	}
+	if b[0] != Version {
+		return nil, ErrInvalidHeader
+	}
+	nameAndSize := make([]byte, int(b[1])+6)
+	if _, err := io.ReadFull(r, nameAndSize); err != nil {
+		return nil, headerErr(err)
+	}
+	nameLen := int(b[1])
+	protocol, err := nyquist.NewProtocol(string(nameAndSize[:nameLen]))
+	if err != nil {
+		return nil, ErrInvalidHeader
+	}
+	switch protocol.Pattern.String() {
+	case pattern.N.String():
+		if opts.Sender != nil {
+			return nil, ErrUnexpectedSender
+		}
+		protocol.Pattern = pattern.N
+	case pattern.X.String():
+		protocol.Pattern = pattern.X
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if protocol.Sig != nil {
+		return nil, ErrInvalidHeader
+	}
+	chunkSize := binary.BigEndian.Uint32(nameAndSize[nameLen:])
+	if chunkSize == 0 || chunkSize > MaxChunkSize {
+		return nil, ErrInvalidHeader
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(nameAndSize[nameLen+4:]))
+	if _, err = io.ReadFull(r, msg); err != nil {
+		return nil, headerErr(err)
+	}
+
+	hs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:       protocol,
+		Prologue:       prologue(chunkSize),
+		LocalStatic:    recipient,
+		MaxMessageSize: -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer hs.Reset()
+
+	if _, err = hs.ReadMessage(nil, msg); err != nyquist.ErrDone {
+		return nil, ErrInvalidHeader
+	}
+	status := hs.GetStatus()
+	if opts.Sender != nil && !bytes.Equal(opts.Sender.Bytes(), status.RemoteStatic.Bytes()) {
+		status.CipherStates[0].Reset()
+		return nil, ErrUnexpectedSender
+	}
+
+	aead, err := protocol.Cipher.New(make([]byte, nyquist.SymmetricKeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &header{
+		cs:        status.CipherStates[0],
+		sender:    status.RemoteStatic,
+		chunkSize: int(chunkSize),
+		overhead:  aead.Overhead(),
+		size:      int64(2 + len(nameAndSize) + len(msg)),
+	}, nil
+}
+
+func (h *header) decryptChunk(dst, ct []byte, index uint64, isFinal bool) ([]byte, error) {
+	h.cs.SetNonce(index)
+	pt, err := h.cs.DecryptWithAd(dst, chunkAD(isFinal), ct)
+	if err != nil {
+		return nil, ErrInvalidChunk
+	}
+	switch ptLen := len(pt) - len(dst); {
+	case !isFinal && ptLen != h.chunkSize:
+		return nil, ErrInvalidChunk
+	case isFinal && ptLen == 0 && index != 0:
+		// Only an empty stream has an empty final chunk.
+		return nil, ErrInvalidChunk
+	}
+	return pt, nil
+}
+
+func headerErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidHeader
+	}
+	return err
+}
+
+func chunkAD(isFinal bool) []byte {
+	if isFinal {
+		return []byte{adFinalChunk}
+	}
+	return []byte{adChunk}
+}
+
+func prologue(chunkSize uint32) []byte {
+	p := make([]byte, 0, len(prologueLabel)+5)
+	p = append(p, prologueLabel...)
+	p = append(p, Version)
+	return binary.BigEndian.AppendUint32(p, chunkSize)
+}
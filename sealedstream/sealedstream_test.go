@@ -0,0 +1,269 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sealedstream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const testChunkSize = 64
+
+func mustGenerateKeypair(t *testing.T) dh.Keypair {
+	kp, err := dh.X25519.GenerateKeypair(rand.Reader)
+	require.NoError(t, err, "GenerateKeypair")
+	return kp
+}
+
+func encrypt(t *testing.T, recipient dh.PublicKey, plaintext []byte, opts *EncryptOptions) []byte {
+	var buf bytes.Buffer
+	enc, err := NewEncryptor(&buf, recipient, opts)
+	require.NoError(t, err, "NewEncryptor")
+
+	// Write in uneven pieces, to exercise the buffering.
+	for b := plaintext; len(b) > 0; {
+		n := min(len(b), 7)
+		_, err = enc.Write(b[:n])
+		require.NoError(t, err, "Write")
+		b = b[n:]
+	}
+	require.NoError(t, enc.Close(), "Close")
+	require.NoError(t, enc.Close(), "Close - again")
+	_, err = enc.Write([]byte("more"))
+	require.Error(t, err, "Write - closed")
+
+	return buf.Bytes()
+}
+
+func decryptStream(recipient dh.Keypair, ciphertext []byte, opts *DecryptOptions) ([]byte, error) {
+	dec, err := NewDecryptor(iotest.HalfReader(bytes.NewReader(ciphertext)), recipient, opts)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+func TestSealedStream(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"RoundTrip", testSealedStreamRoundTrip},
+		{"RandomAccess", testSealedStreamRandomAccess},
+		{"Sender", testSealedStreamSender},
+		{"Tampering", testSealedStreamTampering},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testSealedStreamRoundTrip(t *testing.T) {
+	recipient := mustGenerateKeypair(t)
+	opts := &EncryptOptions{ChunkSize: testChunkSize}
+
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 3 * testChunkSize, 3*testChunkSize + 7} {
+		t.Run(fmt.Sprintf("%d", size), func(t *testing.T) {
+			require := require.New(t)
+
+			plaintext := make([]byte, size)
+			_, _ = rand.Read(plaintext)
+			ciphertext := encrypt(t, recipient.Public(), plaintext, opts)
+
+			decrypted, err := decryptStream(recipient, ciphertext, nil)
+			require.NoError(err, "Decryptor")
+			require.True(bytes.Equal(plaintext, decrypted), "Decryptor - plaintext")
+
+			d, err := NewRandomAccessDecryptor(bytes.NewReader(ciphertext), int64(len(ciphertext)), recipient, nil)
+			require.NoError(err, "NewRandomAccessDecryptor")
+			require.EqualValues(size, d.Size(), "Size")
+			require.EqualValues(max(1, (size+testChunkSize-1)/testChunkSize), d.NumChunks(), "NumChunks")
+
+			decrypted, err = io.ReadAll(io.NewSectionReader(d, 0, d.Size()))
+			require.NoError(err, "RandomAccessDecryptor")
+			require.True(bytes.Equal(plaintext, decrypted), "RandomAccessDecryptor - plaintext")
+		})
+	}
+
+	// The default chunk size.
+	require := require.New(t)
+	plaintext := make([]byte, DefaultChunkSize+1)
+	ciphertext := encrypt(t, recipient.Public(), plaintext, nil)
+	decrypted, err := decryptStream(recipient, ciphertext, nil)
+	require.NoError(err, "Decryptor - default chunk size")
+	require.Equal(plaintext, decrypted, "Decryptor - default chunk size")
+
+	for _, chunkSize := range []int{-1, MaxChunkSize + 1} {
+		_, err = NewEncryptor(io.Discard, recipient.Public(), &EncryptOptions{ChunkSize: chunkSize})
+		require.Error(err, "NewEncryptor - chunk size: %d", chunkSize)
+	}
+}
+
+func testSealedStreamRandomAccess(t *testing.T) {
+	require := require.New(t)
+
+	recipient := mustGenerateKeypair(t)
+	plaintext := make([]byte, 10*testChunkSize+13)
+	_, _ = rand.Read(plaintext)
+	ciphertext := encrypt(t, recipient.Public(), plaintext, &EncryptOptions{ChunkSize: testChunkSize})
+
+	d, err := NewRandomAccessDecryptor(bytes.NewReader(ciphertext), int64(len(ciphertext)), recipient, nil)
+	require.NoError(err, "NewRandomAccessDecryptor")
+	require.Equal(testChunkSize, d.ChunkSize(), "ChunkSize")
+
+	// Chunks in reverse order.
+	for i := int(d.NumChunks()) - 1; i >= 0; i-- {
+		chunk, err := d.ReadChunk(nil, uint64(i))
+		require.NoError(err, "ReadChunk(%d)", i)
+		end := min(len(plaintext), (i+1)*testChunkSize)
+		require.Equal(plaintext[i*testChunkSize:end], chunk, "ReadChunk(%d)", i)
+	}
+	_, err = d.ReadChunk(nil, d.NumChunks())
+	require.Error(err, "ReadChunk - out of range")
+
+	// Arbitrary offsets spanning chunk boundaries.
+	for _, v := range []struct {
+		off, n int
+	}{
+		{0, 1},
+		{5, testChunkSize},
+		{testChunkSize - 1, 2},
+		{3*testChunkSize + 17, 4 * testChunkSize},
+		{len(plaintext) - 3, 3},
+	} {
+		b := make([]byte, v.n)
+		n, err := d.ReadAt(b, int64(v.off))
+		require.NoError(err, "ReadAt(%d, %d)", v.off, v.n)
+		require.Equal(v.n, n, "ReadAt(%d, %d)", v.off, v.n)
+		require.Equal(plaintext[v.off:v.off+v.n], b, "ReadAt(%d, %d)", v.off, v.n)
+	}
+
+	b := make([]byte, 10)
+	n, err := d.ReadAt(b, int64(len(plaintext)-4))
+	require.Equal(io.EOF, err, "ReadAt - past end")
+	require.Equal(4, n, "ReadAt - past end")
+	require.Equal(plaintext[len(plaintext)-4:], b[:n], "ReadAt - past end")
+}
+
+func testSealedStreamSender(t *testing.T) {
+	require := require.New(t)
+
+	recipient, sender, other := mustGenerateKeypair(t), mustGenerateKeypair(t), mustGenerateKeypair(t)
+	plaintext := []byte("authenticated sender")
+
+	ciphertext := encrypt(t, recipient.Public(), plaintext, &EncryptOptions{Sender: sender})
+	dec, err := NewDecryptor(bytes.NewReader(ciphertext), recipient, &DecryptOptions{Sender: sender.Public()})
+	require.NoError(err, "NewDecryptor")
+	require.Equal(sender.Public().Bytes(), dec.Sender().Bytes(), "Sender")
+	decrypted, err := io.ReadAll(dec)
+	require.NoError(err, "ReadAll")
+	require.Equal(plaintext, decrypted, "ReadAll")
+
+	_, err = NewDecryptor(bytes.NewReader(ciphertext), recipient, &DecryptOptions{Sender: other.Public()})
+	require.ErrorIs(err, ErrUnexpectedSender, "NewDecryptor - other sender")
+
+	anonymous := encrypt(t, recipient.Public(), plaintext, nil)
+	d, err := NewRandomAccessDecryptor(bytes.NewReader(anonymous), int64(len(anonymous)), recipient, nil)
+	require.NoError(err, "NewRandomAccessDecryptor - anonymous")
+	require.Nil(d.Sender(), "Sender - anonymous")
+	_, err = NewDecryptor(bytes.NewReader(anonymous), recipient, &DecryptOptions{Sender: sender.Public()})
+	require.ErrorIs(err, ErrUnexpectedSender, "NewDecryptor - anonymous, expected sender")
+
+	_, err = NewDecryptor(bytes.NewReader(ciphertext), other, nil)
+	require.ErrorIs(err, ErrInvalidHeader, "NewDecryptor - wrong recipient")
+}
+
+func testSealedStreamTampering(t *testing.T) {
+	require := require.New(t)
+
+	recipient := mustGenerateKeypair(t)
+	plaintext := make([]byte, 4*testChunkSize)
+	_, _ = rand.Read(plaintext)
+	ciphertext := encrypt(t, recipient.Public(), plaintext, &EncryptOptions{ChunkSize: testChunkSize})
+
+	d, err := NewRandomAccessDecryptor(bytes.NewReader(ciphertext), int64(len(ciphertext)), recipient, nil)
+	require.NoError(err, "NewRandomAccessDecryptor")
+	headerLen := int(d.h.size)
+	encChunkSize := testChunkSize + d.h.overhead
+
+	checkFails := func(ct []byte, descr string) {
+		_, err := decryptStream(recipient, ct, nil)
+		require.Error(err, "Decryptor - %s", descr)
+
+		d, err := NewRandomAccessDecryptor(bytes.NewReader(ct), int64(len(ct)), recipient, nil)
+		if err == nil {
+			_, err = io.ReadAll(io.NewSectionReader(d, 0, d.Size()))
+		}
+		require.Error(err, "RandomAccessDecryptor - %s", descr)
+	}
+
+	// Truncation at a chunk boundary.
+	truncated := ciphertext[:headerLen+3*encChunkSize]
+	_, err = decryptStream(recipient, truncated, nil)
+	require.ErrorIs(err, ErrInvalidChunk, "Decryptor - truncated at chunk boundary")
+	checkFails(truncated, "truncated at chunk boundary")
+
+	// Truncation within a chunk, and of the entire payload.
+	checkFails(ciphertext[:len(ciphertext)-1], "truncated within chunk")
+	_, err = decryptStream(recipient, ciphertext[:headerLen], nil)
+	require.ErrorIs(err, ErrTruncated, "Decryptor - header only")
+	checkFails(ciphertext[:headerLen], "header only")
+
+	// Extension.
+	checkFails(append(append([]byte{}, ciphertext...), ciphertext[headerLen:headerLen+encChunkSize]...), "extended")
+
+	// Reordered chunks.
+	reordered := append([]byte{}, ciphertext...)
+	copy(reordered[headerLen:], ciphertext[headerLen+encChunkSize:headerLen+2*encChunkSize])
+	copy(reordered[headerLen+encChunkSize:], ciphertext[headerLen:headerLen+encChunkSize])
+	checkFails(reordered, "reordered")
+
+	// Modified chunk size.
+	modified := append([]byte{}, ciphertext...)
+	nameLen := int(modified[1])
+	binary.BigEndian.PutUint32(modified[2+nameLen:], testChunkSize*2)
+	_, err = decryptStream(recipient, modified, nil)
+	require.ErrorIs(err, ErrInvalidHeader, "Decryptor - modified chunk size")
+
+	// Flipped bits.
+	for _, off := range []int{0, 1, headerLen - 1, headerLen, len(ciphertext) - 1} {
+		flipped := append([]byte{}, ciphertext...)
+		flipped[off] ^= 0x80
+		checkFails(flipped, fmt.Sprintf("flipped byte %d", off))
+	}
+}
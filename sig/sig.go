@@ -0,0 +1,254 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package sig implements the Noise Protocol Framework signature scheme
+// abstract interface and standard signature schemes, for use with the
+// signature (`v`, `sig`) handshake pattern tokens.
+package sig // import "gitlab.com/yawning/nyquist.git/sig"
+
+import (
+	"crypto/ed25519"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMalformedPrivateKey is the error returned when a serialized
+	// private key is malformed.
+	ErrMalformedPrivateKey = errors.New("nyquist/sig: malformed private key")
+
+	// ErrMalformedPublicKey is the error returned when a serialized public
+	// key is malformed.
+	ErrMalformedPublicKey = errors.New("nyquist/sig: malformed public key")
+
+	// ErrInvalidSignature is the error returned when a signature is
+	// invalid.
+	ErrInvalidSignature = errors.New("nyquist/sig: invalid signature")
+
+	supportedSchemes = map[string]Scheme{
+		"Ed25519": Ed25519,
+	}
+)
+
+// Scheme is a signature scheme.
+type Scheme interface {
+	fmt.Stringer
+
+	// GenerateKeypair generates a new signing keypair using the provided
+	// entropy source.
+	GenerateKeypair(rng io.Reader) (Keypair, error)
+
+	// ParsePrivateKey parses a binary encoded private key.
+	ParsePrivateKey(data []byte) (Keypair, error)
+
+	// ParsePublicKey parses a binary encoded public key.
+	ParsePublicKey(data []byte) (PublicKey, error)
+
+	// PublicKeySize returns the size of public keys in bytes.
+	PublicKeySize() int
+
+	// SignatureSize returns the size of signatures in bytes.
+	SignatureSize() int
+}
+
+// FromString returns a Scheme by algorithm name, or nil.
+func FromString(s string) Scheme {
+	return supportedSchemes[s]
+}
+
+// Keypair is a signing keypair.
+type Keypair interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+
+	// DropPrivate discards the private key.
+	DropPrivate()
+
+	// Public returns the public key of the keypair.
+	Public() PublicKey
+
+	// Sign signs the message with the private key in the keypair.
+	Sign(msg []byte) ([]byte, error)
+}
+
+// PublicKey is a signature verification public key.
+type PublicKey interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+
+	// Bytes returns the binary serialized public key.
+	//
+	// Warning: Altering the returned slice is unsupported and will lead
+	// to unexpected behavior.
+	Bytes() []byte
+
+	// Verify returns true iff the signature over the message is valid.
+	Verify(msg, signature []byte) bool
+}
+
+// Ed25519 is the Ed25519 signature scheme.
+var Ed25519 Scheme = &schemeEd25519{}
+
+type schemeEd25519 struct{}
+
+func (sc *schemeEd25519) String() string {
+	return "Ed25519"
+}
+
+func (sc *schemeEd25519) GenerateKeypair(rng io.Reader) (Keypair, error) {
+	var seed [ed25519.SeedSize]byte
+	if _, err := io.ReadFull(rng, seed[:]); err != nil {
+		return nil, err
+	}
+
+	var kp KeypairEd25519
+	_ = kp.UnmarshalBinary(seed[:])
+	for i := range seed {
+		seed[i] = 0
+	}
+
+	return &kp, nil
+}
+
+func (sc *schemeEd25519) ParsePrivateKey(data []byte) (Keypair, error) {
+	var kp KeypairEd25519
+	if err := kp.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return &kp, nil
+}
+
+func (sc *schemeEd25519) ParsePublicKey(data []byte) (PublicKey, error) {
+	var pk PublicKeyEd25519
+	if err := pk.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return &pk, nil
+}
+
+func (sc *schemeEd25519) PublicKeySize() int {
+	return ed25519.PublicKeySize
+}
+
+func (sc *schemeEd25519) SignatureSize() int {
+	return ed25519.SignatureSize
+}
+
+// KeypairEd25519 is an Ed25519 keypair.
+type KeypairEd25519 struct {
+	privateKey ed25519.PrivateKey
+	publicKey  PublicKeyEd25519
+}
+
+// MarshalBinary marshals the keypair's private key (seed) to binary form.
+func (kp *KeypairEd25519) MarshalBinary() ([]byte, error) {
+	if len(kp.privateKey) != ed25519.PrivateKeySize {
+		return nil, ErrMalformedPrivateKey
+	}
+	out := make([]byte, 0, ed25519.SeedSize)
+	return append(out, kp.privateKey.Seed()...), nil
+}
+
+// UnmarshalBinary unmarshals the keypair's private key (seed) from binary
+// form, and re-derives the corresponding public key.
+func (kp *KeypairEd25519) UnmarshalBinary(data []byte) error {
+	if len(data) != ed25519.SeedSize {
+		return ErrMalformedPrivateKey
+	}
+
+	kp.privateKey = ed25519.NewKeyFromSeed(data)
+	copy(kp.publicKey.rawPublicKey[:], kp.privateKey[ed25519.SeedSize:])
+
+	return nil
+}
+
+// Public returns the public key of the keypair.
+func (kp *KeypairEd25519) Public() PublicKey {
+	return &kp.publicKey
+}
+
+// Sign signs the message with the private key in the keypair.
+func (kp *KeypairEd25519) Sign(msg []byte) ([]byte, error) {
+	if len(kp.privateKey) != ed25519.PrivateKeySize {
+		return nil, ErrMalformedPrivateKey
+	}
+	return ed25519.Sign(kp.privateKey, msg), nil
+}
+
+// DropPrivate discards the private key.
+func (kp *KeypairEd25519) DropPrivate() {
+	for i := range kp.privateKey {
+		kp.privateKey[i] = 0
+	}
+	kp.privateKey = nil
+}
+
+// PublicKeyEd25519 is an Ed25519 public key.
+type PublicKeyEd25519 struct {
+	rawPublicKey [ed25519.PublicKeySize]byte
+}
+
+// MarshalBinary marshals the public key to binary form.
+func (pk *PublicKeyEd25519) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, len(pk.rawPublicKey))
+	return append(out, pk.rawPublicKey[:]...), nil
+}
+
+// UnmarshalBinary unmarshals the public key from binary form.
+func (pk *PublicKeyEd25519) UnmarshalBinary(data []byte) error {
+	if len(data) != ed25519.PublicKeySize {
+		return ErrMalformedPublicKey
+	}
+
+	copy(pk.rawPublicKey[:], data)
+
+	return nil
+}
+
+// Bytes returns the binary serialized public key.
+//
+// Warning: Altering the returned slice is unsupported and will lead to
+// unexpected behavior.
+func (pk *PublicKeyEd25519) Bytes() []byte {
+	return pk.rawPublicKey[:]
+}
+
+// Verify returns true iff the signature over the message is valid.
+func (pk *PublicKeyEd25519) Verify(msg, signature []byte) bool {
+	return ed25519.Verify(pk.rawPublicKey[:], msg, signature)
+}
+
+// Register registers a new signature scheme for use with `FromString()`.
+func Register(sc Scheme) {
+	supportedSchemes[sc.String()] = sc
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package sig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSig(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Ed25519/Vector", testSigEd25519Vector},
+		{"Ed25519/SignVerify", testSigEd25519SignVerify},
+		{"Ed25519/Serialization", testSigEd25519Serialization},
+		{"Ed25519/DropPrivate", testSigEd25519DropPrivate},
+		{"Registry", testSigRegistry},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testSigEd25519Vector(t *testing.T) {
+	require := require.New(t)
+
+	// RFC 8032 7.1 TEST 1.
+	seed, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	publicKey, _ := hex.DecodeString("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	signature, _ := hex.DecodeString("e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+
+	kp, err := Ed25519.ParsePrivateKey(seed)
+	require.NoError(err, "ParsePrivateKey")
+	require.Equal(publicKey, kp.Public().Bytes(), "Public().Bytes()")
+
+	sig, err := kp.Sign(nil)
+	require.NoError(err, "Sign")
+	require.Equal(signature, sig, "Sign")
+	require.True(kp.Public().Verify(nil, sig), "Verify")
+}
+
+func testSigEd25519SignVerify(t *testing.T) {
+	require := require.New(t)
+
+	kp, err := Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	msg := []byte("handshake hash")
+	sig, err := kp.Sign(msg)
+	require.NoError(err, "Sign")
+	require.Len(sig, Ed25519.SignatureSize(), "Sign - signature size")
+	require.True(kp.Public().Verify(msg, sig), "Verify")
+
+	require.False(kp.Public().Verify([]byte("other message"), sig), "Verify - wrong message")
+	badSig := append([]byte{}, sig...)
+	badSig[0] ^= 0x01
+	require.False(kp.Public().Verify(msg, badSig), "Verify - tampered signature")
+	require.False(kp.Public().Verify(msg, sig[:len(sig)-1]), "Verify - truncated signature")
+
+	other, err := Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair - other")
+	require.False(other.Public().Verify(msg, sig), "Verify - wrong key")
+}
+
+func testSigEd25519Serialization(t *testing.T) {
+	require := require.New(t)
+
+	kp, err := Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+
+	b, err := kp.MarshalBinary()
+	require.NoError(err, "MarshalBinary")
+	require.Len(b, ed25519.SeedSize, "MarshalBinary - seed size")
+	kp2, err := Ed25519.ParsePrivateKey(b)
+	require.NoError(err, "ParsePrivateKey")
+	require.Equal(kp.Public().Bytes(), kp2.Public().Bytes(), "ParsePrivateKey - public key")
+
+	b, err = kp.Public().MarshalBinary()
+	require.NoError(err, "Public().MarshalBinary")
+	require.Len(b, Ed25519.PublicKeySize(), "Public().MarshalBinary - size")
+	pk, err := Ed25519.ParsePublicKey(b)
+	require.NoError(err, "ParsePublicKey")
+	require.Equal(kp.Public(), pk, "ParsePublicKey")
+
+	for _, n := range []int{0, ed25519.SeedSize - 1, ed25519.SeedSize + 1, ed25519.PrivateKeySize} {
+		_, err = Ed25519.ParsePrivateKey(make([]byte, n))
+		require.ErrorIs(err, ErrMalformedPrivateKey, "ParsePrivateKey - %d bytes", n)
+	}
+	for _, n := range []int{0, ed25519.PublicKeySize - 1, ed25519.PublicKeySize + 1} {
+		_, err = Ed25519.ParsePublicKey(make([]byte, n))
+		require.ErrorIs(err, ErrMalformedPublicKey, "ParsePublicKey - %d bytes", n)
+	}
+}
+
+func testSigEd25519DropPrivate(t *testing.T) {
+	require := require.New(t)
+
+	kp, err := Ed25519.GenerateKeypair(rand.Reader)
+	require.NoError(err, "GenerateKeypair")
+	publicKey := append([]byte{}, kp.Public().Bytes()...)
+
+	kp.DropPrivate()
+	_, err = kp.Sign([]byte("message"))
+	require.ErrorIs(err, ErrMalformedPrivateKey, "Sign - after DropPrivate")
+	_, err = kp.MarshalBinary()
+	require.ErrorIs(err, ErrMalformedPrivateKey, "MarshalBinary - after DropPrivate")
+	require.Equal(publicKey, kp.Public().Bytes(), "Public() - after DropPrivate")
+}
+
+type testScheme struct {
+	Scheme
+}
+
+func (sc *testScheme) String() string {
+	return "TestScheme"
+}
+
+func testSigRegistry(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(Ed25519, FromString("Ed25519"), "FromString(Ed25519)")
+	require.Nil(FromString("TestScheme"), "FromString(TestScheme) - before Register")
+
+	sc := &testScheme{Ed25519}
+	Register(sc)
+	defer delete(supportedSchemes, sc.String())
+	require.Equal(sc, FromString("TestScheme"), "FromString(TestScheme) - after Register")
+}
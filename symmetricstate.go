@@ -51,6 +51,9 @@ type SymmetricState struct {
 	ck []byte
 	h  []byte
 
+	tracer       HandshakeTracer
+	traceSecrets bool
+
 	hashLen int
 }
 
@@ -70,20 +73,40 @@ func (ss *SymmetricState) InitializeSymmetric(protocolName []byte) {
 	ss.ck = append(ss.ck, ss.h...)
 
 	ss.cs.InitializeKey(nil)
+
+	if ss.tracer != nil {
+		ss.tracer.OnInitializeSymmetric(protocolName, ss.h)
+	}
 }
 
 // MixKey mixes the provided material with the chaining key, and initializes
 // the encapsulated CipherState's key with the output.
 func (ss *SymmetricState) MixKey(inputKeyMaterial []byte) {
 	tempK := make([]byte, ss.hashLen)
+	ckPrev := ss.traceSecret(ss.ck)
 
 	ss.hkdfHash(inputKeyMaterial, ss.ck, tempK)
 	tempK = truncateTo32BytesMax(tempK)
 	ss.cs.InitializeKey(tempK)
+
+	if ss.tracer != nil {
+		ss.tracer.OnMixKey(ss.traceSecret(inputKeyMaterial), ckPrev, ss.traceSecret(ss.ck))
+	}
 }
 
 // MixHash mixes the provided data with the handshake hash.
 func (ss *SymmetricState) MixHash(data []byte) {
+	if ss.tracer == nil {
+		ss.mixHash(data)
+		return
+	}
+
+	hPrev := append([]byte{}, ss.h...)
+	ss.mixHash(data)
+	ss.tracer.OnMixHash(data, hPrev, ss.h)
+}
+
+func (ss *SymmetricState) mixHash(data []byte) {
 	h := ss.hash.New()
 	_, _ = h.Write(ss.h)
 	_, _ = h.Write(data)
@@ -94,11 +117,20 @@ func (ss *SymmetricState) MixHash(data []byte) {
 // the handshake and initializes the encapsulated CipherState with the output.
 func (ss *SymmetricState) MixKeyAndHash(inputKeyMaterial []byte) {
 	tempH, tempK := make([]byte, ss.hashLen), make([]byte, ss.hashLen)
+	ckPrev := ss.traceSecret(ss.ck)
+	var hPrev []byte
+	if ss.tracer != nil {
+		hPrev = append([]byte{}, ss.h...)
+	}
 
 	ss.hkdfHash(inputKeyMaterial, ss.ck, tempH, tempK)
-	ss.MixHash(tempH)
+	ss.mixHash(tempH)
 	tempK = truncateTo32BytesMax(tempK)
 	ss.cs.InitializeKey(tempK)
+
+	if ss.tracer != nil {
+		ss.tracer.OnMixKeyAndHash(ss.traceSecret(inputKeyMaterial), ckPrev, ss.traceSecret(ss.ck), hPrev, ss.h)
+	}
 }
 
 // GetHandshakeHash returns the handshake hash `h`.
@@ -115,6 +147,9 @@ func (ss *SymmetricState) EncryptAndHash(dst, plaintext []byte) []byte {
 	if dst, err = ss.cs.EncryptWithAd(dst, ss.h, plaintext); err != nil {
 		panic("nyquist/SymmetricState: encryptAndHash() failed: " + err.Error())
 	}
+	if ss.tracer != nil {
+		ss.tracer.OnEncryptAndHash(plaintext, dst[ciphertextOff:])
+	}
 	ss.MixHash(dst[ciphertextOff:])
 	return dst
 }
@@ -130,7 +165,17 @@ func (ss *SymmetricState) DecryptAndHash(dst, ciphertext []byte) ([]byte, error)
 
 	ss.MixHash(ciphertext)
 
-	return ss.cs.DecryptWithAd(dst, hPrev, ciphertext)
+	plaintextOff := len(dst)
+	dst, err := ss.cs.DecryptWithAd(dst, hPrev, ciphertext)
+	if ss.tracer != nil {
+		var plaintext []byte
+		if err == nil {
+			plaintext = dst[plaintextOff:]
+		}
+		ss.tracer.OnDecryptAndHash(ciphertext, plaintext, err)
+	}
+
+	return dst, err
 }
 
 // Split returns a pair of CipherState objects for encrypted transport messages.
@@ -145,9 +190,26 @@ func (ss *SymmetricState) Split() (*CipherState, *CipherState) {
 	c1.InitializeKey(tempK1)
 	c2.InitializeKey(tempK2)
 
+	if ss.tracer != nil {
+		ss.tracer.OnSplit(ss.traceSecret(tempK1), ss.traceSecret(tempK2))
+	}
+
 	return c1, c2
 }
 
+// exporterSecret returns the third `HASHLEN` output of the HKDF used by
+// `Split`, so that it is independent of the transport keys.
+func (ss *SymmetricState) exporterSecret() []byte {
+	tempK1, tempK2, secret := make([]byte, ss.hashLen), make([]byte, ss.hashLen), make([]byte, ss.hashLen)
+
+	ss.hkdfHash(nil, tempK1, tempK2, secret)
+	for i := range tempK1 {
+		tempK1[i], tempK2[i] = 0, 0
+	}
+
+	return secret
+}
+
 // CipherState returns the SymmetricState's encapsualted CipherState.
 //
 // Warning: There should be no reason to call this, ever.
@@ -175,6 +237,13 @@ func (ss *SymmetricState) hkdfHash(inputKeyMaterial []byte, outputs ...[]byte) {
 	}
 }
 
+func (ss *SymmetricState) traceSecret(b []byte) []byte {
+	if ss.tracer == nil || !ss.traceSecrets {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
 // Reset clears the SymmetricState, to prevent future calls.
 //
 // Warning: The transcript hash (`h`) is left intact to allow for clearing
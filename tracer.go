@@ -0,0 +1,168 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package nyquist
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+// HandshakeTracer is a handshake tracer for debugging the step-by-step
+// execution of a handshake (eg: when diagnosing interoperability issues with
+// other implementations).
+//
+// Unless `HandshakeConfig.TraceSecrets` is set, secret-bearing values (DH
+// outputs, pre-shared keys, the chaining key `ck`, and cipher keys) will be
+// passed as `nil`.  All other values are always passed.
+//
+// Warning: The slices passed to the tracer MUST NOT be retained or altered.
+type HandshakeTracer interface {
+	// OnInitializeSymmetric will be called when the SymmetricState is
+	// initialized, with the protocol name and the initial handshake hash.
+	OnInitializeSymmetric(protocolName, h []byte)
+
+	// OnToken will be called prior to processing each handshake pattern
+	// token, with the handshake operation (`WriteMessage`, `ReadMessage`,
+	// `ReplayMessage`) and the index of the handshake message being
+	// processed.
+	OnToken(op string, messageIndex int, token pattern.Token)
+
+	// OnMixHash will be called for each `MixHash` call, with the data,
+	// and the handshake hash before and after the call.
+	OnMixHash(data, hPrev, h []byte)
+
+	// OnMixKey will be called for each `MixKey` call, with the input key
+	// material, and the chaining key before and after the call.
+	OnMixKey(inputKeyMaterial, ckPrev, ck []byte)
+
+	// OnMixKeyAndHash will be called for each `MixKeyAndHash` call, with
+	// the input key material, and the chaining key and handshake hash
+	// before and after the call.
+	OnMixKeyAndHash(inputKeyMaterial, ckPrev, ck, hPrev, h []byte)
+
+	// OnEncryptAndHash will be called for each `EncryptAndHash` call, with
+	// the plaintext and ciphertext.
+	OnEncryptAndHash(plaintext, ciphertext []byte)
+
+	// OnDecryptAndHash will be called for each `DecryptAndHash` call, with
+	// the ciphertext, and the plaintext or the error.
+	OnDecryptAndHash(ciphertext, plaintext []byte, err error)
+
+	// OnSplit will be called on `Split`, with the pair of cipher keys.
+	OnSplit(k1, k2 []byte)
+}
+
+// TextTracer is a HandshakeTracer that writes a human readable transcript,
+// with one line per event, suitable for comparison (eg: via `diff`) against
+// the debug output of other implementations.
+//
+// Each line consists of the event name followed by space separated
+// `key=value` pairs, with binary values hex encoded, and values that were
+// not provided to the tracer written as `-`.
+type TextTracer struct {
+	l sync.Mutex
+	w io.Writer
+}
+
+// OnInitializeSymmetric implements the HandshakeTracer interface.
+func (tr *TextTracer) OnInitializeSymmetric(protocolName, h []byte) {
+	tr.printf("InitializeSymmetric protocol_name=%s h=%s", protocolName, traceHex(h))
+}
+
+// OnToken implements the HandshakeTracer interface.
+func (tr *TextTracer) OnToken(op string, messageIndex int, token pattern.Token) {
+	tr.printf("%s message=%d token=%s", op, messageIndex, token)
+}
+
+// OnMixHash implements the HandshakeTracer interface.
+func (tr *TextTracer) OnMixHash(data, hPrev, h []byte) {
+	tr.printf("MixHash data=%s h_prev=%s h=%s", traceHex(data), traceHex(hPrev), traceHex(h))
+}
+
+// OnMixKey implements the HandshakeTracer interface.
+func (tr *TextTracer) OnMixKey(inputKeyMaterial, ckPrev, ck []byte) {
+	tr.printf("MixKey ikm=%s ck_prev=%s ck=%s", traceHex(inputKeyMaterial), traceHex(ckPrev), traceHex(ck))
+}
+
+// OnMixKeyAndHash implements the HandshakeTracer interface.
+func (tr *TextTracer) OnMixKeyAndHash(inputKeyMaterial, ckPrev, ck, hPrev, h []byte) {
+	tr.printf(
+		"MixKeyAndHash ikm=%s ck_prev=%s ck=%s h_prev=%s h=%s",
+		traceHex(inputKeyMaterial),
+		traceHex(ckPrev),
+		traceHex(ck),
+		traceHex(hPrev),
+		traceHex(h),
+	)
+}
+
+// OnEncryptAndHash implements the HandshakeTracer interface.
+func (tr *TextTracer) OnEncryptAndHash(plaintext, ciphertext []byte) {
+	tr.printf("EncryptAndHash plaintext=%s ciphertext=%s", traceHex(plaintext), traceHex(ciphertext))
+}
+
+// OnDecryptAndHash implements the HandshakeTracer interface.
+func (tr *TextTracer) OnDecryptAndHash(ciphertext, plaintext []byte, err error) {
+	if err != nil {
+		tr.printf("DecryptAndHash ciphertext=%s error=%q", traceHex(ciphertext), err.Error())
+		return
+	}
+	tr.printf("DecryptAndHash ciphertext=%s plaintext=%s", traceHex(ciphertext), traceHex(plaintext))
+}
+
+// OnSplit implements the HandshakeTracer interface.
+func (tr *TextTracer) OnSplit(k1, k2 []byte) {
+	tr.printf("Split k1=%s k2=%s", traceHex(k1), traceHex(k2))
+}
+
+func (tr *TextTracer) printf(format string, a ...interface{}) {
+	tr.l.Lock()
+	defer tr.l.Unlock()
+
+	_, _ = fmt.Fprintf(tr.w, format+"\n", a...)
+}
+
+func traceHex(b []byte) string {
+	if b == nil {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewTextTracer creates a new TextTracer that writes to `w`.
+func NewTextTracer(w io.Writer) *TextTracer {
+	return &TextTracer{
+		w: w,
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package nyquist
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2s"
+)
+
+func TestTextTracer(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"Transcript", testTextTracerTranscript},
+		{"Secrets", testTextTracerSecrets},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func mustTraceX(t *testing.T, traceSecrets bool) (*HandshakeState, *HandshakeState, []string, []string) {
+	require := require.New(t)
+
+	var aliceBuf, bobBuf bytes.Buffer
+	aliceHs, bobHs := mustMakeX(t, 0)
+	for _, v := range []struct {
+		hs  *HandshakeState
+		buf *bytes.Buffer
+	}{
+		{aliceHs, &aliceBuf},
+		{bobHs, &bobBuf},
+	} {
+		// Re-initialize the handshakes so that the tracer sees everything.
+		cfg := v.hs.cfg
+		cfg.Tracer, cfg.TraceSecrets = NewTextTracer(v.buf), traceSecrets
+		hs, err := NewHandshake(cfg)
+		require.NoError(err, "NewHandshake")
+		*v.hs = *hs
+	}
+
+	dst, err := aliceHs.WriteMessage(nil, []byte("alice payload"))
+	require.Equal(ErrDone, err, "aliceHs.WriteMessage()")
+	_, err = bobHs.ReadMessage(nil, dst)
+	require.Equal(ErrDone, err, "bobHs.ReadMessage()")
+
+	splitLines := func(b *bytes.Buffer) []string {
+		return strings.Split(strings.TrimSpace(b.String()), "\n")
+	}
+
+	return aliceHs, bobHs, splitLines(&aliceBuf), splitLines(&bobBuf)
+}
+
+func testTextTracerTranscript(t *testing.T) {
+	require := require.New(t)
+
+	aliceHs, _, aliceLines, bobLines := mustTraceX(t, false)
+	require.Len(bobLines, len(aliceLines), "Both sides trace the same number of events")
+
+	// The protocol name is exactly HASHLEN, so it is used as-is for `h`.
+	h0 := []byte("Noise_X_25519_ChaChaPoly_BLAKE2s")
+	h1 := blake2s.Sum256(h0)
+	require.Equal("InitializeSymmetric protocol_name="+string(h0)+" h="+hex.EncodeToString(h0), aliceLines[0], "InitializeSymmetric")
+	require.Equal("MixHash data=- h_prev="+hex.EncodeToString(h0)+" h="+hex.EncodeToString(h1[:]), aliceLines[1], "Prologue MixHash")
+
+	var tokens []string
+	for i, line := range aliceLines {
+		if strings.HasPrefix(line, "WriteMessage") {
+			tokens = append(tokens, strings.TrimPrefix(line, "WriteMessage message=0 token="))
+			require.Equal(strings.Replace(line, "WriteMessage", "ReadMessage", 1), bobLines[i], "Token events match")
+		}
+		if strings.HasPrefix(line, "MixKey ") {
+			require.Equal("MixKey ikm=- ck_prev=- ck=-", line, "Secrets are not traced")
+		}
+	}
+	require.Equal([]string{"e", "es", "s", "ss"}, tokens, "Token events")
+
+	last := aliceLines[len(aliceLines)-2]
+	require.True(strings.HasPrefix(last, "MixHash "), "Payload MixHash")
+	require.True(strings.HasSuffix(last, "h="+hex.EncodeToString(aliceHs.GetStatus().HandshakeHash)), "Final h matches")
+	require.Equal("Split k1=- k2=-", aliceLines[len(aliceLines)-1], "Split keys are not traced")
+}
+
+func testTextTracerSecrets(t *testing.T) {
+	require := require.New(t)
+
+	aliceHs, _, aliceLines, bobLines := mustTraceX(t, true)
+	require.Equal(aliceLines[len(aliceLines)-1], bobLines[len(bobLines)-1], "Split keys match")
+
+	cs1 := aliceHs.GetStatus().CipherStates[0]
+	require.True(strings.HasPrefix(aliceLines[len(aliceLines)-1], "Split k1="+hex.EncodeToString(cs1.k)+" "), "Split k1 matches")
+
+	var sawMixKey bool
+	for _, line := range aliceLines {
+		if strings.HasPrefix(line, "MixKey ") {
+			require.NotContains(line, "=-", "Secrets are traced")
+			sawMixKey = true
+		}
+	}
+	require.True(sawMixKey, "Saw MixKey")
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transcript
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ReadFrames reads a stream of messages (eg: one direction of a reassembled
+// TCP stream), where each message is prefixed by its length as a 16-bit
+// big-endian integer, as recommended by the Noise Protocol Framework
+// specification.
+func ReadFrames(r io.Reader) ([][]byte, error) {
+	var (
+		frames [][]byte
+		off    int64
+	)
+	for {
+		var hdr [2]byte
+		n, err := io.ReadFull(r, hdr[:])
+		switch err {
+		case nil:
+		case io.EOF:
+			return frames, nil
+		default:
+			if n > 0 {
+				return nil, fmt.Errorf("nyquist/transcript: truncated frame length at offset %d", off)
+			}
+			return nil, err
+		}
+
+		frame := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if n, err = io.ReadFull(r, frame); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return nil, fmt.Errorf("nyquist/transcript: truncated frame at offset %d (expected %d bytes, %d remaining)", off, len(frame), n)
+			}
+			return nil, err
+		}
+		frames = append(frames, frame)
+		off += int64(len(hdr) + len(frame))
+	}
+}
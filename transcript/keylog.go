@@ -0,0 +1,203 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transcript
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+const keyLogAbsent = "-"
+
+// KeyLogSession is the key material for a single session recorded in a
+// key log.
+type KeyLogSession struct {
+	// ProtocolName is the session's protocol name.
+	ProtocolName string
+
+	// InitiatorEphemeral is the initiator's ephemeral public key, if any.
+	InitiatorEphemeral []byte
+
+	// ResponderEphemeral is the responder's ephemeral public key, if any.
+	ResponderEphemeral []byte
+
+	// HandshakeHash is the handshake hash (`h`).
+	HandshakeHash []byte
+
+	// Keys are the keys for each of the CipherStates (`cs1`, `cs2`), indexed
+	// by rekey generation.
+	Keys [2][][]byte
+}
+
+// KeyLog is a key log, as written by `nyquist.HandshakeConfig.KeyLog`.
+type KeyLog struct {
+	sessions map[string]*KeyLogSession
+}
+
+// Lookup returns the session with the provided protocol name and ephemeral
+// public keys, or nil.
+func (kl *KeyLog) Lookup(protocolName string, initiatorEphemeral, responderEphemeral []byte) *KeyLogSession {
+	return kl.sessions[keyLogID(protocolName, keyLogHex(initiatorEphemeral), keyLogHex(responderEphemeral))]
+}
+
+// ParseKeyLog parses a key log.  Empty lines and lines starting with `#` are
+// ignored.  Entries may be duplicated (eg: if both ends of a session log to
+// the same destination), as long as they are consistent.
+func ParseKeyLog(r io.Reader) (*KeyLog, error) {
+	kl := &KeyLog{
+		sessions: make(map[string]*KeyLogSession),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := kl.parseEntry(strings.Fields(line)); err != nil {
+			return nil, fmt.Errorf("nyquist/transcript: key log line %d: %w", lineNr, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return kl, nil
+}
+
+func (kl *KeyLog) parseEntry(fields []string) error {
+	var (
+		label string
+		err   error
+	)
+	if len(fields) > 0 {
+		label = fields[0]
+	}
+
+	switch {
+	case label == nyquist.KeyLogLabelSplit && len(fields) == 7:
+		sess := &KeyLogSession{
+			ProtocolName: fields[1],
+		}
+		if sess.InitiatorEphemeral, err = parseKeyLogHex(fields[2]); err != nil {
+			return err
+		}
+		if sess.ResponderEphemeral, err = parseKeyLogHex(fields[3]); err != nil {
+			return err
+		}
+		if sess.HandshakeHash, err = parseKeyLogHex(fields[4]); err != nil {
+			return err
+		}
+		for i := range sess.Keys {
+			var k []byte
+			if k, err = parseKeyLogHex(fields[5+i]); err != nil {
+				return err
+			}
+			if k != nil {
+				sess.Keys[i] = [][]byte{k}
+			}
+		}
+
+		id := keyLogID(sess.ProtocolName, keyLogHex(sess.InitiatorEphemeral), keyLogHex(sess.ResponderEphemeral))
+		if existing := kl.sessions[id]; existing != nil {
+			if !bytes.Equal(existing.HandshakeHash, sess.HandshakeHash) {
+				return fmt.Errorf("inconsistent %s entry", label)
+			}
+			return nil
+		}
+		kl.sessions[id] = sess
+	case label == nyquist.KeyLogLabelRekey && len(fields) == 7:
+		initiatorEphemeral, err := parseKeyLogHex(fields[2])
+		if err != nil {
+			return err
+		}
+		responderEphemeral, err := parseKeyLogHex(fields[3])
+		if err != nil {
+			return err
+		}
+		sess := kl.sessions[keyLogID(fields[1], keyLogHex(initiatorEphemeral), keyLogHex(responderEphemeral))]
+		if sess == nil {
+			return fmt.Errorf("%s entry for unknown session", label)
+		}
+		index, err := strconv.Atoi(fields[4])
+		if err != nil || index < 1 || index > len(sess.Keys) || sess.Keys[index-1] == nil {
+			return fmt.Errorf("invalid %s CipherState index: '%s'", label, fields[4])
+		}
+		generation, err := strconv.Atoi(fields[5])
+		if err != nil || generation < 1 {
+			return fmt.Errorf("invalid %s generation: '%s'", label, fields[5])
+		}
+		k, err := parseKeyLogHex(fields[6])
+		if err != nil || k == nil {
+			return fmt.Errorf("invalid %s key", label)
+		}
+
+		keys := sess.Keys[index-1]
+		switch {
+		case generation < len(keys):
+			if !bytes.Equal(keys[generation], k) {
+				return fmt.Errorf("inconsistent %s entry", label)
+			}
+		case generation == len(keys):
+			sess.Keys[index-1] = append(keys, k)
+		default:
+			return fmt.Errorf("missing %s entries before generation %d", label, generation)
+		}
+	default:
+		return fmt.Errorf("malformed entry")
+	}
+
+	return nil
+}
+
+func keyLogID(protocolName, initiatorEphemeral, responderEphemeral string) string {
+	return protocolName + " " + initiatorEphemeral + " " + responderEphemeral
+}
+
+func keyLogHex(b []byte) string {
+	if b == nil {
+		return keyLogAbsent
+	}
+	return hex.EncodeToString(b)
+}
+
+func parseKeyLogHex(s string) ([]byte, error) {
+	if s == keyLogAbsent {
+		return nil, nil
+	}
+	return hex.DecodeString(s)
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transcript
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var errInvalidMessageIndex = errors.New("nyquist/transcript: invalid message index")
+
+// Field is a region of a handshake message.
+type Field struct {
+	// Token is the handshake pattern token the field corresponds to, or
+	// `pattern.Token_invalid` for the message payload.
+	Token pattern.Token
+
+	// Offset is the offset of the field in the message, in bytes.
+	Offset int
+
+	// Length is the length of the field in bytes.
+	Length int
+
+	// IsEncrypted is true iff the field is encrypted (and thus includes
+	// an authentication tag).
+	IsEncrypted bool
+}
+
+// Bytes returns the field's region of the message.
+func (f *Field) Bytes(msg []byte) []byte {
+	return msg[f.Offset : f.Offset+f.Length]
+}
+
+// MessageLayout is the wire layout of a handshake message.
+type MessageLayout struct {
+	// MessageIndex is the index of the handshake message.
+	MessageIndex int
+
+	// FromInitiator is true iff the message was sent by the initiator.
+	FromInitiator bool
+
+	// Fields are the fields corresponding to each of the message's handshake
+	// pattern tokens that have a wire representation (`e`, `s`, `v`, `sig`).
+	Fields []Field
+
+	// Payload is the message payload.
+	Payload Field
+}
+
+// LayoutError is the error returned when a handshake message does not match
+// the wire layout expected by the protocol.
+type LayoutError struct {
+	// MessageIndex is the index of the handshake message.
+	MessageIndex int
+
+	// Token is the handshake pattern token being parsed, or
+	// `pattern.Token_invalid` for the message payload.
+	Token pattern.Token
+
+	// Offset is the offset in the message where the deviation occurred.
+	Offset int
+
+	// Expected is the minimum number of bytes expected at Offset.
+	Expected int
+
+	// Remaining is the number of bytes actually remaining at Offset.
+	Remaining int
+}
+
+// Error returns the string representation of the error.
+func (e *LayoutError) Error() string {
+	what := "payload"
+	if e.Token != pattern.Token_invalid {
+		what = "token " + e.Token.String()
+	}
+	return fmt.Sprintf(
+		"nyquist/transcript: message %d: truncated %s at offset %d (expected %d bytes, %d remaining)",
+		e.MessageIndex,
+		what,
+		e.Offset,
+		e.Expected,
+		e.Remaining,
+	)
+}
+
+// ParseMessageLayout parses the wire layout of the handshake message with
+// the specified index, based on the protocol's handshake pattern, `DHLEN`,
+// the signature scheme's key and signature sizes, and the cipher's
+// authentication tag size.
+func ParseMessageLayout(protocol *nyquist.Protocol, messageIndex int, msg []byte) (*MessageLayout, error) {
+	messages := protocol.Pattern.Messages()
+	if messageIndex < 0 || messageIndex >= len(messages) {
+		return nil, errInvalidMessageIndex
+	}
+
+	tagLen, err := cipherOverhead(protocol)
+	if err != nil {
+		return nil, err
+	}
+	dhLen := protocol.DH.Size()
+	hasPSKs := protocol.Pattern.NumPSKs() > 0
+
+	// Determine if the CipherState is keyed at the start of the message,
+	// by walking the pattern.  The pre-messages only key the CipherState
+	// if `e` is present and the pattern uses PSKs.
+	hasKey := false
+	for _, msg := range protocol.Pattern.PreMessages() {
+		for _, v := range msg {
+			if v == pattern.Token_e && hasPSKs {
+				hasKey = true
+			}
+		}
+	}
+	for _, msg := range messages[:messageIndex] {
+		hasKey = hasKey || messageKeysCipherState(msg, hasPSKs)
+	}
+
+	layout := &MessageLayout{
+		MessageIndex:  messageIndex,
+		FromInitiator: messageIndex&1 == 0,
+	}
+	off := 0
+	checkLen := func(token pattern.Token, l int) error {
+		if remaining := len(msg) - off; remaining < l {
+			return &LayoutError{
+				MessageIndex: messageIndex,
+				Token:        token,
+				Offset:       off,
+				Expected:     l,
+				Remaining:    remaining,
+			}
+		}
+		return nil
+	}
+
+	for _, v := range messages[messageIndex] {
+		switch v {
+		case pattern.Token_e:
+			if err = checkLen(v, dhLen); err != nil {
+				return nil, err
+			}
+			layout.Fields = append(layout.Fields, Field{
+				Token:  v,
+				Offset: off,
+				Length: dhLen,
+			})
+			off += dhLen
+			if hasPSKs {
+				hasKey = true
+			}
+		case pattern.Token_s, pattern.Token_v, pattern.Token_sig:
+			var l int
+			switch v {
+			case pattern.Token_s:
+				l = dhLen
+			case pattern.Token_v:
+				l = protocol.Sig.PublicKeySize()
+			case pattern.Token_sig:
+				l = protocol.Sig.SignatureSize()
+			}
+			if hasKey {
+				l += tagLen
+			}
+			if err = checkLen(v, l); err != nil {
+				return nil, err
+			}
+			layout.Fields = append(layout.Fields, Field{
+				Token:       v,
+				Offset:      off,
+				Length:      l,
+				IsEncrypted: hasKey,
+			})
+			off += l
+		default:
+			hasKey = hasKey || tokenKeysCipherState(v, hasPSKs)
+		}
+	}
+
+	payloadMin := 0
+	if hasKey {
+		payloadMin = tagLen
+	}
+	if err = checkLen(pattern.Token_invalid, payloadMin); err != nil {
+		return nil, err
+	}
+	layout.Payload = Field{
+		Token:       pattern.Token_invalid,
+		Offset:      off,
+		Length:      len(msg) - off,
+		IsEncrypted: hasKey,
+	}
+
+	return layout, nil
+}
+
+func messageKeysCipherState(msg pattern.Message, hasPSKs bool) bool {
+	for _, v := range msg {
+		if tokenKeysCipherState(v, hasPSKs) {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenKeysCipherState(token pattern.Token, hasPSKs bool) bool {
+	switch token {
+	case pattern.Token_e:
+		return hasPSKs
+	case pattern.Token_ee, pattern.Token_es, pattern.Token_se, pattern.Token_ss, pattern.Token_psk:
+		return true
+	default:
+		return false
+	}
+}
+
+func cipherOverhead(protocol *nyquist.Protocol) (int, error) {
+	aead, err := protocol.Cipher.New(make([]byte, nyquist.SymmetricKeySize))
+	if err != nil {
+		return 0, err
+	}
+	return aead.Overhead(), nil
+}
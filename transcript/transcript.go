@@ -0,0 +1,359 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package transcript implements an offline decoder for recorded Noise
+// Protocol Framework handshakes and sessions.
+package transcript // import "gitlab.com/yawning/nyquist.git/transcript"
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var (
+	// ErrNoKeys is the error set on messages that could not be decrypted
+	// due to missing key material.
+	ErrNoKeys = errors.New("nyquist/transcript: no key material")
+
+	errMissingProtocol = errors.New("nyquist/transcript: missing protocol")
+	errNoRng           = errors.New("nyquist/transcript: entropy source unavailable")
+)
+
+// Config is a decoder configuration.  All key material is optional, and
+// will be used to decrypt as much of the transcript as possible.
+type Config struct {
+	// Protocol is the noise protocol used for the session.
+	Protocol *nyquist.Protocol
+
+	// Prologue is the prologue used for the session.
+	Prologue []byte
+
+	// IsInitiator should be set to true if the key material provided is
+	// that of the initiator.
+	IsInitiator bool
+
+	// LocalStatic is the static keypair of the party being observed, if any.
+	LocalStatic dh.Keypair
+
+	// LocalEphemeral is the ephemeral keypair of the party being observed,
+	// if any.  It is required to follow the handshake past the first message
+	// sent by the party being observed that contains an `e` token.
+	LocalEphemeral dh.Keypair
+
+	// RemoteStatic is the static public key of the peer, if it is part of
+	// the pre-messages.
+	RemoteStatic dh.PublicKey
+
+	// PreSharedKeys is the vector of pre-shared symmetric keys for PSK mode
+	// handshakes.
+	PreSharedKeys [][]byte
+
+	// KeyLog is the optional key log, used to decrypt transport messages.
+	KeyLog *KeyLog
+
+	// MaxMessageSize is the maximum message size, with the same semantics
+	// as `nyquist.HandshakeConfig.MaxMessageSize`.
+	MaxMessageSize int
+}
+
+func (cfg *Config) canReplay() bool {
+	return cfg.LocalStatic != nil || cfg.LocalEphemeral != nil
+}
+
+// HandshakeMessage is a decoded handshake message.
+type HandshakeMessage struct {
+	// Raw is the raw message.
+	Raw []byte
+
+	// Layout is the message's wire layout, if it could be parsed.
+	Layout *MessageLayout
+
+	// Payload is the decrypted message payload, if available.
+	Payload []byte
+
+	// Err is the error encountered while decoding the message, if any.
+	Err error
+}
+
+// TransportMessage is a decoded transport message.
+type TransportMessage struct {
+	// FromInitiator is true iff the message was sent by the initiator.
+	FromInitiator bool
+
+	// Index is the index of the message, in the direction it was sent.
+	Index int
+
+	// Raw is the raw message.
+	Raw []byte
+
+	// Plaintext is the decrypted message, if available.
+	Plaintext []byte
+
+	// Err is the error encountered while decoding the message, if any.
+	Err error
+}
+
+// Transcript is a decoded transcript.
+type Transcript struct {
+	// ProtocolName is the protocol name.
+	ProtocolName string
+
+	// HandshakeMessages are the handshake messages.
+	HandshakeMessages []*HandshakeMessage
+
+	// TransportMessages are the transport messages, ordered by direction
+	// (initiator to responder first).
+	TransportMessages []*TransportMessage
+
+	// HandshakeHash is the handshake hash, if available.
+	HandshakeHash []byte
+}
+
+type failReader struct{}
+
+func (r *failReader) Read(p []byte) (int, error) {
+	return 0, errNoRng
+}
+
+// Decode decodes a recorded session, given each direction's messages.  The
+// handshake is replayed through a `nyquist.HandshakeState` in the observed
+// party's role if any of the party's keypairs are provided, and the
+// transport messages are decrypted with the keys from the key log if
+// present, or the keys derived by the replayed handshake.
+func Decode(cfg *Config, initiatorMessages, responderMessages [][]byte) (*Transcript, error) {
+	if cfg.Protocol == nil {
+		return nil, errMissingProtocol
+	}
+
+	var (
+		hs  *nyquist.HandshakeState
+		err error
+	)
+	if cfg.canReplay() {
+		if hs, err = nyquist.NewHandshake(&nyquist.HandshakeConfig{
+			Protocol:       cfg.Protocol,
+			Prologue:       cfg.Prologue,
+			LocalStatic:    cfg.LocalStatic,
+			LocalEphemeral: cfg.LocalEphemeral,
+			RemoteStatic:   cfg.RemoteStatic,
+			PreSharedKeys:  cfg.PreSharedKeys,
+			Rng:            &failReader{},
+			MaxMessageSize: cfg.MaxMessageSize,
+			IsInitiator:    cfg.IsInitiator,
+		}); err != nil {
+			return nil, err
+		}
+		defer hs.Reset()
+	}
+
+	t := &Transcript{
+		ProtocolName: cfg.Protocol.String(),
+	}
+	msgs := [2][][]byte{initiatorMessages, responderMessages}
+
+	// Handshake messages.
+	var initiatorE, responderE []byte
+	for i := range cfg.Protocol.Pattern.Messages() {
+		fromInitiator := i&1 == 0
+		dir := 1
+		if fromInitiator {
+			dir = 0
+		}
+		if len(msgs[dir]) == 0 {
+			break
+		}
+
+		m := &HandshakeMessage{
+			Raw: msgs[dir][0],
+		}
+		msgs[dir] = msgs[dir][1:]
+		t.HandshakeMessages = append(t.HandshakeMessages, m)
+
+		if m.Layout, m.Err = ParseMessageLayout(cfg.Protocol, i, m.Raw); m.Err == nil {
+			for _, f := range m.Layout.Fields {
+				if f.Token != pattern.Token_e {
+					continue
+				}
+				if fromInitiator {
+					initiatorE = f.Bytes(m.Raw)
+				} else {
+					responderE = f.Bytes(m.Raw)
+				}
+			}
+		}
+
+		if hs == nil {
+			if m.Err == nil {
+				m.Err = ErrNoKeys
+			}
+			continue
+		}
+
+		var payload []byte
+		if fromInitiator == cfg.IsInitiator {
+			payload, err = hs.ReplayMessage(nil, m.Raw)
+		} else {
+			payload, err = hs.ReadMessage(nil, m.Raw)
+		}
+		switch err {
+		case nil:
+			m.Payload = payload
+		case nyquist.ErrDone:
+			m.Payload = payload
+			t.HandshakeHash = hs.GetStatus().HandshakeHash
+		default:
+			// Prefer the layout error, as it is more specific.
+			if m.Err == nil {
+				m.Err = err
+			}
+			hs = nil
+		}
+	}
+
+	// Transport messages.
+	var keys *KeyLogSession
+	if cfg.KeyLog != nil {
+		if keys = cfg.KeyLog.Lookup(t.ProtocolName, initiatorE, responderE); keys != nil && t.HandshakeHash == nil {
+			t.HandshakeHash = keys.HandshakeHash
+		}
+	}
+	var cipherStates []*nyquist.CipherState
+	if hs != nil {
+		if status := hs.GetStatus(); status.Err == nyquist.ErrDone {
+			cipherStates = status.CipherStates
+		}
+	}
+
+	for dir, dirMsgs := range msgs {
+		var (
+			gen   int
+			nonce uint64
+		)
+		for i, raw := range dirMsgs {
+			m := &TransportMessage{
+				FromInitiator: dir == 0,
+				Index:         i,
+				Raw:           raw,
+				Err:           ErrNoKeys,
+			}
+			t.TransportMessages = append(t.TransportMessages, m)
+
+			switch {
+			case keys != nil && keys.Keys[dir] != nil:
+				m.Plaintext, gen, m.Err = decryptWithKeyLog(cfg.Protocol, keys.Keys[dir], gen, nonce, raw)
+			case cipherStates != nil && cipherStates[dir] != nil:
+				cs := cipherStates[dir]
+				cs.SetNonce(nonce)
+				m.Plaintext, m.Err = cs.DecryptWithAd(nil, nil, raw)
+			}
+
+			// Assume that the message consumed a nonce, even if decryption
+			// failed.
+			nonce++
+		}
+	}
+
+	return t, nil
+}
+
+func decryptWithKeyLog(protocol *nyquist.Protocol, keys [][]byte, gen int, nonce uint64, ciphertext []byte) ([]byte, int, error) {
+	// Rekeying is not signaled on the wire, so try the current key first,
+	// and then any subsequent keys.
+	// On failure, the generation is left unaltered, so that a corrupted
+	// message does not cause subsequent messages to skip keys.
+	encodedNonce := protocol.Cipher.EncodeNonce(nonce)
+	for i := gen; i < len(keys); i++ {
+		aead, err := protocol.Cipher.New(keys[i])
+		if err != nil {
+			return nil, gen, err
+		}
+		if plaintext, err := aead.Open(nil, encodedNonce, ciphertext, nil); err == nil {
+			return plaintext, i, nil
+		}
+	}
+	return nil, gen, nyquist.ErrOpen
+}
+
+// Dump writes a human readable representation of the transcript to `w`.
+func (t *Transcript) Dump(w io.Writer) error {
+	var err error
+	printf := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+	direction := func(fromInitiator bool) string {
+		if fromInitiator {
+			return "initiator -> responder"
+		}
+		return "responder -> initiator"
+	}
+
+	printf("protocol: %s\n", t.ProtocolName)
+	for i, m := range t.HandshakeMessages {
+		printf("handshake message %d (%s), %d bytes\n", i, direction(i&1 == 0), len(m.Raw))
+		if m.Layout != nil {
+			for _, f := range append(append([]Field{}, m.Layout.Fields...), m.Layout.Payload) {
+				name := "payload"
+				if f.Token != pattern.Token_invalid {
+					name = f.Token.String()
+				}
+				var encrypted string
+				if f.IsEncrypted {
+					encrypted = " (encrypted)"
+				}
+				printf("  %-7s [%d:%d]%s %s\n", name, f.Offset, f.Offset+f.Length, encrypted, hex.EncodeToString(f.Bytes(m.Raw)))
+			}
+		}
+		if m.Err != nil {
+			printf("  error: %v\n", m.Err)
+		} else {
+			printf("  plaintext: %s\n", hex.EncodeToString(m.Payload))
+		}
+	}
+	if t.HandshakeHash != nil {
+		printf("handshake hash: %s\n", hex.EncodeToString(t.HandshakeHash))
+	}
+	for _, m := range t.TransportMessages {
+		printf("transport message %d (%s), %d bytes\n", m.Index, direction(m.FromInitiator), len(m.Raw))
+		if m.Err != nil {
+			printf("  error: %v\n", m.Err)
+		} else {
+			printf("  plaintext: %s\n", hex.EncodeToString(m.Plaintext))
+		}
+	}
+
+	return err
+}
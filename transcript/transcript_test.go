@@ -0,0 +1,333 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package transcript
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+type testSession struct {
+	protocol *nyquist.Protocol
+
+	aliceStatic, bobStatic       dh.Keypair
+	aliceEphemeral, bobEphemeral dh.Keypair
+
+	keyLog bytes.Buffer
+
+	msgs [2][][]byte
+
+	handshakePayloads [][]byte
+	transportPayloads [2][][]byte
+	handshakeHash     []byte
+}
+
+func mustRecordSession(t *testing.T) *testSession {
+	require := require.New(t)
+
+	var (
+		sess testSession
+		err  error
+	)
+	sess.protocol, err = nyquist.NewProtocol("Noise_IK_25519_ChaChaPoly_BLAKE2s")
+	require.NoError(err, "NewProtocol")
+
+	for _, kp := range []*dh.Keypair{&sess.aliceStatic, &sess.bobStatic, &sess.aliceEphemeral, &sess.bobEphemeral} {
+		*kp, err = sess.protocol.DH.GenerateKeypair(rand.Reader)
+		require.NoError(err, "GenerateKeypair")
+	}
+
+	aliceHs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:       sess.protocol,
+		LocalStatic:    sess.aliceStatic,
+		LocalEphemeral: sess.aliceEphemeral,
+		RemoteStatic:   sess.bobStatic.Public(),
+		KeyLog:         &sess.keyLog,
+		IsInitiator:    true,
+	})
+	require.NoError(err, "NewHandshake(alice)")
+	bobHs, err := nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:       sess.protocol,
+		LocalStatic:    sess.bobStatic,
+		LocalEphemeral: sess.bobEphemeral,
+	})
+	require.NoError(err, "NewHandshake(bob)")
+
+	sess.handshakePayloads = [][]byte{[]byte("alice handshake payload"), []byte("bob handshake payload")}
+	msg, err := aliceHs.WriteMessage(nil, sess.handshakePayloads[0])
+	require.NoError(err, "aliceHs.WriteMessage")
+	sess.msgs[0] = append(sess.msgs[0], msg)
+	_, err = bobHs.ReadMessage(nil, msg)
+	require.NoError(err, "bobHs.ReadMessage")
+	msg, err = bobHs.WriteMessage(nil, sess.handshakePayloads[1])
+	require.Equal(nyquist.ErrDone, err, "bobHs.WriteMessage")
+	sess.msgs[1] = append(sess.msgs[1], msg)
+	_, err = aliceHs.ReadMessage(nil, msg)
+	require.Equal(nyquist.ErrDone, err, "aliceHs.ReadMessage")
+
+	status := aliceHs.GetStatus()
+	sess.handshakeHash = status.HandshakeHash
+	for i, v := range []string{"first", "second", "after rekey"} {
+		if i == 2 {
+			require.NoError(status.CipherStates[0].Rekey(), "Rekey")
+		}
+		pt := []byte("alice transport " + v)
+		msg, err = status.CipherStates[0].EncryptWithAd(nil, nil, pt)
+		require.NoError(err, "EncryptWithAd")
+		sess.msgs[0] = append(sess.msgs[0], msg)
+		sess.transportPayloads[0] = append(sess.transportPayloads[0], pt)
+	}
+	pt := []byte("bob transport")
+	msg, err = bobHs.GetStatus().CipherStates[1].EncryptWithAd(nil, nil, pt)
+	require.NoError(err, "EncryptWithAd")
+	sess.msgs[1] = append(sess.msgs[1], msg)
+	sess.transportPayloads[1] = append(sess.transportPayloads[1], pt)
+
+	return &sess
+}
+
+func requireTransport(t *testing.T, sess *testSession, tr *Transcript) {
+	require := require.New(t)
+
+	var idx [2]int
+	require.Len(tr.TransportMessages, len(sess.transportPayloads[0])+len(sess.transportPayloads[1]))
+	for _, m := range tr.TransportMessages {
+		dir := 1
+		if m.FromInitiator {
+			dir = 0
+		}
+		require.NoError(m.Err, "transport message %d", m.Index)
+		require.Equal(sess.transportPayloads[dir][idx[dir]], m.Plaintext, "transport message %d", m.Index)
+		idx[dir]++
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	for _, v := range []struct {
+		n  string
+		fn func(*testing.T)
+	}{
+		{"KeyLog", testTranscriptKeyLog},
+		{"KeyLogCorrupt", testTranscriptKeyLogCorrupt},
+		{"Replay", testTranscriptReplay},
+		{"Layout", testTranscriptLayout},
+		{"Frames", testTranscriptFrames},
+	} {
+		t.Run(v.n, v.fn)
+	}
+}
+
+func testTranscriptKeyLog(t *testing.T) {
+	require := require.New(t)
+
+	sess := mustRecordSession(t)
+	keyLog, err := ParseKeyLog(strings.NewReader("# comment\n\n" + sess.keyLog.String()))
+	require.NoError(err, "ParseKeyLog")
+
+	tr, err := Decode(&Config{
+		Protocol: sess.protocol,
+		KeyLog:   keyLog,
+	}, sess.msgs[0], sess.msgs[1])
+	require.NoError(err, "Decode")
+
+	require.Len(tr.HandshakeMessages, 2, "HandshakeMessages")
+	for _, m := range tr.HandshakeMessages {
+		require.NotNil(m.Layout, "Layout")
+		require.ErrorIs(m.Err, ErrNoKeys, "Handshake messages can't be decrypted")
+	}
+	require.Equal(sess.handshakeHash, tr.HandshakeHash, "HandshakeHash from key log")
+	requireTransport(t, sess, tr)
+
+	var out bytes.Buffer
+	require.NoError(tr.Dump(&out), "Dump")
+	require.Contains(out.String(), "handshake message 1 (responder -> initiator), 69 bytes\n  e       [0:32] ", "Dump")
+
+	// The ephemeral keys are matched by value, not by their encoding.
+	var upper strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(sess.keyLog.String()), "\n") {
+		fields := strings.Fields(line)
+		fields[2], fields[3] = strings.ToUpper(fields[2]), strings.ToUpper(fields[3])
+		upper.WriteString(strings.Join(fields, " ") + "\n")
+	}
+	keyLog, err = ParseKeyLog(strings.NewReader(upper.String()))
+	require.NoError(err, "ParseKeyLog - upper case")
+	tr, err = Decode(&Config{
+		Protocol: sess.protocol,
+		KeyLog:   keyLog,
+	}, sess.msgs[0], sess.msgs[1])
+	require.NoError(err, "Decode - upper case")
+	requireTransport(t, sess, tr)
+
+	_, err = ParseKeyLog(strings.NewReader(nyquist.KeyLogLabelRekey + " a b c 1 1 00\n"))
+	require.Error(err, "ParseKeyLog - rekey for unknown session")
+}
+
+func testTranscriptKeyLogCorrupt(t *testing.T) {
+	require := require.New(t)
+
+	sess := mustRecordSession(t)
+	keyLog, err := ParseKeyLog(&sess.keyLog)
+	require.NoError(err, "ParseKeyLog")
+
+	// Corrupt the first transport message, which should not prevent the
+	// subsequent messages (before and after the rekey) from decrypting.
+	sess.msgs[0][1][0] ^= 0xa5
+
+	tr, err := Decode(&Config{
+		Protocol: sess.protocol,
+		KeyLog:   keyLog,
+	}, sess.msgs[0], sess.msgs[1])
+	require.NoError(err, "Decode")
+
+	require.Len(tr.TransportMessages, 4, "TransportMessages")
+	require.ErrorIs(tr.TransportMessages[0].Err, nyquist.ErrOpen, "corrupted message")
+	for i, m := range tr.TransportMessages[1:3] {
+		require.NoError(m.Err, "transport message %d", m.Index)
+		require.Equal(sess.transportPayloads[0][i+1], m.Plaintext, "transport message %d", m.Index)
+	}
+}
+
+func testTranscriptReplay(t *testing.T) {
+	require := require.New(t)
+
+	sess := mustRecordSession(t)
+
+	// Only the responder's static key allows the first message to be read.
+	cfg := &Config{
+		Protocol:    sess.protocol,
+		LocalStatic: sess.bobStatic,
+	}
+	tr, err := Decode(cfg, sess.msgs[0], sess.msgs[1])
+	require.NoError(err, "Decode - responder static")
+	require.NoError(tr.HandshakeMessages[0].Err, "Handshake message 0")
+	require.Equal(sess.handshakePayloads[0], tr.HandshakeMessages[0].Payload, "Handshake message 0")
+
+	var hsErr *nyquist.HandshakeError
+	require.ErrorAs(tr.HandshakeMessages[1].Err, &hsErr, "Handshake message 1 - missing e")
+	require.Equal(pattern.Token_e, hsErr.Token, "Handshake message 1 - missing e")
+	for _, m := range tr.TransportMessages {
+		require.ErrorIs(m.Err, ErrNoKeys, "Transport messages can't be decrypted")
+	}
+
+	// With the ephemeral key, the entire handshake can be replayed.
+	cfg.LocalEphemeral = sess.bobEphemeral
+	tr, err = Decode(cfg, sess.msgs[0], sess.msgs[1])
+	require.NoError(err, "Decode - responder static and ephemeral")
+	for i, m := range tr.HandshakeMessages {
+		require.NoError(m.Err, "Handshake message %d", i)
+		require.Equal(sess.handshakePayloads[i], m.Payload, "Handshake message %d", i)
+	}
+	require.Equal(sess.handshakeHash, tr.HandshakeHash, "HandshakeHash from replay")
+
+	// Without a key log, the rekey is not discoverable.
+	for _, m := range tr.TransportMessages {
+		if m.FromInitiator && m.Index == 2 {
+			require.ErrorIs(m.Err, nyquist.ErrOpen, "Transport message after rekey")
+			continue
+		}
+		require.NoError(m.Err, "Transport message")
+	}
+
+	// ... but with one, it is.
+	cfg.KeyLog, err = ParseKeyLog(&sess.keyLog)
+	require.NoError(err, "ParseKeyLog")
+	tr, err = Decode(cfg, sess.msgs[0], sess.msgs[1])
+	require.NoError(err, "Decode - responder keys and key log")
+	requireTransport(t, sess, tr)
+}
+
+func testTranscriptLayout(t *testing.T) {
+	require := require.New(t)
+
+	sess := mustRecordSession(t)
+
+	layout, err := ParseMessageLayout(sess.protocol, 0, sess.msgs[0][0])
+	require.NoError(err, "ParseMessageLayout(0)")
+	require.Equal([]Field{
+		{Token: pattern.Token_e, Offset: 0, Length: 32},
+		{Token: pattern.Token_s, Offset: 32, Length: 48, IsEncrypted: true},
+	}, layout.Fields, "Message 0 fields")
+	require.Equal(Field{Offset: 80, Length: len(sess.handshakePayloads[0]) + 16, IsEncrypted: true}, layout.Payload, "Message 0 payload")
+	require.Equal(sess.aliceEphemeral.Public().Bytes(), layout.Fields[0].Bytes(sess.msgs[0][0]), "Message 0 e")
+
+	_, err = ParseMessageLayout(sess.protocol, 0, sess.msgs[0][0][:40])
+	require.Equal(&LayoutError{
+		MessageIndex: 0,
+		Token:        pattern.Token_s,
+		Offset:       32,
+		Expected:     48,
+		Remaining:    8,
+	}, err, "ParseMessageLayout(0) - truncated s")
+
+	_, err = ParseMessageLayout(sess.protocol, 1, sess.msgs[1][0][:40])
+	require.Equal(&LayoutError{
+		MessageIndex: 1,
+		Token:        pattern.Token_invalid,
+		Offset:       32,
+		Expected:     16,
+		Remaining:    8,
+	}, err, "ParseMessageLayout(1) - truncated payload")
+	require.EqualError(err, "nyquist/transcript: message 1: truncated payload at offset 32 (expected 16 bytes, 8 remaining)")
+
+	// Layout errors are reported in preference to decryption errors.
+	tr, err := Decode(&Config{
+		Protocol:    sess.protocol,
+		LocalStatic: sess.bobStatic,
+	}, [][]byte{sess.msgs[0][0][:40]}, nil)
+	require.NoError(err, "Decode - truncated")
+	require.IsType(&LayoutError{}, tr.HandshakeMessages[0].Err, "Decode - truncated")
+}
+
+func testTranscriptFrames(t *testing.T) {
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	for _, v := range [][]byte{[]byte("first"), {}, []byte("third")} {
+		var hdr [2]byte
+		binary.BigEndian.PutUint16(hdr[:], uint16(len(v)))
+		buf.Write(hdr[:])
+		buf.Write(v)
+	}
+	frames, err := ReadFrames(bytes.NewReader(buf.Bytes()))
+	require.NoError(err, "ReadFrames")
+	require.Equal([][]byte{[]byte("first"), {}, []byte("third")}, frames, "ReadFrames")
+
+	_, err = ReadFrames(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	require.EqualError(err, "nyquist/transcript: truncated frame at offset 9 (expected 5 bytes, 4 remaining)", "ReadFrames - truncated")
+}
@@ -0,0 +1,264 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wireguard
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"gitlab.com/yawning/nyquist.git/dh"
+)
+
+const (
+	// CookieRefreshTime is the interval at which the cookie secret is
+	// rotated, and received cookies expire.
+	CookieRefreshTime = 120 * time.Second
+
+	cookieSize = 16
+)
+
+// CookieChecker validates the MACs on received handshake messages, and
+// generates cookie reply messages when under load.
+type CookieChecker struct {
+	l sync.Mutex
+
+	mac1Key   []byte
+	cookieKey []byte
+
+	secret     [32]byte
+	secretTime time.Time
+
+	now func() time.Time
+	rng io.Reader
+}
+
+// CheckMAC1 verifies the message's mac1.
+func (cc *CookieChecker) CheckMAC1(msg []byte) error {
+	mac1Off, _, err := macOffsets(msg)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac(cc.mac1Key, msg[:mac1Off]), msg[mac1Off:mac1Off+macSize]) {
+		return ErrInvalidMAC1
+	}
+	return nil
+}
+
+// CheckMAC2 verifies the message's mac2, based on the cookie for the
+// source address (eg: the IP address and port).  The mac1 must be
+// verified separately.
+func (cc *CookieChecker) CheckMAC2(msg, src []byte) error {
+	_, mac2Off, err := macOffsets(msg)
+	if err != nil {
+		return err
+	}
+	cookie, err := cc.cookie(src)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac(cookie, msg[:mac2Off]), msg[mac2Off:mac2Off+macSize]) {
+		return ErrInvalidMAC2
+	}
+	return nil
+}
+
+// CreateReply creates a cookie reply message in response to msg, for the
+// source address.
+func (cc *CookieChecker) CreateReply(msg, src []byte) ([]byte, error) {
+	mac1Off, _, err := macOffsets(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := messageHeader(MessageCookieReplyType, MessageCookieReplySize)
+	reply = append(reply, msg[4:8]...) // The sender index of msg.
+	reply = reply[:8+chacha20poly1305.NonceSizeX]
+	if _, err = io.ReadFull(cc.rng, reply[8:]); err != nil {
+		return nil, err
+	}
+
+	cookie, err := cc.cookie(src)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(cc.cookieKey)
+	if err != nil {
+		return nil, err
+	}
+	reply = aead.Seal(reply, reply[8:], cookie, msg[mac1Off:mac1Off+macSize])
+
+	return reply, nil
+}
+
+func (cc *CookieChecker) cookie(src []byte) ([]byte, error) {
+	cc.l.Lock()
+	defer cc.l.Unlock()
+
+	if now := cc.now(); cc.secretTime.IsZero() || now.Sub(cc.secretTime) > CookieRefreshTime {
+		if _, err := io.ReadFull(cc.rng, cc.secret[:]); err != nil {
+			return nil, err
+		}
+		cc.secretTime = now
+	}
+
+	return mac(cc.secret[:], src), nil
+}
+
+// NewCookieChecker creates a new CookieChecker for the local static key.
+func NewCookieChecker(cfg *Config) *CookieChecker {
+	publicKey := cfg.LocalStatic.Public().Bytes()
+	return &CookieChecker{
+		mac1Key:   labeledHash(labelMAC1, publicKey),
+		cookieKey: labeledHash(labelCookie, publicKey),
+		now:       cfg.getNow(),
+		rng:       cfg.getRng(),
+	}
+}
+
+// CookieGenerator adds the MACs to outgoing handshake messages for a peer,
+// and consumes cookie replies from the peer.  It should be shared between
+// all handshakes with the same peer, so that cookies persist across
+// handshake attempts.
+type CookieGenerator struct {
+	l sync.Mutex
+
+	mac1Key   []byte
+	cookieKey []byte
+
+	lastMAC1   []byte
+	cookie     []byte
+	cookieTime time.Time
+
+	now func() time.Time
+}
+
+func (cg *CookieGenerator) addMACs(msg []byte) {
+	cg.l.Lock()
+	defer cg.l.Unlock()
+
+	mac1Off, mac2Off, err := macOffsets(msg)
+	if err != nil {
+		panic("nyquist/wireguard: invalid message for MACs: " + err.Error())
+	}
+
+	cg.lastMAC1 = mac(cg.mac1Key, msg[:mac1Off])
+	copy(msg[mac1Off:], cg.lastMAC1)
+
+	mac2 := msg[mac2Off : mac2Off+macSize]
+	if cg.cookie != nil && cg.now().Sub(cg.cookieTime) < CookieRefreshTime {
+		copy(mac2, mac(cg.cookie, msg[:mac2Off]))
+	} else {
+		for i := range mac2 {
+			mac2[i] = 0
+		}
+	}
+}
+
+func (cg *CookieGenerator) consumeReply(msg []byte) error {
+	cg.l.Lock()
+	defer cg.l.Unlock()
+
+	if cg.lastMAC1 == nil {
+		return ErrInvalidMessage
+	}
+
+	aead, err := chacha20poly1305.NewX(cg.cookieKey)
+	if err != nil {
+		return err
+	}
+	nonce := msg[8 : 8+chacha20poly1305.NonceSizeX]
+	cookie, err := aead.Open(nil, nonce, msg[8+chacha20poly1305.NonceSizeX:], cg.lastMAC1)
+	if err != nil {
+		return ErrInvalidMessage
+	}
+	cg.cookie = cookie
+	cg.cookieTime = cg.now()
+
+	return nil
+}
+
+// NewCookieGenerator creates a new CookieGenerator for the remote static
+// key.
+func NewCookieGenerator(cfg *Config) *CookieGenerator {
+	return newCookieGenerator(cfg.RemoteStatic, cfg.getNow())
+}
+
+func newCookieGenerator(remoteStatic dh.PublicKey, now func() time.Time) *CookieGenerator {
+	publicKey := remoteStatic.Bytes()
+	return &CookieGenerator{
+		mac1Key:   labeledHash(labelMAC1, publicKey),
+		cookieKey: labeledHash(labelCookie, publicKey),
+		now:       now,
+	}
+}
+
+func macOffsets(msg []byte) (int, int, error) {
+	var size int
+	switch MessageType(msg) {
+	case MessageInitiationType:
+		size = MessageInitiationSize
+	case MessageResponseType:
+		size = MessageResponseSize
+	default:
+		return 0, 0, ErrInvalidMessage
+	}
+	if len(msg) != size {
+		return 0, 0, ErrInvalidMessage
+	}
+	return size - 2*macSize, size - macSize, nil
+}
+
+func (cfg *Config) getRng() io.Reader {
+	if cfg.Rng == nil {
+		return rand.Reader
+	}
+	return cfg.Rng
+}
+
+func (cfg *Config) getNow() func() time.Time {
+	if cfg.Now == nil {
+		return time.Now
+	}
+	return cfg.Now
+}
+
+func putIndex(b []byte, index uint32) {
+	binary.LittleEndian.PutUint32(b, index)
+}
+
+func getIndex(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b)
+}
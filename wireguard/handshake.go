@@ -0,0 +1,374 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wireguard
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/dh"
+	"gitlab.com/yawning/nyquist.git/pattern"
+)
+
+var (
+	errNoLookup     = errors.New("nyquist/wireguard: no peer lookup callback")
+	errInvalidRole  = errors.New("nyquist/wireguard: invalid operation for role")
+	errInvalidState = errors.New("nyquist/wireguard: invalid handshake state")
+	errBadPSK       = errors.New("nyquist/wireguard: malformed pre-shared key")
+)
+
+// Config is a WireGuard handshake configuration.
+type Config struct {
+	// LocalStatic is the local static keypair.
+	LocalStatic dh.Keypair
+
+	// RemoteStatic is the remote static public key, required for the
+	// initiator.
+	RemoteStatic dh.PublicKey
+
+	// PresharedKey is the optional pre-shared key for the initiator.  If
+	// nil, the all zero key will be used.
+	PresharedKey []byte
+
+	// Cookies is the optional cookie state for the peer, for the
+	// initiator.  If nil, cookies will not persist across handshakes.
+	Cookies *CookieGenerator
+
+	// LookupPeer is called by the responder with the initiator's static
+	// public key, and must return the pre-shared key for the peer (or nil
+	// for none), or an error to reject the peer.
+	LookupPeer func(remoteStatic dh.PublicKey) ([]byte, error)
+
+	// Now is the clock used for timestamps and cookie expiry, if nil
+	// `time.Now` will be used.
+	Now func() time.Time
+
+	// Rng is the entropy source, if nil `crypto/rand.Reader` will be used.
+	Rng io.Reader
+}
+
+// Handshake is a WireGuard handshake.
+type Handshake struct {
+	cfg     *Config
+	hs      *nyquist.HandshakeState
+	psk     []byte
+	cookies *CookieGenerator
+
+	remoteStatic dh.PublicKey
+	timestamp    TAI64N
+
+	localIndex  uint32
+	remoteIndex uint32
+	isInitiator bool
+}
+
+// LocalIndex returns the local (sender) index.
+func (h *Handshake) LocalIndex() uint32 {
+	return h.localIndex
+}
+
+// RemoteIndex returns the remote (sender) index, once known.
+func (h *Handshake) RemoteIndex() uint32 {
+	return h.remoteIndex
+}
+
+// RemoteStatic returns the remote static public key, once known.
+func (h *Handshake) RemoteStatic() dh.PublicKey {
+	return h.remoteStatic
+}
+
+// Timestamp returns the timestamp of the handshake initiation.  Responders
+// must reject initiations that are not after the most recent initiation
+// from the same peer.
+func (h *Handshake) Timestamp() TAI64N {
+	return h.timestamp
+}
+
+// Reset clears the Handshake, to prevent future calls.
+func (h *Handshake) Reset() {
+	h.hs.Reset()
+	for i := range h.psk {
+		h.psk[i] = 0
+	}
+}
+
+// CreateInitiation creates a handshake initiation message.
+func (h *Handshake) CreateInitiation() ([]byte, error) {
+	if !h.isInitiator {
+		return nil, errInvalidRole
+	}
+
+	h.timestamp = NewTAI64N(h.cfg.getNow()())
+
+	msg := messageHeader(MessageInitiationType, MessageInitiationSize)
+	msg = msg[:8]
+	putIndex(msg[4:], h.localIndex)
+	msg, err := h.hs.WriteMessage(msg, h.timestamp[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) != MessageInitiationSize-2*macSize {
+		return nil, errInvalidState
+	}
+	msg = msg[:MessageInitiationSize]
+	h.cookies.addMACs(msg)
+
+	return msg, nil
+}
+
+// ConsumeCookieReply consumes a cookie reply message sent in response to
+// the most recent handshake message, so that subsequent messages (using
+// the same CookieGenerator) will include a valid mac2.
+func (h *Handshake) ConsumeCookieReply(msg []byte) error {
+	if err := checkMessage(msg, MessageCookieReplyType, MessageCookieReplySize); err != nil {
+		return err
+	}
+	if getIndex(msg[4:]) != h.localIndex {
+		return ErrInvalidIndex
+	}
+	if h.cookies == nil {
+		return errInvalidState
+	}
+	return h.cookies.consumeReply(msg)
+}
+
+// ConsumeResponse consumes a handshake response message, and returns the
+// resulting transport Session.
+func (h *Handshake) ConsumeResponse(msg []byte) (*Session, error) {
+	if !h.isInitiator {
+		return nil, errInvalidRole
+	}
+	if err := checkMessage(msg, MessageResponseType, MessageResponseSize); err != nil {
+		return nil, err
+	}
+	if err := h.checkMAC1(msg); err != nil {
+		return nil, err
+	}
+	if getIndex(msg[8:]) != h.localIndex {
+		return nil, ErrInvalidIndex
+	}
+
+	if _, err := h.hs.ReadMessage(nil, msg[12:MessageResponseSize-2*macSize]); err != nyquist.ErrDone {
+		if err == nil {
+			err = errInvalidState
+		}
+		return nil, err
+	}
+	h.remoteIndex = getIndex(msg[4:])
+
+	return h.newSession()
+}
+
+// ConsumeInitiation consumes a handshake initiation message.  The mac1 is
+// always verified, the mac2 must be verified separately via a
+// CookieChecker if desired.
+func (h *Handshake) ConsumeInitiation(msg []byte) error {
+	if h.isInitiator {
+		return errInvalidRole
+	}
+	if err := checkMessage(msg, MessageInitiationType, MessageInitiationSize); err != nil {
+		return err
+	}
+	if err := h.checkMAC1(msg); err != nil {
+		return err
+	}
+
+	payload, err := h.hs.ReadMessage(nil, msg[8:MessageInitiationSize-2*macSize])
+	if err != nil {
+		return err
+	}
+	if len(payload) != TAI64NSize {
+		return ErrInvalidMessage
+	}
+	copy(h.timestamp[:], payload)
+	h.remoteIndex = getIndex(msg[4:])
+	h.remoteStatic = h.hs.GetStatus().RemoteStatic
+	h.cookies = newCookieGenerator(h.remoteStatic, h.cfg.getNow())
+
+	return nil
+}
+
+// CreateResponse creates a handshake response message, and returns the
+// resulting transport Session.
+func (h *Handshake) CreateResponse() ([]byte, *Session, error) {
+	if h.isInitiator {
+		return nil, nil, errInvalidRole
+	}
+	if h.remoteStatic == nil {
+		return nil, nil, errInvalidState
+	}
+
+	msg := messageHeader(MessageResponseType, MessageResponseSize)
+	msg = msg[:12]
+	putIndex(msg[4:], h.localIndex)
+	putIndex(msg[8:], h.remoteIndex)
+	msg, err := h.hs.WriteMessage(msg, nil)
+	if err != nyquist.ErrDone {
+		if err == nil {
+			err = errInvalidState
+		}
+		return nil, nil, err
+	}
+	if len(msg) != MessageResponseSize-2*macSize {
+		return nil, nil, errInvalidState
+	}
+	msg = msg[:MessageResponseSize]
+	h.cookies.addMACs(msg)
+
+	sess, err := h.newSession()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return msg, sess, nil
+}
+
+func (h *Handshake) checkMAC1(msg []byte) error {
+	cc := CookieChecker{
+		mac1Key: labeledHash(labelMAC1, h.cfg.LocalStatic.Public().Bytes()),
+	}
+	return cc.CheckMAC1(msg)
+}
+
+func (h *Handshake) newSession() (*Session, error) {
+	status := h.hs.GetStatus()
+	cs1, cs2 := status.CipherStates[0], status.CipherStates[1]
+	if !h.isInitiator {
+		cs1, cs2 = cs2, cs1
+	}
+	for i := range h.psk {
+		h.psk[i] = 0
+	}
+
+	return &Session{
+		send:        cs1,
+		recv:        cs2,
+		localIndex:  h.localIndex,
+		remoteIndex: h.remoteIndex,
+	}, nil
+}
+
+// pskObserver is the responder's `nyquist.HandshakeObserver`, used to look
+// up the pre-shared key.
+type pskObserver struct {
+	h *Handshake
+}
+
+func (o *pskObserver) OnPeerPublicKey(token pattern.Token, publicKey dh.PublicKey) error {
+	if token != pattern.Token_s {
+		return nil
+	}
+
+	h := o.h
+
+	psk, err := h.cfg.LookupPeer(publicKey)
+	if err != nil {
+		return err
+	}
+	if psk != nil && len(psk) != nyquist.PreSharedKeySize {
+		return errBadPSK
+	}
+	copy(h.psk, psk)
+
+	return nil
+}
+
+// NewInitiator creates a new initiator handshake, with the local (sender)
+// index.
+func NewInitiator(cfg *Config, localIndex uint32) (*Handshake, error) {
+	h := &Handshake{
+		cfg:          cfg,
+		psk:          make([]byte, nyquist.PreSharedKeySize),
+		remoteStatic: cfg.RemoteStatic,
+		localIndex:   localIndex,
+		isInitiator:  true,
+	}
+	if cfg.PresharedKey != nil {
+		if len(cfg.PresharedKey) != nyquist.PreSharedKeySize {
+			return nil, errBadPSK
+		}
+		copy(h.psk, cfg.PresharedKey)
+	}
+	if cfg.RemoteStatic == nil {
+		return nil, errInvalidState
+	}
+	if h.cookies = cfg.Cookies; h.cookies == nil {
+		h.cookies = NewCookieGenerator(cfg)
+	}
+
+	var err error
+	if h.hs, err = nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:      protocol,
+		Prologue:      []byte(Identifier),
+		LocalStatic:   cfg.LocalStatic,
+		RemoteStatic:  cfg.RemoteStatic,
+		PreSharedKeys: [][]byte{h.psk},
+		Rng:           cfg.Rng,
+		IsInitiator:   true,
+	}); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// NewResponder creates a new responder handshake, with the local (sender)
+// index.
+func NewResponder(cfg *Config, localIndex uint32) (*Handshake, error) {
+	if cfg.LookupPeer == nil {
+		return nil, errNoLookup
+	}
+
+	h := &Handshake{
+		cfg:        cfg,
+		psk:        make([]byte, nyquist.PreSharedKeySize),
+		localIndex: localIndex,
+	}
+
+	// The responder's pre-shared key depends on the initiator's static
+	// public key, which is not known till the initiation is processed.
+	// As the `psk` token is in the response, the pre-shared key buffer is
+	// filled in by the HandshakeObserver.
+	var err error
+	if h.hs, err = nyquist.NewHandshake(&nyquist.HandshakeConfig{
+		Protocol:      protocol,
+		Prologue:      []byte(Identifier),
+		LocalStatic:   cfg.LocalStatic,
+		PreSharedKeys: [][]byte{h.psk},
+		Observer:      &pskObserver{h},
+		Rng:           cfg.Rng,
+	}); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wireguard
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"gitlab.com/yawning/nyquist.git"
+)
+
+const (
+	// RejectAfterMessages is the maximum number of transport messages
+	// that may be sent or received with a Session.
+	RejectAfterMessages = (1 << 64) - (1 << 13) - 1
+
+	paddingMultiple = 16
+
+	replayBlockBits  = 64
+	replayRingBlocks = 128
+	replayWindowSize = (replayRingBlocks - 1) * replayBlockBits
+)
+
+var (
+	// ErrReplay is the error returned when a transport data message is a
+	// replay, or is too old to be checked.
+	ErrReplay = errors.New("nyquist/wireguard: replayed transport message")
+
+	// ErrCounterExhausted is the error returned when a Session has sent
+	// or received `RejectAfterMessages` messages.
+	ErrCounterExhausted = errors.New("nyquist/wireguard: counter exhausted")
+)
+
+// Session is a WireGuard transport data session.
+type Session struct {
+	sendLock    sync.Mutex
+	send        *nyquist.CipherState
+	sendCounter uint64
+
+	recvLock sync.Mutex
+	recv     *nyquist.CipherState
+	replay   replayFilter
+
+	localIndex  uint32
+	remoteIndex uint32
+}
+
+// LocalIndex returns the local (receiver) index.
+func (s *Session) LocalIndex() uint32 {
+	return s.localIndex
+}
+
+// RemoteIndex returns the remote index, used as the receiver index in sent
+// messages.
+func (s *Session) RemoteIndex() uint32 {
+	return s.remoteIndex
+}
+
+// Seal encrypts plaintext into a transport data message, appends it to dst,
+// and returns the potentially new slice.  The plaintext is padded to a
+// multiple of 16 bytes.  An empty plaintext is a keepalive.
+func (s *Session) Seal(dst, plaintext []byte) ([]byte, error) {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	if s.send == nil || s.sendCounter >= RejectAfterMessages {
+		return nil, ErrCounterExhausted
+	}
+
+	padded := plaintext
+	if rem := len(plaintext) % paddingMultiple; rem != 0 {
+		padded = make([]byte, len(plaintext)+paddingMultiple-rem)
+		copy(padded, plaintext)
+	}
+
+	var hdr [MessageTransportHeaderSize]byte
+	hdr[0] = MessageTransportType
+	putIndex(hdr[4:], s.remoteIndex)
+	binary.LittleEndian.PutUint64(hdr[8:], s.sendCounter)
+
+	dst = append(dst, hdr[:]...)
+	s.send.SetNonce(s.sendCounter)
+	dst, err := s.send.EncryptWithAd(dst, nil, padded)
+	if err != nil {
+		return nil, err
+	}
+	s.sendCounter++
+
+	return dst, nil
+}
+
+// Open decrypts a transport data message, appends the (padded) plaintext to
+// dst, and returns the potentially new slice.
+func (s *Session) Open(dst, msg []byte) ([]byte, error) {
+	if len(msg) < MessageTransportOverhead || MessageType(msg) != MessageTransportType {
+		return nil, ErrInvalidMessage
+	}
+	if getIndex(msg[4:]) != s.localIndex {
+		return nil, ErrInvalidIndex
+	}
+	counter := binary.LittleEndian.Uint64(msg[8:])
+	if counter >= RejectAfterMessages {
+		return nil, ErrCounterExhausted
+	}
+
+	s.recvLock.Lock()
+	defer s.recvLock.Unlock()
+
+	if s.recv == nil {
+		return nil, ErrCounterExhausted
+	}
+
+	s.recv.SetNonce(counter)
+	plaintext, err := s.recv.DecryptWithAd(dst, nil, msg[MessageTransportHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The replay filter is only updated after the message is
+	// authenticated.
+	if !s.replay.validate(counter) {
+		return nil, ErrReplay
+	}
+
+	return plaintext, nil
+}
+
+// Reset clears the Session, to prevent future calls.
+func (s *Session) Reset() {
+	s.sendLock.Lock()
+	if s.send != nil {
+		s.send.Reset()
+		s.send = nil
+	}
+	s.sendLock.Unlock()
+
+	s.recvLock.Lock()
+	if s.recv != nil {
+		s.recv.Reset()
+		s.recv = nil
+	}
+	s.recvLock.Unlock()
+}
+
+// replayFilter is a sliding window replay filter, as per RFC 6479.
+type replayFilter struct {
+	last uint64
+	ring [replayRingBlocks]uint64
+}
+
+func (f *replayFilter) validate(counter uint64) bool {
+	indexBlock := counter / replayBlockBits
+	if counter > f.last {
+		current := f.last / replayBlockBits
+		diff := indexBlock - current
+		if diff > replayRingBlocks {
+			diff = replayRingBlocks
+		}
+		for i := current + 1; i <= current+diff; i++ {
+			f.ring[i%replayRingBlocks] = 0
+		}
+		f.last = counter
+	} else if f.last-counter > replayWindowSize {
+		return false
+	}
+
+	indexBlock %= replayRingBlocks
+	bit := uint64(1) << (counter % replayBlockBits)
+	old := f.ring[indexBlock]
+	f.ring[indexBlock] = old | bit
+
+	return old&bit == 0
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2026 Yawning Angel. All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are
+// met:
+//
+// 1. Redistributions of source code must retain the above copyright
+// notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright
+// notice, this list of conditions and the following disclaimer in the
+// documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+// contributors may be used to endorse or promote products derived from
+// this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS
+// IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED
+// TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A
+// PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT
+// HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED
+// TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR
+// PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING
+// NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package wireguard implements the WireGuard handshake and transport data
+// message wire format, on top of the `Noise_IKpsk2_25519_ChaChaPoly_BLAKE2s`
+// protocol.
+package wireguard // import "gitlab.com/yawning/nyquist.git/wireguard"
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+
+	"gitlab.com/yawning/nyquist.git"
+	"gitlab.com/yawning/nyquist.git/hash"
+)
+
+const (
+	// ProtocolName is the Noise protocol name used by WireGuard.
+	ProtocolName = "Noise_IKpsk2_25519_ChaChaPoly_BLAKE2s"
+
+	// Identifier is the WireGuard identifier, used as the Noise prologue.
+	Identifier = "WireGuard v1 zx2c4 Jason@zx2c4.com"
+
+	// MessageInitiationType is the handshake initiation message type.
+	MessageInitiationType = 1
+
+	// MessageResponseType is the handshake response message type.
+	MessageResponseType = 2
+
+	// MessageCookieReplyType is the cookie reply message type.
+	MessageCookieReplyType = 3
+
+	// MessageTransportType is the transport data message type.
+	MessageTransportType = 4
+
+	// MessageInitiationSize is the size of a handshake initiation message.
+	MessageInitiationSize = 148
+
+	// MessageResponseSize is the size of a handshake response message.
+	MessageResponseSize = 92
+
+	// MessageCookieReplySize is the size of a cookie reply message.
+	MessageCookieReplySize = 64
+
+	// MessageTransportHeaderSize is the size of a transport data message
+	// header.
+	MessageTransportHeaderSize = 16
+
+	// MessageTransportOverhead is the transport data message overhead,
+	// excluding padding.
+	MessageTransportOverhead = MessageTransportHeaderSize + 16
+
+	// TAI64NSize is the size of a TAI64N timestamp.
+	TAI64NSize = 12
+
+	macSize = 16
+
+	labelMAC1   = "mac1----"
+	labelCookie = "cookie--"
+
+	// tai64Base is 2^62 + 10 (the TAI - UTC offset at 1970-01-01).
+	tai64Base = 0x400000000000000a
+)
+
+var (
+	// ErrInvalidMessage is the error returned when a message is malformed,
+	// or of an unexpected type.
+	ErrInvalidMessage = errors.New("nyquist/wireguard: invalid message")
+
+	// ErrInvalidMAC1 is the error returned when a message's mac1 is
+	// invalid.
+	ErrInvalidMAC1 = errors.New("nyquist/wireguard: invalid mac1")
+
+	// ErrInvalidMAC2 is the error returned when a message's mac2 is
+	// invalid.
+	ErrInvalidMAC2 = errors.New("nyquist/wireguard: invalid mac2")
+
+	// ErrInvalidIndex is the error returned when a message's receiver
+	// index does not match the local index.
+	ErrInvalidIndex = errors.New("nyquist/wireguard: invalid receiver index")
+
+	protocol *nyquist.Protocol
+)
+
+// TAI64N is a TAI64N timestamp.
+type TAI64N [TAI64NSize]byte
+
+// After returns true iff t is after other.
+func (t TAI64N) After(other TAI64N) bool {
+	for i := range t {
+		if t[i] != other[i] {
+			return t[i] > other[i]
+		}
+	}
+	return false
+}
+
+// Time returns the timestamp as a `time.Time`.
+func (t TAI64N) Time() time.Time {
+	secs := binary.BigEndian.Uint64(t[:8]) - tai64Base
+	nsecs := binary.BigEndian.Uint32(t[8:])
+	return time.Unix(int64(secs), int64(nsecs))
+}
+
+// NewTAI64N returns the TAI64N timestamp for the time.
+func NewTAI64N(t time.Time) TAI64N {
+	var ts TAI64N
+	binary.BigEndian.PutUint64(ts[:8], uint64(t.Unix())+tai64Base)
+	binary.BigEndian.PutUint32(ts[8:], uint32(t.Nanosecond()))
+	return ts
+}
+
+// MessageType returns the WireGuard message type of msg, or 0 if msg is
+// not a well-formed message header.
+func MessageType(msg []byte) int {
+	if len(msg) < 4 || msg[1] != 0 || msg[2] != 0 || msg[3] != 0 {
+		return 0
+	}
+	return int(msg[0])
+}
+
+func messageHeader(msgType int, size int) []byte {
+	msg := make([]byte, 4, size)
+	msg[0] = byte(msgType)
+	return msg
+}
+
+func checkMessage(msg []byte, msgType, size int) error {
+	if len(msg) != size || MessageType(msg) != msgType {
+		return ErrInvalidMessage
+	}
+	return nil
+}
+
+func labeledHash(label string, publicKey []byte) []byte {
+	h := hash.BLAKE2s.New()
+	_, _ = h.Write([]byte(label))
+	_, _ = h.Write(publicKey)
+	return h.Sum(nil)
+}
+
+func mac(key, data []byte) []byte {
+	h, err := blake2s.New128(key)
+	if err != nil {
+		panic("nyquist/wireguard: failed to initialize keyed BLAKE2s: " + err.Error())
+	}
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
+
+func init() {
+	var err error
+	if protocol, err = nyquist.NewProtocol(ProtocolName); err != nil {
+		panic("nyquist/wireguard: failed to initialize protocol: " + err.Error())
+	}
+}